@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// ctlConfig is dronectl's config file. Without a token, one is issued for Name
+// acting as UserType on first use.
+type ctlConfig struct {
+	Endpoint    string `yaml:"endpoint"`
+	Token       string `yaml:"token"`
+	Name        string `yaml:"name"`
+	UserType    string `yaml:"user_type"`
+	Output      string `yaml:"output"`
+	DatabaseURL string `yaml:"database_url"`
+}
+
+func defaultCtlConfig() *ctlConfig {
+	return &ctlConfig{
+		Endpoint:    "http://localhost:" + config.Default().HTTP.Port,
+		Name:        "dronectl",
+		UserType:    "admin",
+		Output:      outputTable,
+		DatabaseURL: config.Default().Database.URL,
+	}
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dronectl", "config.yaml")
+}
+
+// loadConfig reads path over the defaults; an empty path falls back to the default
+// location, which may not exist
+func loadConfig(path string) (*ctlConfig, error) {
+	cfg := defaultCtlConfig()
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && !explicit:
+		case err != nil:
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		default:
+			dec := yaml.NewDecoder(bytes.NewReader(raw))
+			dec.KnownFields(true)
+			if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+			}
+		}
+	}
+
+	if v := os.Getenv("DRONECTL_ENDPOINT"); v != "" {
+		cfg.Endpoint = v
+	}
+	if v := os.Getenv("DRONECTL_TOKEN"); v != "" {
+		cfg.Token = v
+	}
+	return cfg, nil
+}
+
+// override applies the global flags, which win over the file and environment
+func (c *ctlConfig) override(endpoint, token, output string) {
+	if endpoint != "" {
+		c.Endpoint = endpoint
+	}
+	if token != "" {
+		c.Token = token
+	}
+	if output != "" {
+		c.Output = output
+	}
+}
+
+func (c *ctlConfig) validate() error {
+	switch c.Output {
+	case outputTable, outputJSON, outputYAML:
+	default:
+		return fmt.Errorf("output: %q is not one of table, json, yaml", c.Output)
+	}
+	switch c.UserType {
+	case "admin", "enduser", "drone":
+	default:
+		return fmt.Errorf("user_type: %q is not one of admin, enduser, drone", c.UserType)
+	}
+	if c.Endpoint == "" {
+		return errors.New("endpoint: must not be empty")
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/client"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+)
+
+// backlog is what the dispatcher works from: pending orders are handed out to idle
+// drones by dispatch_by, least slack first
+type backlog struct {
+	Pending          []pendingOrder `json:"pending"`
+	IdleDrones       []domain.Drone `json:"idle_drones"`
+	NeedsInspection  int            `json:"drones_needing_inspection"`
+	AwaitingRecovery int            `json:"orders_awaiting_recovery"`
+	Scheduled        int            `json:"orders_scheduled"`
+}
+
+type pendingOrder struct {
+	domain.Order
+	Waiting string `json:"waiting"`
+}
+
+// dispatchTrace is how a single order went through dispatch, and for a pending order
+// which drones could take it next
+type dispatchTrace struct {
+	Order   *domain.Order        `json:"order"`
+	History []domain.OrderEvent  `json:"history"`
+	Plan    *domain.DispatchPlan `json:"plan,omitempty"`
+}
+
+func dispatchInspect(e *env, args []string) error {
+	fs := e.flags("dispatch inspect", "[ORDER_ID]")
+	positional, err := parseArgs(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	api, err := e.api()
+	if err != nil {
+		return err
+	}
+	if len(positional) == 1 {
+		return inspectOrder(e, api, positional[0])
+	}
+	return inspectBacklog(e, api)
+}
+
+func inspectBacklog(e *env, api *client.Client) error {
+	all := true
+	pages := &pageFlags{sort: new(string), cursor: new(string), limit: new(int), all: &all}
+
+	pendingQuery := url.Values{"status": {string(domain.OrderStatusPending)}, "sort": {string(domain.SortByDispatchBy)}}
+	pending, err := collect(pages, pendingQuery, func(query url.Values) (*domain.Page[domain.Order], error) {
+		return api.ListOrders(e.ctx, query)
+	})
+	if err != nil {
+		return err
+	}
+	idle, err := collect(pages, url.Values{"status": {string(domain.DroneStatusIdle)}}, func(query url.Values) (*domain.Page[domain.Drone], error) {
+		return api.ListDrones(e.ctx, query)
+	})
+	if err != nil {
+		return err
+	}
+	// Only the totals are needed for these
+	inspection, err := api.ListDrones(e.ctx, url.Values{"status": {string(domain.DroneStatusNeedsInspection)}, "limit": {"1"}, "with_total": {"true"}})
+	if err != nil {
+		return err
+	}
+	recovery, err := api.ListOrders(e.ctx, url.Values{"status": {string(domain.OrderStatusAwaitingRecovery)}, "limit": {"1"}, "with_total": {"true"}})
+	if err != nil {
+		return err
+	}
+	scheduled, err := api.ListOrders(e.ctx, url.Values{"status": {string(domain.OrderStatusScheduled)}, "limit": {"1"}, "with_total": {"true"}})
+	if err != nil {
+		return err
+	}
+
+	result := backlog{
+		Pending:          make([]pendingOrder, len(pending.Items)),
+		IdleDrones:       idle.Items,
+		NeedsInspection:  inspection.Total,
+		AwaitingRecovery: recovery.Total,
+		Scheduled:        scheduled.Total,
+	}
+	now := time.Now()
+	for i, order := range pending.Items {
+		result.Pending[i] = pendingOrder{Order: order, Waiting: now.Sub(order.CreatedAt).Round(time.Second).String()}
+	}
+
+	return e.render(result, func(t *tableWriter) {
+		t.row("PENDING ORDERS", len(result.Pending))
+		t.row("IDLE DRONES", len(result.IdleDrones))
+		t.row("DRONES NEEDING INSPECTION", result.NeedsInspection)
+		t.row("ORDERS AWAITING RECOVERY", result.AwaitingRecovery)
+		t.row("SCHEDULED ORDERS", result.Scheduled)
+		t.flush()
+		if len(result.Pending) > 0 {
+			t.row()
+			t.row("QUEUE", "ORDER", "PRIORITY", "PICKUP", "DESTINATION", "WAITING", "DISPATCH BY")
+			for i, order := range result.Pending {
+				t.row(i+1, order.ID, order.Priority, point(order.PickupLat, order.PickupLon), point(order.DestLat, order.DestLon), order.Waiting, order.DispatchBy)
+			}
+			t.flush()
+		}
+		if len(result.IdleDrones) > 0 {
+			t.row()
+			droneTable(t, result.IdleDrones)
+		}
+	})
+}
+
+func inspectOrder(e *env, api *client.Client, id string) error {
+	order, err := api.GetOrder(e.ctx, id)
+	if err != nil {
+		return err
+	}
+	history, err := api.OrderHistory(e.ctx, id)
+	if err != nil {
+		return err
+	}
+
+	result := dispatchTrace{Order: order, History: history}
+	if order.Status == domain.OrderStatusPending {
+		if result.Plan, err = api.DispatchPlan(e.ctx, id); err != nil {
+			return err
+		}
+	}
+	return e.render(result, func(t *tableWriter) {
+		orderTable(t, []domain.Order{*order})
+		legTable(t, order.Legs)
+		t.flush()
+		t.row()
+		t.row("AT", "FROM", "TO", "ACTOR", "DRONE", "REASON")
+		for _, event := range history {
+			t.row(event.CreatedAt, string(event.FromStatus), event.ToStatus, event.Actor, event.DroneID, event.Reason)
+		}
+		if result.Plan != nil {
+			planTable(t, result.Plan)
+		}
+	})
+}
+
+func planTable(t *tableWriter, plan *domain.DispatchPlan) {
+	t.flush()
+	t.row()
+	t.row("QUEUE POSITION", plan.QueuePosition)
+	t.row("RANK", "DRONE", "PICKUP (m)", "FLIGHT (m)", "EXCLUDED BY")
+	for _, c := range plan.Candidates {
+		var excluded []string
+		for _, e := range c.Exclusions {
+			excluded = append(excluded, e.Detail)
+		}
+		rank := "-"
+		if c.Eligible() {
+			rank = strconv.Itoa(c.Rank)
+		}
+		t.row(rank, c.DroneName, int(c.PickupDistance), int(c.FlightDistance), strings.Join(excluded, "; "))
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+)
+
+func droneRegister(e *env, args []string) error {
+	fs := e.flags("drone register", "NAME [--capacity N]")
+	capacity := fs.Int("capacity", 1, "orders the drone carries in one trip")
+	positional, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	api, err := e.api()
+	if err != nil {
+		return err
+	}
+	drone, err := api.RegisterDrone(e.ctx, positional[0], *capacity)
+	if err != nil {
+		return err
+	}
+	return e.render(drone, func(t *tableWriter) { droneTable(t, []domain.Drone{*drone}) })
+}
+
+func droneList(e *env, args []string) error {
+	fs := e.flags("drone list", "[flags]")
+	status := fs.String("status", "", "only drones with this status")
+	bbox := fs.String("bbox", "", "only drones within minLat,minLon,maxLat,maxLon")
+	pages := listFlags(fs)
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	api, err := e.api()
+	if err != nil {
+		return err
+	}
+
+	query := pages.query()
+	setIf(query, "status", upper(*status))
+	setIf(query, "bbox", *bbox)
+	page, err := collect(pages, query, func(query url.Values) (*domain.Page[domain.Drone], error) {
+		return api.ListDrones(e.ctx, query)
+	})
+	if err != nil {
+		return err
+	}
+	return e.render(page, func(t *tableWriter) {
+		droneTable(t, page.Items)
+		pageFooter(t, page.Total, len(page.Items), page.NextCursor)
+	})
+}
+
+func droneStatus(e *env, args []string) error {
+	fs := e.flags("drone status", "ID STATUS [--if-match VERSION]")
+	ifMatch := fs.Int("if-match", domain.AnyVersion, "fail unless the drone is at this version")
+	positional, err := parseArgs(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	api, err := e.api()
+	if err != nil {
+		return err
+	}
+	id, status := positional[0], domain.DroneStatus(upper(positional[1]))
+	if err := api.UpdateDroneStatus(e.ctx, id, status, *ifMatch); err != nil {
+		return err
+	}
+	result := map[string]string{"id": id, "status": string(status)}
+	return e.render(result, func(t *tableWriter) { t.row("drone", id, "is now", status) })
+}
+
+func droneRoute(e *env, args []string) error {
+	fs := e.flags("drone route", "ID")
+	positional, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	api, err := e.api()
+	if err != nil {
+		return err
+	}
+	route, err := api.DroneRoute(e.ctx, positional[0])
+	if err != nil {
+		return err
+	}
+	return e.render(route, func(t *tableWriter) {
+		t.row("STOP", "ORDER", "LATITUDE", "LONGITUDE", "DISTANCE (m)", "ETA")
+		for _, stop := range route.Stops {
+			t.row(stop.Kind, stop.OrderID, stop.Lat, stop.Lon, int(stop.Distance), stop.ETA)
+		}
+		t.flush()
+		t.row()
+		t.row("CAPACITY", route.Capacity, "TOTAL (m)", int(route.Distance))
+	})
+}
+
+func droneTable(t *tableWriter, drones []domain.Drone) {
+	t.row("ID", "NAME", "STATUS", "CAPACITY", "LATITUDE", "LONGITUDE", "VERSION", "UPDATED")
+	for _, d := range drones {
+		t.row(d.ID, d.Name, d.Status, d.Capacity, d.Latitude, d.Longitude, strconv.Itoa(d.Version), d.UpdatedAt)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+)
+
+// pageFlags are the paging flags shared by the list commands
+type pageFlags struct {
+	sort   *string
+	cursor *string
+	limit  *int
+	all    *bool
+}
+
+func listFlags(fs *flag.FlagSet) *pageFlags {
+	return &pageFlags{
+		sort:   fs.String("sort", "", "created_at or updated_at (orders also dispatch_by), prefixed with - for descending"),
+		cursor: fs.String("cursor", "", "continue from a previous page's next cursor"),
+		limit:  fs.Int("limit", 0, "page size (server default when 0)"),
+		all:    fs.Bool("all", false, "follow next cursors and print every page"),
+	}
+}
+
+func (p *pageFlags) query() url.Values {
+	// The footer shows the total
+	query := url.Values{"with_total": {"true"}}
+	setIf(query, "sort", *p.sort)
+	setIf(query, "cursor", *p.cursor)
+	if *p.limit > 0 {
+		query.Set("limit", strconv.Itoa(*p.limit))
+	}
+	return query
+}
+
+// collect fetches one page, or every page with --all, merged into one
+func collect[T any](p *pageFlags, query url.Values, fetch func(url.Values) (*domain.Page[T], error)) (*domain.Page[T], error) {
+	page, err := fetch(query)
+	if err != nil {
+		return nil, err
+	}
+	// The first page counted the total, the next ones need not
+	query.Del("with_total")
+	for *p.all && page.NextCursor != "" {
+		query.Set("cursor", page.NextCursor)
+		next, err := fetch(query)
+		if err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, next.Items...)
+		page.NextCursor = next.NextCursor
+	}
+	return page, nil
+}
+
+func pageFooter(t *tableWriter, total, shown int, next string) {
+	t.flush()
+	if next == "" {
+		t.row(fmt.Sprintf("(%d of %d)", shown, total))
+		return
+	}
+	t.row(fmt.Sprintf("(%d of %d, next page: --cursor %s)", shown, total, next))
+}
+
+func setIf(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
@@ -0,0 +1,201 @@
+// dronectl administers the fleet through the HTTP API: drones, orders, dispatch,
+// tokens and database migrations.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/client"
+)
+
+// command is a node of the command tree: either it runs, or it has subcommands
+type command struct {
+	name        string
+	usage       string
+	summary     string
+	run         func(e *env, args []string) error
+	subcommands []*command
+}
+
+var commands = []*command{
+	{name: "drone", summary: "register, list and change the status of drones", subcommands: []*command{
+		{name: "register", usage: "NAME [--capacity N]", summary: "register a drone", run: droneRegister},
+		{name: "list", usage: "[--status S] [--bbox BOX] [--all] [flags]", summary: "list drones", run: droneList},
+		{name: "status", usage: "ID STATUS [--if-match VERSION]", summary: "set a drone's status, e.g. IDLE to release it", run: droneStatus},
+		{name: "route", usage: "ID", summary: "show the stops left on a drone's trip", run: droneRoute},
+	}},
+	{name: "order", summary: "create, inspect, cancel and list orders", subcommands: []*command{
+		{name: "create", usage: "--origin LAT,LON --dest LAT,LON [--idempotency-key KEY]", summary: "place an order", run: orderCreate},
+		{name: "get", usage: "ID", summary: "show an order and its legs", run: orderGet},
+		{name: "cancel", usage: "ID [--if-match VERSION]", summary: "withdraw an order", run: orderCancel},
+		{name: "list", usage: "[--status S] [--drone ID] [--all] [flags]", summary: "list orders", run: orderList},
+	}},
+	{name: "dispatch", summary: "look into the dispatcher", subcommands: []*command{
+		{name: "inspect", usage: "[ORDER_ID]", summary: "show the dispatch backlog, or how one order was dispatched", run: dispatchInspect},
+	}},
+	{name: "token", summary: "issue API tokens", subcommands: []*command{
+		{name: "issue", usage: "NAME [--type admin|enduser|drone]", summary: "issue a token", run: tokenIssue},
+	}},
+	{name: "migrate", usage: "up|down [N|all]|status [--database-url URL]", summary: "apply or revert the database migrations", run: migrateRun},
+}
+
+// env is what every command runs with
+type env struct {
+	ctx    context.Context
+	cfg    *ctlConfig
+	stdout io.Writer
+	stderr io.Writer
+	client *client.Client
+	authed bool
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dronectl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	global := flag.NewFlagSet("dronectl", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	configPath := global.String("config", os.Getenv("DRONECTL_CONFIG"), "config file (default "+defaultConfigPath()+", env DRONECTL_CONFIG)")
+	endpoint := global.String("endpoint", "", "API base URL (env DRONECTL_ENDPOINT)")
+	token := global.String("token", "", "bearer token (env DRONECTL_TOKEN); one is issued for the configured identity otherwise")
+	output := global.String("o", "", "output format: table, json or yaml")
+	global.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: dronectl [flags] COMMAND [SUBCOMMAND] [args]\n\nCommands:\n")
+		printCommands(stderr, commands, "")
+		fmt.Fprintf(stderr, "\nFlags:\n")
+		global.PrintDefaults()
+	}
+	if err := global.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	cfg.override(*endpoint, *token, *output)
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	e := &env{ctx: ctx, cfg: cfg, stdout: stdout, stderr: stderr, client: client.New(cfg.Endpoint, cfg.Token)}
+	e.authed = cfg.Token != ""
+	if global.NArg() == 0 {
+		global.Usage()
+		return flag.ErrHelp
+	}
+	return e.dispatch(commands, global.Args(), "dronectl")
+}
+
+func (e *env) dispatch(cmds []*command, args []string, path string) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprintf(e.stderr, "Usage: %s COMMAND\n\nCommands:\n", path)
+		printCommands(e.stderr, cmds, "")
+		return flag.ErrHelp
+	}
+	for _, cmd := range cmds {
+		if cmd.name != args[0] {
+			continue
+		}
+		if cmd.run != nil {
+			return cmd.run(e, args[1:])
+		}
+		return e.dispatch(cmd.subcommands, args[1:], path+" "+cmd.name)
+	}
+	return fmt.Errorf("unknown command %q, see %s help", args[0], path)
+}
+
+func printCommands(w io.Writer, cmds []*command, prefix string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var walk func(cmds []*command, prefix string)
+	walk = func(cmds []*command, prefix string) {
+		for _, cmd := range cmds {
+			if cmd.run == nil {
+				walk(cmd.subcommands, prefix+cmd.name+" ")
+				continue
+			}
+			fmt.Fprintf(tw, "  %s%s %s\t%s\n", prefix, cmd.name, cmd.usage, cmd.summary)
+		}
+	}
+	walk(cmds, prefix)
+	tw.Flush()
+}
+
+// api returns the client, with a token issued for the configured identity unless
+// one was given
+func (e *env) api() (*client.Client, error) {
+	if !e.authed {
+		token, err := e.client.IssueToken(e.ctx, e.cfg.Name, e.cfg.UserType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get a token for %s %s: %w", e.cfg.UserType, e.cfg.Name, err)
+		}
+		e.client.SetToken(token)
+		e.authed = true
+	}
+	return e.client, nil
+}
+
+// flags returns the flag set of a command
+func (e *env) flags(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.StringVar(&e.cfg.Output, "o", e.cfg.Output, "output format: table, json or yaml")
+	fs.Usage = func() {
+		fmt.Fprintf(e.stderr, "Usage: dronectl %s %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses fs's flags wherever they appear among the positional arguments,
+// and checks the number of positional arguments is within [min, max]
+func parseArgs(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) < min || len(positional) > max {
+		fs.Usage()
+		return nil, fmt.Errorf("%s: expected %s, got %d", fs.Name(), argCount(min, max), len(positional))
+	}
+	return positional, nil
+}
+
+func argCount(min, max int) string {
+	if min == max {
+		return fmt.Sprintf("%d argument(s)", min)
+	}
+	return fmt.Sprintf("%d to %d arguments", min, max)
+}
+
+// upper normalises status arguments, so "idle" works as well as "IDLE"
+func upper(s string) string {
+	return strings.ToUpper(strings.TrimSpace(s))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestRun_IssuesTokenAndFollowsPages(t *testing.T) {
+	var cursors, totals []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/token":
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "issued"})
+		case "/api/v1/drones":
+			assert.Equal(t, "Bearer issued", r.Header.Get("Authorization"))
+			assert.Equal(t, "IDLE", r.URL.Query().Get("status"))
+			cursor := r.URL.Query().Get("cursor")
+			cursors = append(cursors, cursor)
+			totals = append(totals, r.URL.Query().Get("with_total"))
+			page := domain.Page[domain.Drone]{Items: []domain.Drone{{Name: "d1"}}, Total: 2, NextCursor: "p2"}
+			if cursor == "p2" {
+				page = domain.Page[domain.Drone]{Items: []domain.Drone{{Name: "d2"}}, Total: 2}
+			}
+			json.NewEncoder(w).Encode(page)
+		}
+	}))
+	defer srv.Close()
+	cfg := writeConfig(t, "endpoint: "+srv.URL+"\nname: ops\n")
+
+	var stdout bytes.Buffer
+	err := run(context.Background(), []string{"--config", cfg, "drone", "list", "--status", "idle", "--all", "-o", "json"}, &stdout, &bytes.Buffer{})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "p2"}, cursors)
+	assert.Equal(t, []string{"true", ""}, totals, "only the first page counts the total")
+	var page domain.Page[domain.Drone]
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &page))
+	require.Len(t, page.Items, 2)
+	assert.Equal(t, "d2", page.Items[1].Name)
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestRun_OrderCreateTable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer from-flag", r.Header.Get("Authorization"))
+		assert.Equal(t, "key-1", r.Header.Get("Idempotency-Key"))
+		var body map[string]float64
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, 30.5, body["dest_lat"])
+		json.NewEncoder(w).Encode(domain.Order{Status: domain.OrderStatusPending, DestLat: body["dest_lat"], DestLon: body["dest_lon"]})
+	}))
+	defer srv.Close()
+	cfg := writeConfig(t, "endpoint: "+srv.URL+"\ntoken: from-file\n")
+
+	var stdout bytes.Buffer
+	err := run(context.Background(), []string{"--config", cfg, "--token", "from-flag",
+		"order", "create", "--origin", "30,31", "--dest", "30.5,31.5", "--idempotency-key", "key-1"}, &stdout, &bytes.Buffer{})
+
+	require.NoError(t, err)
+	assert.Contains(t, stdout.String(), "PENDING")
+	assert.Contains(t, stdout.String(), "30.50000,31.50000")
+}
+
+func TestRun_RejectsBadInput(t *testing.T) {
+	cfg := writeConfig(t, "endpoint: http://127.0.0.1:1\ntoken: t\n")
+	for _, args := range [][]string{
+		{"order", "create", "--origin", "30", "--dest", "30,31"},
+		{"drone", "status", "only-an-id"},
+		{"fleet", "list"},
+		{"-o", "xml", "drone", "list"},
+	} {
+		err := run(context.Background(), append([]string{"--config", cfg}, args...), &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Error(t, err, "%v", args)
+	}
+
+	err := run(context.Background(), []string{"--config", writeConfig(t, "endpoint_url: x\n"), "drone", "list"}, &bytes.Buffer{}, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "endpoint_url")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/migrate"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/migrations"
+)
+
+// migrationResult lists what a migrate command did, or the state for status
+type migrationResult struct {
+	Version  uint     `json:"version"`
+	Dirty    bool     `json:"dirty"`
+	Applied  []string `json:"applied,omitempty"`
+	Reverted []string `json:"reverted,omitempty"`
+	Pending  []string `json:"pending,omitempty"`
+}
+
+// migrateRun talks to the database directly, like server migrate: migrations must
+// be runnable before there is an API to go through
+func migrateRun(e *env, args []string) error {
+	fs := e.flags("migrate", "up|down [N|all]|status [--database-url URL]")
+	dsn := fs.String("database-url", e.cfg.DatabaseURL, "Postgres DSN")
+	positional, err := parseArgs(fs, args, 1, 2)
+	if err != nil {
+		return err
+	}
+	command := positional[0]
+	if (command != "up" && command != "down" && command != "status") || (len(positional) == 2 && command != "down") {
+		fs.Usage()
+		return fmt.Errorf("migrate: unknown command %q", strings.Join(positional, " "))
+	}
+
+	m, err := migrate.Open(*dsn, migrations.FS)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	var result migrationResult
+	switch command {
+	case "up":
+		applied, err := m.Up(e.ctx)
+		result.Applied = names(applied)
+		if err != nil {
+			e.renderMigrations(result)
+			return err
+		}
+	case "down":
+		steps := 1
+		if len(positional) == 2 {
+			if positional[1] == "all" {
+				steps = 0
+			} else if steps, err = strconv.Atoi(positional[1]); err != nil || steps < 1 {
+				return fmt.Errorf("migrate down: %q is neither a number of steps nor all", positional[1])
+			}
+		}
+		reverted, err := m.Down(e.ctx, steps)
+		result.Reverted = names(reverted)
+		if err != nil {
+			e.renderMigrations(result)
+			return err
+		}
+	}
+
+	status, err := m.Status(e.ctx)
+	if err != nil {
+		return err
+	}
+	result.Version, result.Dirty = status.Version, status.Dirty
+	if command == "status" {
+		result.Applied = names(status.Applied)
+	}
+	result.Pending = names(status.Pending)
+	return e.renderMigrations(result)
+}
+
+func (e *env) renderMigrations(result migrationResult) error {
+	return e.render(result, func(t *tableWriter) {
+		state := "clean"
+		if result.Dirty {
+			state = "dirty"
+		}
+		t.row("version", strconv.FormatUint(uint64(result.Version), 10), state)
+		for _, name := range result.Applied {
+			t.row("applied", name)
+		}
+		for _, name := range result.Reverted {
+			t.row("reverted", name)
+		}
+		for _, name := range result.Pending {
+			t.row("pending", name)
+		}
+	})
+}
+
+func names(migrations []migrate.Migration) []string {
+	out := make([]string, len(migrations))
+	for i, m := range migrations {
+		out[i] = m.String()
+	}
+	return out
+}
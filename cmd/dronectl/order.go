@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/client"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+)
+
+func orderCreate(e *env, args []string) error {
+	fs := e.flags("order create", "--origin LAT,LON --dest LAT,LON [--priority CLASS] [--deliver-after TIME] [--deliver-by TIME] [--idempotency-key KEY]")
+	origin := fs.String("origin", "", "pickup point as LAT,LON")
+	dest := fs.String("dest", "", "drop-off point as LAT,LON")
+	priority := fs.String("priority", "", "MEDICAL, EXPRESS or STANDARD (default)")
+	deliverAfter := fs.String("deliver-after", "", "RFC 3339 start of the delivery window")
+	deliverBy := fs.String("deliver-by", "", "RFC 3339 deadline, no earlier than the priority class promises")
+	key := fs.String("idempotency-key", "", "retrying with the same key returns the same order")
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	var req client.CreateOrderRequest
+	var err error
+	if req.OriginLat, req.OriginLon, err = parsePoint("origin", *origin); err != nil {
+		return err
+	}
+	if req.DestLat, req.DestLon, err = parsePoint("dest", *dest); err != nil {
+		return err
+	}
+	req.Priority = domain.OrderPriority(upper(*priority))
+	if req.DeliverAfter, err = parseTimeFlag("deliver-after", *deliverAfter); err != nil {
+		return err
+	}
+	if req.DeliverBy, err = parseTimeFlag("deliver-by", *deliverBy); err != nil {
+		return err
+	}
+	api, err := e.api()
+	if err != nil {
+		return err
+	}
+	order, err := api.CreateOrder(e.ctx, req, *key)
+	if err != nil {
+		return err
+	}
+	return e.render(order, func(t *tableWriter) { orderTable(t, []domain.Order{*order}) })
+}
+
+func orderGet(e *env, args []string) error {
+	fs := e.flags("order get", "ID")
+	positional, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	api, err := e.api()
+	if err != nil {
+		return err
+	}
+	order, err := api.GetOrder(e.ctx, positional[0])
+	if err != nil {
+		return err
+	}
+	return e.render(order, func(t *tableWriter) {
+		orderTable(t, []domain.Order{*order})
+		if order.ETA != "" {
+			t.flush()
+			t.row("ETA", order.ETA)
+		}
+		legTable(t, order.Legs)
+	})
+}
+
+func orderCancel(e *env, args []string) error {
+	fs := e.flags("order cancel", "ID [--if-match VERSION]")
+	ifMatch := fs.Int("if-match", domain.AnyVersion, "fail unless the order is at this version")
+	positional, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	api, err := e.api()
+	if err != nil {
+		return err
+	}
+	id := positional[0]
+	if err := api.WithdrawOrder(e.ctx, id, *ifMatch); err != nil {
+		return err
+	}
+	result := map[string]string{"id": id, "status": string(domain.OrderStatusCancelled)}
+	return e.render(result, func(t *tableWriter) { t.row("order", id, "is now", domain.OrderStatusCancelled) })
+}
+
+func orderList(e *env, args []string) error {
+	fs := e.flags("order list", "[flags]")
+	status := fs.String("status", "", "only orders with this status")
+	droneID := fs.String("drone", "", "only orders assigned to this drone")
+	after := fs.String("created-after", "", "only orders created after this RFC 3339 time")
+	before := fs.String("created-before", "", "only orders created before this RFC 3339 time")
+	bbox := fs.String("bbox", "", "only orders with a pickup within minLat,minLon,maxLat,maxLon")
+	priority := fs.String("priority", "", "only orders of this priority class")
+	atRisk := fs.Bool("at-risk", false, "only orders flagged at risk of missing their deadline")
+	pages := listFlags(fs)
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	api, err := e.api()
+	if err != nil {
+		return err
+	}
+
+	query := pages.query()
+	setIf(query, "status", upper(*status))
+	setIf(query, "drone_id", *droneID)
+	setIf(query, "created_after", *after)
+	setIf(query, "created_before", *before)
+	setIf(query, "bbox", *bbox)
+	setIf(query, "priority", upper(*priority))
+	if *atRisk {
+		query.Set("at_risk", "true")
+	}
+	page, err := collect(pages, query, func(query url.Values) (*domain.Page[domain.Order], error) {
+		return api.ListOrders(e.ctx, query)
+	})
+	if err != nil {
+		return err
+	}
+	return e.render(page, func(t *tableWriter) {
+		orderTable(t, page.Items)
+		pageFooter(t, page.Total, len(page.Items), page.NextCursor)
+	})
+}
+
+func orderTable(t *tableWriter, orders []domain.Order) {
+	t.row("ID", "STATUS", "PRIORITY", "PICKUP", "DESTINATION", "DRONE", "VERSION", "CREATED", "DELIVER BY")
+	for _, o := range orders {
+		t.row(o.ID, o.Status, o.Priority, point(o.PickupLat, o.PickupLon), point(o.DestLat, o.DestLon), o.DroneID, strconv.Itoa(o.Version), o.CreatedAt, o.DeliverBy)
+	}
+}
+
+func legTable(t *tableWriter, legs []*domain.OrderLeg) {
+	if len(legs) == 0 {
+		return
+	}
+	t.flush()
+	t.row()
+	t.row("LEG", "DRONE", "FROM", "TO", "OUTCOME", "STARTED", "ENDED")
+	for i, leg := range legs {
+		t.row(strconv.Itoa(i+1), leg.DroneID, point(leg.FromLat, leg.FromLon), point(leg.ToLat, leg.ToLon), leg.Outcome, leg.StartedAt, leg.EndedAt)
+	}
+}
+
+func point(lat, lon float64) string {
+	return fmt.Sprintf("%.5f,%.5f", lat, lon)
+}
+
+// parseTimeFlag reads an optional RFC 3339 flag value
+func parseTimeFlag(name, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("--%s: %q is not an RFC 3339 time", name, value)
+	}
+	return &t, nil
+}
+
+// parsePoint reads a LAT,LON flag value
+func parsePoint(name, value string) (lat, lon float64, err error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("--%s: %q is not LAT,LON", name, value)
+	}
+	if lat, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64); err != nil {
+		return 0, 0, fmt.Errorf("--%s: %q is not LAT,LON", name, value)
+	}
+	if lon, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64); err != nil {
+		return 0, 0, fmt.Errorf("--%s: %q is not LAT,LON", name, value)
+	}
+	return lat, lon, nil
+}
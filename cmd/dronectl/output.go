@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// render prints v in the configured format; table writes the human readable form
+func (e *env) render(v interface{}, table func(t *tableWriter)) error {
+	switch e.cfg.Output {
+	case outputJSON:
+		enc := json.NewEncoder(e.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		// Round-trip through JSON so YAML uses the API's field names
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var generic interface{}
+		if err := json.Unmarshal(raw, &generic); err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(e.stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(generic); err != nil {
+			return err
+		}
+		return enc.Close()
+	case outputTable:
+		t := &tableWriter{tw: tabwriter.NewWriter(e.stdout, 0, 0, 2, ' ', 0)}
+		table(t)
+		return t.tw.Flush()
+	default:
+		return fmt.Errorf("output: %q is not one of table, json, yaml", e.cfg.Output)
+	}
+}
+
+// tableWriter aligns rows of cells into columns
+type tableWriter struct {
+	tw *tabwriter.Writer
+}
+
+func (t *tableWriter) row(cells ...interface{}) {
+	parts := make([]string, len(cells))
+	for i, cell := range cells {
+		parts[i] = formatCell(cell)
+	}
+	fmt.Fprintln(t.tw, strings.Join(parts, "\t"))
+}
+
+// flush ends a block of rows, so the next block is aligned on its own
+func (t *tableWriter) flush() {
+	t.tw.Flush()
+}
+
+func formatCell(cell interface{}) string {
+	switch v := cell.(type) {
+	case nil:
+		return "-"
+	case string:
+		if v == "" {
+			return "-"
+		}
+		return v
+	case float64:
+		return fmt.Sprintf("%.5f", v)
+	case time.Time:
+		if v.IsZero() {
+			return "-"
+		}
+		return v.Local().Format(time.DateTime)
+	case *time.Time:
+		if v == nil {
+			return "-"
+		}
+		return formatCell(*v)
+	case time.Duration:
+		return v.Round(time.Second).String()
+	case *ksuid.KSUID:
+		if v == nil {
+			return "-"
+		}
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
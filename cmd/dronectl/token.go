@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/client"
+)
+
+func tokenIssue(e *env, args []string) error {
+	fs := e.flags("token issue", "NAME [--type admin|enduser|drone]")
+	userType := fs.String("type", "admin", "admin, enduser or drone (for a drone, NAME is its registered name)")
+	positional, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	// Issuing needs no token, so don't get one for the configured identity first
+	token, err := client.New(e.cfg.Endpoint, "").IssueToken(e.ctx, positional[0], *userType)
+	if err != nil {
+		return err
+	}
+	result := map[string]string{"access_token": token, "token_type": "Bearer"}
+	return e.render(result, func(t *tableWriter) { t.row(token) })
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net"
 	"net/http"
@@ -13,23 +14,56 @@ import (
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/api"
 	grpcHandler "github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/api/grpc"
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/api/handlers"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/auth"
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/config"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/health"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/infrastructure/membus"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/infrastructure/pgbus"
 	infra_rmq "github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/infrastructure/rabbitmq"
 	infra_redis "github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/infrastructure/redis"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/lifecycle"
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/repository"
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/service"
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/telemetry"
-	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
 )
 
 func main() {
-	// 1. Load Config
-	cfg := config.Load()
+	// 1. Load Config: defaults < config file < environment < flags
+	cfg, opts, err := config.Parse(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if opts.PrintConfig {
+		if err := cfg.Redacted().WriteYAML(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(opts.Args) > 0 {
+		if opts.Args[0] != "migrate" {
+			log.Fatalf("unknown command %q, %s", opts.Args[0], migrateUsage)
+		}
+		if err := runMigrate(cfg, opts.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if cfg.Auth.JWTSecret == config.DefaultJWTSecret {
+		log.Println("WARNING: auth.jwt_secret is the built-in development secret; set JWT_SECRET in production")
+	}
+	auth.SecretKey = []byte(cfg.Auth.JWTSecret)
+	auth.TokenTTL = cfg.Auth.TokenTTL
 
 	// 2. Init Telemetry
 	telemetry.InitLogger()
-	tp, err := telemetry.InitTracer("drone-backend", cfg.OTelCollector)
+	tp, err := telemetry.InitTracer("drone-backend", cfg.Telemetry.CollectorURL)
 	if err != nil {
 		log.Fatalf("failed to init tracer: %v", err)
 	}
@@ -42,97 +76,300 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	defer telemetry.Shutdown(context.Background(), tp, mp)
+	// Readiness: the database is required, the rest only degrades the service
+	checker := health.NewChecker()
 
-	// 3. Init Database
-	repo, err := repository.NewPostgresRepository(cfg.DatabaseURL)
-	if err != nil {
-		log.Fatalf("failed to connect to database: %v", err)
+	var (
+		repo        store
+		redisClient *infra_redis.Client
+	)
+	if cfg.Mode == config.ModeMemory {
+		// 3-4. In-process repository, nothing is persisted.
+		// Without Redis there are no heartbeats, so drones are never marked OFFLINE.
+		log.Println("Running in memory mode: data is lost on exit and drone heartbeats are not monitored")
+		repo = repository.NewMemoryRepository()
+		checker.Add("database", true, repo.Ping)
+	} else {
+		// 3. Init Database
+		if cfg.Database.AutoMigrate {
+			if err := autoMigrate(ctx, cfg.Database.URL); err != nil {
+				log.Fatalf("failed to migrate database: %v", err)
+			}
+		}
+		pgRepo, err := repository.NewPostgresRepository(cfg.Database.URL)
+		if err != nil {
+			log.Fatalf("failed to connect to database: %v", err)
+		}
+		repo = pgRepo
+		checker.Add("database", true, repo.Ping)
+
+		// 4. Init Redis
+		redisClient, err = infra_redis.NewClient(infra_redis.Options{
+			Addr:         cfg.Redis.URL,
+			Password:     cfg.Redis.Password,
+			DB:           cfg.Redis.DB,
+			LocationTTL:  cfg.Redis.LocationTTL,
+			HeartbeatTTL: cfg.Heartbeat.TTL,
+		})
+		if err != nil {
+			log.Printf("failed to connect to redis, running degraded: %v", err)
+			checker.Add("redis", false, health.Unavailable(err))
+		} else {
+			checker.Add("redis", false, redisClient.Ping)
+		}
 	}
-	// 4. Init Redis
-	redisClient, err := infra_redis.NewClient(cfg.RedisURL, "", 0)
-	if err != nil {
-		log.Printf("failed to connect to redis: %v", err)
+	// Fleet gauges (Async): scrapes read counts refreshed every interval
+	gaugesCtx, stopGauges := context.WithCancel(context.Background())
+	gaugesDone := make(chan struct{})
+	if fleetGauges, err := telemetry.RegisterFleetGauges(repo, cfg.Telemetry.FleetInterval); err != nil {
+		log.Printf("failed to register fleet gauges: %v", err)
+		close(gaugesDone)
 	} else {
-		defer redisClient.Close()
+		go func() {
+			defer close(gaugesDone)
+			fleetGauges.Refresh(gaugesCtx)
+			fleetGauges.Start(gaugesCtx)
+		}()
 	}
 
-	// 5. Init RabbitMQ
-	rabbitClient, err := infra_rmq.NewClient(cfg.RabbitMQURL)
+	// 5. Init the event broker. When it is unreachable the server falls back to the
+	// in-process bus: dispatch keeps working, but events no longer leave this instance.
+	bus, err := connectBroker(cfg)
 	if err != nil {
-		log.Printf("failed to connect to rabbitmq: %v", err)
+		log.Printf("failed to connect to the %s event broker, running degraded on in-process events: %v", cfg.EventBroker(), err)
+		checker.Add("event_broker", false, health.Unavailable(err))
+		bus = membus.NewWithMaxDeliveries(cfg.Events.MaxDeliveries)
 	} else {
-		defer rabbitClient.Close()
+		checker.Add("event_broker", false, bus.Ping)
 	}
 
 	// 6. Init Services
 	droneService := service.NewDroneService(repo, redisClient)
-	orderService := service.NewOrderService(repo, rabbitClient)
-	dispatcherService := service.NewDispatcherService(repo, repo)
+	orderService := service.NewOrderService(repo, bus, redisClient, slaPolicy(cfg.SLA))
+	dispatcherService := service.NewDispatcherService(repo, repo, dispatchRules(cfg.Dispatch, cfg.SLA))
 
-	// Worker (Async)
-	orderWorker := service.NewOrderDispatcherWorker(rabbitClient, dispatcherService, repo)
-	if err := orderWorker.Start(); err != nil {
-		log.Printf("Failed to start order worker: %v", err)
+	// Dispatch (Async): the greedy worker matches on each event, and its sweeper retries
+	// what is left; the batch dispatcher assigns everything waiting once per interval
+	queues := service.WorkerQueues{
+		OrderCreated:   cfg.Dispatch.OrderQueue,
+		DroneAvailable: cfg.Dispatch.DroneQueue,
+	}
+	dispatchCtx, stopDispatchLoop := context.WithCancel(context.Background())
+	dispatchDone := make(chan struct{})
+	if cfg.Dispatch.Strategy == config.StrategyBatch {
+		batchDispatcher := service.NewBatchDispatcher(bus, dispatcherService, queues, cfg.Dispatch.BatchInterval)
+		if err := batchDispatcher.Subscribe(); err != nil {
+			log.Printf("Failed to subscribe batch dispatcher: %v", err)
+		}
+		go func() {
+			defer close(dispatchDone)
+			batchDispatcher.Start(dispatchCtx)
+		}()
+		checker.Add("batch_dispatcher", false, batchDispatcher.Check)
+	} else {
+		orderWorker := service.NewOrderDispatcherWorker(bus, dispatcherService, queues)
+		if err := orderWorker.Start(); err != nil {
+			log.Printf("Failed to start order worker: %v", err)
+		}
+		checker.Add("order_worker", false, orderWorker.Check)
+		orderSweeper := service.NewOrderSweeper(dispatcherService, cfg.Dispatch.SweepInterval)
+		go func() {
+			defer close(dispatchDone)
+			orderSweeper.Start(dispatchCtx)
+		}()
+		checker.Add("order_sweeper", false, orderSweeper.Check)
 	}
 
 	// Recovery Handler (Observer)
-	recoveryHandler := service.NewRecoveryHandler(repo)
+	recoveryHandler := service.NewRecoveryHandler(repo, bus)
 	droneService.AddObserver(recoveryHandler)
+	// Drones entering IDLE pick up waiting orders through drone.available events
+	droneService.AddObserver(service.NewAvailabilityPublisher(bus))
+
+	// Order Completion Handler (Observer): frees the drone once an order is finished
+	orderService.AddObserver(service.NewOrderCompletionHandler(droneService, repo, bus))
 
 	// Heartbeat Monitor (Async)
-	heartbeatMonitor := service.NewHeartbeatMonitor(repo, droneService, redisClient)
-	go heartbeatMonitor.Start(ctx)
+	heartbeatMonitor := service.NewHeartbeatMonitor(repo, droneService, redisClient, cfg.Heartbeat.Interval)
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	monitorDone := make(chan struct{})
+	go func() {
+		defer close(monitorDone)
+		heartbeatMonitor.Start(monitorCtx)
+	}()
+	checker.Add("heartbeat_monitor", false, heartbeatMonitor.Check)
+
+	// SLA Monitor (Async): flags orders close to their delivery deadline
+	slaMonitor := service.NewSLAMonitor(repo, bus, cfg.SLA.CheckInterval, cfg.SLA.AtRiskMargin)
+	slaCtx, stopSLAMonitor := context.WithCancel(context.Background())
+	slaDone := make(chan struct{})
+	go func() {
+		defer close(slaDone)
+		slaMonitor.Start(slaCtx)
+	}()
+	checker.Add("sla_monitor", false, slaMonitor.Check)
+
+	// Order Scheduler (Async): releases orders waiting for their delivery window
+	orderScheduler := service.NewOrderScheduler(orderService, cfg.SLA.ScheduleInterval)
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	schedulerDone := make(chan struct{})
+	go func() {
+		defer close(schedulerDone)
+		orderScheduler.Start(schedulerCtx)
+	}()
+	checker.Add("order_scheduler", false, orderScheduler.Check)
 
 	// 5. Init Handlers
 	droneHandler := handlers.NewDroneHandler(droneService, dispatcherService)
 	orderHandler := handlers.NewOrderHandler(orderService)
+	healthHandler := handlers.NewHealthHandler(checker)
 
 	// 6. Init Router
-	r := api.SetupRouter(droneHandler, orderHandler)
+	r := api.SetupRouter(droneHandler, orderHandler, healthHandler)
 
 	// 7. Start servers
 	// HTTP Server
 	srv := &http.Server{
-		Addr:    ":" + cfg.Port,
+		Addr:    ":" + cfg.HTTP.Port,
 		Handler: r,
 	}
 
 	go func() {
-		log.Printf("HTTP Server starting on port %s", cfg.Port)
+		log.Printf("HTTP Server starting on port %s", cfg.HTTP.Port)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("listen: %s\n", err)
 		}
 	}()
 
 	// gRPC Server
-	lis, err := net.Listen("tcp", ":50051")
+	lis, err := net.Listen("tcp", ":"+cfg.GRPC.Port)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	grpcServer := grpc.NewServer()
+	var grpcCreds credentials.TransportCredentials
+	if cfg.GRPC.TLSCert != "" {
+		if grpcCreds, err = grpcHandler.LoadTLSCredentials(cfg.GRPC.TLSCert, cfg.GRPC.TLSKey, cfg.GRPC.ClientCA); err != nil {
+			log.Fatalf("failed to load gRPC TLS credentials: %v", err)
+		}
+	}
+	grpcServer := grpcHandler.NewServer(grpcCreds)
 	droneGrpcServer := grpcHandler.NewDroneServer(droneService)
 	grpcHandler.Register(grpcServer, droneGrpcServer) // Ensure Register function exists in grpc package
 	reflection.Register(grpcServer)
+	grpcHandler.RegisterHealth(ctx, grpcServer, checker)
 
 	go func() {
-		log.Printf("gRPC Server starting on port 50051")
+		log.Printf("gRPC Server starting on port %s", cfg.GRPC.Port)
 		if err := grpcServer.Serve(lis); err != nil {
 			log.Fatalf("failed to serve grpc: %v", err)
 		}
 	}()
 
-	// 8. Wait for Interrupt
+	// 8. Shutdown sequence: stop taking work, drain what is in flight, then release
+	// telemetry and the connections the draining steps still needed
+	lc := lifecycle.NewManager()
+	lc.OnShutdown("http server", cfg.Shutdown.StepTimeout, srv.Shutdown)
+	lc.OnShutdown("heartbeat monitor", cfg.Shutdown.StepTimeout, func(ctx context.Context) error {
+		stopMonitor()
+		return lifecycle.Wait(ctx, monitorDone)
+	})
+	lc.OnShutdown("sla monitor", cfg.Shutdown.StepTimeout, func(ctx context.Context) error {
+		stopSLAMonitor()
+		return lifecycle.Wait(ctx, slaDone)
+	})
+	lc.OnShutdown("order scheduler", cfg.Shutdown.StepTimeout, func(ctx context.Context) error {
+		stopScheduler()
+		return lifecycle.Wait(ctx, schedulerDone)
+	})
+	lc.OnShutdown("dispatch loop", cfg.Shutdown.StepTimeout, func(ctx context.Context) error {
+		stopDispatchLoop()
+		return lifecycle.Wait(ctx, dispatchDone)
+	})
+	lc.OnShutdown("fleet gauges", cfg.Shutdown.StepTimeout, func(ctx context.Context) error {
+		stopGauges()
+		return lifecycle.Wait(ctx, gaugesDone)
+	})
+	lc.OnShutdown("event consumers", cfg.Shutdown.StepTimeout, bus.StopConsuming)
+	lc.OnShutdown("grpc server", cfg.Shutdown.StepTimeout, func(ctx context.Context) error {
+		droneGrpcServer.Drain()
+		return grpcHandler.GracefulStop(ctx, grpcServer)
+	})
+	lc.OnShutdown("telemetry", cfg.Shutdown.StepTimeout, func(ctx context.Context) error {
+		telemetry.Shutdown(ctx, tp, mp)
+		return nil
+	})
+	lc.OnShutdown("event broker", cfg.Shutdown.StepTimeout, func(ctx context.Context) error {
+		return bus.Close()
+	})
+	if redisClient != nil {
+		lc.OnShutdown("redis", cfg.Shutdown.StepTimeout, func(ctx context.Context) error {
+			return redisClient.Close()
+		})
+	}
+	lc.OnShutdown("database", cfg.Shutdown.StepTimeout, func(ctx context.Context) error {
+		return repo.Close()
+	})
+
+	// 9. Wait for Interrupt
 	<-ctx.Done()
 	stop()
 	log.Println("Shutting down gracefully, press Ctrl+C again to force")
 
-	// 9. Shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown: ", err)
+	if err := lc.Shutdown(); err != nil {
+		log.Printf("Shutdown finished with errors: %v", err)
+		os.Exit(1)
 	}
-
 	log.Println("Server exiting")
 }
+
+// store is the persistence the server runs on, Postgres or in memory
+type store interface {
+	repository.DroneRepository
+	repository.OrderRepository
+	telemetry.FleetStats
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// slaPolicy turns the sla config into the deadlines and queue order of new orders
+func slaPolicy(cfg config.SLAConfig) service.SLAPolicy {
+	return service.SLAPolicy{
+		Promise: map[domain.OrderPriority]time.Duration{
+			domain.OrderPriorityMedical:  cfg.Medical,
+			domain.OrderPriorityExpress:  cfg.Express,
+			domain.OrderPriorityStandard: cfg.Standard,
+		},
+		MaxWait:     cfg.MaxWait,
+		CruiseSpeed: float64(cfg.CruiseSpeed),
+	}
+}
+
+// dispatchRules turns the dispatch config into the rules the dispatcher applies;
+// Validate has already checked the geofence
+func dispatchRules(cfg config.DispatchConfig, sla config.SLAConfig) service.DispatchRules {
+	rules := service.DispatchRules{
+		MaxPickupDistance: float64(cfg.MaxPickupDistance),
+		MaxFlightDistance: float64(cfg.MaxFlightDistance),
+		BatteryRange:      float64(cfg.BatteryRange),
+		MaxDetour:         float64(cfg.MaxDetour),
+		CruiseSpeed:       float64(sla.CruiseSpeed),
+		PriorityWeight:    float64(cfg.PriorityWeight),
+		BatteryWeight:     float64(cfg.BatteryWeight),
+	}
+	if b, ok, _ := cfg.GeofenceBounds(); ok {
+		rules.Geofence = &domain.BoundingBox{MinLat: b[0], MinLon: b[1], MaxLat: b[2], MaxLon: b[3]}
+	}
+	return rules
+}
+
+// connectBroker opens the event broker selected by events.broker
+func connectBroker(cfg *config.Config) (events.Broker, error) {
+	switch cfg.EventBroker() {
+	case config.BrokerPostgres:
+		return pgbus.New(cfg.Database.URL, cfg.Events.MaxDeliveries)
+	case config.BrokerMemory:
+		return membus.NewWithMaxDeliveries(cfg.Events.MaxDeliveries), nil
+	default:
+		return infra_rmq.NewClient(cfg.RabbitMQ.URL, cfg.Events.MaxDeliveries)
+	}
+}
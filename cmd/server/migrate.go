@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/config"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/migrate"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/migrations"
+)
+
+const migrateUsage = "usage: server [flags] migrate up|down [N|all]|status"
+
+// runMigrate implements the migrate subcommand against database.url. down reverts one
+// migration unless told how many, or all.
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 || len(args) > 2 || (len(args) == 2 && args[0] != "down") {
+		return errors.New(migrateUsage)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	m, err := migrate.Open(cfg.Database.URL, migrations.FS)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("applied   %s\n", migration)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = parseSteps(args[1]); err != nil {
+				return err
+			}
+		}
+		reverted, err := m.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted  %s\n", migration)
+		}
+		return err
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		state := "clean"
+		if status.Dirty {
+			state = "dirty"
+		}
+		fmt.Printf("version %d (%s)\n", status.Version, state)
+		for _, migration := range status.Applied {
+			fmt.Printf("applied   %s\n", migration)
+		}
+		for _, migration := range status.Pending {
+			fmt.Printf("pending   %s\n", migration)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, %s", args[0], migrateUsage)
+	}
+}
+
+// parseSteps reads the N|all argument of migrate down; 0 means all
+func parseSteps(arg string) (int, error) {
+	if arg == "all" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("migrate down: %q is neither a number of steps nor all", arg)
+	}
+	return n, nil
+}
+
+// autoMigrate applies the pending migrations at startup. Replicas starting together
+// wait for each other on the migration lock.
+func autoMigrate(ctx context.Context, dsn string) error {
+	m, err := migrate.Open(dsn, migrations.FS)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	applied, err := m.Up(ctx)
+	for _, migration := range applied {
+		log.Printf("Applied migration %s", migration)
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/client"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+)
+
+// crew plays the recovery crew: parcels a crashed drone left AWAITING_RECOVERY go
+// back to dispatch after delay
+func crew(ctx context.Context, api *client.Client, s *stats, delay time.Duration) {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	grounded := map[string]time.Time{}
+	query := url.Values{"status": {string(domain.OrderStatusAwaitingRecovery)}, "limit": {"100"}}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		page, err := api.ListOrders(ctx, query)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("crew: failed to list orders awaiting recovery: %v", err)
+				s.add(countAPIErrors)
+			}
+			continue
+		}
+		for _, order := range page.Items {
+			id := order.ID.String()
+			if !s.ours(id) {
+				continue
+			}
+			since, seen := grounded[id]
+			if !seen {
+				grounded[id] = time.Now()
+				continue
+			}
+			if time.Since(since) < delay {
+				continue
+			}
+			if _, err := api.UpdateOrderStatus(ctx, id, domain.OrderStatusPending, "parcel recovered by simulator crew"); err != nil {
+				log.Printf("crew: failed to recover order %s: %v", id, err)
+				s.add(countAPIErrors)
+				continue
+			}
+			log.Printf("crew: recovered parcel of order %s at %.5f,%.5f", id, order.PickupLat, order.PickupLon)
+			delete(grounded, id)
+			s.add(countRecovered)
+		}
+	}
+}
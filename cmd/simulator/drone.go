@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/client"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	pb "github.com/MohamedDenta/Drone-Delivery-Management-Backend/proto/drone"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// settings drive the flight and the injected faults of every drone
+type settings struct {
+	speed        float64 // metres per second
+	tick         time.Duration
+	waypoints    int
+	pollInterval time.Duration
+	capacity     int // orders carried in one trip
+
+	batteryRange     float64 // metres on a full charge
+	minBattery       float64 // percent under which a drone docks to charge
+	chargeTime       time.Duration
+	batteryFaultRate float64 // chance per mission of a cell draining four times faster
+
+	crashRate       float64 // chance per mission
+	dropoutRate     float64 // chance per mission
+	dropoutDuration time.Duration
+	repairDelay     time.Duration
+	inspectionDelay time.Duration
+}
+
+// simDrone is one virtual drone. It only talks to the server as a drone would: its
+// own token, a location stream and the REST calls for jobs and order updates.
+type simDrone struct {
+	name    string
+	id      string
+	api     *client.Client
+	conn    *grpc.ClientConn
+	token   string
+	rng     *rand.Rand
+	cfg     *settings
+	stats   *stats
+	pos     point
+	battery float64 // percent
+
+	stream       pb.DroneService_ReportLocationClient
+	cancelStream context.CancelFunc
+	broken       chan struct{} // closed when the stream ended on the server side
+}
+
+// mission is the plan of one delivery, faults included
+type mission struct {
+	flown     float64
+	drain     float64 // battery drain multiplier
+	crashAt   float64 // metres into the mission, or -1
+	dropoutAt float64 // metres into the mission, or -1
+}
+
+type outcome int
+
+const (
+	arrived outcome = iota
+	stopped
+	crashed
+	batteryDead
+	droppedOut
+)
+
+func (d *simDrone) run(ctx context.Context) {
+	defer d.shutdown()
+	d.report(ctx)
+	for ctx.Err() == nil {
+		if d.battery < d.cfg.minBattery {
+			d.charge(ctx)
+			continue
+		}
+		order := d.nextJob(ctx)
+		if order == nil {
+			d.idle(ctx, d.cfg.pollInterval)
+			continue
+		}
+		d.deliver(ctx, order)
+	}
+}
+
+// nextJob returns the order the dispatcher assigned to this drone, or else reserves one
+func (d *simDrone) nextJob(ctx context.Context) *domain.Order {
+	page, err := d.api.ListOrders(ctx, url.Values{"drone_id": {d.id}, "status": {string(domain.OrderStatusReserved)}, "limit": {"1"}})
+	if err != nil {
+		d.apiError(ctx, "list assigned orders", err)
+		return nil
+	}
+	if len(page.Items) > 0 {
+		return &page.Items[0]
+	}
+
+	order, err := d.api.ReserveJob(ctx, d.id)
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+		return nil // Nothing pending, or the dispatcher got to this drone first
+	}
+	if err != nil {
+		d.apiError(ctx, "reserve job", err)
+		return nil
+	}
+	return order
+}
+
+// deliver flies the trip order starts: just that order, or with spare capacity the
+// route the server planned through every order it batched onto the drone
+func (d *simDrone) deliver(ctx context.Context, order *domain.Order) {
+	stops := d.stops(ctx, order)
+	var length float64
+	at := d.pos
+	for _, stop := range stops {
+		length += distance(at, stop.at)
+		at = stop.at
+		d.stats.orderReserved(stop.orderID)
+	}
+	m := d.plan(length)
+
+	abandoned := map[string]bool{}
+	for _, stop := range stops {
+		if abandoned[stop.orderID] {
+			continue
+		}
+		if !d.flyLeg(ctx, route(d.rng, d.pos, stop.at, d.cfg.waypoints), m, stop.orderID) {
+			return
+		}
+		if _, err := d.api.UpdateOrderStatus(ctx, stop.orderID, stop.then, ""); err != nil {
+			// Usually cancelled or recovered under the drone's feet
+			log.Printf("%s: abandoning order %s, cannot mark it %s: %v", d.name, stop.orderID, stop.then, err)
+			d.stats.add(countAbandoned)
+			abandoned[stop.orderID] = true
+			continue
+		}
+		if stop.then == domain.OrderStatusDelivered {
+			d.stats.orderDelivered(stop.orderID)
+		}
+	}
+}
+
+// stop is a point of a trip and the status the order moves to there
+type stop struct {
+	orderID string
+	at      point
+	then    domain.OrderStatus
+}
+
+// stops lists the stops of the trip order starts, in flight order
+func (d *simDrone) stops(ctx context.Context, order *domain.Order) []stop {
+	id := order.ID.String()
+	single := []stop{
+		{id, point{order.PickupLat, order.PickupLon}, domain.OrderStatusPickedUp},
+		{id, point{order.DestLat, order.DestLon}, domain.OrderStatusDelivered},
+	}
+	if d.cfg.capacity <= 1 {
+		return single
+	}
+	planned, err := d.api.DroneRoute(ctx, d.id)
+	if err != nil {
+		d.apiError(ctx, "get route", err)
+		return single
+	}
+	stops := make([]stop, 0, len(planned.Stops))
+	for _, s := range planned.Stops {
+		then := domain.OrderStatusDelivered
+		if s.Kind == domain.RouteStopPickup {
+			then = domain.OrderStatusPickedUp
+		}
+		stops = append(stops, stop{s.OrderID.String(), point{s.Lat, s.Lon}, then})
+	}
+	return stops
+}
+
+// plan draws the faults of a mission of roughly length metres
+func (d *simDrone) plan(length float64) *mission {
+	m := &mission{drain: 1, crashAt: -1, dropoutAt: -1}
+	if d.rng.Float64() < d.cfg.batteryFaultRate {
+		m.drain = 4
+	}
+	if d.rng.Float64() < d.cfg.crashRate {
+		m.crashAt = d.rng.Float64() * length
+	}
+	if d.rng.Float64() < d.cfg.dropoutRate {
+		m.dropoutAt = d.rng.Float64() * length
+	}
+	return m
+}
+
+// flyLeg flies p to its end and reports whether the mission goes on
+func (d *simDrone) flyLeg(ctx context.Context, p path, m *mission, orderID string) bool {
+	for {
+		var result outcome
+		result, p = d.fly(ctx, p, m)
+		switch result {
+		case arrived:
+			return true
+		case crashed:
+			d.crash(ctx, countCrashes, "crashed")
+			return false
+		case batteryDead:
+			d.crash(ctx, countBatteryFailures, "ran out of battery")
+			return false
+		case droppedOut:
+			if !d.dropout(ctx, orderID) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+}
+
+// fly moves along p one tick at a time until it ends or a fault strikes
+func (d *simDrone) fly(ctx context.Context, p path, m *mission) (outcome, path) {
+	for len(p) > 0 {
+		if !d.wait(ctx, d.cfg.tick) {
+			return stopped, p
+		}
+		var flown float64
+		d.pos, p, flown = p.advance(d.pos, d.cfg.speed*d.cfg.tick.Seconds())
+		m.flown += flown
+		d.battery -= flown / d.cfg.batteryRange * 100 * m.drain
+		switch {
+		case d.battery <= 0:
+			d.battery = 0
+			return batteryDead, p
+		case m.crashAt >= 0 && m.flown >= m.crashAt:
+			return crashed, p
+		case m.dropoutAt >= 0 && m.flown >= m.dropoutAt:
+			m.dropoutAt = -1
+			return droppedOut, p
+		}
+		d.report(ctx)
+	}
+	return arrived, p
+}
+
+// crash reports the drone BROKEN where it stands, which hands its order to recovery,
+// then repairs it
+func (d *simDrone) crash(ctx context.Context, counter, what string) {
+	log.Printf("%s: %s at %.5f,%.5f", d.name, what, d.pos.Lat, d.pos.Lon)
+	d.stats.add(counter)
+	d.report(ctx)
+	d.setStatus(ctx, domain.DroneStatusBroken)
+	d.disconnect()
+	if !d.wait(ctx, d.cfg.repairDelay) {
+		return
+	}
+	d.battery = 100
+	d.report(ctx)
+	d.setStatus(ctx, domain.DroneStatusIdle)
+}
+
+// dropout goes silent long enough for the heartbeat monitor to notice. It reports
+// whether the mission goes on, which it does when the server kept the order on this drone.
+func (d *simDrone) dropout(ctx context.Context, orderID string) bool {
+	log.Printf("%s: dropping out for %s", d.name, d.cfg.dropoutDuration)
+	d.stats.add(countDropouts)
+	d.disconnect()
+	if !d.wait(ctx, d.cfg.dropoutDuration) {
+		return false
+	}
+	d.report(ctx)
+
+	order, err := d.api.GetOrder(ctx, orderID)
+	if err != nil {
+		d.apiError(ctx, "get order after dropout", err)
+		return false
+	}
+	if order.DroneID != nil && order.DroneID.String() == d.id &&
+		(order.Status == domain.OrderStatusReserved || order.Status == domain.OrderStatusPickedUp) {
+		return true
+	}
+
+	// The order went back to dispatch, and the drone to NEEDS_INSPECTION on reconnecting
+	log.Printf("%s: order %s was recovered while offline, waiting for inspection", d.name, orderID)
+	d.stats.add(countDropoutsNoticed)
+	d.idle(ctx, d.cfg.inspectionDelay)
+	d.setStatus(ctx, domain.DroneStatusIdle)
+	return false
+}
+
+// charge docks the drone OFFLINE, so it is not dispatched, until the battery is full
+func (d *simDrone) charge(ctx context.Context) {
+	d.stats.add(countCharges)
+	d.setStatus(ctx, domain.DroneStatusOffline)
+	d.disconnect()
+	if !d.wait(ctx, time.Duration((100-d.battery)/100*float64(d.cfg.chargeTime))) {
+		return
+	}
+	d.battery = 100
+	d.report(ctx)
+	d.setStatus(ctx, domain.DroneStatusIdle)
+}
+
+// shutdown takes the drone out of service, so the dispatcher stops picking it
+func (d *simDrone) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	d.setStatus(ctx, domain.DroneStatusOffline)
+	d.disconnect()
+}
+
+func (d *simDrone) setStatus(ctx context.Context, status domain.DroneStatus) {
+	if err := d.api.UpdateDroneStatus(ctx, d.id, status, domain.AnyVersion); err != nil {
+		d.apiError(ctx, "set status "+string(status), err)
+	}
+}
+
+// idle keeps reporting the position, which is the drone's heartbeat, for duration
+func (d *simDrone) idle(ctx context.Context, duration time.Duration) {
+	for end := time.Now().Add(duration); time.Now().Before(end); {
+		if !d.wait(ctx, d.cfg.tick) {
+			return
+		}
+		d.report(ctx)
+	}
+}
+
+// wait sleeps for duration and reports false if the simulation ended meanwhile
+func (d *simDrone) wait(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (d *simDrone) apiError(ctx context.Context, what string, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	log.Printf("%s: failed to %s: %v", d.name, what, err)
+	d.stats.add(countAPIErrors)
+}
+
+// report sends the current position on the location stream, opening it if needed
+func (d *simDrone) report(ctx context.Context) {
+	if d.stream != nil {
+		select {
+		case <-d.broken:
+			d.disconnect()
+			d.stats.add(countStreamReconnects)
+		default:
+		}
+	}
+	if d.stream == nil {
+		if err := d.connect(ctx); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("%s: failed to open location stream: %v", d.name, err)
+			}
+			return
+		}
+	}
+	if err := d.stream.Send(&pb.LocationRequest{DroneId: d.id, Latitude: d.pos.Lat, Longitude: d.pos.Lon}); err != nil {
+		d.disconnect()
+		d.stats.add(countStreamReconnects)
+	}
+}
+
+func (d *simDrone) connect(ctx context.Context) error {
+	streamCtx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+d.token))
+	stream, err := pb.NewDroneServiceClient(d.conn).ReportLocation(streamCtx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	broken := make(chan struct{})
+	go func() {
+		defer close(broken)
+		for {
+			if _, err := stream.Recv(); err != nil {
+				return
+			}
+		}
+	}()
+	d.stream, d.cancelStream, d.broken = stream, cancel, broken
+	return nil
+}
+
+func (d *simDrone) disconnect() {
+	if d.stream == nil {
+		return
+	}
+	d.stream.CloseSend()
+	d.cancelStream()
+	d.stream = nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+const earthRadiusMeters = 6371000
+
+type point struct {
+	Lat, Lon float64
+}
+
+// distance is the great-circle distance between a and b in metres
+func distance(a, b point) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// towards moves from a up to meters towards b, stopping at b
+func towards(a, b point, meters float64) point {
+	d := distance(a, b)
+	if d <= meters || d == 0 {
+		return b
+	}
+	// Linear interpolation is close enough over the few kilometres of a delivery
+	f := meters / d
+	return point{Lat: a.Lat + (b.Lat-a.Lat)*f, Lon: a.Lon + (b.Lon-a.Lon)*f}
+}
+
+// randomPoint returns a point uniformly distributed within radius metres of center
+func randomPoint(rng *rand.Rand, center point, radius float64) point {
+	r := radius * math.Sqrt(rng.Float64())
+	theta := rng.Float64() * 2 * math.Pi
+	dLat := r * math.Cos(theta) / earthRadiusMeters * 180 / math.Pi
+	dLon := r * math.Sin(theta) / (earthRadiusMeters * math.Cos(center.Lat*math.Pi/180)) * 180 / math.Pi
+	return point{Lat: center.Lat + dLat, Lon: center.Lon + dLon}
+}
+
+// path is a route as a list of points still to reach
+type path []point
+
+// route returns the points to fly from a to b. With waypoints > 0 the route detours
+// through that many points off the straight line, by up to a fifth of its length.
+func route(rng *rand.Rand, a, b point, waypoints int) path {
+	p := make(path, 0, waypoints+1)
+	for i := 1; i <= waypoints; i++ {
+		f := float64(i) / float64(waypoints+1)
+		onLine := point{Lat: a.Lat + (b.Lat-a.Lat)*f, Lon: a.Lon + (b.Lon-a.Lon)*f}
+		p = append(p, randomPoint(rng, onLine, distance(a, b)/5))
+	}
+	return append(p, b)
+}
+
+// advance flies up to meters along the path from pos, and returns the new position,
+// the remaining path and the metres actually flown
+func (p path) advance(pos point, meters float64) (point, path, float64) {
+	flown := 0.0
+	for len(p) > 0 && meters-flown > 0 {
+		next := towards(pos, p[0], meters-flown)
+		flown += distance(pos, next)
+		pos = next
+		if pos != p[0] {
+			break
+		}
+		p = p[1:]
+	}
+	return pos, p, flown
+}
@@ -0,0 +1,260 @@
+// simulator flies a fleet of virtual drones against a running server for load and
+// end-to-end testing, injecting battery, connectivity and crash faults along the way.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+type options struct {
+	endpoint      string
+	grpcAddr      string
+	drones        int
+	orders        int
+	orderInterval time.Duration
+	center        point
+	radius        float64
+	duration      time.Duration
+	recoveryDelay time.Duration
+	seed          int64
+	reportJSON    string
+	drone         settings
+}
+
+func parseOptions(args []string) (*options, error) {
+	opts := &options{}
+	fs := flag.NewFlagSet("simulator", flag.ContinueOnError)
+	fs.StringVar(&opts.endpoint, "endpoint", "http://localhost:8081", "HTTP API base URL")
+	fs.StringVar(&opts.grpcAddr, "grpc", "localhost:50051", "gRPC address for the location streams")
+	fs.IntVar(&opts.drones, "drones", 5, "number of virtual drones")
+	fs.IntVar(&opts.orders, "orders", 20, "number of orders to place")
+	fs.DurationVar(&opts.orderInterval, "order-interval", 2*time.Second, "time between two orders")
+	center := fs.String("center", "30.0444,31.2357", "LAT,LON of the area drones and orders are placed in")
+	fs.Float64Var(&opts.radius, "radius", 3000, "radius of the area in metres")
+	fs.DurationVar(&opts.duration, "duration", 10*time.Minute, "give up on undelivered orders after this long")
+	fs.DurationVar(&opts.recoveryDelay, "recovery-delay", 10*time.Second, "time for the crew to bring a grounded parcel back")
+	fs.Int64Var(&opts.seed, "seed", 0, "random seed, to replay a run (random when 0)")
+	fs.StringVar(&opts.reportJSON, "report-json", "", "also write the summary as JSON to this file")
+
+	d := &opts.drone
+	fs.Float64Var(&d.speed, "speed", 20, "cruise speed in metres per second")
+	fs.DurationVar(&d.tick, "tick", time.Second, "interval of location reports")
+	fs.IntVar(&d.waypoints, "waypoints", 0, "detour through this many waypoints per leg instead of flying straight")
+	fs.DurationVar(&d.pollInterval, "poll", 2*time.Second, "how often idle drones look for a job")
+	fs.IntVar(&d.capacity, "capacity", 1, "orders each drone carries in one trip, flown along the server's route")
+	fs.Float64Var(&d.batteryRange, "battery-range", 15000, "metres a drone flies on a full battery")
+	fs.Float64Var(&d.minBattery, "min-battery", 25, "battery percent under which an idle drone docks to charge")
+	fs.DurationVar(&d.chargeTime, "charge-time", 30*time.Second, "time to charge an empty battery")
+	fs.Float64Var(&d.batteryFaultRate, "battery-fault-rate", 0.05, "chance per mission of a battery draining four times faster")
+	fs.Float64Var(&d.crashRate, "crash-rate", 0.05, "chance per mission of a crash")
+	fs.Float64Var(&d.dropoutRate, "dropout-rate", 0.05, "chance per mission of losing connectivity")
+	fs.DurationVar(&d.dropoutDuration, "dropout-duration", 45*time.Second, "how long a dropout lasts; longer than the server's heartbeat TTL for it to notice")
+	fs.DurationVar(&d.repairDelay, "repair-delay", 20*time.Second, "time to repair a crashed drone")
+	fs.DurationVar(&d.inspectionDelay, "inspection-delay", 5*time.Second, "time to inspect a drone that reconnected mid-delivery")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+
+	var err error
+	if opts.center, err = parsePoint(*center); err != nil {
+		return nil, fmt.Errorf("-center: %w", err)
+	}
+	switch {
+	case opts.drones < 1:
+		return nil, fmt.Errorf("-drones: must be at least 1")
+	case opts.orders < 0:
+		return nil, fmt.Errorf("-orders: must not be negative")
+	case d.speed <= 0 || d.batteryRange <= 0 || opts.radius <= 0:
+		return nil, fmt.Errorf("-speed, -battery-range and -radius must be positive")
+	case d.tick <= 0:
+		return nil, fmt.Errorf("-tick: must be positive")
+	case d.capacity < 1:
+		return nil, fmt.Errorf("-capacity: must be at least 1")
+	}
+	if opts.seed == 0 {
+		opts.seed = time.Now().UnixNano()
+	}
+	return opts, nil
+}
+
+func parsePoint(s string) (point, error) {
+	lat, lon, ok := strings.Cut(s, ",")
+	if !ok {
+		return point{}, fmt.Errorf("%q is not LAT,LON", s)
+	}
+	var p point
+	var err1, err2 error
+	p.Lat, err1 = strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	p.Lon, err2 = strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err1 != nil || err2 != nil {
+		return point{}, fmt.Errorf("%q is not LAT,LON", s)
+	}
+	return p, nil
+}
+
+func main() {
+	opts, err := parseOptions(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	r, err := simulate(ctx, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println()
+	r.print(os.Stdout)
+	if opts.reportJSON != "" {
+		f, err := os.Create(opts.reportJSON)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := r.writeJSON(f); err != nil {
+			log.Fatal(err)
+		}
+		f.Close()
+	}
+	if len(r.Undelivered) > 0 {
+		os.Exit(1)
+	}
+}
+
+// simulate registers the fleet, places the orders and flies until every order is
+// delivered, the duration is up or ctx is cancelled
+func simulate(ctx context.Context, opts *options) (report, error) {
+	log.Printf("Simulating %d drones and %d orders (seed %d)", opts.drones, opts.orders, opts.seed)
+	rng := rand.New(rand.NewSource(opts.seed))
+	s := newStats()
+
+	admin := client.New(opts.endpoint, "")
+	token, err := admin.IssueToken(ctx, "simulator", "admin")
+	if err != nil {
+		return report{}, fmt.Errorf("failed to log in: %w", err)
+	}
+	admin.SetToken(token)
+
+	conn, err := grpc.NewClient(opts.grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return report{}, err
+	}
+	defer conn.Close()
+
+	prefix := fmt.Sprintf("sim-%d-", time.Now().Unix())
+	fleet := make([]*simDrone, opts.drones)
+	for i := range fleet {
+		if fleet[i], err = newSimDrone(ctx, admin, conn, prefix+strconv.Itoa(i+1), opts, rng, s); err != nil {
+			return report{}, err
+		}
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, d := range fleet {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.run(runCtx)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		crew(runCtx, admin, s, opts.recoveryDelay)
+	}()
+
+	placeOrders(runCtx, admin, opts, rng, s)
+	waitDelivered(runCtx, s)
+	cancel()
+	wg.Wait()
+	return s.report(opts.drones), nil
+}
+
+// waitDelivered returns once every order placed was delivered, or ctx is done
+func waitDelivered(ctx context.Context, s *stats) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for !s.done() {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping with orders undelivered: %v", ctx.Err())
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func newSimDrone(ctx context.Context, admin *client.Client, conn *grpc.ClientConn, name string, opts *options, rng *rand.Rand, s *stats) (*simDrone, error) {
+	registered, err := admin.RegisterDrone(ctx, name, opts.drone.capacity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register %s: %w", name, err)
+	}
+	api := client.New(opts.endpoint, "")
+	token, err := api.IssueToken(ctx, name, "drone")
+	if err != nil {
+		return nil, fmt.Errorf("failed to log in as %s: %w", name, err)
+	}
+	api.SetToken(token)
+	return &simDrone{
+		name:    name,
+		id:      registered.ID.String(),
+		api:     api,
+		conn:    conn,
+		token:   token,
+		rng:     rand.New(rand.NewSource(rng.Int63())),
+		cfg:     &opts.drone,
+		stats:   s,
+		pos:     randomPoint(rng, opts.center, opts.radius),
+		battery: 100,
+	}, nil
+}
+
+// placeOrders places opts.orders orders between random points of the area
+func placeOrders(ctx context.Context, admin *client.Client, opts *options, rng *rand.Rand, s *stats) {
+	for i := 0; i < opts.orders; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(opts.orderInterval):
+			}
+		}
+		origin := randomPoint(rng, opts.center, opts.radius)
+		dest := randomPoint(rng, opts.center, opts.radius)
+		order, err := admin.CreateOrder(ctx, client.CreateOrderRequest{
+			OriginLat: origin.Lat, OriginLon: origin.Lon, DestLat: dest.Lat, DestLon: dest.Lon,
+		}, "")
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to place order: %v", err)
+				s.add(countAPIErrors)
+			}
+			continue
+		}
+		s.orderCreated(order.ID.String())
+	}
+}
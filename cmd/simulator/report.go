@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// stats is shared by the drones, the order generator and the crew
+type stats struct {
+	mu      sync.Mutex
+	started time.Time
+	// created is when each order of this run was placed, by order ID
+	created   map[string]time.Time
+	reserved  map[string]time.Time
+	delivered map[string]time.Time
+	counters  map[string]int
+}
+
+func newStats() *stats {
+	return &stats{
+		started:   time.Now(),
+		created:   map[string]time.Time{},
+		reserved:  map[string]time.Time{},
+		delivered: map[string]time.Time{},
+		counters:  map[string]int{},
+	}
+}
+
+// Counter names used in the report
+const (
+	countCrashes          = "crashes"
+	countBatteryFailures  = "battery failures"
+	countDropouts         = "dropouts"
+	countDropoutsNoticed  = "dropouts noticed by server"
+	countAbandoned        = "missions abandoned"
+	countRecovered        = "parcels recovered by crew"
+	countCharges          = "battery charges"
+	countStreamReconnects = "stream reconnects"
+	countAPIErrors        = "api errors"
+)
+
+func (s *stats) add(counter string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[counter]++
+}
+
+func (s *stats) orderCreated(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.created[id] = time.Now()
+}
+
+func (s *stats) ours(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.created[id]
+	return ok
+}
+
+// orderReserved keeps the first reservation; a recovered order is reserved again.
+// Orders placed by someone else than the simulator are not tracked.
+func (s *stats) orderReserved(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, placed := s.created[id]
+	if _, seen := s.reserved[id]; placed && !seen {
+		s.reserved[id] = time.Now()
+	}
+}
+
+func (s *stats) orderDelivered(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, placed := s.created[id]; placed {
+		s.delivered[id] = time.Now()
+	}
+}
+
+// done reports whether every order placed so far was delivered
+func (s *stats) done() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.delivered) == len(s.created)
+}
+
+// durationSummary describes a set of durations
+type durationSummary struct {
+	Count int    `json:"count"`
+	Min   string `json:"min"`
+	P50   string `json:"p50"`
+	P95   string `json:"p95"`
+	Max   string `json:"max"`
+}
+
+func summarize(durations []time.Duration) durationSummary {
+	if len(durations) == 0 {
+		return durationSummary{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	format := func(d time.Duration) string { return d.Round(100 * time.Millisecond).String() }
+	return durationSummary{
+		Count: len(durations),
+		Min:   format(durations[0]),
+		P50:   format(percentile(durations, 50)),
+		P95:   format(percentile(durations, 95)),
+		Max:   format(durations[len(durations)-1]),
+	}
+}
+
+// percentile uses the nearest-rank method on sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// report is the summary printed when the simulation ends
+type report struct {
+	Elapsed      string          `json:"elapsed"`
+	Drones       int             `json:"drones"`
+	Orders       int             `json:"orders"`
+	Delivered    int             `json:"delivered"`
+	Undelivered  []string        `json:"undelivered,omitempty"`
+	WaitTime     durationSummary `json:"wait_time"`     // Placed until reserved by a drone
+	DeliveryTime durationSummary `json:"delivery_time"` // Placed until delivered
+	Events       map[string]int  `json:"events"`
+}
+
+func (s *stats) report(drones int) report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := report{
+		Elapsed: time.Since(s.started).Round(time.Second).String(),
+		Drones:  drones,
+		Orders:  len(s.created),
+		Events:  map[string]int{},
+	}
+	var waits, deliveries []time.Duration
+	for id, created := range s.created {
+		if reserved, ok := s.reserved[id]; ok {
+			waits = append(waits, reserved.Sub(created))
+		}
+		if delivered, ok := s.delivered[id]; ok {
+			deliveries = append(deliveries, delivered.Sub(created))
+		} else {
+			r.Undelivered = append(r.Undelivered, id)
+		}
+	}
+	sort.Strings(r.Undelivered)
+	r.Delivered = len(deliveries)
+	r.WaitTime = summarize(waits)
+	r.DeliveryTime = summarize(deliveries)
+	for name, n := range s.counters {
+		r.Events[name] = n
+	}
+	return r
+}
+
+func (r report) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Simulation summary (%s, %d drones)\n\n", r.Elapsed, r.Drones)
+	fmt.Fprintf(tw, "orders placed\t%d\n", r.Orders)
+	fmt.Fprintf(tw, "delivered\t%d\n", r.Delivered)
+	fmt.Fprintf(tw, "undelivered\t%d\n", len(r.Undelivered))
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "\tcount\tmin\tp50\tp95\tmax\n")
+	for _, row := range []struct {
+		name string
+		d    durationSummary
+	}{{"wait time", r.WaitTime}, {"delivery time", r.DeliveryTime}} {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", row.name, row.d.Count, dash(row.d.Min), dash(row.d.P50), dash(row.d.P95), dash(row.d.Max))
+	}
+	fmt.Fprintln(tw)
+	for _, name := range []string{countCrashes, countBatteryFailures, countDropouts, countDropoutsNoticed, countAbandoned,
+		countRecovered, countCharges, countStreamReconnects, countAPIErrors} {
+		fmt.Fprintf(tw, "%s\t%d\n", name, r.Events[name])
+	}
+	tw.Flush()
+	for _, id := range r.Undelivered {
+		fmt.Fprintf(w, "undelivered order %s\n", id)
+	}
+}
+
+func (r report) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDistance(t *testing.T) {
+	// One degree of latitude is about 111.2 km
+	assert.InDelta(t, 111195, distance(point{30, 31}, point{31, 31}), 10)
+	assert.Zero(t, distance(point{30, 31}, point{30, 31}))
+}
+
+func TestPath_AdvanceThroughWaypoints(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	from, to := point{30, 31}, point{30.01, 31.01}
+	p := route(rng, from, to, 3)
+	assert.Len(t, p, 4)
+	assert.Equal(t, to, p[len(p)-1])
+
+	pos, total := from, 0.0
+	for steps := 0; len(p) > 0; steps++ {
+		var flown float64
+		pos, p, flown = p.advance(pos, 100)
+		assert.LessOrEqual(t, flown, 100.01) // Interpolation is off by well under a centimetre
+		total += flown
+		require.Less(t, steps, 1000, "path never ends")
+	}
+	assert.Equal(t, to, pos)
+	assert.GreaterOrEqual(t, total, distance(from, to))
+}
+
+func TestRandomPoint_StaysInRadius(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	center := point{30.0444, 31.2357}
+	for i := 0; i < 1000; i++ {
+		assert.LessOrEqual(t, distance(center, randomPoint(rng, center, 3000)), 3000.0+1)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var durations []time.Duration
+	for i := 20; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Second)
+	}
+
+	s := summarize(durations)
+
+	assert.Equal(t, durationSummary{Count: 20, Min: "1s", P50: "10s", P95: "19s", Max: "20s"}, s)
+	assert.Equal(t, durationSummary{}, summarize(nil))
+}
@@ -0,0 +1,102 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Identity is the authenticated caller of an RPC
+type Identity struct {
+	Name     string
+	UserType string
+}
+
+type identityKey struct{}
+
+// IdentityFromContext returns the caller set by the auth interceptors
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// publicMethods can be called without credentials (service discovery and health probes)
+var publicMethods = []string{
+	"/grpc.reflection.",
+	"/grpc.health.v1.Health/",
+}
+
+func isPublic(fullMethod string) bool {
+	for _, prefix := range publicMethods {
+		if strings.HasPrefix(fullMethod, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func authUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if isPublic(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	identity, err := authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(context.WithValue(ctx, identityKey{}, identity), req)
+}
+
+func authStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if isPublic(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	identity, err := authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), identityKey{}, identity)})
+}
+
+// authenticatedStream exposes the caller's identity through Context()
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticate identifies the caller by a verified client certificate (its CN is the
+// drone name) or else by the same JWT the REST API uses, sent as "authorization: Bearer <token>".
+func authenticate(ctx context.Context) (Identity, error) {
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.VerifiedChains) > 0 {
+			return Identity{Name: tlsInfo.State.VerifiedChains[0][0].Subject.CommonName, UserType: "drone"}, nil
+		}
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return Identity{}, status.Error(codes.Unauthenticated, "authorization metadata required")
+	}
+
+	token, found := strings.CutPrefix(values[0], "Bearer ")
+	if !found {
+		return Identity{}, status.Error(codes.Unauthenticated, "invalid authorization format")
+	}
+
+	claims, err := auth.ValidateToken(token)
+	if err != nil {
+		return Identity{}, status.Error(codes.Unauthenticated, "invalid or expired token")
+	}
+	return Identity{Name: claims.Name, UserType: claims.UserType}, nil
+}
@@ -0,0 +1,71 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthenticate_JWT(t *testing.T) {
+	token, err := auth.GenerateToken("drone-001", "drone")
+	assert.NoError(t, err)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	identity, err := authenticate(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, Identity{Name: "drone-001", UserType: "drone"}, identity)
+}
+
+func TestAuthenticate_Rejects(t *testing.T) {
+	cases := map[string]context.Context{
+		"no metadata":   context.Background(),
+		"not bearer":    metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Basic abc")),
+		"invalid token": metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer not-a-jwt")),
+	}
+	for name, ctx := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := authenticate(ctx)
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		})
+	}
+}
+
+func TestAuthUnaryInterceptor_SkipsPublicMethods(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	resp, err := authUnaryInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+}
+
+func TestAuthUnaryInterceptor_SetsIdentity(t *testing.T) {
+	token, _ := auth.GenerateToken("admin", "admin")
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	info := &grpc.UnaryServerInfo{FullMethod: "/drone.DroneService/Anything"}
+
+	_, err := authUnaryInterceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		identity, ok := IdentityFromContext(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, "admin", identity.Name)
+		return nil, nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestRecoveryUnaryInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/drone.DroneService/Anything"}
+	_, err := recoveryUnaryInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
@@ -0,0 +1,47 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/health"
+	pb "github.com/MohamedDenta/Drone-Delivery-Management-Backend/proto/drone"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthInterval is how often the gRPC health status is refreshed from the checker
+const healthInterval = 5 * time.Second
+
+// RegisterHealth serves grpc.health.v1 for the whole server ("") and drone.DroneService.
+// Both are SERVING unless the checker reports the service down (a degraded service still
+// accepts drone streams). The status is refreshed until ctx is done, then set to NOT_SERVING.
+func RegisterHealth(ctx context.Context, s *grpc.Server, checker *health.Checker) {
+	srv := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(s, srv)
+
+	update := func() {
+		status := healthpb.HealthCheckResponse_SERVING
+		if checker.Check(ctx).Status == health.StatusDown {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		srv.SetServingStatus("", status)
+		srv.SetServingStatus(pb.DroneService_ServiceDesc.ServiceName, status)
+	}
+	update()
+
+	go func() {
+		ticker := time.NewTicker(healthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				srv.Shutdown()
+				return
+			case <-ticker.C:
+				update()
+			}
+		}
+	}()
+}
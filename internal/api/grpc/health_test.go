@@ -0,0 +1,57 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func healthClient(t *testing.T, checker *health.Checker) healthpb.HealthClient {
+	ctx, cancel := context.WithCancel(context.Background())
+	lis := bufconn.Listen(1 << 20)
+	s := NewServer(nil)
+	RegisterHealth(ctx, s, checker)
+	go s.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		s.Stop()
+	})
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestHealth_DegradedIsServing(t *testing.T) {
+	checker := health.NewChecker()
+	checker.Add("database", true, func(ctx context.Context) error { return nil })
+	checker.Add("rabbitmq", false, health.Unavailable(errors.New("connection refused")))
+
+	// No credentials: health probes are public
+	resp, err := healthClient(t, checker).Check(context.Background(), &healthpb.HealthCheckRequest{Service: "drone.DroneService"})
+
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+}
+
+func TestHealth_DatabaseDownIsNotServing(t *testing.T) {
+	checker := health.NewChecker()
+	checker.Add("database", true, health.Unavailable(errors.New("connection refused")))
+
+	resp, err := healthClient(t, checker).Check(context.Background(), &healthpb.HealthCheckRequest{})
+
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+}
@@ -3,35 +3,91 @@ package grpc
 import (
 	"io"
 	"log"
+	"sync"
 
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/service"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/telemetry"
 	pb "github.com/MohamedDenta/Drone-Delivery-Management-Backend/proto/drone"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type DroneServer struct {
 	pb.UnimplementedDroneServiceServer
 	droneService *service.DroneService
+	// draining is closed by Drain to end the open location streams
+	draining  chan struct{}
+	drainOnce sync.Once
 }
 
 func NewDroneServer(droneService *service.DroneService) *DroneServer {
-	return &DroneServer{droneService: droneService}
+	return &DroneServer{droneService: droneService, draining: make(chan struct{})}
 }
 
+// Drain ends every open location stream with UNAVAILABLE so drones reconnect to another
+// instance, instead of being cut when the server stops
+func (s *DroneServer) Drain() {
+	s.drainOnce.Do(func() { close(s.draining) })
+}
+
+// ReportLocation streams location updates of the authenticated drone. The stream is bound
+// to that drone: a request carrying another drone_id ends it with PermissionDenied.
 func (s *DroneServer) ReportLocation(stream pb.DroneService_ReportLocationServer) error {
-	for {
-		req, err := stream.Recv()
-		if err == io.EOF {
-			return nil
+	ctx := stream.Context()
+	identity, ok := IdentityFromContext(ctx)
+	if !ok || identity.UserType != "drone" {
+		return status.Error(codes.PermissionDenied, "only drones can report locations")
+	}
+	drone, err := s.droneService.GetDroneByName(ctx, identity.Name)
+	if err != nil {
+		return status.Errorf(codes.PermissionDenied, "drone %q is not registered", identity.Name)
+	}
+	droneID := drone.ID.String()
+	defer telemetry.Metrics.LocationStreamOpened(ctx)()
+
+	// Receive in the background so a drain can end the stream between two updates
+	requests, recvErr := make(chan *pb.LocationRequest), make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case requests <- req:
+			case <-done:
+				return
+			}
 		}
-		if err != nil {
+	}()
+
+	for {
+		var req *pb.LocationRequest
+		select {
+		case <-s.draining:
+			return status.Error(codes.Unavailable, "server is shutting down, reconnect")
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
 			return err
+		case req = <-requests:
+		}
+
+		if req.DroneId != "" && req.DroneId != droneID {
+			log.Printf("Rejected location for drone %s on stream of drone %s", req.DroneId, droneID)
+			return status.Errorf(codes.PermissionDenied, "stream is bound to drone %s", droneID)
 		}
 
 		// Update Location in Service (which handles DB + Redis + Observers)
-		err = s.droneService.UpdateLocation(req.DroneId, req.Latitude, req.Longitude)
+		telemetry.Metrics.LocationUpdated(ctx, "grpc")
+		err = s.droneService.UpdateLocation(ctx, droneID, req.Latitude, req.Longitude, req.Battery)
 		if err != nil {
-			log.Printf("Failed to update location for drone %s: %v", req.DroneId, err)
+			log.Printf("Failed to update location for drone %s: %v", droneID, err)
 			continue
 		}
 
@@ -0,0 +1,149 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/service"
+	pb "github.com/MohamedDenta/Drone-Delivery-Management-Backend/proto/drone"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type MockDroneRepo struct {
+	mock.Mock
+}
+
+func (m *MockDroneRepo) CreateDrone(ctx context.Context, drone *domain.Drone) error {
+	return m.Called(drone).Error(0)
+}
+func (m *MockDroneRepo) GetDroneByID(ctx context.Context, id string) (*domain.Drone, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Drone), args.Error(1)
+}
+func (m *MockDroneRepo) GetDroneByName(ctx context.Context, name string) (*domain.Drone, error) {
+	args := m.Called(name)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Drone), args.Error(1)
+}
+func (m *MockDroneRepo) GetIdleDrones(ctx context.Context) ([]*domain.Drone, error) {
+	return nil, nil
+}
+func (m *MockDroneRepo) GetActiveDrones(ctx context.Context) ([]*domain.Drone, error) {
+	return nil, nil
+}
+func (m *MockDroneRepo) ListDrones(ctx context.Context, filter domain.DroneFilter) (*domain.Page[*domain.Drone], error) {
+	return nil, nil
+}
+func (m *MockDroneRepo) UpdateDrone(ctx context.Context, drone *domain.Drone) error {
+	return m.Called(drone).Error(0)
+}
+
+// fakeLocationStream replays requests and records responses
+type fakeLocationStream struct {
+	grpc.ServerStream
+	ctx       context.Context
+	requests  []*pb.LocationRequest
+	responses []*pb.LocationResponse
+}
+
+func (s *fakeLocationStream) Context() context.Context { return s.ctx }
+
+func (s *fakeLocationStream) Recv() (*pb.LocationRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := s.requests[0]
+	s.requests = s.requests[1:]
+	return req, nil
+}
+
+func (s *fakeLocationStream) Send(resp *pb.LocationResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func newDroneStream(name string, requests ...*pb.LocationRequest) *fakeLocationStream {
+	ctx := context.WithValue(context.Background(), identityKey{}, Identity{Name: name, UserType: "drone"})
+	return &fakeLocationStream{ctx: ctx, requests: requests}
+}
+
+func TestReportLocation_UpdatesAuthenticatedDrone(t *testing.T) {
+	repo := new(MockDroneRepo)
+	drone := &domain.Drone{ID: ksuid.New(), Name: "drone-001", Status: domain.DroneStatusIdle}
+	repo.On("GetDroneByName", "drone-001").Return(drone, nil)
+	repo.On("GetDroneByID", drone.ID.String()).Return(drone, nil)
+	repo.On("UpdateDrone", mock.Anything).Return(nil)
+
+	stream := newDroneStream("drone-001", &pb.LocationRequest{DroneId: drone.ID.String(), Latitude: 30, Longitude: 31})
+	err := NewDroneServer(service.NewDroneService(repo, nil)).ReportLocation(stream)
+
+	assert.NoError(t, err)
+	assert.Len(t, stream.responses, 1)
+	assert.Equal(t, 30.0, drone.Latitude)
+}
+
+func TestReportLocation_RejectsOtherDroneID(t *testing.T) {
+	repo := new(MockDroneRepo)
+	drone := &domain.Drone{ID: ksuid.New(), Name: "drone-001"}
+	repo.On("GetDroneByName", "drone-001").Return(drone, nil)
+
+	stream := newDroneStream("drone-001", &pb.LocationRequest{DroneId: ksuid.New().String(), Latitude: 30, Longitude: 31})
+	err := NewDroneServer(service.NewDroneService(repo, nil)).ReportLocation(stream)
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	repo.AssertNotCalled(t, "UpdateDrone", mock.Anything)
+}
+
+func TestReportLocation_RejectsNonDrones(t *testing.T) {
+	repo := new(MockDroneRepo)
+	ctx := context.WithValue(context.Background(), identityKey{}, Identity{Name: "alice", UserType: "enduser"})
+
+	err := NewDroneServer(service.NewDroneService(repo, nil)).ReportLocation(&fakeLocationStream{ctx: ctx})
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// idleLocationStream blocks in Recv until its context ends, like a connected drone
+// that has nothing to report
+type idleLocationStream struct {
+	fakeLocationStream
+}
+
+func (s *idleLocationStream) Recv() (*pb.LocationRequest, error) {
+	<-s.ctx.Done()
+	return nil, s.ctx.Err()
+}
+
+func TestReportLocation_DrainEndsOpenStreams(t *testing.T) {
+	repo := new(MockDroneRepo)
+	drone := &domain.Drone{ID: ksuid.New(), Name: "drone-001"}
+	repo.On("GetDroneByName", "drone-001").Return(drone, nil)
+
+	ctx, cancel := context.WithCancel(newDroneStream("drone-001").ctx)
+	defer cancel()
+	srv := NewDroneServer(service.NewDroneService(repo, nil))
+	result := make(chan error, 1)
+	go func() { result <- srv.ReportLocation(&idleLocationStream{fakeLocationStream{ctx: ctx}}) }()
+
+	srv.Drain()
+
+	select {
+	case err := <-result:
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	case <-time.After(time.Second):
+		t.Fatal("stream still open after Drain")
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/service"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/telemetry"
 	"github.com/gin-gonic/gin"
 )
 
@@ -22,7 +23,8 @@ func NewDroneHandler(droneService *service.DroneService, dispatcherService *serv
 }
 
 type RegisterDroneRequest struct {
-	Name string `json:"name" binding:"required"`
+	Name     string `json:"name" binding:"required"`
+	Capacity int    `json:"capacity" binding:"omitempty,min=1"` // Orders per trip; 1 when omitted
 }
 
 func (h *DroneHandler) Register(c *gin.Context) {
@@ -32,18 +34,20 @@ func (h *DroneHandler) Register(c *gin.Context) {
 		return
 	}
 
-	drone, err := h.droneService.RegisterDrone(req.Name)
+	drone, err := h.droneService.RegisterDrone(c.Request.Context(), req.Name, req.Capacity)
 	if err != nil {
 		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		return
 	}
 
+	setETag(c, drone.Version)
 	c.JSON(http.StatusCreated, drone)
 }
 
 type UpdateLocationRequest struct {
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Battery   *float64 `json:"battery" binding:"omitempty,min=0,max=100"` // Percent; optional
 }
 
 // UpdateLocation handles heartbeat and location updates
@@ -62,14 +66,15 @@ func (h *DroneHandler) UpdateLocation(c *gin.Context) {
 	}
 
 	// Resolve Name -> Drone
-	drone, err := h.droneService.GetDroneByName(name)
+	drone, err := h.droneService.GetDroneByName(c.Request.Context(), name)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "drone not found"})
 		return
 	}
 
 	// Update Location
-	if err := h.droneService.UpdateLocation(drone.ID.String(), req.Latitude, req.Longitude); err != nil {
+	telemetry.Metrics.LocationUpdated(c.Request.Context(), "rest")
+	if err := h.droneService.UpdateLocation(c.Request.Context(), drone.ID.String(), req.Latitude, req.Longitude, req.Battery); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -89,7 +94,7 @@ func (h *DroneHandler) ReserveJob(c *gin.Context) {
 		return
 	}
 
-	order, err := h.dispatcherService.ReserveJob(req.DroneID)
+	order, err := h.dispatcherService.ReserveJob(c.Request.Context(), req.DroneID)
 	if err != nil {
 		slog.Error("failed to reserve job", "error", err)
 		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
@@ -99,13 +104,62 @@ func (h *DroneHandler) ReserveJob(c *gin.Context) {
 	c.JSON(http.StatusOK, order)
 }
 
+// DispatchPlan explains which idle drones the dispatcher would consider for an order,
+// and why the others are excluded. Nothing is reserved.
+func (h *DroneHandler) DispatchPlan(c *gin.Context) {
+	plan, err := h.dispatcherService.PlanDispatch(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err == domain.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// Route returns the stops left on a drone's trip in flight order, with the distance
+// flown and ETA at each
+func (h *DroneHandler) Route(c *gin.Context) {
+	route, err := h.dispatcherService.PlanRoute(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err == domain.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "drone not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, route)
+}
+
+// ListDrones returns a page of drones.
+// Query: status, bbox, sort, cursor, limit, with_total
 func (h *DroneHandler) ListDrones(c *gin.Context) {
-	drones, err := h.droneService.ListDrones()
+	var filter domain.DroneFilter
+	var err error
+	if filter.ListOptions, err = parseListOptions(c); err == nil {
+		filter.BoundingBox, err = parseBoundingBox(c)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.Status = domain.DroneStatus(c.Query("status"))
+
+	page, err := h.droneService.ListDrones(c.Request.Context(), filter)
+	if err != nil {
+		if err == domain.ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
-	c.JSON(http.StatusOK, drones)
+	c.JSON(http.StatusOK, page)
 }
 
 func (h *DroneHandler) UpdateStatus(c *gin.Context) {
@@ -119,11 +173,22 @@ func (h *DroneHandler) UpdateStatus(c *gin.Context) {
 		return
 	}
 
-	err := h.droneService.UpdateStatus(id, domain.DroneStatus(req.Status))
+	ifMatch, err := parseIfMatch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	drone, err := h.droneService.UpdateStatus(c.Request.Context(), id, domain.DroneStatus(req.Status), ifMatch)
 	if err != nil {
+		if err == domain.ErrConflict {
+			writeConflict(c, ifMatch)
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	setETag(c, drone.Version)
 	c.JSON(http.StatusOK, gin.H{"message": "drone status updated successfully"})
 }
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +11,7 @@ import (
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/service"
 	"github.com/gin-gonic/gin"
+	"github.com/segmentio/ksuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -18,46 +20,46 @@ type MockDroneRepo struct {
 	mock.Mock
 }
 
-func (m *MockDroneRepo) CreateDrone(drone *domain.Drone) error {
+func (m *MockDroneRepo) CreateDrone(ctx context.Context, drone *domain.Drone) error {
 	args := m.Called(drone)
 	return args.Error(0)
 }
-func (m *MockDroneRepo) GetDroneByID(id string) (*domain.Drone, error) {
+func (m *MockDroneRepo) GetDroneByID(ctx context.Context, id string) (*domain.Drone, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Drone), args.Error(1)
 }
-func (m *MockDroneRepo) GetDroneByName(name string) (*domain.Drone, error) {
+func (m *MockDroneRepo) GetDroneByName(ctx context.Context, name string) (*domain.Drone, error) {
 	args := m.Called(name)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Drone), args.Error(1)
 }
-func (m *MockDroneRepo) GetIdleDrones() ([]*domain.Drone, error) {
+func (m *MockDroneRepo) GetIdleDrones(ctx context.Context) ([]*domain.Drone, error) {
 	args := m.Called()
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).([]*domain.Drone), args.Error(1)
 }
-func (m *MockDroneRepo) GetActiveDrones() ([]*domain.Drone, error) {
+func (m *MockDroneRepo) GetActiveDrones(ctx context.Context) ([]*domain.Drone, error) {
 	args := m.Called()
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).([]*domain.Drone), args.Error(1)
 }
-func (m *MockDroneRepo) GetAllDrones() ([]*domain.Drone, error) {
-	args := m.Called()
+func (m *MockDroneRepo) ListDrones(ctx context.Context, filter domain.DroneFilter) (*domain.Page[*domain.Drone], error) {
+	args := m.Called(filter)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).([]*domain.Drone), args.Error(1)
+	return args.Get(0).(*domain.Page[*domain.Drone]), args.Error(1)
 }
-func (m *MockDroneRepo) UpdateDrone(drone *domain.Drone) error {
+func (m *MockDroneRepo) UpdateDrone(ctx context.Context, drone *domain.Drone) error {
 	args := m.Called(drone)
 	return args.Error(0)
 }
@@ -93,11 +95,137 @@ func TestListDrones_Endpoint(t *testing.T) {
 	r := gin.New()
 	r.GET("/drones", handler.ListDrones)
 
-	mockRepo.On("GetAllDrones").Return([]*domain.Drone{{Name: "D1"}}, nil)
+	mockRepo.On("ListDrones", domain.DroneFilter{
+		Status:      domain.DroneStatusIdle,
+		BoundingBox: &domain.BoundingBox{MinLat: 29, MinLon: 30, MaxLat: 31, MaxLon: 32},
+		ListOptions: domain.ListOptions{Limit: 10, SortBy: domain.SortByUpdatedAt, Desc: true},
+	}).Return(&domain.Page[*domain.Drone]{Items: []*domain.Drone{{Name: "D1"}}}, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "/drones?status=IDLE&bbox=29,30,31,32&limit=10&sort=-updated_at", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var page domain.Page[domain.Drone]
+	json.Unmarshal(resp.Body.Bytes(), &page)
+	assert.Equal(t, "D1", page.Items[0].Name)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestListDrones_Endpoint_InvalidQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockDroneRepo)
+	handler := NewDroneHandler(service.NewDroneService(mockRepo, nil), nil)
+
+	r := gin.New()
+	r.GET("/drones", handler.ListDrones)
+
+	for _, query := range []string{"limit=abc", "sort=name", "bbox=1,2,3", "with_total=maybe"} {
+		req, _ := http.NewRequest(http.MethodGet, "/drones?"+query, nil)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code, query)
+	}
+	mockRepo.AssertNotCalled(t, "ListDrones", mock.Anything)
+}
+
+func TestDispatchPlan_Endpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	droneRepo := new(MockDroneRepo)
+	orderRepo := new(MockOrderRepo)
+	dispatcher := service.NewDispatcherService(droneRepo, orderRepo, service.DispatchRules{MaxPickupDistance: 5000})
+	handler := NewDroneHandler(service.NewDroneService(droneRepo, nil), dispatcher)
+
+	r := gin.New()
+	r.GET("/orders/:id/dispatch-plan", handler.DispatchPlan)
+
+	order := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusReserved, PickupLat: 30, PickupLon: 31}
+	near := &domain.Drone{ID: ksuid.New(), Name: "near", Status: domain.DroneStatusIdle, Latitude: 30.001, Longitude: 31}
+	far := &domain.Drone{ID: ksuid.New(), Name: "far", Status: domain.DroneStatusIdle, Latitude: 31, Longitude: 31}
+	orderRepo.On("GetOrderByID", order.ID.String()).Return(order, nil)
+	orderRepo.On("GetOrderByID", "missing").Return(nil, domain.ErrNotFound)
+	orderRepo.On("GetActiveOrdersByDroneID", mock.Anything).Return(nil, nil)
+	droneRepo.On("GetIdleDrones").Return([]*domain.Drone{far, near}, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "/orders/"+order.ID.String()+"/dispatch-plan", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var plan domain.DispatchPlan
+	json.Unmarshal(resp.Body.Bytes(), &plan)
+	if assert.Len(t, plan.Candidates, 2) {
+		assert.Equal(t, "near", plan.Candidates[0].DroneName)
+		assert.Equal(t, 1, plan.Candidates[0].Rank)
+		assert.Equal(t, domain.ExcludedByDistance, plan.Candidates[1].Exclusions[0].Reason)
+	}
+	assert.Zero(t, plan.QueuePosition) // Only pending orders queue
+
+	req, _ = http.NewRequest(http.MethodGet, "/orders/missing/dispatch-plan", nil)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestDroneRoute_Endpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	droneRepo := new(MockDroneRepo)
+	orderRepo := new(MockOrderRepo)
+	dispatcher := service.NewDispatcherService(droneRepo, orderRepo, service.DispatchRules{CruiseSpeed: 10})
+	handler := NewDroneHandler(service.NewDroneService(droneRepo, nil), dispatcher)
+
+	r := gin.New()
+	r.GET("/drones/:id/route", handler.Route)
+
+	drone := &domain.Drone{ID: ksuid.New(), Status: domain.DroneStatusDelivering, Capacity: 2, Latitude: 30, Longitude: 31}
+	onBoard := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPickedUp, DroneID: &drone.ID, DestLat: 30.02, DestLon: 31}
+	next := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusReserved, DroneID: &drone.ID, PickupLat: 30.01, PickupLon: 31, DestLat: 30.03, DestLon: 31}
+	droneRepo.On("GetDroneByID", drone.ID.String()).Return(drone, nil)
+	droneRepo.On("GetDroneByID", "missing").Return(nil, domain.ErrNotFound)
+	orderRepo.On("GetActiveOrdersByDroneID", drone.ID.String()).Return([]*domain.Order{onBoard, next}, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "/drones/"+drone.ID.String()+"/route", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var route domain.DroneRoute
+	json.Unmarshal(resp.Body.Bytes(), &route)
+	if assert.Len(t, route.Stops, 3) {
+		assert.Equal(t, domain.RouteStopPickup, route.Stops[0].Kind)
+		assert.Equal(t, onBoard.ID, route.Stops[1].OrderID)
+		assert.Equal(t, next.ID, route.Stops[2].OrderID)
+		assert.NotNil(t, route.Stops[2].ETA)
+	}
+	assert.Equal(t, route.Stops[2].Distance, route.Distance)
+
+	req, _ = http.NewRequest(http.MethodGet, "/drones/missing/route", nil)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestUpdateStatus_Endpoint_ReturnsNewETag(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	droneRepo := new(MockDroneRepo)
+	handler := NewDroneHandler(service.NewDroneService(droneRepo, nil), nil)
+
+	r := gin.New()
+	r.PATCH("/drones/:id/status", handler.UpdateStatus)
+
+	droneID := ksuid.New()
+	droneRepo.On("GetDroneByID", droneID.String()).Return(&domain.Drone{ID: droneID, Status: domain.DroneStatusBroken, Version: 5}, nil)
+	droneRepo.On("UpdateDrone", mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(*domain.Drone).Version++
+	}).Return(nil)
 
-	req, _ := http.NewRequest(http.MethodGet, "/drones", nil)
+	body, _ := json.Marshal(map[string]string{"status": string(domain.DroneStatusIdle)})
+	req, _ := http.NewRequest(http.MethodPatch, "/drones/"+droneID.String()+"/status", bytes.NewBuffer(body))
+	req.Header.Set("If-Match", `"5"`)
 	resp := httptest.NewRecorder()
 	r.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, `"6"`, resp.Header().Get("ETag"))
 }
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+// setETag exposes a record's version so clients can send it back in If-Match
+func setETag(c *gin.Context, version int) {
+	c.Header("ETag", `"`+strconv.Itoa(version)+`"`)
+}
+
+// parseIfMatch reads the expected version from If-Match.
+// A missing header or "*" means the caller accepts whatever version is current.
+func parseIfMatch(c *gin.Context) (int, error) {
+	value := strings.TrimSpace(c.GetHeader("If-Match"))
+	if value == "" || value == "*" {
+		return domain.AnyVersion, nil
+	}
+
+	value = strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, errors.New("If-Match must be an ETag returned by this API")
+	}
+	return version, nil
+}
+
+// writeConflict answers a lost optimistic-locking race: 412 when the client's
+// If-Match is stale, 409 when the server gave up retrying on its own.
+func writeConflict(c *gin.Context, ifMatch int) {
+	if ifMatch != domain.AnyVersion {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "order or drone was modified, fetch it again and retry"})
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": domain.ErrConflict.Error()})
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/health"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func readiness(checker *health.Checker) (*httptest.ResponseRecorder, health.Report) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/readyz", NewHealthHandler(checker).Readiness)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	r.ServeHTTP(w, req)
+
+	var report health.Report
+	json.Unmarshal(w.Body.Bytes(), &report)
+	return w, report
+}
+
+func TestReadiness_DegradedIsReady(t *testing.T) {
+	checker := health.NewChecker()
+	checker.Add("database", true, func(ctx context.Context) error { return nil })
+	checker.Add("redis", false, health.Unavailable(errors.New("connection refused")))
+
+	w, report := readiness(checker)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, health.StatusDegraded, report.Status)
+	assert.Equal(t, "connection refused", report.Checks["redis"].Error)
+}
+
+func TestReadiness_DatabaseDown(t *testing.T) {
+	checker := health.NewChecker()
+	checker.Add("database", true, health.Unavailable(errors.New("connection refused")))
+
+	w, report := readiness(checker)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, health.StatusDown, report.Status)
+}
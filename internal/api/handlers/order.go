@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/service"
@@ -18,26 +22,87 @@ func NewOrderHandler(orderService *service.OrderService) *OrderHandler {
 }
 
 type CreateOrderRequest struct {
-	OriginLat float64 `json:"origin_lat" binding:"required"`
-	OriginLon float64 `json:"origin_lon" binding:"required"`
-	DestLat   float64 `json:"dest_lat" binding:"required"`
-	DestLon   float64 `json:"dest_lon" binding:"required"`
+	OriginLat float64              `json:"origin_lat" binding:"required"`
+	OriginLon float64              `json:"origin_lon" binding:"required"`
+	DestLat   float64              `json:"dest_lat" binding:"required"`
+	DestLon   float64              `json:"dest_lon" binding:"required"`
+	Priority  domain.OrderPriority `json:"priority"`   // MEDICAL, EXPRESS or STANDARD (default)
+	DeliverBy *time.Time           `json:"deliver_by"` // RFC 3339; defaults to the promise of the priority
+	// DeliverAfter (RFC 3339) opens a delivery window; the order is not dispatched early
+	DeliverAfter *time.Time `json:"deliver_after"`
 }
 
+func (r CreateOrderRequest) order() service.OrderRequest {
+	return service.OrderRequest{
+		OriginLat: r.OriginLat, OriginLon: r.OriginLon, DestLat: r.DestLat, DestLon: r.DestLon,
+		Priority: r.Priority, DeliverAfter: r.DeliverAfter, DeliverBy: r.DeliverBy,
+	}
+}
+
+// maxIdempotencyKeyLength matches the idempotency_keys.key column
+const maxIdempotencyKeyLength = 255
+
+// CreateOrder creates an order. Clients on unreliable networks should send an
+// Idempotency-Key header so retries return the original order instead of a duplicate.
 func (h *OrderHandler) CreateOrder(c *gin.Context) {
 	var req CreateOrderRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	req.Priority = domain.OrderPriority(strings.ToUpper(string(req.Priority)))
+	if req.Priority != "" && !req.Priority.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "priority must be one of MEDICAL, EXPRESS, STANDARD"})
+		return
+	}
+
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		h.createOrderIdempotent(c, key, req)
+		return
+	}
+
+	order, err := h.orderService.CreateOrder(c.Request.Context(), actorFromContext(c), req.order())
+	if err != nil {
+		if err == domain.ErrDeadlineTooEarly || err == domain.ErrInvalidWindow {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		slog.Error("failed to create order", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create order"})
+		return
+	}
+
+	setETag(c, order.Version)
+	c.JSON(http.StatusCreated, order)
+}
+
+func (h *OrderHandler) createOrderIdempotent(c *gin.Context, key string, req CreateOrderRequest) {
+	if len(key) > maxIdempotencyKeyLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+		return
+	}
 
-	order, err := h.orderService.CreateOrder(req.OriginLat, req.OriginLon, req.DestLat, req.DestLon)
+	order, replayed, err := h.orderService.CreateOrderIdempotent(c.Request.Context(), actorFromContext(c), key, req.order())
 	if err != nil {
+		switch err {
+		case domain.ErrIdempotencyKeyReused:
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		case domain.ErrDeadlineTooEarly, domain.ErrInvalidWindow:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		slog.Error("failed to create order", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create order"})
 		return
 	}
 
+	setETag(c, order.Version)
+	if replayed {
+		c.Header("Idempotent-Replayed", "true")
+		c.JSON(http.StatusOK, order)
+		return
+	}
 	c.JSON(http.StatusCreated, order)
 }
 
@@ -45,6 +110,7 @@ func (h *OrderHandler) UpdateStatus(c *gin.Context) {
 	id := c.Param("id")
 	var req struct {
 		Status domain.OrderStatus `json:"status" binding:"required"`
+		Reason string             `json:"reason"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -52,17 +118,28 @@ func (h *OrderHandler) UpdateStatus(c *gin.Context) {
 		return
 	}
 
-	order, err := h.orderService.UpdateOrderState(id, req.Status)
+	ifMatch, err := parseIfMatch(c)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	order, err := h.orderService.UpdateOrderState(c.Request.Context(), id, req.Status, actorFromContext(c), req.Reason, ifMatch)
+	if err != nil {
+		if err == domain.ErrConflict {
+			writeConflict(c, ifMatch)
+			return
+		}
 		c.JSON(http.StatusConflict, gin.H{"error": err.Error()}) // Conflict for invalid state transition
 		return
 	}
 
+	setETag(c, order.Version)
 	c.JSON(http.StatusOK, order)
 }
 func (h *OrderHandler) GetOrder(c *gin.Context) {
 	id := c.Param("id")
-	order, err := h.orderService.GetOrder(id)
+	order, err := h.orderService.GetOrder(c.Request.Context(), id)
 	if err != nil {
 		if err == domain.ErrNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
@@ -72,24 +149,92 @@ func (h *OrderHandler) GetOrder(c *gin.Context) {
 		return
 	}
 
+	setETag(c, order.Version)
 	c.JSON(http.StatusOK, order)
 }
 
+// GetOrderHistory returns the audit trail of an order's status transitions
+func (h *OrderHandler) GetOrderHistory(c *gin.Context) {
+	id := c.Param("id")
+	events, err := h.orderService.GetOrderHistory(c.Request.Context(), id)
+	if err != nil {
+		if err == domain.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+// ListOrders returns a page of orders.
+// Query: status, priority, drone_id, at_risk, created_after, created_before, bbox, sort, cursor, limit, with_total
 func (h *OrderHandler) ListOrders(c *gin.Context) {
-	orders, err := h.orderService.ListOrders()
+	filter, err := parseOrderFilter(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, orders)
+
+	page, err := h.orderService.ListOrders(c.Request.Context(), filter)
+	if err != nil {
+		if err == domain.ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func parseOrderFilter(c *gin.Context) (domain.OrderFilter, error) {
+	var filter domain.OrderFilter
+	var err error
+
+	if filter.ListOptions, err = parseListOptions(c, domain.SortByDispatchBy); err != nil {
+		return filter, err
+	}
+	if filter.CreatedAfter, err = parseTimeQuery(c, "created_after"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedBefore, err = parseTimeQuery(c, "created_before"); err != nil {
+		return filter, err
+	}
+	if filter.BoundingBox, err = parseBoundingBox(c); err != nil {
+		return filter, err
+	}
+	if raw := c.Query("at_risk"); raw != "" {
+		if filter.AtRisk, err = strconv.ParseBool(raw); err != nil {
+			return filter, fmt.Errorf("at_risk must be true or false")
+		}
+	}
+	filter.Status = domain.OrderStatus(c.Query("status"))
+	filter.Priority = domain.OrderPriority(strings.ToUpper(c.Query("priority")))
+	filter.DroneID = c.Query("drone_id")
+	return filter, nil
 }
 
 func (h *OrderHandler) WithdrawOrder(c *gin.Context) {
 	id := c.Param("id")
-	if err := h.orderService.WithdrawOrder(id); err != nil {
+	ifMatch, err := parseIfMatch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	order, err := h.orderService.WithdrawOrder(c.Request.Context(), id, actorFromContext(c), ifMatch)
+	if err != nil {
+		if err == domain.ErrConflict {
+			writeConflict(c, ifMatch)
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	setETag(c, order.Version)
 	c.JSON(http.StatusOK, gin.H{"message": "order withdrawn successfully"})
 }
 
@@ -107,11 +252,31 @@ func (h *OrderHandler) UpdateDestination(c *gin.Context) {
 		return
 	}
 
-	err := h.orderService.UpdateOrderCoords(id, req.OriginLat, req.OriginLon, req.DestLat, req.DestLon)
+	ifMatch, err := parseIfMatch(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	order, err := h.orderService.UpdateOrderCoords(c.Request.Context(), id, ifMatch, req.OriginLat, req.OriginLon, req.DestLat, req.DestLon)
+	if err != nil {
+		if err == domain.ErrConflict {
+			writeConflict(c, ifMatch)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	setETag(c, order.Version)
 	c.JSON(http.StatusOK, gin.H{"message": "order destination updated successfully"})
 }
+
+// actorFromContext identifies the caller from the JWT claims set by AuthMiddleware
+func actorFromContext(c *gin.Context) string {
+	user := c.GetString("user")
+	if user == "" {
+		return "unknown"
+	}
+	return c.GetString("role") + ":" + user
+}
@@ -2,12 +2,15 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/infrastructure/membus"
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/service"
 	"github.com/gin-gonic/gin"
 	"github.com/segmentio/ksuid"
@@ -25,58 +28,130 @@ type MockOrderRepo struct {
 	mock.Mock
 }
 
-func (m *MockOrderRepo) CreateOrder(order *domain.Order) error {
+func (m *MockOrderRepo) CreateOrder(ctx context.Context, order *domain.Order) error {
 	args := m.Called(order)
 	return args.Error(0)
 }
-func (m *MockOrderRepo) GetOrderByID(id string) (*domain.Order, error) {
+func (m *MockOrderRepo) CreateOrderIdempotent(ctx context.Context, order *domain.Order, key *domain.IdempotencyKey) (*domain.Order, bool, error) {
+	args := m.Called(order, key)
+	if args.Get(0) == nil {
+		return nil, args.Bool(1), args.Error(2)
+	}
+	return args.Get(0).(*domain.Order), args.Bool(1), args.Error(2)
+}
+func (m *MockOrderRepo) GetOrderByID(ctx context.Context, id string) (*domain.Order, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Order), args.Error(1)
 }
-func (m *MockOrderRepo) GetActiveOrderByDroneID(droneID string) (*domain.Order, error) {
+func (m *MockOrderRepo) GetActiveOrdersByDroneID(ctx context.Context, droneID string) ([]*domain.Order, error) {
 	args := m.Called(droneID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).(*domain.Order), args.Error(1)
+	return args.Get(0).([]*domain.Order), args.Error(1)
 }
-func (m *MockOrderRepo) GetNextPendingOrder() (*domain.Order, error) {
+func (m *MockOrderRepo) GetNextPendingOrder(ctx context.Context) (*domain.Order, error) {
 	args := m.Called()
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Order), args.Error(1)
 }
-func (m *MockOrderRepo) ClaimNextPendingOrder(droneID string) (*domain.Order, error) {
+func (m *MockOrderRepo) ClaimNextPendingOrder(ctx context.Context, droneID string) (*domain.Order, error) {
 	args := m.Called(droneID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Order), args.Error(1)
 }
-func (m *MockOrderRepo) GetAllOrders() ([]*domain.Order, error) {
-	args := m.Called()
+func (m *MockOrderRepo) ClaimNextPendingOrderInWindow(ctx context.Context, droneID string, trip domain.TripEstimate) (*domain.Order, error) {
+	args := m.Called(droneID, trip)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Order), args.Error(1)
+}
+func (m *MockOrderRepo) FlagOrdersAtRisk(ctx context.Context, deadline time.Time) ([]*domain.Order, error) {
+	args := m.Called(deadline)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Order), args.Error(1)
+}
+
+func (m *MockOrderRepo) ReleaseScheduledOrders(ctx context.Context, now time.Time) ([]*domain.Order, error) {
+	args := m.Called(now)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Order), args.Error(1)
+}
+
+func (m *MockOrderRepo) ReserveAssignments(ctx context.Context, pairs []domain.Assignment) ([]*domain.Order, error) {
+	args := m.Called(pairs)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).([]*domain.Order), args.Error(1)
 }
-func (m *MockOrderRepo) UpdateOrder(order *domain.Order) error {
+
+func (m *MockOrderRepo) ClaimPendingOrder(ctx context.Context, orderID, droneID string) (*domain.Order, error) {
+	args := m.Called(orderID, droneID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Order), args.Error(1)
+}
+func (m *MockOrderRepo) ListOrders(ctx context.Context, filter domain.OrderFilter) (*domain.Page[*domain.Order], error) {
+	args := m.Called(filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Page[*domain.Order]), args.Error(1)
+}
+func (m *MockOrderRepo) UpdateOrder(ctx context.Context, order *domain.Order) error {
 	args := m.Called(order)
 	return args.Error(0)
 }
-func (m *MockOrderRepo) UpdateOrderCoords(id string, oLat, oLon, dLat, dLon float64) error {
-	args := m.Called(id, oLat, oLon, dLat, dLon)
+func (m *MockOrderRepo) UpdateOrderCoords(ctx context.Context, order *domain.Order) error {
+	args := m.Called(order)
+	return args.Error(0)
+}
+
+func (m *MockOrderRepo) AppendOrderEvent(ctx context.Context, event *domain.OrderEvent) error {
+	args := m.Called(event)
+	return args.Error(0)
+}
+func (m *MockOrderRepo) GetOrderEvents(ctx context.Context, orderID string) ([]*domain.OrderEvent, error) {
+	args := m.Called(orderID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.OrderEvent), args.Error(1)
+}
+func (m *MockOrderRepo) CreateOrderLeg(ctx context.Context, leg *domain.OrderLeg) error {
+	args := m.Called(leg)
+	return args.Error(0)
+}
+func (m *MockOrderRepo) GetOrderLegs(ctx context.Context, orderID string) ([]*domain.OrderLeg, error) {
+	args := m.Called(orderID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.OrderLeg), args.Error(1)
+}
+func (m *MockOrderRepo) UpdateOrderLeg(ctx context.Context, leg *domain.OrderLeg) error {
+	args := m.Called(leg)
 	return args.Error(0)
 }
 
 func TestCreateOrder_Endpoint(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	mockRepo := new(MockOrderRepo)
-	orderService := service.NewOrderService(mockRepo, nil)
+	orderService := service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{})
 	handler := NewOrderHandler(orderService)
 
 	r := gin.New()
@@ -88,6 +163,7 @@ func TestCreateOrder_Endpoint(t *testing.T) {
 	body, _ := json.Marshal(reqBody)
 
 	mockRepo.On("CreateOrder", mock.Anything).Return(nil)
+	mockRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
 
 	req, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewBuffer(body))
 	resp := httptest.NewRecorder()
@@ -99,22 +175,399 @@ func TestCreateOrder_Endpoint(t *testing.T) {
 	assert.Equal(t, domain.OrderStatusPending, order.Status)
 }
 
+func TestCreateOrder_Endpoint_Priority(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	sla := service.SLAPolicy{Promise: map[domain.OrderPriority]time.Duration{domain.OrderPriorityMedical: time.Hour}}
+	handler := NewOrderHandler(service.NewOrderService(mockRepo, membus.New(), nil, sla))
+
+	r := gin.New()
+	r.POST("/orders", handler.CreateOrder)
+
+	mockRepo.On("CreateOrder", mock.Anything).Return(nil)
+	mockRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(body))
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := post(`{"origin_lat": 10, "origin_lon": 10, "dest_lat": 20, "dest_lon": 20, "priority": "medical"}`)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	var order domain.Order
+	json.Unmarshal(resp.Body.Bytes(), &order)
+	assert.Equal(t, domain.OrderPriorityMedical, order.Priority)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), order.DeliverBy, time.Minute)
+
+	resp = post(`{"origin_lat": 10, "origin_lon": 10, "dest_lat": 20, "dest_lon": 20, "priority": "urgent"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	soon := time.Now().Add(10 * time.Minute).Format(time.RFC3339)
+	resp = post(`{"origin_lat": 10, "origin_lon": 10, "dest_lat": 20, "dest_lon": 20, "priority": "MEDICAL", "deliver_by": "` + soon + `"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Contains(t, resp.Body.String(), "deliver_by")
+}
+
+func TestCreateOrder_Endpoint_DeliveryWindow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	sla := service.SLAPolicy{Promise: map[domain.OrderPriority]time.Duration{domain.OrderPriorityMedical: time.Hour}}
+	handler := NewOrderHandler(service.NewOrderService(mockRepo, membus.New(), nil, sla))
+
+	r := gin.New()
+	r.POST("/orders", handler.CreateOrder)
+
+	mockRepo.On("CreateOrder", mock.Anything).Return(nil)
+	mockRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(body))
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	opens := time.Now().Add(6 * time.Hour).Format(time.RFC3339)
+	closes := time.Now().Add(6*time.Hour + 30*time.Minute).Format(time.RFC3339)
+	resp := post(`{"origin_lat": 10, "origin_lon": 10, "dest_lat": 20, "dest_lon": 20, "priority": "MEDICAL", "deliver_after": "` + opens + `", "deliver_by": "` + closes + `"}`)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	var order domain.Order
+	json.Unmarshal(resp.Body.Bytes(), &order)
+	assert.Equal(t, domain.OrderStatusScheduled, order.Status)
+	assert.NotNil(t, order.DispatchAfter)
+
+	resp = post(`{"origin_lat": 10, "origin_lon": 10, "dest_lat": 20, "dest_lon": 20, "priority": "MEDICAL", "deliver_after": "` + closes + `", "deliver_by": "` + opens + `"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Contains(t, resp.Body.String(), "deliver_after")
+}
+
+func TestCreateOrder_Endpoint_IdempotentReplay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	handler := NewOrderHandler(service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{}))
+
+	r := gin.New()
+	r.POST("/orders", handler.CreateOrder)
+
+	original := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending}
+	mockRepo.On("CreateOrderIdempotent", mock.Anything, mock.MatchedBy(func(k *domain.IdempotencyKey) bool {
+		return k.Key == "retry-1"
+	})).Return(original, true, nil)
+
+	body, _ := json.Marshal(CreateOrderRequest{OriginLat: 10, OriginLon: 10, DestLat: 20, DestLon: 20})
+	req, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewBuffer(body))
+	req.Header.Set("Idempotency-Key", "retry-1")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "true", resp.Header().Get("Idempotent-Replayed"))
+	var order domain.Order
+	json.Unmarshal(resp.Body.Bytes(), &order)
+	assert.Equal(t, original.ID, order.ID)
+	mockRepo.AssertNotCalled(t, "AppendOrderEvent", mock.Anything)
+}
+
+func TestCreateOrder_Endpoint_IdempotencyKeyReused(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	handler := NewOrderHandler(service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{}))
+
+	r := gin.New()
+	r.POST("/orders", handler.CreateOrder)
+
+	mockRepo.On("CreateOrderIdempotent", mock.Anything, mock.Anything).Return(nil, false, domain.ErrIdempotencyKeyReused)
+
+	body, _ := json.Marshal(CreateOrderRequest{OriginLat: 10, OriginLon: 10, DestLat: 20, DestLon: 20})
+	req, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewBuffer(body))
+	req.Header.Set("Idempotency-Key", "retry-1")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+}
+
+func TestUpdateStatus_Endpoint_StaleIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	handler := NewOrderHandler(service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{}))
+
+	r := gin.New()
+	r.POST("/orders/:id/status", handler.UpdateStatus)
+
+	orderID := ksuid.New()
+	mockRepo.On("GetOrderByID", orderID.String()).Return(&domain.Order{ID: orderID, Status: domain.OrderStatusPickedUp, Version: 3}, nil)
+
+	body, _ := json.Marshal(map[string]string{"status": string(domain.OrderStatusDelivered)})
+	req, _ := http.NewRequest(http.MethodPost, "/orders/"+orderID.String()+"/status", bytes.NewBuffer(body))
+	req.Header.Set("If-Match", `"2"`)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code)
+	mockRepo.AssertNotCalled(t, "UpdateOrder", mock.Anything)
+}
+
+func TestUpdateStatus_Endpoint_MatchingIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	handler := NewOrderHandler(service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{}))
+
+	r := gin.New()
+	r.POST("/orders/:id/status", handler.UpdateStatus)
+
+	orderID := ksuid.New()
+	mockRepo.On("GetOrderByID", orderID.String()).Return(&domain.Order{ID: orderID, Status: domain.OrderStatusPickedUp, Version: 3}, nil)
+	mockRepo.On("UpdateOrder", mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(*domain.Order).Version++
+	}).Return(nil)
+	mockRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(map[string]string{"status": string(domain.OrderStatusDelivered)})
+	req, _ := http.NewRequest(http.MethodPost, "/orders/"+orderID.String()+"/status", bytes.NewBuffer(body))
+	req.Header.Set("If-Match", `"3"`)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, `"4"`, resp.Header().Get("ETag"))
+}
+
+func TestWithdrawOrder_Endpoint_ReturnsNewETag(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	handler := NewOrderHandler(service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{}))
+
+	r := gin.New()
+	r.DELETE("/orders/:id", handler.WithdrawOrder)
+
+	orderID := ksuid.New()
+	mockRepo.On("GetOrderByID", orderID.String()).Return(&domain.Order{ID: orderID, Status: domain.OrderStatusPending, Version: 2}, nil)
+	mockRepo.On("UpdateOrder", mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(*domain.Order).Version++
+	}).Return(nil)
+	mockRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
+
+	req, _ := http.NewRequest(http.MethodDelete, "/orders/"+orderID.String(), nil)
+	req.Header.Set("If-Match", `"2"`)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, `"3"`, resp.Header().Get("ETag"))
+}
+
+func TestUpdateDestination_Endpoint_ReturnsNewETag(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	handler := NewOrderHandler(service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{}))
+
+	r := gin.New()
+	r.PATCH("/orders/:id", handler.UpdateDestination)
+
+	orderID := ksuid.New()
+	mockRepo.On("GetOrderByID", orderID.String()).Return(&domain.Order{ID: orderID, Status: domain.OrderStatusPending, Version: 7}, nil)
+	mockRepo.On("UpdateOrderCoords", mock.MatchedBy(func(o *domain.Order) bool {
+		return o.Version == 7 && o.PickupLat == 30 && o.DestLon == 31.1
+	})).Run(func(args mock.Arguments) {
+		args.Get(0).(*domain.Order).Version++
+	}).Return(nil)
+
+	body, _ := json.Marshal(map[string]float64{"origin_lat": 30, "origin_lon": 31, "dest_lat": 30.1, "dest_lon": 31.1})
+	req, _ := http.NewRequest(http.MethodPatch, "/orders/"+orderID.String(), bytes.NewBuffer(body))
+	req.Header.Set("If-Match", `"7"`)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, `"8"`, resp.Header().Get("ETag"))
+}
+
+func TestUpdateStatus_Endpoint_MalformedIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	handler := NewOrderHandler(service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{}))
+
+	r := gin.New()
+	r.POST("/orders/:id/status", handler.UpdateStatus)
+
+	body, _ := json.Marshal(map[string]string{"status": string(domain.OrderStatusDelivered)})
+	req, _ := http.NewRequest(http.MethodPost, "/orders/"+ksuid.New().String()+"/status", bytes.NewBuffer(body))
+	req.Header.Set("If-Match", "not-a-version")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
 func TestGetOrder_Endpoint(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	mockRepo := new(MockOrderRepo)
-	orderService := service.NewOrderService(mockRepo, nil)
+	orderService := service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{})
 	handler := NewOrderHandler(orderService)
 
 	r := gin.New()
 	r.GET("/orders/:id", handler.GetOrder)
 
 	orderID := ksuid.New()
+	droneA, droneB := ksuid.New(), ksuid.New()
+	legs := []*domain.OrderLeg{
+		{ID: ksuid.New(), OrderID: orderID, DroneID: droneA, Outcome: domain.LegOutcomeInterrupted},
+		{ID: ksuid.New(), OrderID: orderID, DroneID: droneB, Outcome: domain.LegOutcomeInProgress},
+	}
 	mockRepo.On("GetOrderByID", orderID.String()).Return(&domain.Order{ID: orderID, Status: domain.OrderStatusPending}, nil)
+	mockRepo.On("GetOrderLegs", orderID.String()).Return(legs, nil)
 
 	req, _ := http.NewRequest(http.MethodGet, "/orders/"+orderID.String(), nil)
 	resp := httptest.NewRecorder()
 	r.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusOK, resp.Code)
+	var order domain.Order
+	json.Unmarshal(resp.Body.Bytes(), &order)
+	assert.Len(t, order.Legs, 2)
+	assert.Equal(t, droneA, order.Legs[0].DroneID)
 	mockRepo.AssertExpectations(t)
 }
+
+func TestListOrders_Endpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	handler := NewOrderHandler(service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{}))
+
+	r := gin.New()
+	r.GET("/orders", handler.ListOrders)
+
+	after := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mockRepo.On("ListOrders", domain.OrderFilter{
+		Status:       domain.OrderStatusPending,
+		DroneID:      "drone-1",
+		CreatedAfter: &after,
+		ListOptions:  domain.ListOptions{Cursor: "abc", Limit: 2, WithTotal: true},
+	}).Return(&domain.Page[*domain.Order]{
+		Items:      []*domain.Order{{ID: ksuid.New()}, {ID: ksuid.New()}},
+		Total:      5,
+		NextCursor: "next",
+	}, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "/orders?status=PENDING&drone_id=drone-1&created_after=2026-01-01T00:00:00Z&cursor=abc&limit=2&with_total=true", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var page domain.Page[domain.Order]
+	json.Unmarshal(resp.Body.Bytes(), &page)
+	assert.Len(t, page.Items, 2)
+	assert.Equal(t, 5, page.Total)
+	assert.Equal(t, "next", page.NextCursor)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestListOrders_Endpoint_CountsOnlyWhenAsked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	handler := NewOrderHandler(service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{}))
+
+	r := gin.New()
+	r.GET("/orders", handler.ListOrders)
+
+	mockRepo.On("ListOrders", mock.MatchedBy(func(f domain.OrderFilter) bool {
+		return !f.WithTotal
+	})).Return(&domain.Page[*domain.Order]{Items: []*domain.Order{{ID: ksuid.New()}}}, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "/orders?status=PENDING", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotContains(t, resp.Body.String(), `"total"`)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestListOrders_Endpoint_DispatchQueue(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	handler := NewOrderHandler(service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{}))
+
+	r := gin.New()
+	r.GET("/orders", handler.ListOrders)
+
+	mockRepo.On("ListOrders", domain.OrderFilter{
+		Status:      domain.OrderStatusPending,
+		Priority:    domain.OrderPriorityExpress,
+		AtRisk:      true,
+		ListOptions: domain.ListOptions{SortBy: domain.SortByDispatchBy},
+	}).Return(&domain.Page[*domain.Order]{}, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "/orders?status=PENDING&priority=express&at_risk=true&sort=dispatch_by", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestListOrders_Endpoint_InvalidCursor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	handler := NewOrderHandler(service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{}))
+
+	r := gin.New()
+	r.GET("/orders", handler.ListOrders)
+
+	mockRepo.On("ListOrders", mock.Anything).Return(nil, domain.ErrInvalidCursor)
+
+	req, _ := http.NewRequest(http.MethodGet, "/orders?cursor=garbage", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestGetOrderHistory_Endpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	orderService := service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{})
+	handler := NewOrderHandler(orderService)
+
+	r := gin.New()
+	r.GET("/orders/:id/history", handler.GetOrderHistory)
+
+	orderID := ksuid.New()
+	events := []*domain.OrderEvent{
+		{ID: ksuid.New(), OrderID: orderID, ToStatus: domain.OrderStatusPending, Actor: "enduser:alice"},
+		{ID: ksuid.New(), OrderID: orderID, FromStatus: domain.OrderStatusPending, ToStatus: domain.OrderStatusReserved, Actor: "system:dispatcher"},
+	}
+	mockRepo.On("GetOrderByID", orderID.String()).Return(&domain.Order{ID: orderID}, nil)
+	mockRepo.On("GetOrderEvents", orderID.String()).Return(events, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "/orders/"+orderID.String()+"/history", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var got []domain.OrderEvent
+	json.Unmarshal(resp.Body.Bytes(), &got)
+	assert.Len(t, got, 2)
+	assert.Equal(t, "system:dispatcher", got[1].Actor)
+}
+
+func TestGetOrderHistory_Endpoint_NotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := new(MockOrderRepo)
+	handler := NewOrderHandler(service.NewOrderService(mockRepo, membus.New(), nil, service.SLAPolicy{}))
+
+	r := gin.New()
+	r.GET("/orders/:id/history", handler.GetOrderHistory)
+
+	mockRepo.On("GetOrderByID", "missing").Return(nil, domain.ErrNotFound)
+
+	req, _ := http.NewRequest(http.MethodGet, "/orders/missing/history", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	mockRepo.AssertNotCalled(t, "GetOrderEvents", mock.Anything)
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+// parseListOptions reads ?cursor=&limit=&sort=&with_total= from the query string.
+// sort is a field name, prefixed with '-' for descending order (e.g. -created_at);
+// extra lists the fields the endpoint accepts beyond created_at and updated_at.
+// Counting the total costs a query over every match, so pages carry it only on request.
+func parseListOptions(c *gin.Context, extra ...domain.SortField) (domain.ListOptions, error) {
+	opts := domain.ListOptions{Cursor: c.Query("cursor")}
+
+	if raw := c.Query("with_total"); raw != "" {
+		withTotal, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("with_total must be true or false")
+		}
+		opts.WithTotal = withTotal
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return opts, fmt.Errorf("limit must be a positive integer")
+		}
+		opts.Limit = n
+	}
+
+	if sort := c.Query("sort"); sort != "" {
+		if strings.HasPrefix(sort, "-") {
+			opts.Desc = true
+			sort = sort[1:]
+		}
+		allowed := append([]domain.SortField{domain.SortByCreatedAt, domain.SortByUpdatedAt}, extra...)
+		if !slices.Contains(allowed, domain.SortField(sort)) {
+			names := make([]string, len(allowed))
+			for i, field := range allowed {
+				names[i] = string(field)
+			}
+			return opts, fmt.Errorf("sort must be one of %s (prefix with - for descending)", strings.Join(names, ", "))
+		}
+		opts.SortBy = domain.SortField(sort)
+	}
+	return opts, nil
+}
+
+// parseBoundingBox reads ?bbox=minLat,minLon,maxLat,maxLon
+func parseBoundingBox(c *gin.Context) (*domain.BoundingBox, error) {
+	raw := c.Query("bbox")
+	if raw == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(raw, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("bbox must be minLat,minLon,maxLat,maxLon")
+	}
+	var values [4]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bbox must be minLat,minLon,maxLat,maxLon")
+		}
+		values[i] = v
+	}
+	if values[0] > values[2] || values[1] > values[3] {
+		return nil, fmt.Errorf("bbox minimums must not exceed maximums")
+	}
+	return &domain.BoundingBox{MinLat: values[0], MinLon: values[1], MaxLat: values[2], MaxLon: values[3]}, nil
+}
+
+// parseTimeQuery reads an optional RFC 3339 timestamp from the query string
+func parseTimeQuery(c *gin.Context, key string) (*time.Time, error) {
+	raw := c.Query(key)
+	if raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC 3339 timestamp", key)
+	}
+	return &t, nil
+}
@@ -10,11 +10,17 @@ import (
 func SetupRouter(
 	droneHandler *handlers.DroneHandler,
 	orderHandler *handlers.OrderHandler,
+	healthHandler *handlers.HealthHandler,
 ) *gin.Engine {
 	r := gin.New()
 
 	// Middleware
 	r.Use(gin.Recovery())
+
+	// Probes are registered before the telemetry middleware so they are not traced
+	r.GET("/healthz", healthHandler.Liveness)
+	r.GET("/readyz", healthHandler.Readiness)
+
 	r.Use(middleware.TelemetryMiddleware("drone-backend"))
 
 	// Public Routes
@@ -30,12 +36,15 @@ func SetupRouter(
 		api.POST("/drones", droneHandler.Register)
 		api.POST("/drones/location", droneHandler.UpdateLocation)
 		api.PATCH("/drones/:id/status", droneHandler.UpdateStatus)
+		api.GET("/drones/:id/route", droneHandler.Route)
 		api.POST("/drones/jobs/reserve", droneHandler.ReserveJob)
 
 		// Order Routes
 		api.GET("/orders", orderHandler.ListOrders)
 		api.POST("/orders", orderHandler.CreateOrder)
 		api.GET("/orders/:id", orderHandler.GetOrder)
+		api.GET("/orders/:id/history", orderHandler.GetOrderHistory)
+		api.GET("/orders/:id/dispatch-plan", droneHandler.DispatchPlan)
 		api.PATCH("/orders/:id", orderHandler.UpdateDestination)
 		api.POST("/orders/:id/status", orderHandler.UpdateStatus)
 		api.DELETE("/orders/:id", orderHandler.WithdrawOrder)
@@ -7,9 +7,12 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// SecretKey is the secret used to sign tokens. In production, load this from environment variables.
+// SecretKey is the secret used to sign tokens; main sets it from auth.jwt_secret
 var SecretKey = []byte("super-secret-key-change-me")
 
+// TokenTTL is the lifetime of issued tokens
+var TokenTTL = 24 * time.Hour
+
 // Claims represents the JWT claims
 type Claims struct {
 	Name     string `json:"name"`
@@ -19,7 +22,7 @@ type Claims struct {
 
 // GenerateToken creates a new JWT token for a user
 func GenerateToken(name, userType string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+	expirationTime := time.Now().Add(TokenTTL)
 	claims := &Claims{
 		Name:     name,
 		UserType: userType,
@@ -0,0 +1,240 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+)
+
+// Client calls the HTTP API (see internal/api) for the command line tools
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// Error is a non-2xx answer of the API
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func New(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// SetToken sets the bearer token sent with every request
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// IssueToken asks the API for a token for name acting as userType (admin, enduser or drone)
+func (c *Client) IssueToken(ctx context.Context, name, userType string) (string, error) {
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := c.do(ctx, http.MethodPost, "/auth/token", nil, map[string]string{"name": name, "user_type": userType}, &resp)
+	return resp.AccessToken, err
+}
+
+// RegisterDrone adds a drone carrying up to capacity orders per trip; 0 means one
+func (c *Client) RegisterDrone(ctx context.Context, name string, capacity int) (*domain.Drone, error) {
+	body := map[string]interface{}{"name": name}
+	if capacity > 0 {
+		body["capacity"] = capacity
+	}
+	var drone domain.Drone
+	if err := c.do(ctx, http.MethodPost, "/api/v1/drones", nil, body, &drone); err != nil {
+		return nil, err
+	}
+	return &drone, nil
+}
+
+// ListDrones returns one page; query takes the API's filters (status, bbox, sort, cursor, limit)
+func (c *Client) ListDrones(ctx context.Context, query url.Values) (*domain.Page[domain.Drone], error) {
+	var page domain.Page[domain.Drone]
+	if err := c.do(ctx, http.MethodGet, "/api/v1/drones?"+query.Encode(), nil, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// UpdateDroneStatus changes a drone's status; ifMatch is the expected version, or domain.AnyVersion
+func (c *Client) UpdateDroneStatus(ctx context.Context, id string, status domain.DroneStatus, ifMatch int) error {
+	return c.do(ctx, http.MethodPatch, "/api/v1/drones/"+url.PathEscape(id)+"/status", ifMatchHeader(ifMatch),
+		map[string]string{"status": string(status)}, nil)
+}
+
+// UpdateLocation reports the position of the drone the token belongs to
+func (c *Client) UpdateLocation(ctx context.Context, lat, lon float64) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/drones/location", nil, map[string]float64{"latitude": lat, "longitude": lon}, nil)
+}
+
+// DroneRoute returns the stops left on a drone's trip, in flight order
+func (c *Client) DroneRoute(ctx context.Context, id string) (*domain.DroneRoute, error) {
+	var route domain.DroneRoute
+	if err := c.do(ctx, http.MethodGet, "/api/v1/drones/"+url.PathEscape(id)+"/route", nil, nil, &route); err != nil {
+		return nil, err
+	}
+	return &route, nil
+}
+
+// ReserveJob assigns the oldest pending order to the drone
+func (c *Client) ReserveJob(ctx context.Context, droneID string) (*domain.Order, error) {
+	var order domain.Order
+	if err := c.do(ctx, http.MethodPost, "/api/v1/drones/jobs/reserve", nil, map[string]string{"drone_id": droneID}, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// CreateOrderRequest is the body of POST /api/v1/orders
+type CreateOrderRequest struct {
+	OriginLat float64 `json:"origin_lat"`
+	OriginLon float64 `json:"origin_lon"`
+	DestLat   float64 `json:"dest_lat"`
+	DestLon   float64 `json:"dest_lon"`
+	// Priority is MEDICAL, EXPRESS or STANDARD; the server defaults to STANDARD
+	Priority domain.OrderPriority `json:"priority,omitempty"`
+	// DeliverBy asks for a later deadline than the priority class promises
+	DeliverBy *time.Time `json:"deliver_by,omitempty"`
+	// DeliverAfter opens a delivery window; the order is held back until then
+	DeliverAfter *time.Time `json:"deliver_after,omitempty"`
+}
+
+// CreateOrder places an order; a non-empty idempotencyKey makes retries return the same order
+func (c *Client) CreateOrder(ctx context.Context, req CreateOrderRequest, idempotencyKey string) (*domain.Order, error) {
+	header := http.Header{}
+	if idempotencyKey != "" {
+		header.Set("Idempotency-Key", idempotencyKey)
+	}
+	var order domain.Order
+	if err := c.do(ctx, http.MethodPost, "/api/v1/orders", header, req, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func (c *Client) GetOrder(ctx context.Context, id string) (*domain.Order, error) {
+	var order domain.Order
+	if err := c.do(ctx, http.MethodGet, "/api/v1/orders/"+url.PathEscape(id), nil, nil, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func (c *Client) OrderHistory(ctx context.Context, id string) ([]domain.OrderEvent, error) {
+	var history []domain.OrderEvent
+	if err := c.do(ctx, http.MethodGet, "/api/v1/orders/"+url.PathEscape(id)+"/history", nil, nil, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// DispatchPlan explains which idle drones the dispatcher would consider for an order
+func (c *Client) DispatchPlan(ctx context.Context, id string) (*domain.DispatchPlan, error) {
+	var plan domain.DispatchPlan
+	if err := c.do(ctx, http.MethodGet, "/api/v1/orders/"+url.PathEscape(id)+"/dispatch-plan", nil, nil, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// ListOrders returns one page; query takes the API's filters (status, drone_id,
+// created_after, created_before, bbox, sort, cursor, limit)
+func (c *Client) ListOrders(ctx context.Context, query url.Values) (*domain.Page[domain.Order], error) {
+	var page domain.Page[domain.Order]
+	if err := c.do(ctx, http.MethodGet, "/api/v1/orders?"+query.Encode(), nil, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// UpdateOrderStatus moves an order along its lifecycle, e.g. PICKED_UP or DELIVERED by its drone
+func (c *Client) UpdateOrderStatus(ctx context.Context, id string, status domain.OrderStatus, reason string) (*domain.Order, error) {
+	var order domain.Order
+	body := map[string]string{"status": string(status), "reason": reason}
+	if err := c.do(ctx, http.MethodPost, "/api/v1/orders/"+url.PathEscape(id)+"/status", nil, body, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// WithdrawOrder cancels an order; ifMatch is the expected version, or domain.AnyVersion
+func (c *Client) WithdrawOrder(ctx context.Context, id string, ifMatch int) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/orders/"+url.PathEscape(id), ifMatchHeader(ifMatch), nil, nil)
+}
+
+func ifMatchHeader(version int) http.Header {
+	if version == domain.AnyVersion {
+		return nil
+	}
+	return http.Header{"If-Match": {`"` + strconv.Itoa(version) + `"`}}
+}
+
+// do sends body as JSON and decodes a 2xx response into out, when out is not nil
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(raw, &apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = strings.TrimSpace(string(raw))
+		}
+		return &Error{StatusCode: resp.StatusCode, Message: apiErr.Error}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s %s response: %w", method, path, err)
+	}
+	return nil
+}
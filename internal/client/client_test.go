@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_IssueTokenThenAuthenticates(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/token":
+			var body map[string]string
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, "admin", body["user_type"])
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "t0k3n", "token_type": "Bearer"})
+		case "/api/v1/drones":
+			assert.Equal(t, "Bearer t0k3n", r.Header.Get("Authorization"))
+			assert.Equal(t, "IDLE", r.URL.Query().Get("status"))
+			json.NewEncoder(w).Encode(domain.Page[domain.Drone]{Items: []domain.Drone{{Name: "d1"}}, Total: 1})
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL+"/", "")
+	token, err := c.IssueToken(context.Background(), "ops", "admin")
+	require.NoError(t, err)
+	c.SetToken(token)
+
+	page, err := c.ListDrones(context.Background(), url.Values{"status": {"IDLE"}})
+
+	require.NoError(t, err)
+	assert.Equal(t, "d1", page.Items[0].Name)
+}
+
+func TestClient_ReportsAPIErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, `"3"`, r.Header.Get("If-Match"))
+		w.WriteHeader(http.StatusPreconditionFailed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "order or drone was modified"})
+	}))
+	defer srv.Close()
+
+	err := New(srv.URL, "t").WithdrawOrder(context.Background(), "abc", 3)
+
+	var apiErr *Error
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusPreconditionFailed, apiErr.StatusCode)
+	assert.Equal(t, "order or drone was modified", apiErr.Message)
+}
@@ -0,0 +1,214 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Options are the command line switches that are not settings themselves
+type Options struct {
+	// File is the YAML config file (--config or CONFIG_FILE), optional
+	File string
+	// PrintConfig asks to print the effective configuration and exit
+	PrintConfig bool
+	// Args are the arguments left after the flags, i.e. a subcommand
+	Args []string
+}
+
+// setting is one leaf of Config with the metadata from its struct tags
+type setting struct {
+	key    string // YAML path, also the flag name
+	env    string
+	desc   string
+	secret string // "true" hides the value, "url" only the password in it
+	value  reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Parse builds the configuration from the defaults, then the YAML file, then the
+// environment, then the flags in args, and validates the result
+func Parse(args []string) (*Config, Options, error) {
+	cfg := Default()
+	settings := settingsOf(cfg)
+
+	var opts Options
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.StringVar(&opts.File, "config", os.Getenv("CONFIG_FILE"), "YAML config file (env CONFIG_FILE)")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective configuration, secrets redacted, and exit")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: server [flags] [migrate up|down [N|all]|status]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	// Flags win over the file and the environment, so they are applied last
+	flagValues := map[string]string{}
+	for _, s := range settings {
+		apply := func(raw string) error {
+			// Reject malformed values while parsing, with the flag name in the error
+			if err := set(reflect.New(s.value.Type()).Elem(), raw); err != nil {
+				return err
+			}
+			flagValues[s.key] = raw
+			return nil
+		}
+		if s.value.Kind() == reflect.Bool {
+			// --flag alone means true
+			fs.BoolFunc(s.key, s.usage(), apply)
+		} else {
+			fs.Func(s.key, s.usage(), apply)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, opts, err
+	}
+	opts.Args = fs.Args()
+
+	if opts.File != "" {
+		if err := loadFile(cfg, opts.File); err != nil {
+			return nil, opts, err
+		}
+	}
+	for _, s := range settings {
+		if raw, ok := os.LookupEnv(s.env); ok {
+			if err := set(s.value, raw); err != nil {
+				return nil, opts, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if raw, ok := flagValues[s.key]; ok {
+			set(s.value, raw)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, opts, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, opts, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true) // a typo in a key is an error, not a silently ignored setting
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// settingsOf lists the leaves of cfg, pointing into it
+func settingsOf(cfg *Config) []setting {
+	var settings []setting
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			key := prefix + field.Tag.Get("yaml")
+			if field.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), key+".")
+				continue
+			}
+			settings = append(settings, setting{
+				key:    key,
+				env:    field.Tag.Get("env"),
+				desc:   field.Tag.Get("desc"),
+				secret: field.Tag.Get("secret"),
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	return settings
+}
+
+func (s setting) usage() string {
+	def := format(s.value)
+	if s.secret != "" {
+		def = redact(s.secret, def)
+	}
+	return fmt.Sprintf("%s (env %s, default %q)", s.desc, s.env, def)
+}
+
+func set(v reflect.Value, raw string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+func format(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// Redacted returns a copy that is safe to print: secrets are masked and URLs lose their password
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	for _, s := range settingsOf(&redacted) {
+		if s.secret != "" && s.value.Kind() == reflect.String {
+			s.value.SetString(redact(s.secret, s.value.String()))
+		}
+	}
+	return &redacted
+}
+
+func redact(kind, value string) string {
+	if value == "" {
+		return ""
+	}
+	if kind == "url" {
+		if u, err := url.Parse(value); err == nil {
+			return u.Redacted()
+		}
+	}
+	return "REDACTED"
+}
+
+// WriteYAML writes the configuration in the config file format
+func (c *Config) WriteYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
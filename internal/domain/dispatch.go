@@ -0,0 +1,125 @@
+package domain
+
+import (
+	"math"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// ExclusionReason is the dispatch rule that keeps a drone from an order
+type ExclusionReason string
+
+const (
+	ExcludedByStatus   ExclusionReason = "status"   // Drone is not IDLE
+	ExcludedByCapacity ExclusionReason = "capacity" // Drone is still on a trip; batches are only formed when a trip starts
+	ExcludedByDistance ExclusionReason = "distance" // Pickup is farther than the dispatch limit
+	ExcludedByRange    ExclusionReason = "range"    // Pickup plus delivery is longer than the flight limit
+	ExcludedByGeofence ExclusionReason = "geofence" // Drone, pickup or destination is outside the service area
+	ExcludedByWindow   ExclusionReason = "window"   // Drone would deliver before deliver_after or after deliver_by
+	ExcludedByBattery  ExclusionReason = "battery"  // Pickup plus delivery is longer than the drone's charge lets it fly
+)
+
+// DispatchExclusion is one rule a drone fails for an order
+type DispatchExclusion struct {
+	Reason ExclusionReason `json:"reason"`
+	Detail string          `json:"detail"`
+}
+
+// DispatchCandidate is a drone as the dispatcher sees it for one order
+type DispatchCandidate struct {
+	DroneID        ksuid.KSUID         `json:"drone_id"`
+	DroneName      string              `json:"drone_name"`
+	DroneStatus    DroneStatus         `json:"drone_status"`
+	Rank           int                 `json:"rank,omitempty"`    // 1 is the drone dispatched first; 0 when excluded
+	PickupDistance float64             `json:"pickup_distance_m"` // Drone to the parcel
+	FlightDistance float64             `json:"flight_distance_m"` // Drone to the parcel, then on to the destination
+	Exclusions     []DispatchExclusion `json:"exclusions,omitempty"`
+}
+
+// Eligible reports whether the drone passes every dispatch rule
+func (c *DispatchCandidate) Eligible() bool {
+	return len(c.Exclusions) == 0
+}
+
+// DispatchPlan explains what the dispatcher would do with an order right now
+type DispatchPlan struct {
+	OrderID     ksuid.KSUID `json:"order_id"`
+	OrderStatus OrderStatus `json:"order_status"`
+	// QueuePosition counts the pending orders queued ahead of this one (earlier
+	// dispatch_by), plus one. Drones becoming available claim the first order in
+	// queue they are eligible for.
+	QueuePosition int                 `json:"queue_position,omitempty"`
+	Candidates    []DispatchCandidate `json:"candidates"` // Idle drones, eligible ones first by rank
+}
+
+// Assignment pairs a pending order with the idle drone the batch dispatcher chose for it
+type Assignment struct {
+	DroneID ksuid.KSUID
+	OrderID ksuid.KSUID
+}
+
+// TripEstimate is a drone leaving Lat/Lon at Now and cruising at Speed m/s to a
+// pickup, then on to the destination. A claim given one only hands out orders the
+// drone would deliver inside their window.
+type TripEstimate struct {
+	Lat, Lon float64
+	Speed    float64
+	Now      time.Time
+}
+
+// DeliversInWindow reports whether the drone would deliver order inside its window,
+// within a second. An order that would be late even with its drone already at the
+// pickup is late with any drone, so then the deadline excludes none.
+func (t TripEstimate) DeliversInWindow(order *Order) bool {
+	flight := func(meters float64) time.Duration {
+		return time.Duration(meters / t.Speed * float64(time.Second))
+	}
+	delivery := DistanceMeters(order.PickupLat, order.PickupLon, order.DestLat, order.DestLon)
+	arrival := t.Now.Add(flight(DistanceMeters(t.Lat, t.Lon, order.PickupLat, order.PickupLon) + delivery))
+
+	if order.DeliverAfter != nil && order.DeliverAfter.Sub(arrival) >= time.Second {
+		return false
+	}
+	if !order.DeliverBy.IsZero() && !t.Now.Add(flight(delivery)).After(order.DeliverBy) {
+		return arrival.Sub(order.DeliverBy) < time.Second
+	}
+	return true
+}
+
+// DistanceMeters is the great-circle distance between two points
+func DistanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000
+	rad1, rad2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dLat := rad2 - rad1
+	dLon := (lon2 - lon1) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad1)*math.Cos(rad2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// RouteStopKind is what a drone does at a stop of its route
+type RouteStopKind string
+
+const (
+	RouteStopPickup  RouteStopKind = "PICKUP"
+	RouteStopDropoff RouteStopKind = "DROPOFF"
+)
+
+// RouteStop is one pickup or drop-off on a drone's trip
+type RouteStop struct {
+	OrderID  ksuid.KSUID   `json:"order_id"`
+	Kind     RouteStopKind `json:"kind"`
+	Lat      float64       `json:"lat"`
+	Lon      float64       `json:"lon"`
+	Distance float64       `json:"distance_m"`    // Flight from the drone's position along the route
+	ETA      *time.Time    `json:"eta,omitempty"` // Unknown without a cruise speed
+}
+
+// DroneRoute is the planned order of stops for the orders a drone holds; the ETA of
+// an order's drop-off is the order's ETA
+type DroneRoute struct {
+	DroneID  ksuid.KSUID `json:"drone_id"`
+	Capacity int         `json:"capacity"`
+	Stops    []RouteStop `json:"stops"`
+	Distance float64     `json:"distance_m"`
+}
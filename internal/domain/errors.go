@@ -3,5 +3,16 @@ package domain
 import "errors"
 
 var (
-	ErrNotFound = errors.New("record not found")
+	ErrNotFound        = errors.New("record not found")
+	ErrNoPendingOrders = errors.New("no pending orders available")
+	ErrDroneNotIdle    = errors.New("drone is not idle")
+	ErrDroneBusy       = errors.New("drone still holds an active order")
+	ErrInvalidCursor   = errors.New("invalid pagination cursor")
+	ErrConflict        = errors.New("record was modified concurrently")
+	ErrInvalidBattery  = errors.New("battery must be between 0 and 100 percent")
+
+	ErrDeadlineTooEarly = errors.New("deliver_by is earlier than the priority class can promise")
+	ErrInvalidWindow    = errors.New("deliver_after must be before deliver_by")
+
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used with a different request")
 )
@@ -10,3 +10,30 @@ type OrderCreatedEvent struct {
 	DestLon   float64   `json:"dest_lon"`
 	Timestamp time.Time `json:"timestamp"`
 }
+
+// DroneAvailableEvent is emitted whenever a drone enters IDLE and can take a job
+type DroneAvailableEvent struct {
+	DroneID   string    `json:"drone_id"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// OrderCompletedEvent is emitted when an order reaches a terminal state
+// (routing keys order.delivered, order.failed, order.cancelled)
+type OrderCompletedEvent struct {
+	OrderID   string      `json:"order_id"`
+	DroneID   string      `json:"drone_id,omitempty"`
+	Status    OrderStatus `json:"status"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// OrderSLAAtRiskEvent is emitted once per order when the SLA monitor finds it close to
+// its delivery deadline, or past it (routing key order.sla_at_risk)
+type OrderSLAAtRiskEvent struct {
+	OrderID   string        `json:"order_id"`
+	Status    OrderStatus   `json:"status"`
+	Priority  OrderPriority `json:"priority"`
+	DeliverBy time.Time     `json:"deliver_by"`
+	Timestamp time.Time     `json:"timestamp"`
+}
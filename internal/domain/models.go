@@ -6,53 +6,149 @@ import (
 	"github.com/segmentio/ksuid"
 )
 
+// AnyVersion skips the optimistic locking check when passed as an expected version
+const AnyVersion = 0
+
 // DroneStatus represents the current state of a drone
 type DroneStatus string
 
 const (
-	DroneStatusIdle       DroneStatus = "IDLE"
-	DroneStatusDelivering DroneStatus = "DELIVERING"
-	DroneStatusBroken     DroneStatus = "BROKEN"
-	DroneStatusOffline    DroneStatus = "OFFLINE"
+	DroneStatusIdle            DroneStatus = "IDLE"
+	DroneStatusDelivering      DroneStatus = "DELIVERING"
+	DroneStatusBroken          DroneStatus = "BROKEN"
+	DroneStatusOffline         DroneStatus = "OFFLINE"
+	DroneStatusNeedsInspection DroneStatus = "NEEDS_INSPECTION" // Reconnected after losing contact mid-delivery
 )
 
 // Drone represents a delivery drone in the system
 type Drone struct {
-	ID        ksuid.KSUID `json:"id"`
-	Name      string      `json:"name"`
-	Status    DroneStatus `json:"status"`
-	Latitude  float64     `json:"latitude"`
-	Longitude float64     `json:"longitude"`
-	CreatedAt time.Time   `json:"created_at"`
-	UpdatedAt time.Time   `json:"updated_at"`
+	ID             ksuid.KSUID `json:"id"`
+	Name           string      `json:"name"`
+	Status         DroneStatus `json:"status"`
+	PreviousStatus DroneStatus `json:"previous_status,omitempty"` // Status held before the last change
+	Latitude       float64     `json:"latitude"`
+	Longitude      float64     `json:"longitude"`
+	Capacity       int         `json:"capacity"`          // Orders carried in one trip
+	Battery        *float64    `json:"battery,omitempty"` // Charge left in percent, as last reported; nil until the drone reports it
+	Version        int         `json:"version"`           // Optimistic locking; bumped on every update
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
 }
 
 // OrderStatus represents the lifecycle state of an order
 type OrderStatus string
 
 const (
-	OrderStatusPending   OrderStatus = "PENDING"
-	OrderStatusReserved  OrderStatus = "RESERVED"
-	OrderStatusPickedUp  OrderStatus = "PICKED_UP"
-	OrderStatusDelivered OrderStatus = "DELIVERED"
-	OrderStatusFailed    OrderStatus = "FAILED"
-	OrderStatusCancelled OrderStatus = "CANCELLED"
+	OrderStatusScheduled        OrderStatus = "SCHEDULED" // Waiting for its delivery window; released to PENDING when due
+	OrderStatusPending          OrderStatus = "PENDING"
+	OrderStatusReserved         OrderStatus = "RESERVED"
+	OrderStatusPickedUp         OrderStatus = "PICKED_UP"
+	OrderStatusDelivered        OrderStatus = "DELIVERED"
+	OrderStatusFailed           OrderStatus = "FAILED"
+	OrderStatusCancelled        OrderStatus = "CANCELLED"
+	OrderStatusAwaitingRecovery OrderStatus = "AWAITING_RECOVERY" // Parcel on the ground with a broken drone; needs a recovery crew
+)
+
+// OrderPriority is the service class of an order. It sets how soon delivery is
+// promised, and so how early the order is dispatched.
+type OrderPriority string
+
+const (
+	OrderPriorityMedical  OrderPriority = "MEDICAL"
+	OrderPriorityExpress  OrderPriority = "EXPRESS"
+	OrderPriorityStandard OrderPriority = "STANDARD"
 )
 
+// Valid reports whether p is one of the known classes
+func (p OrderPriority) Valid() bool {
+	switch p {
+	case OrderPriorityMedical, OrderPriorityExpress, OrderPriorityStandard:
+		return true
+	}
+	return false
+}
+
 // Order represents a delivery order
 type Order struct {
-	ID        ksuid.KSUID  `json:"id"`
-	Status    OrderStatus  `json:"status"`
-	OriginLat float64      `json:"origin_lat"`
-	OriginLon float64      `json:"origin_lon"`
-	DestLat   float64      `json:"dest_lat"`
-	DestLon   float64      `json:"dest_lon"`
-	DroneID   *ksuid.KSUID `json:"drone_id,omitempty"` // Nullable if not assigned
-	CreatedAt time.Time    `json:"created_at"`
-	UpdatedAt time.Time    `json:"updated_at"`
+	ID        ksuid.KSUID   `json:"id"`
+	Status    OrderStatus   `json:"status"`
+	Priority  OrderPriority `json:"priority"`
+	OriginLat float64       `json:"origin_lat"`
+	OriginLon float64       `json:"origin_lon"`
+	PickupLat float64       `json:"pickup_lat"` // Where the parcel currently waits; origin until a hand-off
+	PickupLon float64       `json:"pickup_lon"`
+	DestLat   float64       `json:"dest_lat"`
+	DestLon   float64       `json:"dest_lon"`
+	DroneID   *ksuid.KSUID  `json:"drone_id,omitempty"` // Nullable if not assigned
+	// DeliverBy is the promised delivery deadline. DispatchBy orders the pending queue:
+	// the latest time to leave the pickup and still meet DeliverBy, brought forward so
+	// no order waits longer than the SLA's maximum wait.
+	DeliverBy  time.Time `json:"deliver_by"`
+	DispatchBy time.Time `json:"dispatch_by"`
+	// DeliverAfter opens a delivery window ending at DeliverBy. The order stays
+	// SCHEDULED until DispatchAfter, the earliest departure that does not arrive early.
+	DeliverAfter  *time.Time `json:"deliver_after,omitempty"`
+	DispatchAfter *time.Time `json:"dispatch_after,omitempty"`
+	SLAAtRiskAt   *time.Time `json:"sla_at_risk_at,omitempty"` // When the SLA monitor flagged the deadline as at risk
+	Version       int        `json:"version"`                  // Optimistic locking; bumped on every update
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
 
 	// Response-only fields (not stored in DB directly or calculated)
 	CurrentLat float64 `json:"current_lat,omitempty"`
 	CurrentLon float64 `json:"current_lon,omitempty"`
 	ETA        string  `json:"eta,omitempty"`
+
+	Legs []*OrderLeg `json:"legs,omitempty"` // Hand-off chain, one leg per assigned drone
+}
+
+// LegOutcome describes how a single drone's part of a delivery ended
+type LegOutcome string
+
+const (
+	LegOutcomeInProgress  LegOutcome = "IN_PROGRESS"
+	LegOutcomeDelivered   LegOutcome = "DELIVERED"
+	LegOutcomeInterrupted LegOutcome = "INTERRUPTED" // Drone broke or went offline; parcel handed off
+	LegOutcomeFailed      LegOutcome = "FAILED"
+	LegOutcomeCancelled   LegOutcome = "CANCELLED"
+)
+
+// OrderLeg is the stretch of a delivery flown by one drone. ToLat/ToLon is the
+// destination while in progress and the point where the leg actually ended afterwards.
+type OrderLeg struct {
+	ID        ksuid.KSUID `json:"id"`
+	OrderID   ksuid.KSUID `json:"order_id"`
+	DroneID   ksuid.KSUID `json:"drone_id"`
+	FromLat   float64     `json:"from_lat"`
+	FromLon   float64     `json:"from_lon"`
+	ToLat     float64     `json:"to_lat"`
+	ToLon     float64     `json:"to_lon"`
+	Outcome   LegOutcome  `json:"outcome"`
+	StartedAt time.Time   `json:"started_at"`
+	EndedAt   *time.Time  `json:"ended_at,omitempty"`
+}
+
+// OrderEvent records a single status transition of an order (audit trail)
+type OrderEvent struct {
+	ID         ksuid.KSUID  `json:"id"`
+	OrderID    ksuid.KSUID  `json:"order_id"`
+	FromStatus OrderStatus  `json:"from_status,omitempty"` // Empty for the creation event
+	ToStatus   OrderStatus  `json:"to_status"`
+	Actor      string       `json:"actor"` // "<user_type>:<name>" from the JWT, or "system:<component>"
+	DroneID    *ksuid.KSUID `json:"drone_id,omitempty"`
+	Latitude   *float64     `json:"latitude,omitempty"`
+	Longitude  *float64     `json:"longitude,omitempty"`
+	Reason     string       `json:"reason,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+// IdempotencyKey remembers which order a client-supplied Idempotency-Key produced,
+// so retries of the same request return the original order instead of a duplicate.
+type IdempotencyKey struct {
+	UserID      string      `json:"user_id"`
+	Key         string      `json:"key"`
+	RequestHash string      `json:"request_hash"`
+	OrderID     ksuid.KSUID `json:"order_id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	ExpiresAt   time.Time   `json:"expires_at"`
 }
@@ -0,0 +1,66 @@
+package domain
+
+import "time"
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 200
+)
+
+// SortField is a column list endpoints can be ordered by
+type SortField string
+
+const (
+	SortByCreatedAt SortField = "created_at"
+	SortByUpdatedAt SortField = "updated_at"
+	// SortByDispatchBy is the order of the dispatch queue (orders only)
+	SortByDispatchBy SortField = "dispatch_by"
+)
+
+// ListOptions controls cursor pagination and ordering of list queries.
+// Cursor is the opaque next_cursor returned by the previous page.
+type ListOptions struct {
+	Cursor string
+	Limit  int
+	SortBy SortField
+	Desc   bool
+	// WithTotal counts every matching record into Page.Total, at the cost of one more
+	// query; without it Total is 0
+	WithTotal bool
+}
+
+// BoundingBox selects records whose position lies within the given lat/lon rectangle
+type BoundingBox struct {
+	MinLat float64
+	MinLon float64
+	MaxLat float64
+	MaxLon float64
+}
+
+// OrderFilter narrows an order listing. Zero values mean "no filter".
+// The bounding box applies to the parcel's current pickup point.
+type OrderFilter struct {
+	Status         OrderStatus
+	Priority       OrderPriority
+	DroneID        string
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	DispatchBefore *time.Time // Queued ahead of this dispatch_by
+	AtRisk         bool       // Only orders the SLA monitor flagged
+	BoundingBox    *BoundingBox
+	ListOptions
+}
+
+// DroneFilter narrows a drone listing. Zero values mean "no filter".
+type DroneFilter struct {
+	Status      DroneStatus
+	BoundingBox *BoundingBox
+	ListOptions
+}
+
+// Page is one page of a cursor-paginated listing
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int    `json:"total,omitempty"` // Only counted when asked for, see ListOptions.WithTotal
+	NextCursor string `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,79 @@
+// Package events is the contract between the services and the message broker.
+// The broker is a deployment choice: RabbitMQ (infrastructure/rabbitmq), Postgres
+// LISTEN/NOTIFY (infrastructure/pgbus) or in process (infrastructure/membus).
+package events
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Message is a delivery as seen by a Handler
+type Message struct {
+	Body       []byte
+	RoutingKey string
+	MessageID  string
+	// DeliveryCount is 1 on the first attempt and grows with every redelivery
+	DeliveryCount int
+	Timestamp     time.Time
+}
+
+// Handler processes one message. ctx carries the publisher's trace;
+// returning an error redelivers the message.
+type Handler func(ctx context.Context, msg Message) error
+
+// DefaultMaxDeliveries is how often a broker delivers a message whose handler keeps
+// failing before moving it aside as a dead letter
+const DefaultMaxDeliveries = 10
+
+// Publisher publishes domain events, e.g. order.created
+type Publisher interface {
+	// Publish sends payload as JSON to every queue bound to a pattern matching routingKey
+	Publish(ctx context.Context, routingKey string, payload interface{}) error
+}
+
+// Subscriber consumes domain events. Subscriptions to the same queue compete for its
+// messages, across processes when the broker is shared.
+type Subscriber interface {
+	// Subscribe declares queue if needed, binds it to the routingKey pattern and
+	// starts a consumer on it
+	Subscribe(queue, routingKey string, handler Handler) error
+	// Consumers is the number of subscriptions still consuming
+	Consumers() int
+}
+
+// Broker is a complete implementation, as wired by the server
+type Broker interface {
+	Publisher
+	Subscriber
+	Ping(ctx context.Context) error
+	// StopConsuming cancels every subscription and waits for the messages being
+	// handled, or until ctx expires. Publishing still works.
+	StopConsuming(ctx context.Context) error
+	Close() error
+}
+
+// MatchTopic applies AMQP topic rules to dot-separated words:
+// '*' matches exactly one word, '#' zero or more
+func MatchTopic(pattern, routingKey string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(routingKey, "."))
+}
+
+func matchWords(pattern, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+	if pattern[0] == "#" {
+		for i := 0; i <= len(key); i++ {
+			if matchWords(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(key) == 0 || (pattern[0] != "*" && pattern[0] != key[0]) {
+		return false
+	}
+	return matchWords(pattern[1:], key[1:])
+}
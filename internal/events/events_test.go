@@ -0,0 +1,27 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		pattern, key string
+		match        bool
+	}{
+		{"order.created", "order.created", true},
+		{"order.created", "order.delivered", false},
+		{"order.*", "order.created", true},
+		{"order.*", "order.created.late", false},
+		{"order.#", "order", true},
+		{"order.#", "order.created.late", true},
+		{"#", "drone.available", true},
+		{"*.available", "drone.available", true},
+		{"*.available", "available", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, MatchTopic(c.pattern, c.key), "%s ~ %s", c.pattern, c.key)
+	}
+}
@@ -0,0 +1,106 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusUp Status = "up"
+	// StatusDegraded means an optional dependency is down: the service still works,
+	// with reduced functionality (e.g. no caching or async dispatch)
+	StatusDegraded Status = "degraded"
+	StatusDown     Status = "down"
+)
+
+// checkTimeout bounds a single dependency check
+const checkTimeout = 2 * time.Second
+
+// CheckFunc reports whether a dependency is usable; a non-nil error marks it down
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name     string
+	critical bool
+	fn       CheckFunc
+}
+
+// Checker aggregates the dependency checks behind the readiness probes
+type Checker struct {
+	checks []check
+}
+
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Add registers a check. A failing critical check takes the service down,
+// any other failing check only degrades it.
+func (c *Checker) Add(name string, critical bool, fn CheckFunc) {
+	c.checks = append(c.checks, check{name: name, critical: critical, fn: fn})
+}
+
+type CheckResult struct {
+	Status    Status `json:"status"`
+	Critical  bool   `json:"critical"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+type Report struct {
+	Status Status                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Check runs every check concurrently and derives the overall status
+func (c *Checker) Check(ctx context.Context) Report {
+	results := make([]CheckResult, len(c.checks))
+	var wg sync.WaitGroup
+	for i, chk := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = run(ctx, chk)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusUp, Checks: make(map[string]CheckResult, len(c.checks))}
+	for i, chk := range c.checks {
+		result := results[i]
+		report.Checks[chk.name] = result
+		if result.Status == StatusUp {
+			continue
+		}
+		if chk.critical {
+			report.Status = StatusDown
+		} else if report.Status == StatusUp {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func run(ctx context.Context, chk check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := chk.fn(ctx)
+	result := CheckResult{Status: StatusUp, Critical: chk.critical, LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Unavailable is the check of a dependency that could not be set up at startup
+func Unavailable(err error) CheckFunc {
+	return func(ctx context.Context) error {
+		return err
+	}
+}
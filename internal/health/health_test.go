@@ -0,0 +1,60 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func up(ctx context.Context) error { return nil }
+
+func TestChecker_AllUp(t *testing.T) {
+	c := NewChecker()
+	c.Add("database", true, up)
+	c.Add("redis", false, up)
+
+	report := c.Check(context.Background())
+
+	assert.Equal(t, StatusUp, report.Status)
+	assert.Equal(t, StatusUp, report.Checks["database"].Status)
+	assert.Equal(t, StatusUp, report.Checks["redis"].Status)
+}
+
+func TestChecker_OptionalDependencyDownDegrades(t *testing.T) {
+	c := NewChecker()
+	c.Add("database", true, up)
+	c.Add("redis", false, Unavailable(errors.New("connection refused")))
+
+	report := c.Check(context.Background())
+
+	assert.Equal(t, StatusDegraded, report.Status)
+	assert.Equal(t, StatusDown, report.Checks["redis"].Status)
+	assert.Equal(t, "connection refused", report.Checks["redis"].Error)
+}
+
+func TestChecker_CriticalDependencyDown(t *testing.T) {
+	c := NewChecker()
+	c.Add("database", true, Unavailable(errors.New("connection refused")))
+	c.Add("redis", false, Unavailable(errors.New("connection refused")))
+
+	report := c.Check(context.Background())
+
+	assert.Equal(t, StatusDown, report.Status)
+}
+
+func TestChecker_StopsWithContext(t *testing.T) {
+	c := NewChecker()
+	c.Add("rabbitmq", false, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report := c.Check(ctx)
+
+	assert.Equal(t, StatusDegraded, report.Status)
+	assert.Equal(t, context.Canceled.Error(), report.Checks["rabbitmq"].Error)
+}
@@ -0,0 +1,245 @@
+package membus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
+	"github.com/segmentio/ksuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// redeliveryDelay spaces out retries of a message whose handler failed
+const redeliveryDelay = 500 * time.Millisecond
+
+// Bus is an in-process events.Broker with the semantics of the RabbitMQ topic exchange:
+// routing-key patterns, competing consumers on a queue and redelivery on handler
+// errors, up to a number of deliveries after which the message is dead-lettered.
+// Messages live in memory and are lost on exit.
+type Bus struct {
+	mu     sync.Mutex
+	queues map[string]*queue
+	// maxDeliveries is how often a failing message is delivered before it is dead-lettered
+	maxDeliveries int
+
+	consumers atomic.Int32
+	consuming sync.WaitGroup
+}
+
+var _ events.Broker = (*Bus)(nil)
+
+type envelope struct {
+	msg     events.Message
+	headers propagation.MapCarrier
+}
+
+type queue struct {
+	name     string
+	bindings []string
+
+	mu      sync.Mutex
+	pending []envelope
+	ready   chan struct{} // signalled when a message is queued
+	done    chan struct{} // closed by StopConsuming
+
+	// dead holds the messages whose handler failed every delivery, guarded by mu
+	dead []events.Message
+}
+
+func New() *Bus {
+	return NewWithMaxDeliveries(events.DefaultMaxDeliveries)
+}
+
+// NewWithMaxDeliveries returns a bus that dead-letters a message once its handler
+// has failed maxDeliveries times
+func NewWithMaxDeliveries(maxDeliveries int) *Bus {
+	return &Bus{queues: make(map[string]*queue), maxDeliveries: maxDeliveries}
+}
+
+// Publish sends payload as JSON to every queue with a matching binding.
+// The caller's trace context travels with the message.
+func (b *Bus) Publish(ctx context.Context, routingKey string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	headers := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, headers)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, q := range b.queues {
+		if !q.bound(routingKey) {
+			continue
+		}
+		q.push(envelope{
+			msg: events.Message{
+				Body:          body,
+				RoutingKey:    routingKey,
+				MessageID:     ksuid.New().String(),
+				DeliveryCount: 1,
+				Timestamp:     time.Now(),
+			},
+			headers: headers,
+		})
+	}
+	return nil
+}
+
+// Subscribe declares queue (if needed), binds it to routingKey and starts a consumer on it
+func (b *Bus) Subscribe(queueName, routingKey string, handler events.Handler) error {
+	b.mu.Lock()
+	q, ok := b.queues[queueName]
+	if !ok {
+		q = &queue{name: queueName, ready: make(chan struct{}, 1), done: make(chan struct{})}
+		b.queues[queueName] = q
+	}
+	select {
+	case <-q.done:
+		b.mu.Unlock()
+		return errors.New("bus is no longer consuming")
+	default:
+	}
+	q.bindings = append(q.bindings, routingKey)
+	b.mu.Unlock()
+
+	b.consumers.Add(1)
+	b.consuming.Add(1)
+	go func() {
+		defer b.consuming.Done()
+		defer b.consumers.Add(-1)
+		q.consume(handler, b.maxDeliveries)
+	}()
+	return nil
+}
+
+// DeadLetters returns the messages of queue that were dead-lettered, oldest first
+func (b *Bus) DeadLetters(queueName string) []events.Message {
+	b.mu.Lock()
+	q, ok := b.queues[queueName]
+	b.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]events.Message(nil), q.dead...)
+}
+
+// Consumers is the number of running consumers
+func (b *Bus) Consumers() int {
+	return int(b.consumers.Load())
+}
+
+// StopConsuming stops every consumer and waits for the messages being handled, or until
+// ctx expires. Messages still queued are dropped.
+func (b *Bus) StopConsuming(ctx context.Context) error {
+	b.mu.Lock()
+	for _, q := range b.queues {
+		select {
+		case <-q.done:
+		default:
+			close(q.done)
+		}
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.consuming.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("in-flight messages not drained: %w", ctx.Err())
+	}
+}
+
+// Ping always succeeds: there is no connection to lose
+func (b *Bus) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op; call StopConsuming to stop the consumers
+func (b *Bus) Close() error {
+	return nil
+}
+
+func (q *queue) bound(routingKey string) bool {
+	for _, pattern := range q.bindings {
+		if events.MatchTopic(pattern, routingKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *queue) push(e envelope) {
+	q.mu.Lock()
+	q.pending = append(q.pending, e)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *queue) pop() (envelope, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return envelope{}, false
+	}
+	e := q.pending[0]
+	q.pending = q.pending[1:]
+	if len(q.pending) > 0 {
+		// Wake competing consumers for the rest
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+	}
+	return e, true
+}
+
+func (q *queue) consume(handler events.Handler, maxDeliveries int) {
+	for {
+		select {
+		case <-q.done:
+			return
+		default:
+		}
+		e, ok := q.pop()
+		if !ok {
+			select {
+			case <-q.ready:
+			case <-q.done:
+				return
+			}
+			continue
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(context.Background(), e.headers)
+		if err := handler(ctx, e.msg); err != nil {
+			log.Printf("Error handling message %s: %v", e.msg.MessageID, err)
+			if e.msg.DeliveryCount >= maxDeliveries {
+				log.Printf("Dead-lettering message %s of %s after %d deliveries", e.msg.MessageID, q.name, e.msg.DeliveryCount)
+				q.mu.Lock()
+				q.dead = append(q.dead, e.msg)
+				q.mu.Unlock()
+				continue
+			}
+			e.msg.DeliveryCount++
+			time.AfterFunc(redeliveryDelay, func() { q.push(e) })
+		}
+	}
+}
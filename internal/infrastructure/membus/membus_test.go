@@ -0,0 +1,110 @@
+package membus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, ch <-chan events.Message) events.Message {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message delivered")
+		return events.Message{}
+	}
+}
+
+func TestBus_RoutesByTopic(t *testing.T) {
+	bus := New()
+	orders, all := make(chan events.Message, 4), make(chan events.Message, 4)
+	require.NoError(t, bus.Subscribe("orders", "order.created", func(ctx context.Context, msg events.Message) error {
+		orders <- msg
+		return nil
+	}))
+	require.NoError(t, bus.Subscribe("audit", "order.#", func(ctx context.Context, msg events.Message) error {
+		all <- msg
+		return nil
+	}))
+
+	require.NoError(t, bus.Publish(context.Background(), "order.created", map[string]string{"order_id": "1"}))
+	require.NoError(t, bus.Publish(context.Background(), "order.delivered", map[string]string{"order_id": "1"}))
+	require.NoError(t, bus.Publish(context.Background(), "drone.available", map[string]string{"drone_id": "1"}))
+
+	msg := receive(t, orders)
+	assert.Equal(t, "order.created", msg.RoutingKey)
+	assert.JSONEq(t, `{"order_id":"1"}`, string(msg.Body))
+	assert.Equal(t, "order.created", receive(t, all).RoutingKey)
+	assert.Equal(t, "order.delivered", receive(t, all).RoutingKey)
+	assert.Empty(t, orders)
+	assert.Equal(t, 2, bus.Consumers())
+}
+
+func TestBus_RedeliversFailedMessages(t *testing.T) {
+	bus := New()
+	deliveries := make(chan events.Message, 4)
+	require.NoError(t, bus.Subscribe("orders", "order.created", func(ctx context.Context, msg events.Message) error {
+		deliveries <- msg
+		if msg.DeliveryCount == 1 {
+			return errors.New("database unavailable")
+		}
+		return nil
+	}))
+
+	require.NoError(t, bus.Publish(context.Background(), "order.created", "payload"))
+
+	first, second := receive(t, deliveries), receive(t, deliveries)
+	assert.Equal(t, 1, first.DeliveryCount)
+	assert.Equal(t, 2, second.DeliveryCount)
+	assert.Equal(t, first.MessageID, second.MessageID)
+}
+
+func TestBus_StopConsumingWaitsForInFlightMessages(t *testing.T) {
+	bus := New()
+	started, finished := make(chan struct{}), make(chan struct{})
+	require.NoError(t, bus.Subscribe("orders", "order.created", func(ctx context.Context, msg events.Message) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		close(finished)
+		return nil
+	}))
+	require.NoError(t, bus.Publish(context.Background(), "order.created", "payload"))
+	<-started
+
+	require.NoError(t, bus.StopConsuming(context.Background()))
+
+	select {
+	case <-finished:
+	default:
+		t.Fatal("StopConsuming returned before the handler finished")
+	}
+	assert.Equal(t, 0, bus.Consumers())
+}
+
+func TestBus_DeadLettersMessagesThatKeepFailing(t *testing.T) {
+	bus := NewWithMaxDeliveries(2)
+	deliveries := make(chan events.Message, 4)
+	require.NoError(t, bus.Subscribe("orders", "order.created", func(ctx context.Context, msg events.Message) error {
+		deliveries <- msg
+		return errors.New("malformed payload")
+	}))
+
+	require.NoError(t, bus.Publish(context.Background(), "order.created", "payload"))
+	receive(t, deliveries)
+	receive(t, deliveries)
+
+	require.Eventually(t, func() bool { return len(bus.DeadLetters("orders")) == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, bus.DeadLetters("orders")[0].DeliveryCount)
+	select {
+	case msg := <-deliveries:
+		t.Fatalf("dead-lettered message delivered again (delivery %d)", msg.DeliveryCount)
+	case <-time.After(time.Second):
+	}
+}
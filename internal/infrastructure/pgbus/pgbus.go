@@ -0,0 +1,336 @@
+package pgbus
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
+	"github.com/lib/pq"
+	"github.com/segmentio/ksuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	// notifyChannel carries the name of a queue that has just received a message
+	notifyChannel = "event_bus"
+	// pollInterval bounds the latency of a missed notification and picks up retries
+	// and expired leases
+	pollInterval = time.Second
+	// lease is how long a claimed message stays hidden from the other consumers. A
+	// handler running longer than this may see its message delivered twice.
+	lease = 30 * time.Second
+	// redeliveryDelay spaces out retries of a message whose handler failed
+	redeliveryDelay = 500 * time.Millisecond
+	queryTimeout    = 5 * time.Second
+)
+
+// Bus is an events.Broker on Postgres, for deployments that would rather not run
+// RabbitMQ. Messages are rows of event_messages (migration 000008), one per bound
+// queue, and survive restarts; NOTIFY wakes the consumers as soon as a publishing
+// transaction commits. Consumers on the same queue compete through
+// SELECT ... FOR UPDATE SKIP LOCKED, across server instances too. A message whose
+// handler has failed maxDeliveries times moves to event_dead_letters (migration 000013).
+type Bus struct {
+	db       *sql.DB
+	listener *pq.Listener
+	// maxDeliveries is how often a failing message is delivered before it is dead-lettered
+	maxDeliveries int
+
+	// stopping is cancelled by StopConsuming
+	stopping context.Context
+	stop     context.CancelFunc
+
+	mu     sync.Mutex
+	wakeup map[string]chan struct{}
+
+	consumers atomic.Int32
+	consuming sync.WaitGroup
+}
+
+var _ events.Broker = (*Bus)(nil)
+
+// New connects to the database at dsn. Polling queries are not traced, only the
+// publisher's trace context is carried over to the handlers.
+func New(dsn string, maxDeliveries int) (*Bus, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+
+	listener := pq.NewListener(dsn, 100*time.Millisecond, 10*time.Second, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Event listener: %v", err)
+		}
+	})
+	if err := listener.Listen(notifyChannel); err != nil {
+		listener.Close()
+		db.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", notifyChannel, err)
+	}
+
+	stopping, stop := context.WithCancel(context.Background())
+	b := &Bus{
+		db:            db,
+		listener:      listener,
+		maxDeliveries: maxDeliveries,
+		stopping:      stopping,
+		stop:          stop,
+		wakeup:        make(map[string]chan struct{}),
+	}
+	go b.dispatchNotifications()
+	return b, nil
+}
+
+// Publish stores payload as JSON for every queue with a matching binding, in one
+// transaction. The caller's trace context travels with the message.
+func (b *Bus) Publish(ctx context.Context, routingKey string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	headers, err := json.Marshal(carrier)
+	if err != nil {
+		return fmt.Errorf("failed to marshal headers: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queues, err := boundQueues(ctx, tx, routingKey)
+	if err != nil {
+		return err
+	}
+	messageID := ksuid.New().String()
+	for _, queue := range queues {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO event_messages (queue, routing_key, message_id, body, headers) VALUES ($1, $2, $3, $4, $5)`,
+			queue, routingKey, messageID, body, headers); err != nil {
+			return fmt.Errorf("failed to enqueue message for %s: %w", queue, err)
+		}
+		// Sent on commit, so consumers never wake up before the row is visible
+		if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, notifyChannel, queue); err != nil {
+			return fmt.Errorf("failed to notify %s: %w", queue, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// boundQueues lists the queues whose binding patterns match routingKey, once each
+func boundQueues(ctx context.Context, tx *sql.Tx, routingKey string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT queue, routing_key FROM event_bindings ORDER BY queue`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bindings: %w", err)
+	}
+	defer rows.Close()
+
+	var queues []string
+	for rows.Next() {
+		var queue, pattern string
+		if err := rows.Scan(&queue, &pattern); err != nil {
+			return nil, err
+		}
+		matched := len(queues) > 0 && queues[len(queues)-1] == queue
+		if !matched && events.MatchTopic(pattern, routingKey) {
+			queues = append(queues, queue)
+		}
+	}
+	return queues, rows.Err()
+}
+
+// Subscribe declares the binding (bindings are shared by every instance using the
+// database) and starts a consumer on queue
+func (b *Bus) Subscribe(queue, routingKey string, handler events.Handler) error {
+	if b.stopping.Err() != nil {
+		return errors.New("bus is no longer consuming")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	if _, err := b.db.ExecContext(ctx,
+		`INSERT INTO event_bindings (queue, routing_key) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		queue, routingKey); err != nil {
+		return fmt.Errorf("failed to bind queue: %w", err)
+	}
+
+	wakeup := b.wakeupFor(queue)
+	b.consumers.Add(1)
+	b.consuming.Add(1)
+	go func() {
+		defer b.consuming.Done()
+		defer b.consumers.Add(-1)
+		b.consume(queue, wakeup, handler)
+	}()
+	return nil
+}
+
+func (b *Bus) consume(queue string, wakeup <-chan struct{}, handler events.Handler) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for b.stopping.Err() == nil {
+		delivered, err := b.deliverNext(queue, handler)
+		if err != nil {
+			log.Printf("Failed to consume from %s: %v", queue, err)
+		}
+		if delivered {
+			continue
+		}
+		select {
+		case <-b.stopping.Done():
+			return
+		case <-wakeup:
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverNext runs handler on the next message of queue. The message is deleted once
+// handled and made visible again after a failure, or dead-lettered after the last
+// delivery allowed. It reports false when nothing was waiting.
+func (b *Bus) deliverNext(queue string, handler events.Handler) (bool, error) {
+	id, msg, headers, err := b.claim(queue)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	carrier := propagation.MapCarrier{}
+	if err := json.Unmarshal(headers, &carrier); err != nil {
+		log.Printf("Dropping unreadable trace headers of message %s: %v", msg.MessageID, err)
+	}
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), carrier)
+	if err := handler(ctx, msg); err != nil {
+		log.Printf("Error handling message %s: %v", msg.MessageID, err)
+		if msg.DeliveryCount >= b.maxDeliveries {
+			log.Printf("Dead-lettering message %s of %s after %d deliveries", msg.MessageID, queue, msg.DeliveryCount)
+			return true, b.deadLetter(id, err)
+		}
+		return true, b.exec(`UPDATE event_messages SET visible_at = NOW() + make_interval(secs => $2) WHERE id = $1`,
+			id, redeliveryDelay.Seconds())
+	}
+	return true, b.exec(`DELETE FROM event_messages WHERE id = $1`, id)
+}
+
+// deadLetter moves a message to event_dead_letters with the error of its last delivery
+func (b *Bus) deadLetter(id int64, cause error) error {
+	return b.exec(`
+		WITH dead AS (
+			DELETE FROM event_messages WHERE id = $1
+			RETURNING id, queue, routing_key, message_id, body, headers, delivery_count, published_at
+		)
+		INSERT INTO event_dead_letters (id, queue, routing_key, message_id, body, headers, delivery_count, published_at, error)
+		SELECT id, queue, routing_key, message_id, body, headers, delivery_count, published_at, $2 FROM dead`,
+		id, cause.Error())
+}
+
+// claim leases the oldest visible message of queue, or returns sql.ErrNoRows
+func (b *Bus) claim(queue string) (id int64, msg events.Message, headers []byte, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	err = b.db.QueryRowContext(ctx, `
+		UPDATE event_messages
+		SET delivery_count = delivery_count + 1, visible_at = NOW() + make_interval(secs => $2)
+		WHERE id = (
+			SELECT id FROM event_messages
+			WHERE queue = $1 AND visible_at <= NOW()
+			ORDER BY id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, routing_key, message_id, body, headers, delivery_count, published_at`,
+		queue, lease.Seconds(),
+	).Scan(&id, &msg.RoutingKey, &msg.MessageID, &msg.Body, &headers, &msg.DeliveryCount, &msg.Timestamp)
+	return id, msg, headers, err
+}
+
+func (b *Bus) exec(query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	_, err := b.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (b *Bus) wakeupFor(queue string) chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch, ok := b.wakeup[queue]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		b.wakeup[queue] = ch
+	}
+	return ch
+}
+
+// dispatchNotifications wakes the consumers of the queue named in each notification
+func (b *Bus) dispatchNotifications() {
+	for n := range b.listener.Notify {
+		b.mu.Lock()
+		for queue, ch := range b.wakeup {
+			// nil follows a reconnection, when notifications may have been lost
+			if n != nil && n.Extra != queue {
+				continue
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Consumers is the number of running consumers
+func (b *Bus) Consumers() int {
+	return int(b.consumers.Load())
+}
+
+// StopConsuming stops every consumer and waits for the messages being handled, or until
+// ctx expires. Messages still queued stay in the database for the next start.
+func (b *Bus) StopConsuming(ctx context.Context) error {
+	b.stop()
+
+	done := make(chan struct{})
+	go func() {
+		b.consuming.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("in-flight messages not drained: %w", ctx.Err())
+	}
+}
+
+// Ping checks the database and the notification connection
+func (b *Bus) Ping(ctx context.Context) error {
+	if err := b.db.PingContext(ctx); err != nil {
+		return err
+	}
+	return b.listener.Ping()
+}
+
+func (b *Bus) Close() error {
+	return errors.Join(b.listener.Close(), b.db.Close())
+}
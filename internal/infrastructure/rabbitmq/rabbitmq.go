@@ -3,19 +3,46 @@ package rabbitmq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/segmentio/ksuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
 )
 
+const (
+	exchangeName = "drone_delivery"
+	// deadLetterExchange routes each dead-lettered message, by the name of the queue it
+	// came from, to that queue's <name>.dead_letter queue
+	deadLetterExchange = "drone_delivery.dead_letter"
+)
+
+// Client is the RabbitMQ events.Broker. Handlers run in a consumer span continuing
+// the publisher's trace. Queues are quorum queues: a failed message is requeued until
+// it has been delivered maxDeliveries times, then dead-lettered.
 type Client struct {
 	conn    *amqp.Connection
 	channel *amqp.Channel
+	// maxDeliveries is how often a failing message is delivered before it is dead-lettered
+	maxDeliveries int
+	// consumers counts the Subscribe goroutines still receiving deliveries
+	consumers atomic.Int32
+	// consuming tracks the same goroutines, so StopConsuming can wait for in-flight messages
+	consuming sync.WaitGroup
+	mu        sync.Mutex
+	tags      []string
 }
 
-func NewClient(url string) (*Client, error) {
+var _ events.Broker = (*Client)(nil)
+
+func NewClient(url string, maxDeliveries int) (*Client, error) {
 	conn, err := amqp.Dial(url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to rabbitmq: %w", err)
@@ -29,54 +56,79 @@ func NewClient(url string) (*Client, error) {
 
 	// Declare Exchange
 	err = ch.ExchangeDeclare(
-		"drone_delivery", // name
-		"topic",          // type
-		true,             // durable
-		false,            // auto-deleted
-		false,            // internal
-		false,            // no-wait
-		nil,              // arguments
+		exchangeName, // name
+		"topic",      // type
+		true,         // durable
+		false,        // auto-deleted
+		false,        // internal
+		false,        // no-wait
+		nil,          // arguments
 	)
 	if err != nil {
 		ch.Close()
 		conn.Close()
 		return nil, fmt.Errorf("failed to declare exchange: %w", err)
 	}
+	if err := ch.ExchangeDeclare(deadLetterExchange, "direct", true, false, false, false, nil); err != nil {
+		ch.Close()
+		conn.Close()
+		return nil, fmt.Errorf("failed to declare dead-letter exchange: %w", err)
+	}
 
 	return &Client{
-		conn:    conn,
-		channel: ch,
+		conn:          conn,
+		channel:       ch,
+		maxDeliveries: maxDeliveries,
 	}, nil
 }
 
+// Publish sends payload as JSON. The caller's trace context travels in the message
+// headers so consumers continue the same trace.
 func (c *Client) Publish(ctx context.Context, routingKey string, payload interface{}) error {
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	return c.channel.PublishWithContext(ctx,
-		"drone_delivery", // exchange
-		routingKey,       // routing key
-		false,            // mandatory
-		false,            // immediate
+	messageID := ksuid.New().String()
+	ctx, span := tracer.Start(ctx, routingKey+" publish", producerSpanOptions(exchangeName, routingKey, messageID)...)
+	defer span.End()
+
+	headers := amqp.Table{}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+
+	err = c.channel.PublishWithContext(ctx,
+		exchangeName, // exchange
+		routingKey,   // routing key
+		false,        // mandatory
+		false,        // immediate
 		amqp.Publishing{
 			ContentType: "application/json",
+			MessageId:   messageID,
+			Headers:     headers,
 			Body:        body,
 			Timestamp:   time.Now(),
 		},
 	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
 }
 
-func (c *Client) Subscribe(queueName, routingKey string, handler func(body []byte) error) error {
-	// 1. Declare Queue
+func (c *Client) Subscribe(queueName, routingKey string, handler events.Handler) error {
+	// 1. Declare the dead-letter queue, then the queue itself
+	if err := c.declareDeadLetterQueue(queueName); err != nil {
+		return err
+	}
 	q, err := c.channel.QueueDeclare(
 		queueName, // name
 		true,      // durable
 		false,     // delete when unused
 		false,     // exclusive
 		false,     // no-wait
-		nil,       // arguments
+		queueArgs(queueName, c.maxDeliveries),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to declare queue: %w", err)
@@ -84,9 +136,9 @@ func (c *Client) Subscribe(queueName, routingKey string, handler func(body []byt
 
 	// 2. Bind Queue to Exchange
 	err = c.channel.QueueBind(
-		q.Name,           // queue name
-		routingKey,       // routing key
-		"drone_delivery", // exchange
+		q.Name,       // queue name
+		routingKey,   // routing key
+		exchangeName, // exchange
 		false,
 		nil,
 	)
@@ -95,9 +147,10 @@ func (c *Client) Subscribe(queueName, routingKey string, handler func(body []byt
 	}
 
 	// 3. Consume
+	tag := q.Name + "-" + ksuid.New().String()
 	msgs, err := c.channel.Consume(
 		q.Name, // queue
-		"",     // consumer
+		tag,    // consumer
 		false,  // auto-ack
 		false,  // exclusive
 		false,  // no-local
@@ -107,15 +160,27 @@ func (c *Client) Subscribe(queueName, routingKey string, handler func(body []byt
 	if err != nil {
 		return fmt.Errorf("failed to register consumer: %w", err)
 	}
+	c.mu.Lock()
+	c.tags = append(c.tags, tag)
+	c.mu.Unlock()
 
+	c.consumers.Add(1)
+	c.consuming.Add(1)
 	go func() {
+		defer c.consuming.Done()
+		defer c.consumers.Add(-1)
 		for d := range msgs {
-			if err := handler(d.Body); err != nil {
-				log.Printf("Error handling message: %v", err)
-				// Negative Ack (requeue)
-				d.Nack(false, true)
-			} else {
+			err := handle(q.Name, d, handler)
+			switch {
+			case err == nil:
 				d.Ack(false)
+			case deliveryCount(d) >= c.maxDeliveries:
+				log.Printf("Error handling message %s: %v. Dead-lettering it after %d deliveries", d.MessageId, err, deliveryCount(d))
+				// Rejected without requeue, the queue moves it to its dead-letter queue
+				d.Nack(false, false)
+			default:
+				log.Printf("Error handling message %s: %v", d.MessageId, err)
+				d.Nack(false, true)
 			}
 		}
 	}()
@@ -123,11 +188,126 @@ func (c *Client) Subscribe(queueName, routingKey string, handler func(body []byt
 	return nil
 }
 
-func (c *Client) Close() {
-	if c.channel != nil {
-		c.channel.Close()
+// queueArgs makes queue a quorum queue, which counts deliveries (x-delivery-count),
+// and sends what it dead-letters to its dead-letter queue. The delivery limit is a
+// backstop for messages whose consumer died before rejecting them.
+func queueArgs(queue string, maxDeliveries int) amqp.Table {
+	return amqp.Table{
+		"x-queue-type":              "quorum",
+		"x-delivery-limit":          maxDeliveries,
+		"x-dead-letter-exchange":    deadLetterExchange,
+		"x-dead-letter-routing-key": queue,
+	}
+}
+
+// declareDeadLetterQueue declares <queue>.dead_letter, where failed messages of queue
+// wait for an operator to inspect, move or purge them
+func (c *Client) declareDeadLetterQueue(queue string) error {
+	name := queue + ".dead_letter"
+	if _, err := c.channel.QueueDeclare(name, true, false, false, false, amqp.Table{"x-queue-type": "quorum"}); err != nil {
+		return fmt.Errorf("failed to declare dead-letter queue: %w", err)
+	}
+	if err := c.channel.QueueBind(name, queue, deadLetterExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind dead-letter queue: %w", err)
+	}
+	return nil
+}
+
+// handle runs handler in a consumer span that continues the publisher's trace
+func handle(queue string, d amqp.Delivery, handler events.Handler) error {
+	msg := newMessage(d)
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier(d.Headers))
+	ctx, span := tracer.Start(ctx, queue+" process", consumerSpanOptions(queue, msg)...)
+	defer span.End()
+
+	err := handler(ctx, msg)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func newMessage(d amqp.Delivery) events.Message {
+	return events.Message{
+		Body:          d.Body,
+		RoutingKey:    d.RoutingKey,
+		MessageID:     d.MessageId,
+		DeliveryCount: deliveryCount(d),
+		Timestamp:     d.Timestamp,
+	}
+}
+
+// deliveryCount uses the x-delivery-count header that quorum queues maintain (number of
+// earlier attempts). Classic queues only flag redeliveries, so the count is a lower bound there.
+func deliveryCount(d amqp.Delivery) int {
+	switch n := d.Headers["x-delivery-count"].(type) {
+	case int64:
+		return int(n) + 1
+	case int32:
+		return int(n) + 1
+	case int:
+		return n + 1
+	}
+	if d.Redelivered {
+		return 2
+	}
+	return 1
+}
+
+// Ping reports whether the broker connection and channel are still open
+func (c *Client) Ping(ctx context.Context) error {
+	if c.conn.IsClosed() {
+		return errors.New("connection closed")
+	}
+	if c.channel.IsClosed() {
+		return errors.New("channel closed")
+	}
+	return nil
+}
+
+// Consumers is the number of subscriptions still consuming; a subscription stops
+// when the broker closes its channel
+func (c *Client) Consumers() int {
+	return int(c.consumers.Load())
+}
+
+// StopConsuming cancels every subscription and waits until the messages already
+// delivered have been handled and acked, or until ctx expires. Publishing still works.
+func (c *Client) StopConsuming(ctx context.Context) error {
+	c.mu.Lock()
+	tags := c.tags
+	c.tags = nil
+	c.mu.Unlock()
+
+	var errs []error
+	for _, tag := range tags {
+		// The broker stops delivering; the delivery channel closes once drained
+		if err := c.channel.Cancel(tag, false); err != nil {
+			errs = append(errs, fmt.Errorf("failed to cancel consumer %s: %w", tag, err))
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.consuming.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("in-flight messages not drained: %w", ctx.Err()))
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Client) Close() error {
+	var errs []error
+	if c.channel != nil && !c.channel.IsClosed() {
+		errs = append(errs, c.channel.Close())
 	}
-	if c.conn != nil {
-		c.conn.Close()
+	if c.conn != nil && !c.conn.IsClosed() {
+		errs = append(errs, c.conn.Close())
 	}
+	return errors.Join(errs...)
 }
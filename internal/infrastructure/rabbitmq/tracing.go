@@ -0,0 +1,60 @@
+package rabbitmq
+
+import (
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/infrastructure/rabbitmq")
+
+// headerCarrier lets the OpenTelemetry propagator read and write W3C trace context
+// (traceparent, tracestate, baggage) in AMQP message headers.
+type headerCarrier amqp.Table
+
+var _ propagation.TextMapCarrier = headerCarrier{}
+
+func (h headerCarrier) Get(key string) string {
+	value, _ := h[key].(string)
+	return value
+}
+
+func (h headerCarrier) Set(key, value string) {
+	h[key] = value
+}
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// messagingAttributes describes a message the same way on both sides of the broker
+func messagingAttributes(exchange, routingKey, messageID string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystem("rabbitmq"),
+		semconv.MessagingDestinationName(exchange),
+		semconv.MessagingRabbitmqDestinationRoutingKey(routingKey),
+		semconv.MessagingMessageID(messageID),
+	}
+}
+
+func producerSpanOptions(exchange, routingKey, messageID string) []trace.SpanStartOption {
+	attrs := append(messagingAttributes(exchange, routingKey, messageID), semconv.MessagingOperationPublish)
+	return []trace.SpanStartOption{trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(attrs...)}
+}
+
+func consumerSpanOptions(queue string, msg events.Message) []trace.SpanStartOption {
+	attrs := append(messagingAttributes(exchangeName, msg.RoutingKey, msg.MessageID),
+		semconv.MessagingOperationProcess,
+		semconv.MessagingSourceName(queue),
+		attribute.Int("messaging.rabbitmq.delivery_count", msg.DeliveryCount),
+	)
+	return []trace.SpanStartOption{trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(attrs...)}
+}
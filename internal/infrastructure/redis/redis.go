@@ -3,22 +3,49 @@ package redis
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 )
 
+// commandTimeout bounds each Redis round trip; callers' deadlines apply when shorter
+const commandTimeout = 2 * time.Second
+
+type Options struct {
+	Addr     string
+	Password string
+	DB       int
+	// LocationTTL is how long a cached location is served after the last report
+	LocationTTL time.Duration
+	// HeartbeatTTL is how long a drone counts as alive after the last report
+	HeartbeatTTL time.Duration
+}
+
 type Client struct {
-	rdb *redis.Client
+	rdb          *redis.Client
+	locationTTL  time.Duration
+	heartbeatTTL time.Duration
 }
 
-func NewClient(addr string, password string, db int) (*Client, error) {
+func NewClient(opts Options) (*Client, error) {
 	rdb := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password, // no password set
-		DB:       db,       // use default DB
+		Addr:     opts.Addr,
+		Password: opts.Password,
+		DB:       opts.DB,
+
+		DialTimeout:           commandTimeout,
+		ReadTimeout:           commandTimeout,
+		WriteTimeout:          commandTimeout,
+		ContextTimeoutEnabled: true,
 	})
 
+	// Trace every command as a child span of the caller
+	if err := redisotel.InstrumentTracing(rdb); err != nil {
+		return nil, fmt.Errorf("failed to instrument redis: %w", err)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -26,18 +53,18 @@ func NewClient(addr string, password string, db int) (*Client, error) {
 		return nil, fmt.Errorf("failed to connect to redis: %w", err)
 	}
 
-	return &Client{rdb: rdb}, nil
+	return &Client{rdb: rdb, locationTTL: opts.LocationTTL, heartbeatTTL: opts.HeartbeatTTL}, nil
 }
 
 func (c *Client) SetDroneLocation(ctx context.Context, id string, lat, lon float64) error {
 	key := fmt.Sprintf("drone:%s:location", id)
 	val := fmt.Sprintf("%f,%f", lat, lon)
-	return c.rdb.Set(ctx, key, val, 1*time.Minute).Err() // TTL 1 minute
+	return c.rdb.Set(ctx, key, val, c.locationTTL).Err()
 }
 
 func (c *Client) SetDroneHeartbeat(ctx context.Context, id string) error {
 	key := fmt.Sprintf("drone:%s:heartbeat", id)
-	return c.rdb.Set(ctx, key, "alive", 30*time.Second).Err() // 30s TTL
+	return c.rdb.Set(ctx, key, "alive", c.heartbeatTTL).Err()
 }
 
 func (c *Client) HasDroneHeartbeat(ctx context.Context, id string) (bool, error) {
@@ -61,6 +88,35 @@ func (c *Client) GetDroneLocation(ctx context.Context, id string) (float64, floa
 	return lat, lon, nil
 }
 
+// SetIdempotencyKey caches the outcome of an idempotent request as "<request hash>|<order id>"
+func (c *Client) SetIdempotencyKey(ctx context.Context, userID, key, requestHash, orderID string, ttl time.Duration) error {
+	redisKey := fmt.Sprintf("idempotency:%s:%s", userID, key)
+	return c.rdb.Set(ctx, redisKey, requestHash+"|"+orderID, ttl).Err()
+}
+
+// GetIdempotencyKey returns the cached request hash and order id; found is false on a cache miss
+func (c *Client) GetIdempotencyKey(ctx context.Context, userID, key string) (requestHash, orderID string, found bool, err error) {
+	redisKey := fmt.Sprintf("idempotency:%s:%s", userID, key)
+	val, err := c.rdb.Get(ctx, redisKey).Result()
+	if err == redis.Nil {
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+
+	parts := strings.SplitN(val, "|", 2)
+	if len(parts) != 2 {
+		return "", "", false, fmt.Errorf("malformed idempotency cache entry")
+	}
+	return parts[0], parts[1], true, nil
+}
+
+// Ping checks that Redis is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	return c.rdb.Ping(ctx).Err()
+}
+
 func (c *Client) Close() error {
 	if c.rdb == nil {
 		return nil
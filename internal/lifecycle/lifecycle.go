@@ -0,0 +1,81 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// StopFunc stops one component. It should return once the component is drained,
+// or when ctx expires.
+type StopFunc func(ctx context.Context) error
+
+type step struct {
+	name    string
+	timeout time.Duration
+	stop    StopFunc
+}
+
+// Manager stops the application's components in the order they were registered,
+// each within its own deadline so a slow step cannot starve the ones after it
+// (telemetry is still flushed and the database still closed).
+type Manager struct {
+	mu    sync.Mutex
+	steps []step
+	once  sync.Once
+	err   error
+}
+
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+// OnShutdown appends a step to the shutdown sequence
+func (m *Manager) OnShutdown(name string, timeout time.Duration, stop StopFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.steps = append(m.steps, step{name: name, timeout: timeout, stop: stop})
+}
+
+// Shutdown runs every step once, in order. Failing steps are logged and do not stop
+// the sequence; their errors are joined in the result. Later calls return the same result.
+func (m *Manager) Shutdown() error {
+	m.once.Do(func() {
+		m.mu.Lock()
+		steps := m.steps
+		m.mu.Unlock()
+
+		var errs []error
+		for _, s := range steps {
+			start := time.Now()
+			if err := m.run(s); err != nil {
+				log.Printf("Shutdown: %s failed after %s: %v", s.name, time.Since(start).Round(time.Millisecond), err)
+				errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+				continue
+			}
+			log.Printf("Shutdown: %s done in %s", s.name, time.Since(start).Round(time.Millisecond))
+		}
+		m.err = errors.Join(errs...)
+	})
+	return m.err
+}
+
+func (m *Manager) run(s step) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	return s.stop(ctx)
+}
+
+// Wait blocks until done is closed or ctx expires; it adapts "closed when finished"
+// channels to a StopFunc
+func Wait(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,57 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_StopsInOrder(t *testing.T) {
+	m := NewManager()
+	var order []string
+	for _, name := range []string{"http", "consumers", "grpc", "telemetry", "database"} {
+		m.OnShutdown(name, time.Second, func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		})
+	}
+
+	assert.NoError(t, m.Shutdown())
+	assert.Equal(t, []string{"http", "consumers", "grpc", "telemetry", "database"}, order)
+}
+
+func TestManager_SlowStepDoesNotStarveLaterSteps(t *testing.T) {
+	m := NewManager()
+	m.OnShutdown("consumers", 10*time.Millisecond, func(ctx context.Context) error {
+		return Wait(ctx, make(chan struct{})) // never drains
+	})
+	var flushed bool
+	m.OnShutdown("telemetry", time.Second, func(ctx context.Context) error {
+		flushed = ctx.Err() == nil
+		return nil
+	})
+
+	err := m.Shutdown()
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "consumers")
+	assert.True(t, flushed)
+}
+
+func TestManager_ShutdownRunsOnce(t *testing.T) {
+	m := NewManager()
+	calls := 0
+	m.OnShutdown("database", time.Second, func(ctx context.Context) error {
+		calls++
+		return errors.New("already closed")
+	})
+
+	first := m.Shutdown()
+	second := m.Shutdown()
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, first, second)
+}
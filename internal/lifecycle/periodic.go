@@ -0,0 +1,58 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Periodic runs a background task once per interval and reports, as a health check,
+// whether its loop is still going
+type Periodic struct {
+	name     string
+	interval time.Duration
+	tick     func(ctx context.Context)
+	// lastTick is when the loop last ran (unix nanos), 0 while it is not running
+	lastTick atomic.Int64
+}
+
+func NewPeriodic(name string, interval time.Duration, tick func(ctx context.Context)) *Periodic {
+	return &Periodic{name: name, interval: interval, tick: tick}
+}
+
+// Start runs the task every interval until ctx is cancelled. A run started before
+// shutdown is not cancelled with ctx: it goes to completion rather than leaving its
+// work half-done, and Start returns right after it.
+func (p *Periodic) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	log.Printf("%s started", p.name)
+	p.lastTick.Store(time.Now().UnixNano())
+	defer p.lastTick.Store(0)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.lastTick.Store(time.Now().UnixNano())
+			p.tick(context.WithoutCancel(ctx))
+		}
+	}
+}
+
+// Check fails when the loop is not running or has stalled for several intervals
+func (p *Periodic) Check(ctx context.Context) error {
+	last := p.lastTick.Load()
+	if last == 0 {
+		return errors.New("not running")
+	}
+	if since := time.Since(time.Unix(0, last)); since > 3*p.interval {
+		return fmt.Errorf("last run %s ago", since.Round(time.Second))
+	}
+	return nil
+}
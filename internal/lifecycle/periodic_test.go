@@ -0,0 +1,40 @@
+package lifecycle
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeriodic_RunsUntilCancelled(t *testing.T) {
+	var runs atomic.Int32
+	p := NewPeriodic("test loop", 5*time.Millisecond, func(ctx context.Context) {
+		assert.NoError(t, ctx.Err())
+		runs.Add(1)
+	})
+	assert.EqualError(t, p.Check(context.Background()), "not running")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Start(ctx)
+	}()
+
+	assert.Eventually(t, func() bool { return runs.Load() >= 2 }, time.Second, time.Millisecond)
+	assert.NoError(t, p.Check(context.Background()))
+
+	cancel()
+	<-done
+	assert.EqualError(t, p.Check(context.Background()), "not running")
+}
+
+func TestPeriodic_CheckReportsStall(t *testing.T) {
+	p := NewPeriodic("test loop", time.Second, func(ctx context.Context) {})
+	p.lastTick.Store(time.Now().Add(-10 * time.Second).UnixNano())
+
+	assert.EqualError(t, p.Check(context.Background()), "last run 10s ago")
+}
@@ -0,0 +1,50 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad_PairsAndOrdersMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000010_later.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+		"000010_later.down.sql": {Data: []byte("DROP TABLE b;")},
+		"000002_first.up.sql":   {Data: []byte("CREATE TABLE a ();")},
+		"000002_first.down.sql": {Data: []byte("DROP TABLE a;")},
+		"README.md":             {Data: []byte("not a migration")},
+	}
+
+	loaded, err := Load(fsys)
+
+	require.NoError(t, err)
+	require.Len(t, loaded, 2)
+	assert.Equal(t, uint(2), loaded[0].Version)
+	assert.Equal(t, "first", loaded[0].Name)
+	assert.Equal(t, "DROP TABLE a;", loaded[0].Down)
+	assert.Equal(t, "000010_later", loaded[1].String())
+}
+
+func TestLoad_RejectsIncompleteOrClashingMigrations(t *testing.T) {
+	_, err := Load(fstest.MapFS{"000001_init.up.sql": {Data: []byte("SELECT 1;")}})
+	assert.ErrorContains(t, err, "000001_init")
+
+	_, err = Load(fstest.MapFS{
+		"000001_init.up.sql":    {Data: []byte("SELECT 1;")},
+		"000001_other.down.sql": {Data: []byte("SELECT 1;")},
+	})
+	assert.ErrorContains(t, err, "version 1")
+}
+
+func TestLoad_EmbeddedMigrations(t *testing.T) {
+	loaded, err := Load(migrations.FS)
+
+	require.NoError(t, err)
+	require.NotEmpty(t, loaded)
+	for i, m := range loaded {
+		assert.Equal(t, uint(i+1), m.Version, "versions must be consecutive, %s is out of line", m)
+	}
+}
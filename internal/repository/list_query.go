@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+)
+
+// listQuery accumulates WHERE conditions for list endpoints. Conditions use '?'
+// placeholders which are numbered ($1, $2, ...) in the order they are added.
+type listQuery struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (q *listQuery) where(condition string, args ...interface{}) {
+	for _, arg := range args {
+		q.args = append(q.args, arg)
+		condition = strings.Replace(condition, "?", "$"+strconv.Itoa(len(q.args)), 1)
+	}
+	q.conditions = append(q.conditions, condition)
+}
+
+func (q *listQuery) whereBoundingBox(latColumn, lonColumn string, box *domain.BoundingBox) {
+	if box == nil {
+		return
+	}
+	q.where(latColumn+" BETWEEN ? AND ?", box.MinLat, box.MaxLat)
+	q.where(lonColumn+" BETWEEN ? AND ?", box.MinLon, box.MaxLon)
+}
+
+func (q *listQuery) clause() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.conditions, " AND ")
+}
+
+// sortColumns whitelists the columns list endpoints may be ordered by
+var sortColumns = map[domain.SortField]string{
+	domain.SortByCreatedAt:  "created_at",
+	domain.SortByUpdatedAt:  "updated_at",
+	domain.SortByDispatchBy: "dispatch_by", // Orders only
+}
+
+// normalizeListOptions applies defaults and bounds to the requested page
+func normalizeListOptions(opts domain.ListOptions) domain.ListOptions {
+	if opts.SortBy == "" {
+		opts.SortBy = domain.SortByCreatedAt
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = domain.DefaultPageLimit
+	}
+	if opts.Limit > domain.MaxPageLimit {
+		opts.Limit = domain.MaxPageLimit
+	}
+	return opts
+}
+
+// Cursors encode the sort key and id of the last row of a page, so the next page
+// continues with a keyset condition instead of an OFFSET scan.
+func encodeCursor(sortValue time.Time, id string) string {
+	raw := strconv.FormatInt(sortValue.UnixNano(), 10) + "|" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", domain.ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return time.Time{}, "", domain.ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", domain.ErrInvalidCursor
+	}
+	return time.Unix(0, nanos), parts[1], nil
+}
+
+// listPage runs a filtered, keyset-paginated SELECT against table and wraps the result in a Page.
+// sortKey extracts the value of the sort column and the id from a scanned row.
+func listPage[T any](ctx context.Context, r *PostgresRepository, table, columns string, q *listQuery, opts domain.ListOptions,
+	scan func(rowScanner) (T, error), sortKey func(T, domain.SortField) (time.Time, string)) (*domain.Page[T], error) {
+
+	opts = normalizeListOptions(opts)
+	column, ok := sortColumns[opts.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort field %q", opts.SortBy)
+	}
+
+	// Total ignores the cursor so it stays stable across pages
+	var total int
+	if opts.WithTotal {
+		if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table+q.clause(), q.args...).Scan(&total); err != nil {
+			return nil, err
+		}
+	}
+
+	direction, comparison := "ASC", ">"
+	if opts.Desc {
+		direction, comparison = "DESC", "<"
+	}
+	if opts.Cursor != "" {
+		value, id, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		q.where("("+column+", id) "+comparison+" (?, ?)", value, id)
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	query := fmt.Sprintf(`SELECT %s FROM %s%s ORDER BY %s %s, id %s LIMIT %d`,
+		columns, table, q.clause(), column, direction, direction, opts.Limit+1)
+	rows, err := r.db.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]T, 0, opts.Limit)
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &domain.Page[T]{Items: items, Total: total}
+	if len(items) > opts.Limit {
+		page.Items = items[:opts.Limit]
+		value, id := sortKey(page.Items[opts.Limit-1], opts.SortBy)
+		page.NextCursor = encodeCursor(value, id)
+	}
+	return page, nil
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListQuery_NumbersPlaceholders(t *testing.T) {
+	q := &listQuery{}
+	q.where("status = ?", "PENDING")
+	q.whereBoundingBox("pickup_lat", "pickup_lon", &domain.BoundingBox{MinLat: 1, MinLon: 2, MaxLat: 3, MaxLon: 4})
+
+	assert.Equal(t, " WHERE status = $1 AND pickup_lat BETWEEN $2 AND $3 AND pickup_lon BETWEEN $4 AND $5", q.clause())
+	assert.Equal(t, []interface{}{"PENDING", 1.0, 3.0, 2.0, 4.0}, q.args)
+}
+
+func TestCursor_RoundTrip(t *testing.T) {
+	ts := time.Date(2026, 3, 4, 5, 6, 7, 123456000, time.UTC)
+	cursor := encodeCursor(ts, "2abcDEF")
+
+	value, id, err := decodeCursor(cursor)
+
+	assert.NoError(t, err)
+	assert.True(t, ts.Equal(value))
+	assert.Equal(t, "2abcDEF", id)
+}
+
+func TestCursor_Invalid(t *testing.T) {
+	for _, cursor := range []string{"!!!", encodeCursor(time.Now(), "")[:4], "bm90LWEtY3Vyc29y"} {
+		_, _, err := decodeCursor(cursor)
+		assert.Equal(t, domain.ErrInvalidCursor, err, cursor)
+	}
+}
+
+func TestNormalizeListOptions(t *testing.T) {
+	opts := normalizeListOptions(domain.ListOptions{Limit: 10000})
+	assert.Equal(t, domain.MaxPageLimit, opts.Limit)
+	assert.Equal(t, domain.SortByCreatedAt, opts.SortBy)
+
+	opts = normalizeListOptions(domain.ListOptions{})
+	assert.Equal(t, domain.DefaultPageLimit, opts.Limit)
+}
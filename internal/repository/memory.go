@@ -0,0 +1,675 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/segmentio/ksuid"
+)
+
+// MemoryRepository keeps drones and orders in memory, for tests and infrastructure-free
+// demos. It mirrors PostgresRepository: optimistic locking on version, atomic claims of
+// pending orders, idempotency keys and keyset pagination. Callers get copies, so
+// mutating a returned record does not change the store until it is written back.
+type MemoryRepository struct {
+	mu              sync.RWMutex
+	drones          map[string]*domain.Drone
+	orders          map[string]*domain.Order
+	legs            map[string][]*domain.OrderLeg
+	events          map[string][]*domain.OrderEvent
+	idempotencyKeys map[string]*domain.IdempotencyKey
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		drones:          make(map[string]*domain.Drone),
+		orders:          make(map[string]*domain.Order),
+		legs:            make(map[string][]*domain.OrderLeg),
+		events:          make(map[string][]*domain.OrderEvent),
+		idempotencyKeys: make(map[string]*domain.IdempotencyKey),
+	}
+}
+
+// Ping always succeeds
+func (r *MemoryRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op; the data lives as long as the process
+func (r *MemoryRepository) Close() error {
+	return nil
+}
+
+// --- Drone Implementation ---
+
+func copyDrone(d *domain.Drone) *domain.Drone {
+	c := *d
+	if d.Battery != nil {
+		battery := *d.Battery
+		c.Battery = &battery
+	}
+	return &c
+}
+
+func (r *MemoryRepository) CreateDrone(ctx context.Context, drone *domain.Drone) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.drones {
+		if existing.Name == drone.Name {
+			return fmt.Errorf("drone name %q already exists", drone.Name)
+		}
+	}
+	drone.Version = 1
+	stored := copyDrone(drone)
+	stored.UpdatedAt = time.Now()
+	r.drones[drone.ID.String()] = stored
+	return nil
+}
+
+func (r *MemoryRepository) GetDroneByID(ctx context.Context, id string) (*domain.Drone, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	drone, ok := r.drones[id]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return copyDrone(drone), nil
+}
+
+func (r *MemoryRepository) GetDroneByName(ctx context.Context, name string) (*domain.Drone, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, drone := range r.drones {
+		if drone.Name == name {
+			return copyDrone(drone), nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (r *MemoryRepository) GetIdleDrones(ctx context.Context) ([]*domain.Drone, error) {
+	return r.findDrones(func(d *domain.Drone) bool { return d.Status == domain.DroneStatusIdle }), nil
+}
+
+func (r *MemoryRepository) GetActiveDrones(ctx context.Context) ([]*domain.Drone, error) {
+	return r.findDrones(func(d *domain.Drone) bool {
+		return d.Status == domain.DroneStatusIdle || d.Status == domain.DroneStatusDelivering
+	}), nil
+}
+
+func (r *MemoryRepository) findDrones(match func(*domain.Drone) bool) []*domain.Drone {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var drones []*domain.Drone
+	for _, drone := range r.drones {
+		if match(drone) {
+			drones = append(drones, copyDrone(drone))
+		}
+	}
+	return drones
+}
+
+func (r *MemoryRepository) ListDrones(ctx context.Context, filter domain.DroneFilter) (*domain.Page[*domain.Drone], error) {
+	drones := r.findDrones(func(d *domain.Drone) bool {
+		if filter.Status != "" && d.Status != filter.Status {
+			return false
+		}
+		return inBoundingBox(filter.BoundingBox, d.Latitude, d.Longitude)
+	})
+	return memoryPage(drones, filter.ListOptions, droneSortKey)
+}
+
+// CountDronesByStatus returns the number of drones in each status (for fleet metrics)
+func (r *MemoryRepository) CountDronesByStatus(ctx context.Context) (map[domain.DroneStatus]int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := make(map[domain.DroneStatus]int64)
+	for _, drone := range r.drones {
+		counts[drone.Status]++
+	}
+	return counts, nil
+}
+
+// UpdateDrone writes the drone only if its version still matches the stored one,
+// otherwise it returns domain.ErrConflict
+func (r *MemoryRepository) UpdateDrone(ctx context.Context, drone *domain.Drone) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.drones[drone.ID.String()]
+	if !ok || stored.Version != drone.Version {
+		return domain.ErrConflict
+	}
+	stored.Status = drone.Status
+	stored.PreviousStatus = drone.PreviousStatus
+	stored.Latitude, stored.Longitude = drone.Latitude, drone.Longitude
+	stored.Battery = copyDrone(drone).Battery
+	stored.Version++
+	stored.UpdatedAt = time.Now()
+	drone.Version++
+	return nil
+}
+
+// --- Order Implementation ---
+
+func copyOrder(o *domain.Order) *domain.Order {
+	c := *o
+	if o.DroneID != nil {
+		droneID := *o.DroneID
+		c.DroneID = &droneID
+	}
+	if o.DeliverAfter != nil {
+		opens := *o.DeliverAfter
+		c.DeliverAfter = &opens
+	}
+	if o.DispatchAfter != nil {
+		departs := *o.DispatchAfter
+		c.DispatchAfter = &departs
+	}
+	if o.SLAAtRiskAt != nil {
+		flagged := *o.SLAAtRiskAt
+		c.SLAAtRiskAt = &flagged
+	}
+	c.Legs = nil
+	return &c
+}
+
+func (r *MemoryRepository) CreateOrder(ctx context.Context, order *domain.Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.insertOrder(order)
+	return nil
+}
+
+func (r *MemoryRepository) insertOrder(order *domain.Order) {
+	order.Version = 1
+	r.orders[order.ID.String()] = copyOrder(order)
+}
+
+// CreateOrderIdempotent inserts order and claims its idempotency key atomically. A live
+// key replays the original order, or fails with ErrIdempotencyKeyReused for another body.
+func (r *MemoryRepository) CreateOrderIdempotent(ctx context.Context, order *domain.Order, key *domain.IdempotencyKey) (*domain.Order, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := key.UserID + "\x00" + key.Key
+	if existing, ok := r.idempotencyKeys[id]; ok && existing.ExpiresAt.After(time.Now()) {
+		if existing.RequestHash != key.RequestHash {
+			return nil, false, domain.ErrIdempotencyKeyReused
+		}
+		original, ok := r.orders[existing.OrderID.String()]
+		if !ok {
+			return nil, false, domain.ErrNotFound
+		}
+		return copyOrder(original), true, nil
+	}
+
+	r.insertOrder(order)
+	stored := *key
+	r.idempotencyKeys[id] = &stored
+	return order, false, nil
+}
+
+func (r *MemoryRepository) GetOrderByID(ctx context.Context, id string) (*domain.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	order, ok := r.orders[id]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return copyOrder(order), nil
+}
+
+func (r *MemoryRepository) GetActiveOrdersByDroneID(ctx context.Context, droneID string) ([]*domain.Order, error) {
+	orders := r.findOrders(func(o *domain.Order) bool {
+		return o.DroneID != nil && o.DroneID.String() == droneID &&
+			(o.Status == domain.OrderStatusReserved || o.Status == domain.OrderStatusPickedUp)
+	})
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID.String() < orders[j].ID.String() })
+	return orders, nil
+}
+
+func (r *MemoryRepository) GetNextPendingOrder(ctx context.Context) (*domain.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	order := r.nextPendingOrder(nil)
+	if order == nil {
+		return nil, domain.ErrNotFound
+	}
+	return copyOrder(order), nil
+}
+
+// nextPendingOrder is the PENDING order first in queue order (dispatch_by, then id)
+// among those eligible accepts, or all of them when it is nil; the caller holds the lock
+func (r *MemoryRepository) nextPendingOrder(eligible func(*domain.Order) bool) *domain.Order {
+	var next *domain.Order
+	for _, order := range r.orders {
+		if order.Status != domain.OrderStatusPending || (eligible != nil && !eligible(order)) {
+			continue
+		}
+		if next == nil || order.DispatchBy.Before(next.DispatchBy) ||
+			(order.DispatchBy.Equal(next.DispatchBy) && order.ID.String() < next.ID.String()) {
+			next = order
+		}
+	}
+	return next
+}
+
+// ClaimNextPendingOrder reserves the next PENDING order in queue order for droneID. The lookup and the
+// update happen under one lock, so concurrent claims never hand out the same order.
+func (r *MemoryRepository) ClaimNextPendingOrder(ctx context.Context, droneID string) (*domain.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order := r.nextPendingOrder(nil)
+	if order == nil {
+		return nil, domain.ErrNotFound
+	}
+	drone, ok := r.drones[droneID]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	id := drone.ID
+	order.Status = domain.OrderStatusReserved
+	order.DroneID = &id
+	order.Version++
+	order.UpdatedAt = time.Now()
+	return copyOrder(order), nil
+}
+
+// ClaimNextPendingOrderInWindow is ClaimNextPendingOrder restricted to the orders the
+// drone would deliver inside their window on trip
+func (r *MemoryRepository) ClaimNextPendingOrderInWindow(ctx context.Context, droneID string, trip domain.TripEstimate) (*domain.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order := r.nextPendingOrder(trip.DeliversInWindow)
+	if order == nil {
+		return nil, domain.ErrNotFound
+	}
+	drone, ok := r.drones[droneID]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	id := drone.ID
+	order.Status = domain.OrderStatusReserved
+	order.DroneID = &id
+	order.Version++
+	order.UpdatedAt = time.Now()
+	return copyOrder(order), nil
+}
+
+// ClaimPendingOrder reserves orderID for droneID if it is still PENDING; ErrNotFound
+// means another drone claimed it first
+func (r *MemoryRepository) ClaimPendingOrder(ctx context.Context, orderID, droneID string) (*domain.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order, ok := r.orders[orderID]
+	if !ok || order.Status != domain.OrderStatusPending {
+		return nil, domain.ErrNotFound
+	}
+	drone, ok := r.drones[droneID]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	id := drone.ID
+	order.Status = domain.OrderStatusReserved
+	order.DroneID = &id
+	order.Version++
+	order.UpdatedAt = time.Now()
+	return copyOrder(order), nil
+}
+
+// ReserveAssignments reserves each order for its drone and marks the drone DELIVERING,
+// all at once. A pair whose drone is no longer IDLE and free, or whose order is no
+// longer PENDING, is skipped.
+func (r *MemoryRepository) ReserveAssignments(ctx context.Context, pairs []domain.Assignment) ([]*domain.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var reserved []*domain.Order
+	for _, pair := range pairs {
+		drone, ok := r.drones[pair.DroneID.String()]
+		if !ok || drone.Status != domain.DroneStatusIdle || r.holdsActiveOrder(drone.ID) {
+			continue
+		}
+		order, ok := r.orders[pair.OrderID.String()]
+		if !ok || order.Status != domain.OrderStatusPending {
+			continue
+		}
+		id := drone.ID
+		order.Status = domain.OrderStatusReserved
+		order.DroneID = &id
+		order.Version++
+		order.UpdatedAt = now
+		drone.Status = domain.DroneStatusDelivering
+		drone.Version++
+		drone.UpdatedAt = now
+		reserved = append(reserved, copyOrder(order))
+	}
+	return reserved, nil
+}
+
+// holdsActiveOrder reports whether a drone has reserved or carries an order; the
+// caller holds the lock
+func (r *MemoryRepository) holdsActiveOrder(droneID ksuid.KSUID) bool {
+	for _, order := range r.orders {
+		if order.DroneID != nil && *order.DroneID == droneID &&
+			(order.Status == domain.OrderStatusReserved || order.Status == domain.OrderStatusPickedUp) {
+			return true
+		}
+	}
+	return false
+}
+
+// FlagOrdersAtRisk marks the undelivered orders due before deadline as at risk and
+// returns them. Each order is flagged once.
+func (r *MemoryRepository) FlagOrdersAtRisk(ctx context.Context, deadline time.Time) ([]*domain.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var flagged []*domain.Order
+	now := time.Now()
+	for _, order := range r.orders {
+		switch order.Status {
+		case domain.OrderStatusScheduled, domain.OrderStatusPending, domain.OrderStatusReserved, domain.OrderStatusPickedUp, domain.OrderStatusAwaitingRecovery:
+		default:
+			continue
+		}
+		if order.SLAAtRiskAt != nil || !order.DeliverBy.Before(deadline) {
+			continue
+		}
+		at := now
+		order.SLAAtRiskAt = &at
+		order.Version++
+		order.UpdatedAt = now
+		flagged = append(flagged, copyOrder(order))
+	}
+	return flagged, nil
+}
+
+// ReleaseScheduledOrders moves the SCHEDULED orders due for dispatch by now to PENDING
+// and returns them
+func (r *MemoryRepository) ReleaseScheduledOrders(ctx context.Context, now time.Time) ([]*domain.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var released []*domain.Order
+	for _, order := range r.orders {
+		if order.Status != domain.OrderStatusScheduled || order.DispatchAfter == nil || order.DispatchAfter.After(now) {
+			continue
+		}
+		order.Status = domain.OrderStatusPending
+		order.Version++
+		order.UpdatedAt = time.Now()
+		released = append(released, copyOrder(order))
+	}
+	return released, nil
+}
+
+// CountOrdersByStatus returns the number of orders in each status (for fleet metrics)
+func (r *MemoryRepository) CountOrdersByStatus(ctx context.Context) (map[domain.OrderStatus]int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := make(map[domain.OrderStatus]int64)
+	for _, order := range r.orders {
+		counts[order.Status]++
+	}
+	return counts, nil
+}
+
+func (r *MemoryRepository) findOrders(match func(*domain.Order) bool) []*domain.Order {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var orders []*domain.Order
+	for _, order := range r.orders {
+		if match(order) {
+			orders = append(orders, copyOrder(order))
+		}
+	}
+	return orders
+}
+
+func (r *MemoryRepository) ListOrders(ctx context.Context, filter domain.OrderFilter) (*domain.Page[*domain.Order], error) {
+	orders := r.findOrders(func(o *domain.Order) bool {
+		switch {
+		case filter.Status != "" && o.Status != filter.Status:
+			return false
+		case filter.DroneID != "" && (o.DroneID == nil || o.DroneID.String() != filter.DroneID):
+			return false
+		case filter.CreatedAfter != nil && o.CreatedAt.Before(*filter.CreatedAfter):
+			return false
+		case filter.CreatedBefore != nil && !o.CreatedAt.Before(*filter.CreatedBefore):
+			return false
+		case filter.Priority != "" && o.Priority != filter.Priority:
+			return false
+		case filter.DispatchBefore != nil && !o.DispatchBy.Before(*filter.DispatchBefore):
+			return false
+		case filter.AtRisk && o.SLAAtRiskAt == nil:
+			return false
+		}
+		return inBoundingBox(filter.BoundingBox, o.PickupLat, o.PickupLon)
+	})
+	return memoryPage(orders, filter.ListOptions, orderSortKey)
+}
+
+// UpdateOrder writes the order only if its version still matches the stored one,
+// otherwise it returns domain.ErrConflict
+func (r *MemoryRepository) UpdateOrder(ctx context.Context, order *domain.Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.orders[order.ID.String()]
+	if !ok || stored.Version != order.Version {
+		return domain.ErrConflict
+	}
+	updated := copyOrder(stored)
+	updated.Status = order.Status
+	updated.DroneID = nil
+	if order.DroneID != nil {
+		droneID := *order.DroneID
+		updated.DroneID = &droneID
+	}
+	updated.PickupLat, updated.PickupLon = order.PickupLat, order.PickupLon
+	updated.UpdatedAt = order.UpdatedAt
+	updated.Version++
+	r.orders[order.ID.String()] = updated
+	order.Version++
+	return nil
+}
+
+// UpdateOrderCoords changes the route of an order and the dispatch times derived from
+// it; the pickup point moves with the origin
+func (r *MemoryRepository) UpdateOrderCoords(ctx context.Context, order *domain.Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.orders[order.ID.String()]
+	if !ok || stored.Version != order.Version {
+		return domain.ErrConflict
+	}
+	updated := copyOrder(stored)
+	updated.OriginLat, updated.OriginLon = order.OriginLat, order.OriginLon
+	updated.PickupLat, updated.PickupLon = order.OriginLat, order.OriginLon
+	updated.DestLat, updated.DestLon = order.DestLat, order.DestLon
+	updated.DispatchBy = order.DispatchBy
+	updated.DispatchAfter = nil
+	if order.DispatchAfter != nil {
+		dispatchAfter := *order.DispatchAfter
+		updated.DispatchAfter = &dispatchAfter
+	}
+	updated.UpdatedAt = order.UpdatedAt
+	updated.Version++
+	r.orders[order.ID.String()] = updated
+	order.Version++
+	return nil
+}
+
+// --- Order Leg Implementation ---
+
+func copyLeg(l *domain.OrderLeg) *domain.OrderLeg {
+	c := *l
+	if l.EndedAt != nil {
+		endedAt := *l.EndedAt
+		c.EndedAt = &endedAt
+	}
+	return &c
+}
+
+func (r *MemoryRepository) CreateOrderLeg(ctx context.Context, leg *domain.OrderLeg) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	orderID := leg.OrderID.String()
+	r.legs[orderID] = append(r.legs[orderID], copyLeg(leg))
+	return nil
+}
+
+func (r *MemoryRepository) GetOrderLegs(ctx context.Context, orderID string) ([]*domain.OrderLeg, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var legs []*domain.OrderLeg
+	for _, leg := range r.legs[orderID] {
+		legs = append(legs, copyLeg(leg))
+	}
+	sort.SliceStable(legs, func(i, j int) bool {
+		if !legs[i].StartedAt.Equal(legs[j].StartedAt) {
+			return legs[i].StartedAt.Before(legs[j].StartedAt)
+		}
+		return legs[i].ID.String() < legs[j].ID.String()
+	})
+	return legs, nil
+}
+
+func (r *MemoryRepository) UpdateOrderLeg(ctx context.Context, leg *domain.OrderLeg) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, stored := range r.legs[leg.OrderID.String()] {
+		if stored.ID == leg.ID {
+			updated := copyLeg(leg)
+			stored.ToLat, stored.ToLon = updated.ToLat, updated.ToLon
+			stored.Outcome = updated.Outcome
+			stored.EndedAt = updated.EndedAt
+			return nil
+		}
+	}
+	return nil
+}
+
+// --- Order History Implementation ---
+
+func copyEvent(e *domain.OrderEvent) *domain.OrderEvent {
+	c := *e
+	if e.DroneID != nil {
+		droneID := *e.DroneID
+		c.DroneID = &droneID
+	}
+	if e.Latitude != nil {
+		lat, lon := *e.Latitude, *e.Longitude
+		c.Latitude, c.Longitude = &lat, &lon
+	}
+	return &c
+}
+
+func (r *MemoryRepository) AppendOrderEvent(ctx context.Context, event *domain.OrderEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	orderID := event.OrderID.String()
+	r.events[orderID] = append(r.events[orderID], copyEvent(event))
+	return nil
+}
+
+func (r *MemoryRepository) GetOrderEvents(ctx context.Context, orderID string) ([]*domain.OrderEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var events []*domain.OrderEvent
+	for _, event := range r.events[orderID] {
+		events = append(events, copyEvent(event))
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].CreatedAt.Equal(events[j].CreatedAt) {
+			return events[i].CreatedAt.Before(events[j].CreatedAt)
+		}
+		return events[i].ID.String() < events[j].ID.String()
+	})
+	return events, nil
+}
+
+// --- Listing helpers ---
+
+func inBoundingBox(box *domain.BoundingBox, lat, lon float64) bool {
+	if box == nil {
+		return true
+	}
+	return lat >= box.MinLat && lat <= box.MaxLat && lon >= box.MinLon && lon <= box.MaxLon
+}
+
+// memoryPage sorts and paginates items the way listPage does in SQL: ordered by
+// (sort column, id), continuing after the cursor, with the total, if asked for, ignoring
+// the cursor
+func memoryPage[T any](items []T, opts domain.ListOptions, sortKey func(T, domain.SortField) (time.Time, string)) (*domain.Page[T], error) {
+	opts = normalizeListOptions(opts)
+	if _, ok := sortColumns[opts.SortBy]; !ok {
+		return nil, fmt.Errorf("unsupported sort field %q", opts.SortBy)
+	}
+
+	less := func(at time.Time, aid string, bt time.Time, bid string) bool {
+		if opts.Desc {
+			at, aid, bt, bid = bt, bid, at, aid
+		}
+		if !at.Equal(bt) {
+			return at.Before(bt)
+		}
+		return aid < bid
+	}
+	sort.Slice(items, func(i, j int) bool {
+		it, iid := sortKey(items[i], opts.SortBy)
+		jt, jid := sortKey(items[j], opts.SortBy)
+		return less(it, iid, jt, jid)
+	})
+
+	page := &domain.Page[T]{Items: make([]T, 0, opts.Limit)}
+	if opts.WithTotal {
+		page.Total = len(items)
+	}
+	if opts.Cursor != "" {
+		value, id, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		start := sort.Search(len(items), func(i int) bool {
+			t, iid := sortKey(items[i], opts.SortBy)
+			return less(value, id, t, iid)
+		})
+		items = items[start:]
+	}
+
+	if len(items) > opts.Limit {
+		page.Items = append(page.Items, items[:opts.Limit]...)
+		value, id := sortKey(page.Items[opts.Limit-1], opts.SortBy)
+		page.NextCursor = encodeCursor(value, id)
+	} else {
+		page.Items = append(page.Items, items...)
+	}
+	return page, nil
+}
@@ -0,0 +1,337 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDrone(t *testing.T, r *MemoryRepository, name string) *domain.Drone {
+	drone := &domain.Drone{ID: ksuid.New(), Name: name, Status: domain.DroneStatusIdle, CreatedAt: time.Now()}
+	require.NoError(t, r.CreateDrone(context.Background(), drone))
+	return drone
+}
+
+func newTestOrder(t *testing.T, r *MemoryRepository, createdAt time.Time) *domain.Order {
+	order := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending, DispatchBy: createdAt, CreatedAt: createdAt, UpdatedAt: createdAt}
+	require.NoError(t, r.CreateOrder(context.Background(), order))
+	return order
+}
+
+func TestMemoryRepository_ClaimsOldestPendingOrder(t *testing.T) {
+	r := NewMemoryRepository()
+	drone := newTestDrone(t, r, "drone-001")
+	now := time.Now()
+	newer := newTestOrder(t, r, now)
+	older := newTestOrder(t, r, now.Add(-time.Minute))
+
+	claimed, err := r.ClaimNextPendingOrder(context.Background(), drone.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, older.ID, claimed.ID)
+	assert.Equal(t, domain.OrderStatusReserved, claimed.Status)
+	assert.Equal(t, drone.ID, *claimed.DroneID)
+	assert.Equal(t, 2, claimed.Version)
+
+	claimed, err = r.ClaimNextPendingOrder(context.Background(), drone.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, newer.ID, claimed.ID)
+
+	_, err = r.ClaimNextPendingOrder(context.Background(), drone.ID.String())
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+}
+
+func TestMemoryRepository_ClaimsInQueueOrder(t *testing.T) {
+	r := NewMemoryRepository()
+	drone := newTestDrone(t, r, "drone-001")
+	now := time.Now()
+	older := newTestOrder(t, r, now.Add(-time.Minute))
+	urgent := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending, Priority: domain.OrderPriorityMedical,
+		DispatchBy: now.Add(-time.Hour), CreatedAt: now, UpdatedAt: now}
+	require.NoError(t, r.CreateOrder(context.Background(), urgent))
+
+	claimed, err := r.ClaimNextPendingOrder(context.Background(), drone.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, urgent.ID, claimed.ID, "less slack goes first, whatever the creation time")
+
+	claimed, err = r.ClaimNextPendingOrder(context.Background(), drone.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, older.ID, claimed.ID)
+}
+
+func TestMemoryRepository_ClaimsOnlyOrdersDeliveredInWindow(t *testing.T) {
+	r := NewMemoryRepository()
+	drone := newTestDrone(t, r, "drone-001")
+	now := time.Now()
+	// 1.1 km to the pickup, then 1.1 km to the destination: 222s at 10 m/s
+	create := func(dispatchBy, deliverBy time.Time, deliverAfter *time.Time) *domain.Order {
+		order := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending, PickupLat: 0, PickupLon: 0.01, DestLat: 0, DestLon: 0.02,
+			DispatchBy: dispatchBy, DeliverBy: deliverBy, DeliverAfter: deliverAfter, CreatedAt: now, UpdatedAt: now}
+		require.NoError(t, r.CreateOrder(context.Background(), order))
+		return order
+	}
+	opens := now.Add(time.Hour)
+	create(now.Add(-2*time.Hour), now.Add(2*time.Hour), &opens)   // Would arrive before the window opens
+	create(now.Add(-time.Hour), now.Add(150*time.Second), nil)    // Would arrive late, another drone might not
+	hopeless := create(now, now.Add(time.Minute), nil)            // Late with any drone
+	fits := create(now.Add(time.Minute), now.Add(time.Hour), nil) // Fits
+	trip := domain.TripEstimate{Speed: 10, Now: now}
+
+	claimed, err := r.ClaimNextPendingOrderInWindow(context.Background(), drone.ID.String(), trip)
+	require.NoError(t, err)
+	assert.Equal(t, hopeless.ID, claimed.ID)
+
+	claimed, err = r.ClaimNextPendingOrderInWindow(context.Background(), drone.ID.String(), trip)
+	require.NoError(t, err)
+	assert.Equal(t, fits.ID, claimed.ID)
+
+	_, err = r.ClaimNextPendingOrderInWindow(context.Background(), drone.ID.String(), trip)
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+}
+
+func TestMemoryRepository_FlagsOrdersAtRiskOnce(t *testing.T) {
+	r := NewMemoryRepository()
+	now := time.Now()
+	create := func(status domain.OrderStatus, deliverBy time.Time) *domain.Order {
+		order := &domain.Order{ID: ksuid.New(), Status: status, DeliverBy: deliverBy, CreatedAt: now, UpdatedAt: now}
+		require.NoError(t, r.CreateOrder(context.Background(), order))
+		return order
+	}
+	due := create(domain.OrderStatusPickedUp, now.Add(5*time.Minute))
+	create(domain.OrderStatusPending, now.Add(time.Hour))
+	create(domain.OrderStatusDelivered, now.Add(5*time.Minute))
+
+	flagged, err := r.FlagOrdersAtRisk(context.Background(), now.Add(15*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, flagged, 1)
+	assert.Equal(t, due.ID, flagged[0].ID)
+	assert.NotNil(t, flagged[0].SLAAtRiskAt)
+
+	flagged, err = r.FlagOrdersAtRisk(context.Background(), now.Add(15*time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, flagged)
+
+	page, err := r.ListOrders(context.Background(), domain.OrderFilter{AtRisk: true, ListOptions: domain.ListOptions{WithTotal: true}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, page.Total)
+}
+
+func TestMemoryRepository_ReleasesScheduledOrdersWhenDue(t *testing.T) {
+	r := NewMemoryRepository()
+	drone := newTestDrone(t, r, "drone-001")
+	now := time.Now()
+	schedule := func(dispatchAfter time.Time) *domain.Order {
+		order := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusScheduled, DispatchAfter: &dispatchAfter, CreatedAt: now, UpdatedAt: now}
+		require.NoError(t, r.CreateOrder(context.Background(), order))
+		return order
+	}
+	due := schedule(now.Add(-time.Second))
+	schedule(now.Add(time.Hour))
+
+	// Scheduled orders are not in the dispatch queue
+	_, err := r.ClaimNextPendingOrder(context.Background(), drone.ID.String())
+	assert.Equal(t, domain.ErrNotFound, err)
+
+	released, err := r.ReleaseScheduledOrders(context.Background(), now)
+	require.NoError(t, err)
+	require.Len(t, released, 1)
+	assert.Equal(t, due.ID, released[0].ID)
+	assert.Equal(t, domain.OrderStatusPending, released[0].Status)
+
+	released, err = r.ReleaseScheduledOrders(context.Background(), now)
+	require.NoError(t, err)
+	assert.Empty(t, released)
+}
+
+func TestMemoryRepository_ClaimsAGivenPendingOrderOnce(t *testing.T) {
+	r := NewMemoryRepository()
+	first := newTestDrone(t, r, "drone-001")
+	second := newTestDrone(t, r, "drone-002")
+	order := newTestOrder(t, r, time.Now())
+
+	claimed, err := r.ClaimPendingOrder(context.Background(), order.ID.String(), first.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, domain.OrderStatusReserved, claimed.Status)
+	assert.Equal(t, first.ID, *claimed.DroneID)
+
+	_, err = r.ClaimPendingOrder(context.Background(), order.ID.String(), second.ID.String())
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+}
+
+func TestMemoryRepository_ReservesAnAssignmentTogether(t *testing.T) {
+	r := NewMemoryRepository()
+	free := newTestDrone(t, r, "drone-001")
+	busy := newTestDrone(t, r, "drone-002")
+	now := time.Now()
+	held := newTestOrder(t, r, now)
+	first := newTestOrder(t, r, now)
+	second := newTestOrder(t, r, now)
+	_, err := r.ClaimPendingOrder(context.Background(), held.ID.String(), busy.ID.String())
+	require.NoError(t, err)
+
+	reserved, err := r.ReserveAssignments(context.Background(), []domain.Assignment{
+		{DroneID: free.ID, OrderID: first.ID},
+		{DroneID: busy.ID, OrderID: second.ID}, // Still holds an order
+	})
+	require.NoError(t, err)
+	if assert.Len(t, reserved, 1) {
+		assert.Equal(t, first.ID, reserved[0].ID)
+		assert.Equal(t, domain.OrderStatusReserved, reserved[0].Status)
+	}
+	drone, err := r.GetDroneByID(context.Background(), free.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, domain.DroneStatusDelivering, drone.Status)
+	order, err := r.GetOrderByID(context.Background(), second.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, domain.OrderStatusPending, order.Status)
+}
+
+func TestMemoryRepository_ListsEveryActiveOrderOfADrone(t *testing.T) {
+	r := NewMemoryRepository()
+	drone := newTestDrone(t, r, "drone-001")
+	now := time.Now()
+	first := newTestOrder(t, r, now)
+	second := newTestOrder(t, r, now)
+	newTestOrder(t, r, now) // Never claimed
+
+	orders, err := r.GetActiveOrdersByDroneID(context.Background(), drone.ID.String())
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+
+	for _, order := range []*domain.Order{second, first} {
+		_, err = r.ClaimPendingOrder(context.Background(), order.ID.String(), drone.ID.String())
+		require.NoError(t, err)
+	}
+	orders, err = r.GetActiveOrdersByDroneID(context.Background(), drone.ID.String())
+	require.NoError(t, err)
+	if assert.Len(t, orders, 2) {
+		assert.True(t, orders[0].ID.String() < orders[1].ID.String())
+	}
+}
+
+func TestMemoryRepository_ConcurrentClaimsNeverShareAnOrder(t *testing.T) {
+	r := NewMemoryRepository()
+	const drones, orders = 20, 50
+	for i := 0; i < orders; i++ {
+		newTestOrder(t, r, time.Now())
+	}
+	var droneIDs []string
+	for i := 0; i < drones; i++ {
+		droneIDs = append(droneIDs, newTestDrone(t, r, ksuid.New().String()).ID.String())
+	}
+
+	var mu sync.Mutex
+	claims := map[ksuid.KSUID]int{}
+	var wg sync.WaitGroup
+	for _, droneID := range droneIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				order, err := r.ClaimNextPendingOrder(context.Background(), droneID)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				claims[order.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, claims, orders)
+	for id, n := range claims {
+		assert.Equal(t, 1, n, "order %s claimed %d times", id, n)
+	}
+}
+
+func TestMemoryRepository_UpdateRejectsStaleVersion(t *testing.T) {
+	r := NewMemoryRepository()
+	order := newTestOrder(t, r, time.Now())
+
+	first, _ := r.GetOrderByID(context.Background(), order.ID.String())
+	second, _ := r.GetOrderByID(context.Background(), order.ID.String())
+
+	first.Status = domain.OrderStatusCancelled
+	require.NoError(t, r.UpdateOrder(context.Background(), first))
+	assert.Equal(t, 2, first.Version)
+
+	second.Status = domain.OrderStatusReserved
+	assert.ErrorIs(t, r.UpdateOrder(context.Background(), second), domain.ErrConflict)
+
+	stored, _ := r.GetOrderByID(context.Background(), order.ID.String())
+	assert.Equal(t, domain.OrderStatusCancelled, stored.Status)
+}
+
+func TestMemoryRepository_ReturnsCopies(t *testing.T) {
+	r := NewMemoryRepository()
+	drone := newTestDrone(t, r, "drone-001")
+
+	got, _ := r.GetDroneByID(context.Background(), drone.ID.String())
+	got.Status = domain.DroneStatusBroken
+
+	stored, _ := r.GetDroneByID(context.Background(), drone.ID.String())
+	assert.Equal(t, domain.DroneStatusIdle, stored.Status)
+}
+
+func TestMemoryRepository_IdempotentCreateReplays(t *testing.T) {
+	r := NewMemoryRepository()
+	key := func(orderID ksuid.KSUID, hash string) *domain.IdempotencyKey {
+		return &domain.IdempotencyKey{UserID: "enduser:alice", Key: "k1", RequestHash: hash, OrderID: orderID, ExpiresAt: time.Now().Add(time.Hour)}
+	}
+	first := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending}
+	_, replayed, err := r.CreateOrderIdempotent(context.Background(), first, key(first.ID, "h1"))
+	require.NoError(t, err)
+	assert.False(t, replayed)
+
+	retry := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending}
+	stored, replayed, err := r.CreateOrderIdempotent(context.Background(), retry, key(retry.ID, "h1"))
+	require.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, first.ID, stored.ID)
+
+	other := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending}
+	_, _, err = r.CreateOrderIdempotent(context.Background(), other, key(other.ID, "h2"))
+	assert.ErrorIs(t, err, domain.ErrIdempotencyKeyReused)
+
+	counts, _ := r.CountOrdersByStatus(context.Background())
+	assert.Equal(t, int64(1), counts[domain.OrderStatusPending])
+}
+
+func TestMemoryRepository_ListOrdersPaginates(t *testing.T) {
+	r := NewMemoryRepository()
+	start := time.Now()
+	var ids []ksuid.KSUID
+	for i := 0; i < 5; i++ {
+		ids = append(ids, newTestOrder(t, r, start.Add(time.Duration(i)*time.Second)).ID)
+	}
+
+	filter := domain.OrderFilter{ListOptions: domain.ListOptions{Limit: 2, Desc: true, WithTotal: true}}
+	var seen []ksuid.KSUID
+	for {
+		page, err := r.ListOrders(context.Background(), filter)
+		require.NoError(t, err)
+		assert.Equal(t, 5, page.Total)
+		for _, order := range page.Items {
+			seen = append(seen, order.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		filter.Cursor = page.NextCursor
+	}
+
+	assert.Equal(t, []ksuid.KSUID{ids[4], ids[3], ids[2], ids[1], ids[0]}, seen)
+
+	// Internal callers that only walk the pages skip the count
+	page, err := r.ListOrders(context.Background(), domain.OrderFilter{ListOptions: domain.ListOptions{Limit: 2}})
+	require.NoError(t, err)
+	assert.Len(t, page.Items, 2)
+	assert.Zero(t, page.Total)
+}
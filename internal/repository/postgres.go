@@ -1,48 +1,82 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"time"
 
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 )
 
 type DroneRepository interface {
-	CreateDrone(drone *domain.Drone) error
-	GetDroneByID(id string) (*domain.Drone, error)
-	GetDroneByName(name string) (*domain.Drone, error)
-	GetIdleDrones() ([]*domain.Drone, error)
-	GetActiveDrones() ([]*domain.Drone, error)
-	GetAllDrones() ([]*domain.Drone, error)
-	UpdateDrone(drone *domain.Drone) error
+	CreateDrone(ctx context.Context, drone *domain.Drone) error
+	GetDroneByID(ctx context.Context, id string) (*domain.Drone, error)
+	GetDroneByName(ctx context.Context, name string) (*domain.Drone, error)
+	GetIdleDrones(ctx context.Context) ([]*domain.Drone, error)
+	GetActiveDrones(ctx context.Context) ([]*domain.Drone, error)
+	ListDrones(ctx context.Context, filter domain.DroneFilter) (*domain.Page[*domain.Drone], error)
+	UpdateDrone(ctx context.Context, drone *domain.Drone) error
 }
 
 type OrderRepository interface {
-	CreateOrder(order *domain.Order) error
-	GetOrderByID(id string) (*domain.Order, error)
-	GetActiveOrderByDroneID(droneID string) (*domain.Order, error)
-	GetNextPendingOrder() (*domain.Order, error)
-	ClaimNextPendingOrder(droneID string) (*domain.Order, error)
-	GetAllOrders() ([]*domain.Order, error)
-	UpdateOrder(order *domain.Order) error
-	UpdateOrderCoords(id string, originLat, originLon, destLat, destLon float64) error
+	CreateOrder(ctx context.Context, order *domain.Order) error
+	CreateOrderIdempotent(ctx context.Context, order *domain.Order, key *domain.IdempotencyKey) (*domain.Order, bool, error)
+	GetOrderByID(ctx context.Context, id string) (*domain.Order, error)
+	GetActiveOrdersByDroneID(ctx context.Context, droneID string) ([]*domain.Order, error)
+	GetNextPendingOrder(ctx context.Context) (*domain.Order, error)
+	ClaimNextPendingOrder(ctx context.Context, droneID string) (*domain.Order, error)
+	ClaimNextPendingOrderInWindow(ctx context.Context, droneID string, trip domain.TripEstimate) (*domain.Order, error)
+	ClaimPendingOrder(ctx context.Context, orderID, droneID string) (*domain.Order, error)
+	ReserveAssignments(ctx context.Context, pairs []domain.Assignment) ([]*domain.Order, error)
+	FlagOrdersAtRisk(ctx context.Context, deadline time.Time) ([]*domain.Order, error)
+	ReleaseScheduledOrders(ctx context.Context, now time.Time) ([]*domain.Order, error)
+	ListOrders(ctx context.Context, filter domain.OrderFilter) (*domain.Page[*domain.Order], error)
+	UpdateOrder(ctx context.Context, order *domain.Order) error
+	UpdateOrderCoords(ctx context.Context, order *domain.Order) error
+	AppendOrderEvent(ctx context.Context, event *domain.OrderEvent) error
+	GetOrderEvents(ctx context.Context, orderID string) ([]*domain.OrderEvent, error)
+	CreateOrderLeg(ctx context.Context, leg *domain.OrderLeg) error
+	GetOrderLegs(ctx context.Context, orderID string) ([]*domain.OrderLeg, error)
+	UpdateOrderLeg(ctx context.Context, leg *domain.OrderLeg) error
 }
 
+// defaultQueryTimeout bounds a single repository call so a stuck query cannot pin a connection
+const defaultQueryTimeout = 5 * time.Second
+
 type PostgresRepository struct {
-	db *sql.DB
+	db           *sql.DB
+	queryTimeout time.Duration
 }
 
 func NewPostgresRepository(connStr string) (*PostgresRepository, error) {
-	db, err := sql.Open("postgres", connStr)
+	// Every query, exec and transaction becomes a child span of the caller's trace
+	db, err := otelsql.Open("postgres", connStr, otelsql.WithAttributes(semconv.DBSystemPostgreSQL))
 	if err != nil {
 		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultQueryTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
 		return nil, err
 	}
 
-	return &PostgresRepository{db: db}, nil
+	return &PostgresRepository{db: db, queryTimeout: defaultQueryTimeout}, nil
+}
+
+func (r *PostgresRepository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, r.queryTimeout)
+}
+
+// Ping checks that the database is reachable
+func (r *PostgresRepository) Ping(ctx context.Context) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return r.db.PingContext(ctx)
 }
 
 // Close closes the database connection
@@ -52,40 +86,26 @@ func (r *PostgresRepository) Close() error {
 
 // --- Drone Implementation ---
 
-func (r *PostgresRepository) CreateDrone(drone *domain.Drone) error {
-	query := `INSERT INTO drones (id, name, status, latitude, longitude, created_at) 
-	          VALUES ($1, $2, $3, $4, $5, $6)`
-	_, err := r.db.Exec(query, drone.ID, drone.Name, drone.Status, drone.Latitude, drone.Longitude, drone.CreatedAt)
-	return err
-}
-
-func (r *PostgresRepository) GetDroneByID(id string) (*domain.Drone, error) {
-	query := `SELECT id, name, status, latitude, longitude, created_at FROM drones WHERE id = $1`
-	row := r.db.QueryRow(query, id)
+const droneColumns = `id, name, status, previous_status, latitude, longitude, capacity, battery, version, created_at, updated_at`
 
-	var drone domain.Drone
-	err := row.Scan(&drone.ID, &drone.Name, &drone.Status, &drone.Latitude, &drone.Longitude, &drone.CreatedAt)
-	if err == sql.ErrNoRows {
-		return nil, domain.ErrNotFound
-	}
-	return &drone, err
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-func (r *PostgresRepository) GetDroneByName(name string) (*domain.Drone, error) {
-	query := `SELECT id, name, status, latitude, longitude, created_at FROM drones WHERE name = $1`
-	row := r.db.QueryRow(query, name)
-
+func scanDrone(row rowScanner) (*domain.Drone, error) {
 	var drone domain.Drone
-	err := row.Scan(&drone.ID, &drone.Name, &drone.Status, &drone.Latitude, &drone.Longitude, &drone.CreatedAt)
-	if err == sql.ErrNoRows {
-		return nil, domain.ErrNotFound
+	var previousStatus sql.NullString
+	err := row.Scan(&drone.ID, &drone.Name, &drone.Status, &previousStatus, &drone.Latitude, &drone.Longitude, &drone.Capacity, &drone.Battery, &drone.Version, &drone.CreatedAt, &drone.UpdatedAt)
+	if err != nil {
+		return nil, err
 	}
-	return &drone, err
+	drone.PreviousStatus = domain.DroneStatus(previousStatus.String)
+	return &drone, nil
 }
 
-func (r *PostgresRepository) GetIdleDrones() ([]*domain.Drone, error) {
-	query := `SELECT id, name, status, latitude, longitude, created_at, updated_at FROM drones WHERE status = 'IDLE'`
-	rows, err := r.db.Query(query)
+func (r *PostgresRepository) queryDrones(ctx context.Context, query string, args ...interface{}) ([]*domain.Drone, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -93,163 +113,610 @@ func (r *PostgresRepository) GetIdleDrones() ([]*domain.Drone, error) {
 
 	var drones []*domain.Drone
 	for rows.Next() {
-		var drone domain.Drone
-		err := rows.Scan(&drone.ID, &drone.Name, &drone.Status, &drone.Latitude, &drone.Longitude, &drone.CreatedAt, &drone.UpdatedAt)
+		drone, err := scanDrone(rows)
 		if err != nil {
 			return nil, err
 		}
-		drones = append(drones, &drone)
+		drones = append(drones, drone)
 	}
-	return drones, nil
+	return drones, rows.Err()
 }
 
-func (r *PostgresRepository) GetActiveDrones() ([]*domain.Drone, error) {
-	query := `SELECT id, name, status, latitude, longitude, created_at, updated_at FROM drones WHERE status IN ('IDLE', 'DELIVERING')`
-	rows, err := r.db.Query(query)
-	if err != nil {
-		return nil, err
+func (r *PostgresRepository) CreateDrone(ctx context.Context, drone *domain.Drone) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	drone.Version = 1
+	query := `INSERT INTO drones (id, name, status, latitude, longitude, capacity, version, created_at) 
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := r.db.ExecContext(ctx, query, drone.ID, drone.Name, drone.Status, drone.Latitude, drone.Longitude, drone.Capacity, drone.Version, drone.CreatedAt)
+	return err
+}
+
+func (r *PostgresRepository) GetDroneByID(ctx context.Context, id string) (*domain.Drone, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + droneColumns + ` FROM drones WHERE id = $1`
+	drone, err := scanDrone(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrNotFound
 	}
-	defer rows.Close()
+	return drone, err
+}
 
-	var drones []*domain.Drone
-	for rows.Next() {
-		var drone domain.Drone
-		err := rows.Scan(&drone.ID, &drone.Name, &drone.Status, &drone.Latitude, &drone.Longitude, &drone.CreatedAt, &drone.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		drones = append(drones, &drone)
+func (r *PostgresRepository) GetDroneByName(ctx context.Context, name string) (*domain.Drone, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + droneColumns + ` FROM drones WHERE name = $1`
+	drone, err := scanDrone(r.db.QueryRowContext(ctx, query, name))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrNotFound
+	}
+	return drone, err
+}
+
+func (r *PostgresRepository) GetIdleDrones(ctx context.Context) ([]*domain.Drone, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	return r.queryDrones(ctx, `SELECT `+droneColumns+` FROM drones WHERE status = 'IDLE'`)
+}
+
+func (r *PostgresRepository) GetActiveDrones(ctx context.Context) ([]*domain.Drone, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	return r.queryDrones(ctx, `SELECT `+droneColumns+` FROM drones WHERE status IN ('IDLE', 'DELIVERING')`)
+}
+
+func (r *PostgresRepository) ListDrones(ctx context.Context, filter domain.DroneFilter) (*domain.Page[*domain.Drone], error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	q := &listQuery{}
+	if filter.Status != "" {
+		q.where("status = ?", filter.Status)
+	}
+	q.whereBoundingBox("latitude", "longitude", filter.BoundingBox)
+
+	return listPage(ctx, r, "drones", droneColumns, q, filter.ListOptions, scanDrone, droneSortKey)
+}
+
+func droneSortKey(d *domain.Drone, sortBy domain.SortField) (time.Time, string) {
+	if sortBy == domain.SortByUpdatedAt {
+		return d.UpdatedAt, d.ID.String()
+	}
+	return d.CreatedAt, d.ID.String()
+}
+
+// CountDronesByStatus returns the number of drones in each status (for fleet metrics)
+func (r *PostgresRepository) CountDronesByStatus(ctx context.Context) (map[domain.DroneStatus]int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	counts := make(map[domain.DroneStatus]int64)
+	err := r.countByStatus(ctx, "drones", func(status string, n int64) { counts[domain.DroneStatus(status)] = n })
+	return counts, err
+}
+
+// UpdateDrone writes the drone only if nobody else updated it since it was read
+// (optimistic locking on version); otherwise it returns domain.ErrConflict.
+func (r *PostgresRepository) UpdateDrone(ctx context.Context, drone *domain.Drone) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE drones SET status = $1, previous_status = NULLIF($2, ''), latitude = $3, longitude = $4, battery = $5, version = version + 1, updated_at = NOW()
+	          WHERE id = $6 AND version = $7`
+	res, err := r.db.ExecContext(ctx, query, drone.Status, string(drone.PreviousStatus), drone.Latitude, drone.Longitude, drone.Battery, drone.ID, drone.Version)
+	if err := checkVersionedUpdate(res, err); err != nil {
+		return err
+	}
+	drone.Version++
+	return nil
+}
+
+// --- Order Implementation ---
+
+const orderColumns = `id, status, priority, origin_lat, origin_lon, pickup_lat, pickup_lon, dest_lat, dest_lon, drone_id,
+	deliver_by, dispatch_by, deliver_after, dispatch_after, sla_at_risk_at, version, created_at, updated_at`
+
+func scanOrder(row rowScanner) (*domain.Order, error) {
+	var order domain.Order
+	err := row.Scan(&order.ID, &order.Status, &order.Priority, &order.OriginLat, &order.OriginLon, &order.PickupLat, &order.PickupLon,
+		&order.DestLat, &order.DestLon, &order.DroneID, &order.DeliverBy, &order.DispatchBy, &order.DeliverAfter, &order.DispatchAfter, &order.SLAAtRiskAt,
+		&order.Version, &order.CreatedAt, &order.UpdatedAt)
+	if err != nil {
+		return nil, err
 	}
-	return drones, nil
+	return &order, nil
 }
 
-func (r *PostgresRepository) GetAllDrones() ([]*domain.Drone, error) {
-	query := `SELECT id, name, status, latitude, longitude, created_at, updated_at FROM drones`
-	rows, err := r.db.Query(query)
+func (r *PostgresRepository) queryOrders(ctx context.Context, query string, args ...interface{}) ([]*domain.Order, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var drones []*domain.Drone
+	var orders []*domain.Order
 	for rows.Next() {
-		var drone domain.Drone
-		err := rows.Scan(&drone.ID, &drone.Name, &drone.Status, &drone.Latitude, &drone.Longitude, &drone.CreatedAt, &drone.UpdatedAt)
+		order, err := scanOrder(rows)
 		if err != nil {
 			return nil, err
 		}
-		drones = append(drones, &drone)
+		orders = append(orders, order)
 	}
-	return drones, nil
+	return orders, rows.Err()
 }
 
-func (r *PostgresRepository) UpdateDrone(drone *domain.Drone) error {
-	query := `UPDATE drones SET status = $1, latitude = $2, longitude = $3, updated_at = NOW() WHERE id = $4`
-	_, err := r.db.Exec(query, drone.Status, drone.Latitude, drone.Longitude, drone.ID)
-	return err
+func (r *PostgresRepository) queryOrder(ctx context.Context, query string, args ...interface{}) (*domain.Order, error) {
+	order, err := scanOrder(r.db.QueryRowContext(ctx, query, args...))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrNotFound
+	}
+	return order, err
 }
 
-// --- Order Implementation ---
+const insertOrderQuery = `INSERT INTO orders (id, status, priority, origin_lat, origin_lon, pickup_lat, pickup_lon, dest_lat, dest_lon,
+	          deliver_by, dispatch_by, deliver_after, dispatch_after, version, created_at, updated_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`
 
-func (r *PostgresRepository) CreateOrder(order *domain.Order) error {
-	query := `INSERT INTO orders (id, status, origin_lat, origin_lon, dest_lat, dest_lon, created_at, updated_at) 
-	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
-	_, err := r.db.Exec(query, order.ID, order.Status, order.OriginLat, order.OriginLon, order.DestLat, order.DestLon, order.CreatedAt, order.UpdatedAt)
+func insertOrderArgs(order *domain.Order) []interface{} {
+	return []interface{}{order.ID, order.Status, order.Priority, order.OriginLat, order.OriginLon, order.PickupLat, order.PickupLon,
+		order.DestLat, order.DestLon, order.DeliverBy, order.DispatchBy, order.DeliverAfter, order.DispatchAfter, order.Version, order.CreatedAt, order.UpdatedAt}
+}
+
+func (r *PostgresRepository) CreateOrder(ctx context.Context, order *domain.Order) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	order.Version = 1
+	_, err := r.db.ExecContext(ctx, insertOrderQuery, insertOrderArgs(order)...)
 	return err
 }
 
-func (r *PostgresRepository) GetOrderByID(id string) (*domain.Order, error) {
-	query := `SELECT id, status, origin_lat, origin_lon, dest_lat, dest_lon, drone_id, created_at, updated_at FROM orders WHERE id = $1`
-	row := r.db.QueryRow(query, id)
+// CreateOrderIdempotent inserts order and claims its idempotency key in one transaction.
+// If a live key already exists for the user, nothing is inserted: the original order is
+// returned with replayed=true, or ErrIdempotencyKeyReused if the request hash differs.
+// Expired keys are reclaimed by the new request.
+func (r *PostgresRepository) CreateOrderIdempotent(ctx context.Context, order *domain.Order, key *domain.IdempotencyKey) (*domain.Order, bool, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-	var order domain.Order
-	err := row.Scan(&order.ID, &order.Status, &order.OriginLat, &order.OriginLon, &order.DestLat, &order.DestLon, &order.DroneID, &order.CreatedAt, &order.UpdatedAt)
-	if err == sql.ErrNoRows {
-		return nil, domain.ErrNotFound
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
 	}
-	return &order, err
-}
+	defer tx.Rollback()
 
-func (r *PostgresRepository) GetActiveOrderByDroneID(droneID string) (*domain.Order, error) {
-	query := `SELECT id, status, origin_lat, origin_lon, dest_lat, dest_lon, drone_id, created_at, updated_at 
-	          FROM orders WHERE drone_id = $1 AND status IN ('RESERVED', 'PICKED_UP') LIMIT 1`
-	row := r.db.QueryRow(query, droneID)
+	order.Version = 1
+	if _, err := tx.ExecContext(ctx, insertOrderQuery, insertOrderArgs(order)...); err != nil {
+		return nil, false, err
+	}
 
-	var order domain.Order
-	err := row.Scan(&order.ID, &order.Status, &order.OriginLat, &order.OriginLon, &order.DestLat, &order.DestLon, &order.DroneID, &order.CreatedAt, &order.UpdatedAt)
-	if err == sql.ErrNoRows {
-		return nil, domain.ErrNotFound
+	// Concurrent requests with the same key block on the unique index until the first commits
+	claimKey := `INSERT INTO idempotency_keys (user_id, key, request_hash, order_id, created_at, expires_at)
+	             VALUES ($1, $2, $3, $4, $5, $6)
+	             ON CONFLICT (user_id, key) DO UPDATE
+	             SET request_hash = EXCLUDED.request_hash, order_id = EXCLUDED.order_id,
+	                 created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+	             WHERE idempotency_keys.expires_at < NOW()
+	             RETURNING order_id`
+	var claimedOrderID string
+	err = tx.QueryRowContext(ctx, claimKey, key.UserID, key.Key, key.RequestHash, key.OrderID, key.CreatedAt, key.ExpiresAt).Scan(&claimedOrderID)
+	if err == nil {
+		if err := tx.Commit(); err != nil {
+			return nil, false, err
+		}
+		return order, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, err
 	}
-	return &order, err
-}
 
-func (r *PostgresRepository) GetNextPendingOrder() (*domain.Order, error) {
-	query := `SELECT id, status, origin_lat, origin_lon, dest_lat, dest_lon, drone_id, created_at, updated_at 
-	          FROM orders WHERE status = 'PENDING' ORDER BY created_at ASC LIMIT 1`
-	row := r.db.QueryRow(query)
+	// Live key owned by an earlier request: discard our order and replay theirs
+	var existingHash, existingOrderID string
+	lookup := `SELECT request_hash, order_id FROM idempotency_keys WHERE user_id = $1 AND key = $2`
+	if err := tx.QueryRowContext(ctx, lookup, key.UserID, key.Key).Scan(&existingHash, &existingOrderID); err != nil {
+		return nil, false, err
+	}
+	if existingHash != key.RequestHash {
+		return nil, false, domain.ErrIdempotencyKeyReused
+	}
+	tx.Rollback()
 
-	var order domain.Order
-	err := row.Scan(&order.ID, &order.Status, &order.OriginLat, &order.OriginLon, &order.DestLat, &order.DestLon, &order.DroneID, &order.CreatedAt, &order.UpdatedAt)
-	if err == sql.ErrNoRows {
-		return nil, domain.ErrNotFound
+	original, err := r.GetOrderByID(ctx, existingOrderID)
+	if err != nil {
+		return nil, false, err
 	}
-	return &order, err
+	return original, true, nil
 }
 
-func (r *PostgresRepository) ClaimNextPendingOrder(droneID string) (*domain.Order, error) {
+func (r *PostgresRepository) GetOrderByID(ctx context.Context, id string) (*domain.Order, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	return r.queryOrder(ctx, `SELECT `+orderColumns+` FROM orders WHERE id = $1`, id)
+}
+
+// GetActiveOrdersByDroneID returns the orders a drone has reserved or carries, a
+// whole batch for drones with capacity for several
+func (r *PostgresRepository) GetActiveOrdersByDroneID(ctx context.Context, droneID string) ([]*domain.Order, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + orderColumns + ` 
+	          FROM orders WHERE drone_id = $1 AND status IN ('RESERVED', 'PICKED_UP') ORDER BY id`
+	return r.queryOrders(ctx, query, droneID)
+}
+
+func (r *PostgresRepository) GetNextPendingOrder(ctx context.Context) (*domain.Order, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + orderColumns + ` 
+	          FROM orders WHERE status = 'PENDING' ORDER BY dispatch_by ASC, id ASC LIMIT 1`
+	return r.queryOrder(ctx, query)
+}
+
+func (r *PostgresRepository) ClaimNextPendingOrder(ctx context.Context, droneID string) (*domain.Order, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
 	// Atomic reservation using FOR UPDATE SKIP LOCKED
-	// This finds the next pending order, locks it (skipping already locked ones), and updates it.
+	// This finds the next pending order in queue order (dispatch_by, see domain.Order),
+	// locks it (skipping already locked ones), and updates it.
 	query := `
 		UPDATE orders
-		SET status = 'RESERVED', drone_id = $1, updated_at = NOW()
+		SET status = 'RESERVED', drone_id = $1, version = version + 1, updated_at = NOW()
 		WHERE id = (
 			SELECT id
 			FROM orders
 			WHERE status = 'PENDING'
-			ORDER BY created_at ASC
+			ORDER BY dispatch_by ASC, id ASC
 			FOR UPDATE SKIP LOCKED
 			LIMIT 1
 		)
-		RETURNING id, status, origin_lat, origin_lon, dest_lat, dest_lon, drone_id, created_at, updated_at
-	`
-	row := r.db.QueryRow(query, droneID)
+		RETURNING ` + orderColumns
+	return r.queryOrder(ctx, query, droneID)
+}
+
+// ClaimNextPendingOrderInWindow is ClaimNextPendingOrder restricted to the orders the
+// drone would deliver inside their window on trip (see domain.TripEstimate), so queue
+// order and the window are resolved in one statement
+func (r *PostgresRepository) ClaimNextPendingOrderInWindow(ctx context.Context, droneID string, trip domain.TripEstimate) (*domain.Order, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	// $2/$3 is the drone's position, $4 its speed in m/s, $5 the departure
+	query := `
+		UPDATE orders
+		SET status = 'RESERVED', drone_id = $1, version = version + 1, updated_at = NOW()
+		WHERE id = (
+			SELECT o.id
+			FROM orders o
+			CROSS JOIN LATERAL (
+				SELECT ` + sqlDistance("$2", "$3", "o.pickup_lat", "o.pickup_lon") + ` AS to_pickup,
+				       ` + sqlDistance("o.pickup_lat", "o.pickup_lon", "o.dest_lat", "o.dest_lon") + ` AS delivery
+			) d
+			CROSS JOIN LATERAL (
+				SELECT $5::timestamptz + make_interval(secs => (d.to_pickup + d.delivery) / $4) AS arrival,
+				       $5::timestamptz + make_interval(secs => d.delivery / $4) AS earliest
+			) eta
+			WHERE o.status = 'PENDING'
+			  AND (o.deliver_after IS NULL OR o.deliver_after < eta.arrival + INTERVAL '1 second')
+			  AND (eta.earliest > o.deliver_by OR eta.arrival < o.deliver_by + INTERVAL '1 second')
+			ORDER BY o.dispatch_by ASC, o.id ASC
+			FOR UPDATE OF o SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING ` + orderColumns
+	return r.queryOrder(ctx, query, droneID, trip.Lat, trip.Lon, trip.Speed, trip.Now)
+}
 
-	var order domain.Order
-	err := row.Scan(&order.ID, &order.Status, &order.OriginLat, &order.OriginLon, &order.DestLat, &order.DestLon, &order.DroneID, &order.CreatedAt, &order.UpdatedAt)
-	if err == sql.ErrNoRows {
-		return nil, domain.ErrNotFound
+// sqlDistance is domain.DistanceMeters as a SQL expression over two points
+func sqlDistance(lat1, lon1, lat2, lon2 string) string {
+	return fmt.Sprintf(`(2 * 6371000 * asin(least(1, sqrt(
+		power(sin(radians(%[3]s - %[1]s) / 2), 2) +
+		cos(radians(%[1]s)) * cos(radians(%[3]s)) * power(sin(radians(%[4]s - %[2]s) / 2), 2)))))`,
+		lat1, lon1, lat2, lon2)
+}
+
+// ClaimPendingOrder reserves orderID for droneID if it is still PENDING; ErrNotFound
+// means another drone claimed it first
+func (r *PostgresRepository) ClaimPendingOrder(ctx context.Context, orderID, droneID string) (*domain.Order, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE orders
+		SET status = 'RESERVED', drone_id = $1, version = version + 1, updated_at = NOW()
+		WHERE id = $2 AND status = 'PENDING'
+		RETURNING ` + orderColumns
+	return r.queryOrder(ctx, query, droneID, orderID)
+}
+
+// ReserveAssignments reserves each order for its drone and marks the drone DELIVERING,
+// all in one transaction. A pair whose drone is no longer IDLE and free, or whose order
+// is no longer PENDING, is skipped. It returns the orders reserved.
+func (r *PostgresRepository) ReserveAssignments(ctx context.Context, pairs []domain.Assignment) ([]*domain.Order, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lockDrone := `SELECT id FROM drones WHERE id = $1 AND status = 'IDLE'
+	              AND NOT EXISTS (SELECT 1 FROM orders WHERE drone_id = $1 AND status IN ('RESERVED', 'PICKED_UP'))
+	              FOR UPDATE`
+	claimOrder := `UPDATE orders SET status = 'RESERVED', drone_id = $1, version = version + 1, updated_at = NOW()
+	               WHERE id = $2 AND status = 'PENDING'
+	               RETURNING ` + orderColumns
+	startTrip := `UPDATE drones SET status = 'DELIVERING', version = version + 1, updated_at = NOW() WHERE id = $1`
+
+	var reserved []*domain.Order
+	for _, pair := range pairs {
+		var id string
+		err := tx.QueryRowContext(ctx, lockDrone, pair.DroneID).Scan(&id)
+		if err == sql.ErrNoRows {
+			continue // Taken or gone since the listing
+		}
+		if err != nil {
+			return nil, err
+		}
+		order, err := scanOrder(tx.QueryRowContext(ctx, claimOrder, pair.DroneID, pair.OrderID))
+		if err == sql.ErrNoRows {
+			continue // Claimed by another drone since the listing
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, startTrip, pair.DroneID); err != nil {
+			return nil, err
+		}
+		reserved = append(reserved, order)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return reserved, nil
+}
+
+// CountOrdersByStatus returns the number of orders in each status (for fleet metrics)
+func (r *PostgresRepository) CountOrdersByStatus(ctx context.Context) (map[domain.OrderStatus]int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	counts := make(map[domain.OrderStatus]int64)
+	err := r.countByStatus(ctx, "orders", func(status string, n int64) { counts[domain.OrderStatus(status)] = n })
+	return counts, err
+}
+
+func (r *PostgresRepository) countByStatus(ctx context.Context, table string, add func(status string, n int64)) error {
+	rows, err := r.db.QueryContext(ctx, `SELECT status, COUNT(*) FROM `+table+` GROUP BY status`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var n int64
+		if err := rows.Scan(&status, &n); err != nil {
+			return err
+		}
+		add(status, n)
+	}
+	return rows.Err()
+}
+
+func (r *PostgresRepository) ListOrders(ctx context.Context, filter domain.OrderFilter) (*domain.Page[*domain.Order], error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	q := &listQuery{}
+	if filter.Status != "" {
+		q.where("status = ?", filter.Status)
+	}
+	if filter.DroneID != "" {
+		q.where("drone_id = ?", filter.DroneID)
+	}
+	if filter.CreatedAfter != nil {
+		q.where("created_at >= ?", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		q.where("created_at < ?", *filter.CreatedBefore)
 	}
-	return &order, err
+	if filter.Priority != "" {
+		q.where("priority = ?", filter.Priority)
+	}
+	if filter.DispatchBefore != nil {
+		q.where("dispatch_by < ?", *filter.DispatchBefore)
+	}
+	if filter.AtRisk {
+		q.where("sla_at_risk_at IS NOT NULL")
+	}
+	q.whereBoundingBox("pickup_lat", "pickup_lon", filter.BoundingBox)
+
+	return listPage(ctx, r, "orders", orderColumns, q, filter.ListOptions, scanOrder, orderSortKey)
+}
+
+func orderSortKey(o *domain.Order, sortBy domain.SortField) (time.Time, string) {
+	switch sortBy {
+	case domain.SortByUpdatedAt:
+		return o.UpdatedAt, o.ID.String()
+	case domain.SortByDispatchBy:
+		return o.DispatchBy, o.ID.String()
+	}
+	return o.CreatedAt, o.ID.String()
 }
 
-func (r *PostgresRepository) GetAllOrders() ([]*domain.Order, error) {
-	query := `SELECT id, status, origin_lat, origin_lon, dest_lat, dest_lon, drone_id, created_at, updated_at FROM orders`
-	rows, err := r.db.Query(query)
+// FlagOrdersAtRisk marks the undelivered orders due before deadline as at risk and
+// returns them. Each order is flagged once.
+func (r *PostgresRepository) FlagOrdersAtRisk(ctx context.Context, deadline time.Time) ([]*domain.Order, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE orders
+		SET sla_at_risk_at = NOW(), version = version + 1, updated_at = NOW()
+		WHERE sla_at_risk_at IS NULL AND deliver_by < $1
+		  AND status IN ('SCHEDULED', 'PENDING', 'RESERVED', 'PICKED_UP', 'AWAITING_RECOVERY')
+		RETURNING ` + orderColumns
+	return r.queryOrders(ctx, query, deadline)
+}
+
+// ReleaseScheduledOrders moves the SCHEDULED orders due for dispatch by now to PENDING
+// and returns them
+func (r *PostgresRepository) ReleaseScheduledOrders(ctx context.Context, now time.Time) ([]*domain.Order, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE orders
+		SET status = 'PENDING', version = version + 1, updated_at = NOW()
+		WHERE status = 'SCHEDULED' AND dispatch_after <= $1
+		RETURNING ` + orderColumns
+	return r.queryOrders(ctx, query, now)
+}
+
+// UpdateOrder writes the order only if nobody else updated it since it was read
+// (optimistic locking on version); otherwise it returns domain.ErrConflict.
+func (r *PostgresRepository) UpdateOrder(ctx context.Context, order *domain.Order) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE orders SET status = $1, drone_id = $2, pickup_lat = $3, pickup_lon = $4, version = version + 1, updated_at = $5
+	          WHERE id = $6 AND version = $7`
+	res, err := r.db.ExecContext(ctx, query, order.Status, order.DroneID, order.PickupLat, order.PickupLon, order.UpdatedAt, order.ID, order.Version)
+	if err := checkVersionedUpdate(res, err); err != nil {
+		return err
+	}
+	order.Version++
+	return nil
+}
+
+// UpdateOrderCoords writes the new route of an order that has not been picked up yet,
+// so the parcel's pickup point moves with its origin, together with the dispatch times
+// derived from the route. The version must still be the one the caller read.
+func (r *PostgresRepository) UpdateOrderCoords(ctx context.Context, order *domain.Order) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE orders SET origin_lat = $1, origin_lon = $2, pickup_lat = $1, pickup_lon = $2, dest_lat = $3, dest_lon = $4,
+	          dispatch_by = $5, dispatch_after = $6, version = version + 1, updated_at = $7 WHERE id = $8 AND version = $9`
+	res, err := r.db.ExecContext(ctx, query, order.OriginLat, order.OriginLon, order.DestLat, order.DestLon,
+		order.DispatchBy, order.DispatchAfter, order.UpdatedAt, order.ID, order.Version)
+	if err := checkVersionedUpdate(res, err); err != nil {
+		return err
+	}
+	order.Version++
+	return nil
+}
+
+// checkVersionedUpdate maps an UPDATE ... WHERE version = $n that matched no row to ErrConflict
+func checkVersionedUpdate(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrConflict
+	}
+	return nil
+}
+
+// --- Order Leg Implementation ---
+
+func (r *PostgresRepository) CreateOrderLeg(ctx context.Context, leg *domain.OrderLeg) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO order_legs (id, order_id, drone_id, from_lat, from_lon, to_lat, to_lon, outcome, started_at, ended_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err := r.db.ExecContext(ctx, query, leg.ID, leg.OrderID, leg.DroneID, leg.FromLat, leg.FromLon, leg.ToLat, leg.ToLon,
+		leg.Outcome, leg.StartedAt, leg.EndedAt)
+	return err
+}
+
+func (r *PostgresRepository) GetOrderLegs(ctx context.Context, orderID string) ([]*domain.OrderLeg, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, order_id, drone_id, from_lat, from_lon, to_lat, to_lon, outcome, started_at, ended_at
+	          FROM order_legs WHERE order_id = $1 ORDER BY started_at ASC, id ASC`
+	rows, err := r.db.QueryContext(ctx, query, orderID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var orders []*domain.Order
+	var legs []*domain.OrderLeg
 	for rows.Next() {
-		var order domain.Order
-		err := rows.Scan(&order.ID, &order.Status, &order.OriginLat, &order.OriginLon, &order.DestLat, &order.DestLon, &order.DroneID, &order.CreatedAt, &order.UpdatedAt)
+		var leg domain.OrderLeg
+		err := rows.Scan(&leg.ID, &leg.OrderID, &leg.DroneID, &leg.FromLat, &leg.FromLon, &leg.ToLat, &leg.ToLon,
+			&leg.Outcome, &leg.StartedAt, &leg.EndedAt)
 		if err != nil {
 			return nil, err
 		}
-		orders = append(orders, &order)
+		legs = append(legs, &leg)
 	}
-	return orders, nil
+	return legs, rows.Err()
 }
 
-func (r *PostgresRepository) UpdateOrder(order *domain.Order) error {
-	query := `UPDATE orders SET status = $1, drone_id = $2, origin_lat = $3, origin_lon = $4, updated_at = $5 WHERE id = $6`
-	_, err := r.db.Exec(query, order.Status, order.DroneID, order.OriginLat, order.OriginLon, order.UpdatedAt, order.ID)
+func (r *PostgresRepository) UpdateOrderLeg(ctx context.Context, leg *domain.OrderLeg) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE order_legs SET to_lat = $1, to_lon = $2, outcome = $3, ended_at = $4 WHERE id = $5`
+	_, err := r.db.ExecContext(ctx, query, leg.ToLat, leg.ToLon, leg.Outcome, leg.EndedAt, leg.ID)
 	return err
 }
 
-func (r *PostgresRepository) UpdateOrderCoords(id string, originLat, originLon, destLat, destLon float64) error {
-	query := `UPDATE orders SET origin_lat = $1, origin_lon = $2, dest_lat = $3, dest_lon = $4, updated_at = NOW() WHERE id = $5`
-	_, err := r.db.Exec(query, originLat, originLon, destLat, destLon, id)
+// --- Order History Implementation ---
+
+func (r *PostgresRepository) AppendOrderEvent(ctx context.Context, event *domain.OrderEvent) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO order_events (id, order_id, from_status, to_status, actor, drone_id, latitude, longitude, reason, created_at)
+	          VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, $10)`
+	_, err := r.db.ExecContext(ctx, query, event.ID, event.OrderID, string(event.FromStatus), event.ToStatus, event.Actor,
+		event.DroneID, event.Latitude, event.Longitude, event.Reason, event.CreatedAt)
 	return err
 }
+
+func (r *PostgresRepository) GetOrderEvents(ctx context.Context, orderID string) ([]*domain.OrderEvent, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, order_id, from_status, to_status, actor, drone_id, latitude, longitude, reason, created_at
+	          FROM order_events WHERE order_id = $1 ORDER BY created_at ASC, id ASC`
+	rows, err := r.db.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*domain.OrderEvent
+	for rows.Next() {
+		var event domain.OrderEvent
+		var fromStatus sql.NullString
+		err := rows.Scan(&event.ID, &event.OrderID, &fromStatus, &event.ToStatus, &event.Actor,
+			&event.DroneID, &event.Latitude, &event.Longitude, &event.Reason, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		event.FromStatus = domain.OrderStatus(fromStatus.String)
+		events = append(events, &event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,107 @@
+package service
+
+import "math"
+
+// assign pairs rows with columns of a cost matrix at the least total cost, using
+// the Hungarian algorithm. A row gets at most one column and a column at most one
+// row; with more rows than columns some rows stay unpaired. An infinite cost
+// forbids a pair. It returns the column of each row, or -1.
+func assign(cost [][]float64) []int {
+	rows := len(cost)
+	if rows == 0 || len(cost[0]) == 0 {
+		return filled(rows, -1)
+	}
+	cols := len(cost[0])
+
+	// The algorithm below needs no more rows than columns: solve the transpose
+	if rows > cols {
+		transposed := make([][]float64, cols)
+		for j := range transposed {
+			transposed[j] = make([]float64, rows)
+			for i := range cost {
+				transposed[j][i] = cost[i][j]
+			}
+		}
+		result := filled(rows, -1)
+		for j, i := range assign(transposed) {
+			if i >= 0 {
+				result[i] = j
+			}
+		}
+		return result
+	}
+
+	// Forbidden pairs cost more than any allowed assignment, and are dropped after
+	var forbidden float64 = 1
+	for _, row := range cost {
+		for _, c := range row {
+			if !math.IsInf(c, 1) {
+				forbidden += math.Abs(c)
+			}
+		}
+	}
+	at := func(i, j int) float64 {
+		if math.IsInf(cost[i][j], 1) {
+			return forbidden
+		}
+		return cost[i][j]
+	}
+
+	// Shortest augmenting paths with row and column potentials u and v, 1-indexed;
+	// match[j] is the row paired with column j and column 0 is the virtual start
+	u := make([]float64, rows+1)
+	v := make([]float64, cols+1)
+	match := make([]int, cols+1)
+	way := make([]int, cols+1)
+	for i := 1; i <= rows; i++ {
+		match[0] = i
+		j0 := 0
+		minCost := filled(cols+1, math.Inf(1))
+		used := make([]bool, cols+1)
+		for match[j0] != 0 {
+			used[j0] = true
+			i0, delta, j1 := match[j0], math.Inf(1), 0
+			for j := 1; j <= cols; j++ {
+				if used[j] {
+					continue
+				}
+				if reduced := at(i0-1, j-1) - u[i0] - v[j]; reduced < minCost[j] {
+					minCost[j], way[j] = reduced, j0
+				}
+				if minCost[j] < delta {
+					delta, j1 = minCost[j], j
+				}
+			}
+			for j := 0; j <= cols; j++ {
+				if used[j] {
+					u[match[j]] += delta
+					v[j] -= delta
+				} else {
+					minCost[j] -= delta
+				}
+			}
+			j0 = j1
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			match[j0] = match[j1]
+			j0 = j1
+		}
+	}
+
+	result := filled(rows, -1)
+	for j := 1; j <= cols; j++ {
+		if i := match[j] - 1; i >= 0 && !math.IsInf(cost[i][j-1], 1) {
+			result[i] = j - 1
+		}
+	}
+	return result
+}
+
+func filled[T any](n int, value T) []T {
+	s := make([]T, n)
+	for i := range s {
+		s[i] = value
+	}
+	return s
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// totalCost sums the pairs of an assignment and counts them
+func totalCost(cost [][]float64, assignment []int) (float64, int) {
+	var total float64
+	var pairs int
+	for i, j := range assignment {
+		if j >= 0 {
+			total += cost[i][j]
+			pairs++
+		}
+	}
+	return total, pairs
+}
+
+// bruteForce tries every assignment, preferring more pairs, then the lower cost
+func bruteForce(cost [][]float64) (float64, int) {
+	best, bestPairs := math.Inf(1), -1
+	used := make([]bool, len(cost[0]))
+	var try func(i int, total float64, pairs int)
+	try = func(i int, total float64, pairs int) {
+		if i == len(cost) {
+			if pairs > bestPairs || (pairs == bestPairs && total < best) {
+				best, bestPairs = total, pairs
+			}
+			return
+		}
+		try(i+1, total, pairs)
+		for j := range cost[i] {
+			if !used[j] && !math.IsInf(cost[i][j], 1) {
+				used[j] = true
+				try(i+1, total+cost[i][j], pairs+1)
+				used[j] = false
+			}
+		}
+	}
+	try(0, 0, 0)
+	return best, bestPairs
+}
+
+func TestAssign_MatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for n := 0; n < 200; n++ {
+		rows, cols := 1+rng.Intn(5), 1+rng.Intn(5)
+		cost := make([][]float64, rows)
+		for i := range cost {
+			cost[i] = make([]float64, cols)
+			for j := range cost[i] {
+				cost[i][j] = float64(rng.Intn(1000))
+				if rng.Float64() < 0.2 {
+					cost[i][j] = math.Inf(1)
+				}
+			}
+		}
+
+		assignment := assign(cost)
+		total, pairs := totalCost(cost, assignment)
+		wantTotal, wantPairs := bruteForce(cost)
+		assert.Equal(t, wantPairs, pairs, "cost %v", cost)
+		assert.InDelta(t, wantTotal, total, 1e-6, "cost %v", cost)
+
+		seen := map[int]bool{}
+		for _, j := range assignment {
+			if j >= 0 {
+				assert.False(t, seen[j], "column %d assigned twice", j)
+				seen[j] = true
+			}
+		}
+	}
+}
+
+func TestAssign_Empty(t *testing.T) {
+	assert.Empty(t, assign(nil))
+	assert.Equal(t, []int{-1, -1}, assign([][]float64{{}, {}}))
+}
+
+// greedyAssign is what the event-driven worker does: in queue order, each order
+// takes the cheapest drone still free
+func greedyAssign(cost [][]float64) []int {
+	assignment := filled(len(cost), -1)
+	taken := make([]bool, len(cost))
+	for j := range cost[0] {
+		best := -1
+		for i := range cost {
+			if !taken[i] && !math.IsInf(cost[i][j], 1) && (best < 0 || cost[i][j] < cost[best][j]) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			taken[best] = true
+			assignment[best] = j
+		}
+	}
+	return assignment
+}
+
+// fleetFixture places drones and pending orders at random within about 5 km and
+// returns the batch dispatcher's cost matrix
+func fleetFixture(rng *rand.Rand, drones, orders int) [][]float64 {
+	rules := DispatchRules{MaxPickupDistance: 4000, PriorityWeight: 2000}
+	priorities := []domain.OrderPriority{domain.OrderPriorityMedical, domain.OrderPriorityExpress, domain.OrderPriorityStandard}
+	now := time.Now()
+	pending := make([]*domain.Order, orders)
+	for j := range pending {
+		pending[j] = &domain.Order{
+			ID:        ksuid.New(),
+			Priority:  priorities[rng.Intn(len(priorities))],
+			PickupLat: 30 + rng.Float64()*0.05, PickupLon: 31 + rng.Float64()*0.05,
+			DestLat: 30 + rng.Float64()*0.05, DestLon: 31 + rng.Float64()*0.05,
+			DispatchBy: now.Add(time.Duration(rng.Intn(60)-10) * time.Minute),
+		}
+	}
+	cost := make([][]float64, drones)
+	for i := range cost {
+		drone := &domain.Drone{ID: ksuid.New(), Status: domain.DroneStatusIdle,
+			Latitude: 30 + rng.Float64()*0.05, Longitude: 31 + rng.Float64()*0.05}
+		cost[i] = make([]float64, orders)
+		for j, order := range pending {
+			cost[i][j] = rules.cost(order, drone, now)
+		}
+	}
+	return cost
+}
+
+func TestAssign_NeverWorseThanGreedy(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	for n := 0; n < 50; n++ {
+		cost := fleetFixture(rng, 10, 15)
+		total, pairs := totalCost(cost, assign(cost))
+		greedyTotal, greedyPairs := totalCost(cost, greedyAssign(cost))
+		assert.GreaterOrEqual(t, pairs, greedyPairs)
+		if pairs == greedyPairs {
+			assert.LessOrEqual(t, total, greedyTotal+1e-6)
+		}
+	}
+}
+
+// The benchmarks report the summed cost (metres to the pickups plus priority
+// weight) of the pairs each strategy picks, next to the time it takes
+func benchmarkStrategy(b *testing.B, strategy func([][]float64) []int, drones, orders int) {
+	rng := rand.New(rand.NewSource(42))
+	fixtures := make([][][]float64, 20)
+	for i := range fixtures {
+		fixtures[i] = fleetFixture(rng, drones, orders)
+	}
+	var total float64
+	var pairs int
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		cost := fixtures[n%len(fixtures)]
+		t, p := totalCost(cost, strategy(cost))
+		total += t
+		pairs += p
+	}
+	b.ReportMetric(total/float64(b.N), "cost/round")
+	b.ReportMetric(float64(pairs)/float64(b.N), "pairs/round")
+}
+
+func BenchmarkDispatch_Greedy(b *testing.B) { benchmarkStrategy(b, greedyAssign, 50, 100) }
+func BenchmarkDispatch_Batch(b *testing.B)  { benchmarkStrategy(b, assign, 50, 100) }
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
+)
+
+// AvailabilityPublisher emits a drone.available event whenever a drone enters IDLE
+// (registration, reconnection, release by an admin), so the dispatcher can match it
+// against the backlog of pending orders.
+type AvailabilityPublisher struct {
+	publisher events.Publisher
+}
+
+func NewAvailabilityPublisher(publisher events.Publisher) *AvailabilityPublisher {
+	return &AvailabilityPublisher{publisher: publisher}
+}
+
+func (p *AvailabilityPublisher) OnDroneStatusChanged(ctx context.Context, droneID string, oldStatus, newStatus domain.DroneStatus, currentLat, currentLon float64) {
+	if newStatus != domain.DroneStatusIdle || oldStatus == domain.DroneStatusIdle {
+		return
+	}
+
+	event := domain.DroneAvailableEvent{
+		DroneID:   droneID,
+		Latitude:  currentLat,
+		Longitude: currentLon,
+		Timestamp: time.Now(),
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if err := p.publisher.Publish(ctx, "drone.available", event); err != nil {
+		log.Printf("Failed to publish DroneAvailable event for drone %s: %v", droneID, err)
+	}
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/lifecycle"
+	"go.opentelemetry.io/otel"
+)
+
+// BatchDispatcher replaces the OrderDispatcherWorker when dispatch.strategy is batch:
+// instead of matching each order or drone as its event arrives, it assigns every
+// pending order and idle drone together once per interval.
+type BatchDispatcher struct {
+	// Periodic runs dispatch every interval
+	*lifecycle.Periodic
+
+	subscriber events.Subscriber
+	dispatcher *DispatcherService
+	queues     WorkerQueues
+	// subscriptions is how many consumers Subscribe opened
+	subscriptions int
+}
+
+func NewBatchDispatcher(subscriber events.Subscriber, dispatcher *DispatcherService, queues WorkerQueues, interval time.Duration) *BatchDispatcher {
+	d := &BatchDispatcher{
+		subscriber: subscriber,
+		dispatcher: dispatcher,
+		queues:     queues,
+	}
+	d.Periodic = lifecycle.NewPeriodic("Batch Dispatcher", interval, d.dispatch)
+	return d
+}
+
+// Subscribe consumes the worker's queues, so order.created and drone.available events
+// do not pile up while nothing matches on them. The next round picks both sides up.
+func (d *BatchDispatcher) Subscribe() error {
+	skip := func(ctx context.Context, msg events.Message) error { return nil }
+	if err := d.subscriber.Subscribe(d.queues.OrderCreated, "order.created", skip); err != nil {
+		return err
+	}
+	d.subscriptions++
+	if err := d.subscriber.Subscribe(d.queues.DroneAvailable, "drone.available", skip); err != nil {
+		return err
+	}
+	d.subscriptions++
+	return nil
+}
+
+// Check fails when the dispatch loop is not running or has stalled for several
+// intervals, or when one of its consumers has stopped
+func (d *BatchDispatcher) Check(ctx context.Context) error {
+	if err := d.Periodic.Check(ctx); err != nil {
+		return err
+	}
+	if running := d.subscriber.Consumers(); running < d.subscriptions {
+		return fmt.Errorf("%d of %d consumers running", running, d.subscriptions)
+	}
+	return nil
+}
+
+func (d *BatchDispatcher) dispatch(ctx context.Context) {
+	ctx, span := otel.Tracer("batch-dispatcher").Start(ctx, "BatchDispatcher.dispatch")
+	defer span.End()
+
+	orders, err := d.dispatcher.DispatchBatch(ctx)
+	if err != nil {
+		log.Printf("BatchDispatcher: failed to dispatch pending orders: %v", err)
+	}
+	for _, order := range orders {
+		log.Printf("BatchDispatcher: assigned order %s to drone %s", order.ID, order.DroneID)
+	}
+}
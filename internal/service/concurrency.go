@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+)
+
+// maxConflictRetries bounds how often a read-modify-write is retried after losing a race
+const maxConflictRetries = 3
+
+// retryOnConflict runs a read-modify-write until it stops failing with ErrConflict.
+// A caller that pinned a version (ifMatch) gets the conflict straight back instead,
+// since re-reading would silently apply its change on top of someone else's.
+func retryOnConflict(ifMatch int, attempt func() error) error {
+	for i := 1; ; i++ {
+		err := attempt()
+		if err != domain.ErrConflict || ifMatch != domain.AnyVersion || i == maxConflictRetries {
+			return err
+		}
+	}
+}
+
+// checkVersion fails with ErrConflict when the caller expects a different version than stored
+func checkVersion(ifMatch, current int) error {
+	if ifMatch != domain.AnyVersion && ifMatch != current {
+		return domain.ErrConflict
+	}
+	return nil
+}
+
+// afterCommit keeps the caller's trace but drops its cancellation, so the follow-up work
+// of a committed change (audit trail, legs, observers, events) runs even if the client
+// has already gone away.
+func afterCommit(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
+}
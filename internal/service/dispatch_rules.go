@@ -0,0 +1,171 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+)
+
+// DispatchRules decide which drones may take an order. Zero values disable a rule.
+// Both the dispatcher and the dispatch-plan endpoint evaluate them through evaluate,
+// so the explanation cannot drift from what the dispatcher does.
+type DispatchRules struct {
+	MaxPickupDistance float64             // Metres from the drone to the pickup point
+	MaxFlightDistance float64             // Metres from the drone to the pickup, then to the destination
+	Geofence          *domain.BoundingBox // Drone, pickup and destination must lie inside
+	// BatteryRange is how many metres a fully charged drone flies; a drone's reported
+	// battery level scales it into the range it has left
+	BatteryRange float64
+	// MaxDetour is how many metres each extra order may add to the route of a drone
+	// with capacity for several; 0 dispatches one order per trip
+	MaxDetour   float64
+	CruiseSpeed float64 // m/s, for the ETAs along a route and the delivery window
+	// PriorityWeight is how many metres of extra flight to the pickup the batch
+	// dispatcher accepts to serve an order one priority class sooner
+	PriorityWeight float64
+	// BatteryWeight is how many metres of extra flight to the pickup the batch
+	// dispatcher accepts to send a fully charged drone rather than a flat one
+	BatteryWeight float64
+}
+
+// priorityRank orders the classes for the batch dispatcher, most urgent first
+var priorityRank = map[domain.OrderPriority]float64{
+	domain.OrderPriorityMedical:  0,
+	domain.OrderPriorityExpress:  1,
+	domain.OrderPriorityStandard: 2,
+}
+
+// cost is what the batch dispatcher minimises over all pairs: the flight to the
+// pickup, plus PriorityWeight per class below MEDICAL, plus BatteryWeight scaled by
+// the share of charge the drone has used, so fuller drones take the work. An order
+// past its dispatch_by costs like a MEDICAL one, so standard orders are not starved.
+// A drone that reports no battery level counts as fully charged. Pairs the rules
+// exclude cost +Inf.
+func (r DispatchRules) cost(order *domain.Order, drone *domain.Drone, now time.Time) float64 {
+	c := r.evaluate(order, drone, false, now)
+	if !c.Eligible() {
+		return math.Inf(1)
+	}
+	cost := c.PickupDistance
+	if drone.Battery != nil {
+		cost += r.BatteryWeight * (1 - *drone.Battery/100)
+	}
+	if order.DispatchBy.Before(now) {
+		return cost
+	}
+	return cost + r.PriorityWeight*priorityRank[order.Priority]
+}
+
+// perOrder reports whether the rules depend on the order in a way the repository
+// cannot check while claiming; the delivery window it checks itself (see trip)
+func (r DispatchRules) perOrder() bool {
+	return r.MaxPickupDistance > 0 || r.MaxFlightDistance > 0 || r.BatteryRange > 0 || r.Geofence != nil
+}
+
+// trip is the flight the repository checks delivery windows against when drone claims
+// an order at now; nil without a cruise speed, when no window excludes a drone
+func (r DispatchRules) trip(drone *domain.Drone, now time.Time) *domain.TripEstimate {
+	if r.CruiseSpeed <= 0 {
+		return nil
+	}
+	return &domain.TripEstimate{Lat: drone.Latitude, Lon: drone.Longitude, Speed: r.CruiseSpeed, Now: now}
+}
+
+// evaluate applies the rules to one drone for one order, dispatched at now. busy is
+// whether the drone still holds active orders.
+func (r DispatchRules) evaluate(order *domain.Order, drone *domain.Drone, busy bool, now time.Time) domain.DispatchCandidate {
+	pickupDistance := domain.DistanceMeters(drone.Latitude, drone.Longitude, order.PickupLat, order.PickupLon)
+	deliveryDistance := domain.DistanceMeters(order.PickupLat, order.PickupLon, order.DestLat, order.DestLon)
+	c := domain.DispatchCandidate{
+		DroneID:        drone.ID,
+		DroneName:      drone.Name,
+		DroneStatus:    drone.Status,
+		PickupDistance: math.Round(pickupDistance),
+		FlightDistance: math.Round(pickupDistance + deliveryDistance),
+	}
+	exclude := func(reason domain.ExclusionReason, format string, args ...interface{}) {
+		c.Exclusions = append(c.Exclusions, domain.DispatchExclusion{Reason: reason, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	if drone.Status != domain.DroneStatusIdle {
+		exclude(domain.ExcludedByStatus, "drone is %s", drone.Status)
+	}
+	if busy {
+		exclude(domain.ExcludedByCapacity, "drone is still on a trip")
+	}
+	if r.MaxPickupDistance > 0 && c.PickupDistance > r.MaxPickupDistance {
+		exclude(domain.ExcludedByDistance, "pickup is %.0f m away, limit is %.0f m", c.PickupDistance, r.MaxPickupDistance)
+	}
+	if r.MaxFlightDistance > 0 && c.FlightDistance > r.MaxFlightDistance {
+		exclude(domain.ExcludedByRange, "delivery needs %.0f m of flight, limit is %.0f m", c.FlightDistance, r.MaxFlightDistance)
+	}
+	if r.BatteryRange > 0 && drone.Battery != nil {
+		if left := math.Round(r.BatteryRange * *drone.Battery / 100); c.FlightDistance > left {
+			exclude(domain.ExcludedByBattery, "delivery needs %.0f m of flight, battery at %.0f%% leaves %.0f m", c.FlightDistance, *drone.Battery, left)
+		}
+	}
+	if r.Geofence != nil {
+		for _, p := range []struct {
+			what     string
+			lat, lon float64
+		}{
+			{"drone", drone.Latitude, drone.Longitude},
+			{"pickup", order.PickupLat, order.PickupLon},
+			{"destination", order.DestLat, order.DestLon},
+		} {
+			if !inBox(r.Geofence, p.lat, p.lon) {
+				exclude(domain.ExcludedByGeofence, "%s %.5f,%.5f is outside the service area", p.what, p.lat, p.lon)
+			}
+		}
+	}
+	if r.CruiseSpeed > 0 {
+		r.checkWindow(order, pickupDistance, deliveryDistance, now, exclude)
+	}
+	return c
+}
+
+// checkWindow excludes a drone that would deliver outside the order's window, flying
+// to the pickup and on to the destination at CruiseSpeed. An order that would be late
+// even if its drone were already at the pickup is late with any drone, so then the
+// deadline excludes none.
+func (r DispatchRules) checkWindow(order *domain.Order, pickupDistance, deliveryDistance float64, now time.Time,
+	exclude func(reason domain.ExclusionReason, format string, args ...interface{})) {
+	flight := func(meters float64) time.Duration {
+		return time.Duration(meters / r.CruiseSpeed * float64(time.Second))
+	}
+	arrival := now.Add(flight(pickupDistance + deliveryDistance))
+
+	if order.DeliverAfter != nil {
+		if early := order.DeliverAfter.Sub(arrival); early >= time.Second {
+			exclude(domain.ExcludedByWindow, "would deliver %s before the window opens", early.Round(time.Second))
+		}
+	}
+	if !order.DeliverBy.IsZero() && !now.Add(flight(deliveryDistance)).After(order.DeliverBy) {
+		if late := arrival.Sub(order.DeliverBy); late >= time.Second {
+			exclude(domain.ExcludedByWindow, "would deliver %s after the deadline", late.Round(time.Second))
+		}
+	}
+}
+
+// rankCandidates orders eligible drones nearest to the pickup first and numbers them;
+// excluded drones follow, also by distance
+func rankCandidates(candidates []domain.DispatchCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Eligible() != candidates[j].Eligible() {
+			return candidates[i].Eligible()
+		}
+		return candidates[i].PickupDistance < candidates[j].PickupDistance
+	})
+	for i := range candidates {
+		if candidates[i].Eligible() {
+			candidates[i].Rank = i + 1
+		}
+	}
+}
+
+func inBox(box *domain.BoundingBox, lat, lon float64) bool {
+	return lat >= box.MinLat && lat <= box.MaxLat && lon >= box.MinLon && lon <= box.MaxLon
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatchRules_Evaluate(t *testing.T) {
+	// Pickup about 1.1 km north of the drone, destination another 2.2 km north
+	drone := &domain.Drone{Name: "d1", Status: domain.DroneStatusIdle, Latitude: 30.0, Longitude: 31.0}
+	order := &domain.Order{PickupLat: 30.01, PickupLon: 31.0, DestLat: 30.03, DestLon: 31.0}
+
+	reasons := func(c domain.DispatchCandidate) []domain.ExclusionReason {
+		var out []domain.ExclusionReason
+		for _, e := range c.Exclusions {
+			out = append(out, e.Reason)
+		}
+		return out
+	}
+
+	now := time.Now()
+	c := DispatchRules{}.evaluate(order, drone, false, now)
+	assert.True(t, c.Eligible())
+	assert.InDelta(t, 1112, c.PickupDistance, 1)
+	assert.InDelta(t, 3336, c.FlightDistance, 1)
+
+	c = DispatchRules{MaxPickupDistance: 1000, MaxFlightDistance: 3000}.evaluate(order, drone, false, now)
+	assert.Equal(t, []domain.ExclusionReason{domain.ExcludedByDistance, domain.ExcludedByRange}, reasons(c))
+
+	fence := &domain.BoundingBox{MinLat: 29.9, MinLon: 30.9, MaxLat: 30.02, MaxLon: 31.1}
+	c = DispatchRules{Geofence: fence}.evaluate(order, drone, false, now)
+	if assert.Equal(t, []domain.ExclusionReason{domain.ExcludedByGeofence}, reasons(c)) {
+		assert.Contains(t, c.Exclusions[0].Detail, "destination")
+	}
+
+	charging := *drone
+	charging.Status = domain.DroneStatusOffline
+	c = DispatchRules{}.evaluate(order, &charging, true, now)
+	assert.Equal(t, []domain.ExclusionReason{domain.ExcludedByStatus, domain.ExcludedByCapacity}, reasons(c))
+}
+
+func TestDispatchRules_Window(t *testing.T) {
+	// Pickup about 1.1 km north of near, destination another 2.2 km north: 334 s at 10 m/s
+	near := &domain.Drone{Status: domain.DroneStatusIdle, Latitude: 30.0, Longitude: 31.0}
+	// About 55 km south: another hour and a half of flight
+	far := &domain.Drone{Status: domain.DroneStatusIdle, Latitude: 29.5, Longitude: 31.0}
+	now := time.Now()
+	order := &domain.Order{PickupLat: 30.01, PickupLon: 31.0, DestLat: 30.03, DestLon: 31.0, DeliverBy: now.Add(time.Hour)}
+	rules := DispatchRules{CruiseSpeed: 10}
+
+	c := rules.evaluate(order, near, false, now)
+	assert.True(t, c.Eligible())
+	c = rules.evaluate(order, far, false, now)
+	if assert.Len(t, c.Exclusions, 1) {
+		assert.Equal(t, domain.ExcludedByWindow, c.Exclusions[0].Reason)
+		assert.Contains(t, c.Exclusions[0].Detail, "after the deadline")
+	}
+	assert.True(t, math.IsInf(rules.cost(order, far, now), 1))
+
+	// Late whichever drone flies it: the deadline no longer picks between them
+	late := *order
+	late.DeliverBy = now.Add(time.Minute)
+	c = rules.evaluate(&late, far, false, now)
+	assert.True(t, c.Eligible())
+
+	// The window opens after the near drone would arrive
+	opens := now.Add(10 * time.Minute)
+	scheduled := *order
+	scheduled.DeliverAfter = &opens
+	c = rules.evaluate(&scheduled, near, false, now)
+	if assert.Len(t, c.Exclusions, 1) {
+		assert.Equal(t, domain.ExcludedByWindow, c.Exclusions[0].Reason)
+		assert.Contains(t, c.Exclusions[0].Detail, "before the window opens")
+	}
+
+	// Without a cruise speed the window is not checked
+	c = DispatchRules{}.evaluate(order, far, false, now)
+	assert.True(t, c.Eligible())
+}
+
+func TestDispatchRules_Battery(t *testing.T) {
+	// The delivery needs about 3.3 km of flight
+	low, full := 20.0, 100.0
+	drone := &domain.Drone{Status: domain.DroneStatusIdle, Latitude: 30.0, Longitude: 31.0, Battery: &low}
+	order := &domain.Order{PickupLat: 30.01, PickupLon: 31.0, DestLat: 30.03, DestLon: 31.0}
+	now := time.Now()
+
+	c := DispatchRules{BatteryRange: 10000}.evaluate(order, drone, false, now)
+	if assert.Len(t, c.Exclusions, 1) {
+		assert.Equal(t, domain.ExcludedByBattery, c.Exclusions[0].Reason)
+		assert.Contains(t, c.Exclusions[0].Detail, "leaves 2000 m")
+	}
+
+	// The fuller drone costs less, and one that never reported counts as full
+	rules := DispatchRules{BatteryWeight: 1000}
+	charged := *drone
+	charged.Battery = &full
+	unknown := *drone
+	unknown.Battery = nil
+	assert.InDelta(t, rules.cost(order, &charged, now)+800, rules.cost(order, drone, now), 1e-6)
+	assert.Equal(t, rules.cost(order, &charged, now), rules.cost(order, &unknown, now))
+}
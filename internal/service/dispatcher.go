@@ -1,55 +1,358 @@
 package service
 
 import (
-	"errors"
+	"context"
+	"fmt"
+	"log"
+	"time"
 
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/repository"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/telemetry"
+	"github.com/segmentio/ksuid"
 )
 
 type DispatcherService struct {
 	droneRepo repository.DroneRepository
 	orderRepo repository.OrderRepository
+	rules     DispatchRules
 }
 
-func NewDispatcherService(droneRepo repository.DroneRepository, orderRepo repository.OrderRepository) *DispatcherService {
+func NewDispatcherService(droneRepo repository.DroneRepository, orderRepo repository.OrderRepository, rules DispatchRules) *DispatcherService {
 	return &DispatcherService{
 		droneRepo: droneRepo,
 		orderRepo: orderRepo,
+		rules:     rules,
 	}
 }
 
 // ReserveJob assigns the next pending order to the requesting drone
-func (s *DispatcherService) ReserveJob(droneID string) (*domain.Order, error) {
+func (s *DispatcherService) ReserveJob(ctx context.Context, droneID string) (*domain.Order, error) {
 	// 1. Get Drone
-	drone, err := s.droneRepo.GetDroneByID(droneID)
+	drone, err := s.droneRepo.GetDroneByID(ctx, droneID)
 	if err != nil {
 		return nil, err
 	}
 
 	// 2. Validate Drone Status
 	if drone.Status != domain.DroneStatusIdle {
-		return nil, errors.New("drone is not idle")
+		return nil, domain.ErrDroneNotIdle
 	}
 
-	// 3. Claim Next Pending Order (Atomic)
-	order, err := s.orderRepo.ClaimNextPendingOrder(drone.ID.String())
+	// 3. Batches are formed when a trip starts; a drone on a trip takes no more orders
+	busy, err := s.holdsActiveOrder(ctx, droneID)
+	if err != nil {
+		return nil, err
+	}
+	if busy {
+		return nil, domain.ErrDroneBusy
+	}
+
+	// 4. Claim the first pending order in queue the drone may take (Atomic). Only
+	// rules the claim cannot check take a scan of the queue.
+	var order *domain.Order
+	if s.rules.perOrder() {
+		order, err = s.claimEligibleOrder(ctx, drone)
+	} else if trip := s.rules.trip(drone, time.Now()); trip != nil {
+		order, err = s.orderRepo.ClaimNextPendingOrderInWindow(ctx, drone.ID.String(), *trip)
+	} else {
+		order, err = s.orderRepo.ClaimNextPendingOrder(ctx, drone.ID.String())
+	}
 	if err != nil {
 		if err == domain.ErrNotFound {
-			return nil, errors.New("no pending orders available")
+			return nil, domain.ErrNoPendingOrders
 		}
 		return nil, err
 	}
+	return s.startTrip(ctx, drone, order)
+}
+
+// startTrip fills the trip of a drone that has just claimed order, records the
+// reservations and marks the drone DELIVERING. If the drone cannot be updated, every
+// order of the trip goes back to PENDING.
+func (s *DispatcherService) startTrip(ctx context.Context, drone *domain.Drone, order *domain.Order) (*domain.Order, error) {
+	droneID := drone.ID.String()
+	batch := s.fillBatch(ctx, drone, order)
+	ctx = afterCommit(ctx)
+	s.recordTrip(ctx, drone, batch)
 
-	// 4. Update Drone Status
-	drone.Status = domain.DroneStatusDelivering
-	if err := s.droneRepo.UpdateDrone(drone); err != nil {
-		// Rollback order assignment if drone update fails
-		order.Status = domain.OrderStatusPending
-		order.DroneID = nil
-		_ = s.orderRepo.UpdateOrder(order)
+	// Update Drone Status. A concurrent location report only bumps the version,
+	// so re-read and retry as long as the drone is still IDLE.
+	err := retryOnConflict(domain.AnyVersion, func() error {
+		if drone.Status != domain.DroneStatusIdle {
+			return domain.ErrDroneNotIdle
+		}
+		drone.Status = domain.DroneStatusDelivering
+		if err := s.droneRepo.UpdateDrone(ctx, drone); err != domain.ErrConflict {
+			return err
+		}
+		var err error
+		if drone, err = s.droneRepo.GetDroneByID(ctx, droneID); err != nil {
+			return err
+		}
+		return domain.ErrConflict
+	})
+	if err != nil {
+		// Rollback order assignments if drone update fails
+		for _, reserved := range batch {
+			reserved.Status = domain.OrderStatusPending
+			reserved.DroneID = nil
+			if rollbackErr := s.orderRepo.UpdateOrder(ctx, reserved); rollbackErr == nil {
+				closeActiveOrderLeg(ctx, s.orderRepo, reserved.ID.String(), domain.LegOutcomeCancelled, nil, nil)
+				recordOrderEvent(ctx, s.orderRepo, newOrderEvent(reserved, domain.OrderStatusReserved, ActorDispatcher, "drone update failed"))
+				telemetry.Metrics.OrderTransitioned(ctx, string(reserved.Status))
+			}
+		}
 		return nil, err
 	}
 
 	return order, nil
 }
+
+// recordTrip starts a leg and records the reservation of each order of a drone's trip
+func (s *DispatcherService) recordTrip(ctx context.Context, drone *domain.Drone, batch []*domain.Order) {
+	for _, reserved := range batch {
+		// The new leg starts wherever the parcel currently waits
+		startOrderLeg(ctx, s.orderRepo, reserved, drone.ID)
+
+		event := newOrderEvent(reserved, domain.OrderStatusPending, ActorDispatcher, "")
+		event.Latitude, event.Longitude = &drone.Latitude, &drone.Longitude
+		if len(batch) > 1 {
+			event.Reason = fmt.Sprintf("batch of %d", len(batch))
+		}
+		recordOrderEvent(ctx, s.orderRepo, event)
+		telemetry.Metrics.OrderTransitioned(ctx, string(reserved.Status))
+		telemetry.Metrics.OrderDispatched(ctx, reserved.CreatedAt)
+	}
+}
+
+// claimEligibleOrder walks the pending orders in queue order and claims the first one
+// the rules allow drone to take. ErrNotFound means there is none.
+func (s *DispatcherService) claimEligibleOrder(ctx context.Context, drone *domain.Drone) (*domain.Order, error) {
+	filter := domain.OrderFilter{
+		Status:      domain.OrderStatusPending,
+		ListOptions: domain.ListOptions{Limit: domain.MaxPageLimit, SortBy: domain.SortByDispatchBy},
+	}
+	now := time.Now()
+	for {
+		page, err := s.orderRepo.ListOrders(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, pending := range page.Items {
+			if c := s.rules.evaluate(pending, drone, false, now); !c.Eligible() {
+				continue
+			}
+			order, err := s.orderRepo.ClaimPendingOrder(ctx, pending.ID.String(), drone.ID.String())
+			if err == domain.ErrNotFound {
+				continue // Claimed by another drone since the listing
+			}
+			return order, err
+		}
+		if page.NextCursor == "" {
+			return nil, domain.ErrNotFound
+		}
+		filter.Cursor = page.NextCursor
+	}
+}
+
+// fillBatch claims more pending orders for a drone with room for several, in queue
+// order, as long as each adds at most MaxDetour to the planned route. It returns
+// every order of the trip, first included.
+func (s *DispatcherService) fillBatch(ctx context.Context, drone *domain.Drone, first *domain.Order) []*domain.Order {
+	batch := []*domain.Order{first}
+	if drone.Capacity <= 1 || s.rules.MaxDetour <= 0 {
+		return batch
+	}
+	page, err := s.orderRepo.ListOrders(ctx, domain.OrderFilter{
+		Status:      domain.OrderStatusPending,
+		ListOptions: domain.ListOptions{Limit: domain.MaxPageLimit, SortBy: domain.SortByDispatchBy},
+	})
+	if err != nil {
+		log.Printf("Failed to list orders to batch with %s for drone %s: %v", first.ID, drone.ID, err)
+		return batch
+	}
+
+	now := time.Now()
+	length := routeLength(drone.Latitude, drone.Longitude, planRoute(drone.Latitude, drone.Longitude, batch))
+	for _, pending := range page.Items {
+		if len(batch) >= drone.Capacity {
+			break
+		}
+		if c := s.rules.evaluate(pending, drone, false, now); !c.Eligible() {
+			continue
+		}
+		extended := routeLength(drone.Latitude, drone.Longitude, planRoute(drone.Latitude, drone.Longitude, append(batch[:len(batch):len(batch)], pending)))
+		if extended-length > s.rules.MaxDetour || (s.rules.MaxFlightDistance > 0 && extended > s.rules.MaxFlightDistance) {
+			continue
+		}
+		order, err := s.orderRepo.ClaimPendingOrder(ctx, pending.ID.String(), drone.ID.String())
+		if err == domain.ErrNotFound {
+			continue // Claimed by another drone since the listing
+		}
+		if err != nil {
+			log.Printf("Failed to batch order %s for drone %s: %v", pending.ID, drone.ID, err)
+			break
+		}
+		batch = append(batch, order)
+		length = extended
+	}
+	return batch
+}
+
+// PlanRoute sequences the stops of the orders a drone holds, with the distance and,
+// given a cruise speed, the ETA of each
+func (s *DispatcherService) PlanRoute(ctx context.Context, droneID string) (*domain.DroneRoute, error) {
+	drone, err := s.droneRepo.GetDroneByID(ctx, droneID)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := s.orderRepo.GetActiveOrdersByDroneID(ctx, droneID)
+	if err != nil {
+		return nil, err
+	}
+	route := &domain.DroneRoute{
+		DroneID:  drone.ID,
+		Capacity: drone.Capacity,
+		Stops:    planRoute(drone.Latitude, drone.Longitude, orders),
+	}
+	route.Distance = estimateRoute(drone.Latitude, drone.Longitude, route.Stops, s.rules.CruiseSpeed, time.Now())
+	return route, nil
+}
+
+// DispatchBatch assigns pending orders to idle drones all at once, at the least total
+// cost over every pair rather than one order at a time, then reserves the chosen
+// pairs in one transaction. A pair whose drone or order was taken since the listing
+// is skipped and left to the next round. Each drone flies only the order it was
+// assigned: the cost does not account for batching, so trips are not filled. It
+// returns the orders reserved.
+func (s *DispatcherService) DispatchBatch(ctx context.Context) ([]*domain.Order, error) {
+	idle, err := s.droneRepo.GetIdleDrones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	drones := make([]*domain.Drone, 0, len(idle))
+	for _, drone := range idle {
+		busy, err := s.holdsActiveOrder(ctx, drone.ID.String())
+		if err != nil {
+			return nil, err
+		}
+		if !busy {
+			drones = append(drones, drone)
+		}
+	}
+	if len(drones) == 0 {
+		return nil, nil
+	}
+	page, err := s.orderRepo.ListOrders(ctx, domain.OrderFilter{
+		Status:      domain.OrderStatusPending,
+		ListOptions: domain.ListOptions{Limit: domain.MaxPageLimit, SortBy: domain.SortByDispatchBy},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Items) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	cost := make([][]float64, len(drones))
+	for i, drone := range drones {
+		cost[i] = make([]float64, len(page.Items))
+		for j, order := range page.Items {
+			cost[i][j] = s.rules.cost(order, drone, now)
+		}
+	}
+
+	var pairs []domain.Assignment
+	byID := make(map[ksuid.KSUID]*domain.Drone, len(drones))
+	for i, j := range assign(cost) {
+		if j >= 0 {
+			pairs = append(pairs, domain.Assignment{DroneID: drones[i].ID, OrderID: page.Items[j].ID})
+			byID[drones[i].ID] = drones[i]
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	reserved, err := s.orderRepo.ReserveAssignments(ctx, pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = afterCommit(ctx)
+	for _, order := range reserved {
+		s.recordTrip(ctx, byID[*order.DroneID], []*domain.Order{order})
+	}
+	return reserved, nil
+}
+
+// MatchIdleDrones offers the pending orders to every idle drone again, as a
+// drone.available event would. Orders the rules excluded every drone from when they
+// were created wait for no event: their window opens, a drone flies closer or charges.
+// It returns the orders reserved.
+func (s *DispatcherService) MatchIdleDrones(ctx context.Context) ([]*domain.Order, error) {
+	drones, err := s.droneRepo.GetIdleDrones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var reserved []*domain.Order
+	for _, drone := range drones {
+		order, err := s.ReserveJob(ctx, drone.ID.String())
+		switch err {
+		case nil:
+			reserved = append(reserved, order)
+		case domain.ErrNoPendingOrders, domain.ErrDroneNotIdle, domain.ErrDroneBusy, domain.ErrNotFound:
+			// Nothing this drone may take, or it changed state since the listing
+		default:
+			return reserved, err
+		}
+	}
+	return reserved, nil
+}
+
+// PlanDispatch evaluates every idle drone against the dispatch rules for an order
+// and ranks them, without reserving anything
+func (s *DispatcherService) PlanDispatch(ctx context.Context, orderID string) (*domain.DispatchPlan, error) {
+	order, err := s.orderRepo.GetOrderByID(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	drones, err := s.droneRepo.GetIdleDrones(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &domain.DispatchPlan{
+		OrderID:     order.ID,
+		OrderStatus: order.Status,
+		Candidates:  make([]domain.DispatchCandidate, 0, len(drones)),
+	}
+	now := time.Now()
+	for _, drone := range drones {
+		busy, err := s.holdsActiveOrder(ctx, drone.ID.String())
+		if err != nil {
+			return nil, err
+		}
+		plan.Candidates = append(plan.Candidates, s.rules.evaluate(order, drone, busy, now))
+	}
+	rankCandidates(plan.Candidates)
+
+	if order.Status == domain.OrderStatusPending {
+		ahead, err := s.orderRepo.ListOrders(ctx, domain.OrderFilter{
+			Status:         domain.OrderStatusPending,
+			DispatchBefore: &order.DispatchBy,
+			ListOptions:    domain.ListOptions{Limit: 1, WithTotal: true},
+		})
+		if err != nil {
+			return nil, err
+		}
+		plan.QueuePosition = ahead.Total + 1
+	}
+	return plan, nil
+}
+
+func (s *DispatcherService) holdsActiveOrder(ctx context.Context, droneID string) (bool, error) {
+	orders, err := s.orderRepo.GetActiveOrdersByDroneID(ctx, droneID)
+	return len(orders) > 0, err
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -13,7 +14,7 @@ import (
 func TestReserveJob_Success(t *testing.T) {
 	mockDroneRepo := new(MockDroneRepository)
 	mockOrderRepo := new(MockOrderRepository)
-	dispatcher := NewDispatcherService(mockDroneRepo, mockOrderRepo)
+	dispatcher := NewDispatcherService(mockDroneRepo, mockOrderRepo, DispatchRules{})
 
 	droneID := ksuid.New()
 	drone := &domain.Drone{
@@ -32,9 +33,23 @@ func TestReserveJob_Success(t *testing.T) {
 		UpdatedAt: time.Now(),
 	}
 
+	// The drone carries nothing yet
+	mockOrderRepo.On("GetActiveOrdersByDroneID", droneID.String()).Return(nil, nil)
+
 	// Expect Atomic Claim
 	mockOrderRepo.On("ClaimNextPendingOrder", droneID.String()).Return(claimedOrder, nil)
 
+	// Expect a new leg starting at the parcel's pickup point
+	mockOrderRepo.On("CreateOrderLeg", mock.MatchedBy(func(l *domain.OrderLeg) bool {
+		return l.OrderID == orderID && l.DroneID == droneID && l.Outcome == domain.LegOutcomeInProgress
+	})).Return(nil)
+
+	// Expect Audit Trail Entry
+	mockOrderRepo.On("AppendOrderEvent", mock.MatchedBy(func(e *domain.OrderEvent) bool {
+		return e.OrderID == orderID && e.FromStatus == domain.OrderStatusPending &&
+			e.ToStatus == domain.OrderStatusReserved && e.Actor == ActorDispatcher && *e.DroneID == droneID
+	})).Return(nil)
+
 	// UpdateOrder should NOT be called in success path (it's handled by ClaimNextPendingOrder)
 
 	// Expect Drone Update
@@ -42,7 +57,7 @@ func TestReserveJob_Success(t *testing.T) {
 		return d.Status == domain.DroneStatusDelivering
 	})).Return(nil)
 
-	assignedOrder, err := dispatcher.ReserveJob(droneID.String())
+	assignedOrder, err := dispatcher.ReserveJob(context.Background(), droneID.String())
 
 	assert.NoError(t, err)
 	assert.NotNil(t, assignedOrder)
@@ -56,12 +71,264 @@ func TestReserveJob_Success(t *testing.T) {
 func TestReserveJob_NoDroneFound(t *testing.T) {
 	mockDroneRepo := new(MockDroneRepository)
 	mockOrderRepo := new(MockOrderRepository)
-	dispatcher := NewDispatcherService(mockDroneRepo, mockOrderRepo)
+	dispatcher := NewDispatcherService(mockDroneRepo, mockOrderRepo, DispatchRules{})
 
 	mockDroneRepo.On("GetDroneByID", "invalid-id").Return(nil, domain.ErrNotFound) // Assuming domain has ErrNotFound or repo returns generic error? Repo returns arbitrary error.
 	// Actually repo defines ErrNotFound in repository package, but we mock it.
 	// Let's return errors.New("not found") or just verify behavior on error.
 
-	_, err := dispatcher.ReserveJob("invalid-id")
+	_, err := dispatcher.ReserveJob(context.Background(), "invalid-id")
 	assert.Error(t, err)
 }
+
+func TestReserveJob_DroneNotIdle(t *testing.T) {
+	mockDroneRepo := new(MockDroneRepository)
+	mockOrderRepo := new(MockOrderRepository)
+	dispatcher := NewDispatcherService(mockDroneRepo, mockOrderRepo, DispatchRules{})
+
+	droneID := ksuid.New()
+	mockDroneRepo.On("GetDroneByID", droneID.String()).Return(&domain.Drone{ID: droneID, Status: domain.DroneStatusDelivering}, nil)
+
+	_, err := dispatcher.ReserveJob(context.Background(), droneID.String())
+
+	assert.Equal(t, domain.ErrDroneNotIdle, err)
+	mockOrderRepo.AssertNotCalled(t, "ClaimNextPendingOrder", mock.Anything)
+}
+
+func TestReserveJob_DroneBusy(t *testing.T) {
+	mockDroneRepo := new(MockDroneRepository)
+	mockOrderRepo := new(MockOrderRepository)
+	dispatcher := NewDispatcherService(mockDroneRepo, mockOrderRepo, DispatchRules{})
+
+	droneID := ksuid.New()
+	mockDroneRepo.On("GetDroneByID", droneID.String()).Return(&domain.Drone{ID: droneID, Status: domain.DroneStatusIdle}, nil)
+	mockOrderRepo.On("GetActiveOrdersByDroneID", droneID.String()).Return([]*domain.Order{{ID: ksuid.New(), Status: domain.OrderStatusPickedUp}}, nil)
+
+	_, err := dispatcher.ReserveJob(context.Background(), droneID.String())
+
+	assert.Equal(t, domain.ErrDroneBusy, err)
+	mockOrderRepo.AssertNotCalled(t, "ClaimNextPendingOrder", mock.Anything)
+}
+
+func TestReserveJob_SkipsOrdersOutOfRange(t *testing.T) {
+	mockDroneRepo := new(MockDroneRepository)
+	mockOrderRepo := new(MockOrderRepository)
+	dispatcher := NewDispatcherService(mockDroneRepo, mockOrderRepo, DispatchRules{MaxPickupDistance: 5000})
+
+	droneID := ksuid.New()
+	drone := &domain.Drone{ID: droneID, Status: domain.DroneStatusIdle, Latitude: 30.0, Longitude: 31.0}
+	far := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending, PickupLat: 30.5, PickupLon: 31.0}
+	near := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending, PickupLat: 30.01, PickupLon: 31.0}
+	claimed := &domain.Order{ID: near.ID, Status: domain.OrderStatusReserved, DroneID: &droneID}
+
+	mockDroneRepo.On("GetDroneByID", droneID.String()).Return(drone, nil)
+	mockOrderRepo.On("GetActiveOrdersByDroneID", droneID.String()).Return(nil, nil)
+	mockOrderRepo.On("ListOrders", mock.MatchedBy(func(f domain.OrderFilter) bool {
+		return f.Status == domain.OrderStatusPending && f.SortBy == domain.SortByDispatchBy && !f.Desc
+	})).Return(&domain.Page[*domain.Order]{Items: []*domain.Order{far, near}}, nil)
+	mockOrderRepo.On("ClaimPendingOrder", near.ID.String(), droneID.String()).Return(claimed, nil)
+	mockOrderRepo.On("CreateOrderLeg", mock.Anything).Return(nil)
+	mockOrderRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
+	mockDroneRepo.On("UpdateDrone", mock.Anything).Return(nil)
+
+	order, err := dispatcher.ReserveJob(context.Background(), droneID.String())
+
+	assert.NoError(t, err)
+	assert.Equal(t, near.ID, order.ID)
+	mockOrderRepo.AssertNotCalled(t, "ClaimPendingOrder", far.ID.String(), mock.Anything)
+	mockOrderRepo.AssertNotCalled(t, "ClaimNextPendingOrder", mock.Anything)
+}
+
+func TestReserveJob_ClaimsInWindowWithoutScanning(t *testing.T) {
+	mockDroneRepo := new(MockDroneRepository)
+	mockOrderRepo := new(MockOrderRepository)
+	dispatcher := NewDispatcherService(mockDroneRepo, mockOrderRepo, DispatchRules{CruiseSpeed: 15})
+
+	droneID := ksuid.New()
+	drone := &domain.Drone{ID: droneID, Status: domain.DroneStatusIdle, Latitude: 30.0, Longitude: 31.0}
+	claimed := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusReserved, DroneID: &droneID}
+
+	mockDroneRepo.On("GetDroneByID", droneID.String()).Return(drone, nil)
+	mockOrderRepo.On("GetActiveOrdersByDroneID", droneID.String()).Return(nil, nil)
+	mockOrderRepo.On("ClaimNextPendingOrderInWindow", droneID.String(), mock.MatchedBy(func(trip domain.TripEstimate) bool {
+		return trip.Lat == 30.0 && trip.Lon == 31.0 && trip.Speed == 15 && !trip.Now.IsZero()
+	})).Return(claimed, nil)
+	mockOrderRepo.On("CreateOrderLeg", mock.Anything).Return(nil)
+	mockOrderRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
+	mockDroneRepo.On("UpdateDrone", mock.Anything).Return(nil)
+
+	order, err := dispatcher.ReserveJob(context.Background(), droneID.String())
+
+	assert.NoError(t, err)
+	assert.Equal(t, claimed.ID, order.ID)
+	mockOrderRepo.AssertNotCalled(t, "ListOrders", mock.Anything)
+	mockOrderRepo.AssertNotCalled(t, "ClaimNextPendingOrder", mock.Anything)
+}
+
+func TestReserveJob_BatchesOrdersWithinDetour(t *testing.T) {
+	mockDroneRepo := new(MockDroneRepository)
+	mockOrderRepo := new(MockOrderRepository)
+	dispatcher := NewDispatcherService(mockDroneRepo, mockOrderRepo, DispatchRules{MaxDetour: 2000})
+
+	droneID := ksuid.New()
+	drone := &domain.Drone{ID: droneID, Status: domain.DroneStatusIdle, Capacity: 2, Latitude: 30.0, Longitude: 31.0}
+	first := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusReserved, DroneID: &droneID,
+		PickupLat: 30.0, PickupLon: 31.0, DestLat: 30.05, DestLon: 31.0}
+	// Same way, about 1.1km further
+	along := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending,
+		PickupLat: 30.01, PickupLon: 31.0, DestLat: 30.06, DestLon: 31.0}
+	// The opposite way
+	behind := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending,
+		PickupLat: 29.99, PickupLon: 31.0, DestLat: 29.95, DestLon: 31.0}
+	claimed := *along
+	claimed.Status, claimed.DroneID = domain.OrderStatusReserved, &droneID
+
+	mockDroneRepo.On("GetDroneByID", droneID.String()).Return(drone, nil)
+	mockOrderRepo.On("GetActiveOrdersByDroneID", droneID.String()).Return(nil, nil)
+	mockOrderRepo.On("ClaimNextPendingOrder", droneID.String()).Return(first, nil)
+	mockOrderRepo.On("ListOrders", mock.Anything).
+		Return(&domain.Page[*domain.Order]{Items: []*domain.Order{behind, along}}, nil)
+	mockOrderRepo.On("ClaimPendingOrder", along.ID.String(), droneID.String()).Return(&claimed, nil)
+	mockOrderRepo.On("CreateOrderLeg", mock.Anything).Return(nil).Twice()
+	mockOrderRepo.On("AppendOrderEvent", mock.MatchedBy(func(e *domain.OrderEvent) bool {
+		return e.Reason == "batch of 2"
+	})).Return(nil).Twice()
+	mockDroneRepo.On("UpdateDrone", mock.Anything).Return(nil)
+
+	order, err := dispatcher.ReserveJob(context.Background(), droneID.String())
+
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, order.ID)
+	mockOrderRepo.AssertNotCalled(t, "ClaimPendingOrder", behind.ID.String(), mock.Anything)
+	mockDroneRepo.AssertExpectations(t)
+	mockOrderRepo.AssertExpectations(t)
+}
+
+func TestPlanDispatch_RanksAndExplains(t *testing.T) {
+	mockDroneRepo := new(MockDroneRepository)
+	mockOrderRepo := new(MockOrderRepository)
+	dispatcher := NewDispatcherService(mockDroneRepo, mockOrderRepo, DispatchRules{MaxPickupDistance: 5000})
+
+	order := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending, PickupLat: 30.0, PickupLon: 31.0,
+		DestLat: 30.02, DestLon: 31.0, DispatchBy: time.Now()}
+	near := &domain.Drone{ID: ksuid.New(), Name: "near", Status: domain.DroneStatusIdle, Latitude: 30.001, Longitude: 31.0}
+	nearer := &domain.Drone{ID: ksuid.New(), Name: "nearer", Status: domain.DroneStatusIdle, Latitude: 30.0005, Longitude: 31.0}
+	far := &domain.Drone{ID: ksuid.New(), Name: "far", Status: domain.DroneStatusIdle, Latitude: 30.5, Longitude: 31.0}
+	busy := &domain.Drone{ID: ksuid.New(), Name: "busy", Status: domain.DroneStatusIdle, Latitude: 30.0, Longitude: 31.0}
+
+	mockOrderRepo.On("GetOrderByID", order.ID.String()).Return(order, nil)
+	mockDroneRepo.On("GetIdleDrones").Return([]*domain.Drone{far, near, busy, nearer}, nil)
+	mockOrderRepo.On("GetActiveOrdersByDroneID", busy.ID.String()).Return([]*domain.Order{{ID: ksuid.New()}}, nil)
+	mockOrderRepo.On("GetActiveOrdersByDroneID", mock.Anything).Return(nil, nil)
+	mockOrderRepo.On("ListOrders", mock.MatchedBy(func(f domain.OrderFilter) bool {
+		return f.Status == domain.OrderStatusPending && f.DispatchBefore != nil && f.DispatchBefore.Equal(order.DispatchBy)
+	})).Return(&domain.Page[*domain.Order]{Total: 2}, nil)
+
+	plan, err := dispatcher.PlanDispatch(context.Background(), order.ID.String())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, plan.QueuePosition)
+	if assert.Len(t, plan.Candidates, 4) {
+		assert.Equal(t, "nearer", plan.Candidates[0].DroneName)
+		assert.Equal(t, 1, plan.Candidates[0].Rank)
+		assert.Equal(t, "near", plan.Candidates[1].DroneName)
+		assert.Equal(t, 2, plan.Candidates[1].Rank)
+		// Excluded drones follow unranked, nearest first
+		assert.Equal(t, "busy", plan.Candidates[2].DroneName)
+		assert.Zero(t, plan.Candidates[2].Rank)
+		assert.Equal(t, domain.ExcludedByCapacity, plan.Candidates[2].Exclusions[0].Reason)
+		assert.Equal(t, "far", plan.Candidates[3].DroneName)
+		assert.Equal(t, domain.ExcludedByDistance, plan.Candidates[3].Exclusions[0].Reason)
+	}
+	mockOrderRepo.AssertNotCalled(t, "ClaimPendingOrder", mock.Anything, mock.Anything)
+	mockOrderRepo.AssertNotCalled(t, "ClaimNextPendingOrder", mock.Anything)
+}
+
+func TestDispatchBatch_MinimisesTotalPickupDistance(t *testing.T) {
+	mockDroneRepo := new(MockDroneRepository)
+	mockOrderRepo := new(MockOrderRepository)
+	dispatcher := NewDispatcherService(mockDroneRepo, mockOrderRepo, DispatchRules{})
+
+	south := &domain.Drone{ID: ksuid.New(), Status: domain.DroneStatusIdle, Latitude: 30.0, Longitude: 31.0}
+	north := &domain.Drone{ID: ksuid.New(), Status: domain.DroneStatusIdle, Latitude: 30.01, Longitude: 31.0}
+	busy := &domain.Drone{ID: ksuid.New(), Status: domain.DroneStatusIdle, Latitude: 30.005, Longitude: 31.0}
+	// Served one at a time, the first order would take the north drone and send the
+	// south one 1.7 km to the second
+	first := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending, PickupLat: 30.0055, PickupLon: 31.0}
+	second := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending, PickupLat: 30.015, PickupLon: 31.0}
+	claim := func(order *domain.Order, drone *domain.Drone) *domain.Order {
+		claimed := *order
+		claimed.Status, claimed.DroneID = domain.OrderStatusReserved, &drone.ID
+		return &claimed
+	}
+
+	mockDroneRepo.On("GetIdleDrones").Return([]*domain.Drone{south, north, busy}, nil)
+	mockOrderRepo.On("GetActiveOrdersByDroneID", busy.ID.String()).Return([]*domain.Order{{ID: ksuid.New()}}, nil)
+	mockOrderRepo.On("GetActiveOrdersByDroneID", mock.Anything).Return(nil, nil)
+	mockOrderRepo.On("ListOrders", mock.MatchedBy(func(f domain.OrderFilter) bool {
+		return f.Status == domain.OrderStatusPending && f.SortBy == domain.SortByDispatchBy
+	})).Return(&domain.Page[*domain.Order]{Items: []*domain.Order{first, second}}, nil)
+	mockOrderRepo.On("ReserveAssignments", mock.MatchedBy(func(pairs []domain.Assignment) bool {
+		return assert.ElementsMatch(t, []domain.Assignment{
+			{DroneID: south.ID, OrderID: first.ID},
+			{DroneID: north.ID, OrderID: second.ID},
+		}, pairs)
+	})).Return([]*domain.Order{claim(first, south), claim(second, north)}, nil)
+	mockOrderRepo.On("CreateOrderLeg", mock.Anything).Return(nil)
+	mockOrderRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
+
+	reserved, err := dispatcher.DispatchBatch(context.Background())
+
+	assert.NoError(t, err)
+	assert.Len(t, reserved, 2)
+	mockOrderRepo.AssertExpectations(t)
+	// The drones were marked DELIVERING with their orders, and no trip was filled
+	mockDroneRepo.AssertNotCalled(t, "UpdateDrone", mock.Anything)
+	mockOrderRepo.AssertNotCalled(t, "ClaimPendingOrder", mock.Anything, mock.Anything)
+}
+
+func TestDispatchBatch_SkipsPairsTakenMeanwhile(t *testing.T) {
+	mockDroneRepo := new(MockDroneRepository)
+	mockOrderRepo := new(MockOrderRepository)
+	dispatcher := NewDispatcherService(mockDroneRepo, mockOrderRepo, DispatchRules{})
+
+	drone := &domain.Drone{ID: ksuid.New(), Status: domain.DroneStatusIdle}
+	order := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending}
+
+	mockDroneRepo.On("GetIdleDrones").Return([]*domain.Drone{drone}, nil)
+	mockOrderRepo.On("GetActiveOrdersByDroneID", drone.ID.String()).Return(nil, nil)
+	mockOrderRepo.On("ListOrders", mock.Anything).Return(&domain.Page[*domain.Order]{Items: []*domain.Order{order}}, nil)
+	mockOrderRepo.On("ReserveAssignments", []domain.Assignment{{DroneID: drone.ID, OrderID: order.ID}}).Return(nil, nil)
+
+	reserved, err := dispatcher.DispatchBatch(context.Background())
+
+	assert.NoError(t, err)
+	assert.Empty(t, reserved)
+	mockOrderRepo.AssertNotCalled(t, "AppendOrderEvent", mock.Anything)
+}
+
+func TestMatchIdleDrones_OffersPendingOrdersToEveryIdleDrone(t *testing.T) {
+	mockDroneRepo := new(MockDroneRepository)
+	mockOrderRepo := new(MockOrderRepository)
+	dispatcher := NewDispatcherService(mockDroneRepo, mockOrderRepo, DispatchRules{})
+
+	first := &domain.Drone{ID: ksuid.New(), Status: domain.DroneStatusIdle}
+	second := &domain.Drone{ID: ksuid.New(), Status: domain.DroneStatusIdle}
+	claimed := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusReserved, DroneID: &first.ID}
+
+	mockDroneRepo.On("GetIdleDrones").Return([]*domain.Drone{first, second}, nil)
+	mockDroneRepo.On("GetDroneByID", first.ID.String()).Return(first, nil)
+	mockDroneRepo.On("GetDroneByID", second.ID.String()).Return(second, nil)
+	mockOrderRepo.On("GetActiveOrdersByDroneID", mock.Anything).Return(nil, nil)
+	mockOrderRepo.On("ClaimNextPendingOrder", first.ID.String()).Return(claimed, nil)
+	mockOrderRepo.On("ClaimNextPendingOrder", second.ID.String()).Return(nil, domain.ErrNotFound)
+	mockOrderRepo.On("CreateOrderLeg", mock.Anything).Return(nil)
+	mockOrderRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
+	mockDroneRepo.On("UpdateDrone", mock.Anything).Return(nil)
+
+	reserved, err := dispatcher.MatchIdleDrones(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*domain.Order{claimed}, reserved)
+	mockOrderRepo.AssertExpectations(t)
+}
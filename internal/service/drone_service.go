@@ -14,7 +14,7 @@ import (
 
 // DroneStatusObserver defines the interface for listening to drone status changes
 type DroneStatusObserver interface {
-	OnDroneStatusChanged(droneID string, oldStatus, newStatus domain.DroneStatus, currentLat, currentLon float64)
+	OnDroneStatusChanged(ctx context.Context, droneID string, oldStatus, newStatus domain.DroneStatus, currentLat, currentLon float64)
 }
 
 type DroneService struct {
@@ -35,9 +35,10 @@ func (s *DroneService) AddObserver(observer DroneStatusObserver) {
 	s.observers = append(s.observers, observer)
 }
 
-func (s *DroneService) RegisterDrone(name string) (*domain.Drone, error) {
+// RegisterDrone adds a drone that carries up to capacity orders per trip (at least one)
+func (s *DroneService) RegisterDrone(ctx context.Context, name string, capacity int) (*domain.Drone, error) {
 	// check if exists
-	if _, err := s.repo.GetDroneByName(name); err == nil {
+	if _, err := s.repo.GetDroneByName(ctx, name); err == nil {
 		return nil, errors.New("drone already exists")
 	}
 
@@ -47,60 +48,124 @@ func (s *DroneService) RegisterDrone(name string) (*domain.Drone, error) {
 		Status:    domain.DroneStatusIdle,
 		Latitude:  0,
 		Longitude: 0,
+		Capacity:  max(capacity, 1),
 		CreatedAt: time.Now(),
 	}
 
-	if err := s.repo.CreateDrone(drone); err != nil {
+	if err := s.repo.CreateDrone(ctx, drone); err != nil {
 		return nil, err
 	}
+	ctx = afterCommit(ctx)
+
+	// A new drone enters service as IDLE; observers see it as a transition from no status
+	s.notifyObservers(ctx, drone.ID.String(), "", drone.Status, drone.Latitude, drone.Longitude)
 	return drone, nil
 }
 
-func (s *DroneService) UpdateLocation(id string, lat, lon float64) error {
-	drone, err := s.repo.GetDroneByID(id)
+func (s *DroneService) notifyObservers(ctx context.Context, droneID string, oldStatus, newStatus domain.DroneStatus, currentLat, currentLon float64) {
+	for _, observer := range s.observers {
+		observer.OnDroneStatusChanged(ctx, droneID, oldStatus, newStatus, currentLat, currentLon)
+	}
+}
+
+// UpdateLocation records a drone's position and, when it reports one, its battery
+// level; a nil battery keeps the last level reported
+func (s *DroneService) UpdateLocation(ctx context.Context, id string, lat, lon float64, battery *float64) error {
+	if battery != nil && (*battery < 0 || *battery > 100) {
+		return domain.ErrInvalidBattery
+	}
+	var drone *domain.Drone
+	var oldStatus domain.DroneStatus
+	var reconnected bool
+	err := retryOnConflict(domain.AnyVersion, func() error {
+		var err error
+		if drone, err = s.repo.GetDroneByID(ctx, id); err != nil {
+			return err
+		}
+		drone.Latitude = lat
+		drone.Longitude = lon
+		if battery != nil {
+			drone.Battery = battery
+		}
+
+		// Reconnection: a location report from an OFFLINE drone brings it back into service
+		oldStatus = drone.Status
+		reconnected = oldStatus == domain.DroneStatusOffline
+		if reconnected {
+			drone.Status = reconnectStatus(drone)
+			drone.PreviousStatus = oldStatus
+		}
+		return s.repo.UpdateDrone(ctx, drone)
+	})
 	if err != nil {
 		return err
 	}
-	drone.Latitude = lat
-	drone.Longitude = lon
+	ctx = afterCommit(ctx)
 
 	// Cache Location and Heartbeat in Redis
 	if s.redisClient != nil {
-		if err := s.redisClient.SetDroneLocation(context.Background(), id, lat, lon); err != nil {
+		if err := s.redisClient.SetDroneLocation(ctx, id, lat, lon); err != nil {
 			log.Printf("Failed to cache drone location: %v", err)
 		}
-		if err := s.redisClient.SetDroneHeartbeat(context.Background(), id); err != nil {
+		if err := s.redisClient.SetDroneHeartbeat(ctx, id); err != nil {
 			log.Printf("Failed to set drone heartbeat: %v", err)
 		}
 	}
 
-	return s.repo.UpdateDrone(drone)
+	if reconnected {
+		log.Printf("Drone %s reconnected, status %s -> %s", id, oldStatus, drone.Status)
+		s.notifyObservers(ctx, id, oldStatus, drone.Status, lat, lon)
+	}
+	return nil
 }
 
-func (s *DroneService) UpdateStatus(id string, status domain.DroneStatus) error {
-	drone, err := s.repo.GetDroneByID(id)
-	if err != nil {
-		return err
+// reconnectStatus decides where an OFFLINE drone goes when it reports in again.
+// A drone that lost contact mid-delivery had its order recovered and must be
+// inspected before it is trusted with another one.
+func reconnectStatus(drone *domain.Drone) domain.DroneStatus {
+	if drone.PreviousStatus == domain.DroneStatusDelivering {
+		return domain.DroneStatusNeedsInspection
 	}
+	return domain.DroneStatusIdle
+}
 
-	// Broken Drone Recovery Logic
-	// Notify observers
-	for _, observer := range s.observers {
-		observer.OnDroneStatusChanged(id, drone.Status, status, drone.Latitude, drone.Longitude)
+// UpdateStatus sets the drone's status and returns the updated drone.
+// ifMatch is the version the caller last saw, or domain.AnyVersion.
+func (s *DroneService) UpdateStatus(ctx context.Context, id string, status domain.DroneStatus, ifMatch int) (*domain.Drone, error) {
+	var drone *domain.Drone
+	var oldStatus domain.DroneStatus
+	err := retryOnConflict(ifMatch, func() error {
+		var err error
+		if drone, err = s.repo.GetDroneByID(ctx, id); err != nil {
+			return err
+		}
+		if err := checkVersion(ifMatch, drone.Version); err != nil {
+			return err
+		}
+
+		oldStatus = drone.Status
+		drone.PreviousStatus = oldStatus
+		drone.Status = status
+		return s.repo.UpdateDrone(ctx, drone)
+	})
+	if err != nil {
+		return nil, err
 	}
+	ctx = afterCommit(ctx)
 
-	drone.Status = status
-	return s.repo.UpdateDrone(drone)
+	// Notify observers once the new status is persisted (Broken Drone Recovery, drone.available)
+	s.notifyObservers(ctx, id, oldStatus, status, drone.Latitude, drone.Longitude)
+	return drone, nil
 }
 
-func (s *DroneService) GetDrone(id string) (*domain.Drone, error) {
-	return s.repo.GetDroneByID(id)
+func (s *DroneService) GetDrone(ctx context.Context, id string) (*domain.Drone, error) {
+	return s.repo.GetDroneByID(ctx, id)
 }
 
-func (s *DroneService) GetDroneByName(name string) (*domain.Drone, error) {
-	return s.repo.GetDroneByName(name)
+func (s *DroneService) GetDroneByName(ctx context.Context, name string) (*domain.Drone, error) {
+	return s.repo.GetDroneByName(ctx, name)
 }
 
-func (s *DroneService) ListDrones() ([]*domain.Drone, error) {
-	return s.repo.GetAllDrones()
+func (s *DroneService) ListDrones(ctx context.Context, filter domain.DroneFilter) (*domain.Page[*domain.Drone], error) {
+	return s.repo.ListDrones(ctx, filter)
 }
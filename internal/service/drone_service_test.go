@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"testing"
 
@@ -15,7 +16,7 @@ type MockDroneStatusObserver struct {
 	mock.Mock
 }
 
-func (m *MockDroneStatusObserver) OnDroneStatusChanged(droneID string, oldStatus, newStatus domain.DroneStatus, currentLat, currentLon float64) {
+func (m *MockDroneStatusObserver) OnDroneStatusChanged(ctx context.Context, droneID string, oldStatus, newStatus domain.DroneStatus, currentLat, currentLon float64) {
 	m.Called(droneID, oldStatus, newStatus, currentLat, currentLon)
 }
 
@@ -30,10 +31,10 @@ func TestRegisterDrone_Success(t *testing.T) {
 
 	// Expect CreateDrone to be called
 	mockRepo.On("CreateDrone", mock.MatchedBy(func(d *domain.Drone) bool {
-		return d.Name == name && d.Status == domain.DroneStatusIdle && d.ID != ksuid.Nil
+		return d.Name == name && d.Status == domain.DroneStatusIdle && d.ID != ksuid.Nil && d.Capacity == 1
 	})).Return(nil)
 
-	drone, err := service.RegisterDrone(name)
+	drone, err := service.RegisterDrone(context.Background(), name, 0)
 
 	assert.NoError(t, err)
 	assert.NotNil(t, drone)
@@ -41,6 +42,22 @@ func TestRegisterDrone_Success(t *testing.T) {
 	mockRepo.AssertExpectations(t)
 }
 
+func TestRegisterDrone_NotifiesObservers(t *testing.T) {
+	mockRepo := new(MockDroneRepository)
+	mockObserver := new(MockDroneStatusObserver)
+	service := NewDroneService(mockRepo, nil)
+	service.AddObserver(mockObserver)
+
+	mockRepo.On("GetDroneByName", "Drone-02").Return(nil, domain.ErrNotFound)
+	mockRepo.On("CreateDrone", mock.Anything).Return(nil)
+	mockObserver.On("OnDroneStatusChanged", mock.AnythingOfType("string"), domain.DroneStatus(""), domain.DroneStatusIdle, 0.0, 0.0).Return()
+
+	_, err := service.RegisterDrone(context.Background(), "Drone-02", 3)
+
+	assert.NoError(t, err)
+	mockObserver.AssertExpectations(t)
+}
+
 func TestRegisterDrone_DuplicateName(t *testing.T) {
 	mockRepo := new(MockDroneRepository)
 	service := NewDroneService(mockRepo, nil)
@@ -51,7 +68,7 @@ func TestRegisterDrone_DuplicateName(t *testing.T) {
 	// Expect GetDroneByName to return Success (Found), which means duplicate
 	mockRepo.On("GetDroneByName", name).Return(existingDrone, nil)
 
-	_, err := service.RegisterDrone(name)
+	_, err := service.RegisterDrone(context.Background(), name, 1)
 
 	assert.Error(t, err)
 	assert.Equal(t, "drone already exists", err.Error())
@@ -70,12 +87,74 @@ func TestUpdateLocation(t *testing.T) {
 		return d.ID == id && d.Latitude == 10.5 && d.Longitude == 20.5
 	})).Return(nil)
 
-	err := service.UpdateLocation(id.String(), 10.5, 20.5)
+	err := service.UpdateLocation(context.Background(), id.String(), 10.5, 20.5, nil)
 
 	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
 }
 
+func TestUpdateLocation_RecordsBattery(t *testing.T) {
+	mockRepo := new(MockDroneRepository)
+	service := NewDroneService(mockRepo, nil)
+
+	id := ksuid.New()
+	mockRepo.On("GetDroneByID", id.String()).Return(&domain.Drone{ID: id}, nil)
+	mockRepo.On("UpdateDrone", mock.MatchedBy(func(d *domain.Drone) bool {
+		return d.Battery != nil && *d.Battery == 42
+	})).Return(nil)
+
+	battery := 42.0
+	assert.NoError(t, service.UpdateLocation(context.Background(), id.String(), 10.5, 20.5, &battery))
+	mockRepo.AssertExpectations(t)
+
+	battery = 120
+	err := service.UpdateLocation(context.Background(), id.String(), 10.5, 20.5, &battery)
+	assert.ErrorIs(t, err, domain.ErrInvalidBattery)
+}
+
+func TestUpdateLocation_ReconnectOffline_ReturnsToIdle(t *testing.T) {
+	mockRepo := new(MockDroneRepository)
+	mockObserver := new(MockDroneStatusObserver)
+	service := NewDroneService(mockRepo, nil)
+	service.AddObserver(mockObserver)
+
+	id := ksuid.New()
+	existingDrone := &domain.Drone{ID: id, Status: domain.DroneStatusOffline, PreviousStatus: domain.DroneStatusIdle}
+
+	mockRepo.On("GetDroneByID", id.String()).Return(existingDrone, nil)
+	mockRepo.On("UpdateDrone", mock.MatchedBy(func(d *domain.Drone) bool {
+		return d.Status == domain.DroneStatusIdle && d.PreviousStatus == domain.DroneStatusOffline
+	})).Return(nil)
+	mockObserver.On("OnDroneStatusChanged", id.String(), domain.DroneStatusOffline, domain.DroneStatusIdle, 10.5, 20.5).Return()
+
+	err := service.UpdateLocation(context.Background(), id.String(), 10.5, 20.5, nil)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockObserver.AssertExpectations(t)
+}
+
+func TestUpdateLocation_ReconnectAfterDelivery_NeedsInspection(t *testing.T) {
+	mockRepo := new(MockDroneRepository)
+	mockObserver := new(MockDroneStatusObserver)
+	service := NewDroneService(mockRepo, nil)
+	service.AddObserver(mockObserver)
+
+	id := ksuid.New()
+	existingDrone := &domain.Drone{ID: id, Status: domain.DroneStatusOffline, PreviousStatus: domain.DroneStatusDelivering}
+
+	mockRepo.On("GetDroneByID", id.String()).Return(existingDrone, nil)
+	mockRepo.On("UpdateDrone", mock.MatchedBy(func(d *domain.Drone) bool {
+		return d.Status == domain.DroneStatusNeedsInspection
+	})).Return(nil)
+	mockObserver.On("OnDroneStatusChanged", id.String(), domain.DroneStatusOffline, domain.DroneStatusNeedsInspection, 1.0, 2.0).Return()
+
+	err := service.UpdateLocation(context.Background(), id.String(), 1.0, 2.0, nil)
+
+	assert.NoError(t, err)
+	mockObserver.AssertExpectations(t)
+}
+
 func TestUpdateStatus_BrokenRescue_NotifyObserver(t *testing.T) {
 	mockRepo := new(MockDroneRepository)
 	mockObserver := new(MockDroneStatusObserver)
@@ -95,13 +174,39 @@ func TestUpdateStatus_BrokenRescue_NotifyObserver(t *testing.T) {
 	// Expect Observer Notification
 	mockObserver.On("OnDroneStatusChanged", droneID.String(), domain.DroneStatusDelivering, domain.DroneStatusBroken, 50.0, 10.0).Return()
 
-	err := service.UpdateStatus(droneID.String(), domain.DroneStatusBroken)
+	_, err := service.UpdateStatus(context.Background(), droneID.String(), domain.DroneStatusBroken, domain.AnyVersion)
 
 	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
 	mockObserver.AssertExpectations(t)
 }
 
+// ctxObserver records whether the context it was notified with was still live
+type ctxObserver struct{ ctxErr error }
+
+func (o *ctxObserver) OnDroneStatusChanged(ctx context.Context, droneID string, oldStatus, newStatus domain.DroneStatus, currentLat, currentLon float64) {
+	o.ctxErr = ctx.Err()
+}
+
+func TestUpdateStatus_ObserversOutliveTheRequest(t *testing.T) {
+	mockRepo := new(MockDroneRepository)
+	observer := &ctxObserver{}
+	service := NewDroneService(mockRepo, nil)
+	service.AddObserver(observer)
+
+	droneID := ksuid.New()
+	mockRepo.On("GetDroneByID", droneID.String()).Return(&domain.Drone{ID: droneID, Status: domain.DroneStatusDelivering}, nil)
+	mockRepo.On("UpdateDrone", mock.Anything).Return(nil)
+
+	// The client hangs up right after the write
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := service.UpdateStatus(ctx, droneID.String(), domain.DroneStatusBroken, domain.AnyVersion)
+
+	assert.NoError(t, err)
+	assert.NoError(t, observer.ctxErr)
+}
+
 func TestListDrones(t *testing.T) {
 	mockRepo := new(MockDroneRepository)
 	service := NewDroneService(mockRepo, nil)
@@ -111,12 +216,12 @@ func TestListDrones(t *testing.T) {
 		{ID: ksuid.New(), Name: "Drone-02"},
 	}
 
-	mockRepo.On("GetAllDrones").Return(expectedDrones, nil)
+	mockRepo.On("ListDrones", domain.DroneFilter{}).Return(&domain.Page[*domain.Drone]{Items: expectedDrones, Total: 2}, nil)
 
-	drones, err := service.ListDrones()
+	page, err := service.ListDrones(context.Background(), domain.DroneFilter{})
 
 	assert.NoError(t, err)
-	assert.Len(t, drones, 2)
-	assert.Equal(t, "Drone-01", drones[0].Name)
+	assert.Len(t, page.Items, 2)
+	assert.Equal(t, "Drone-01", page.Items[0].Name)
 	mockRepo.AssertExpectations(t)
 }
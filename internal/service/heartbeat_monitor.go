@@ -7,43 +7,39 @@ import (
 
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
 	infra "github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/infrastructure/redis"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/lifecycle"
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/repository"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/telemetry"
+	"go.opentelemetry.io/otel"
 )
 
 type HeartbeatMonitor struct {
+	// Periodic runs checkDrones every interval and serves the health check
+	*lifecycle.Periodic
+
 	droneRepo    repository.DroneRepository
 	droneService *DroneService
 	redisClient  *infra.Client
-	interval     time.Duration
 }
 
-func NewHeartbeatMonitor(repo repository.DroneRepository, svc *DroneService, redis *infra.Client) *HeartbeatMonitor {
-	return &HeartbeatMonitor{
+// NewHeartbeatMonitor scans active drones every interval and marks the ones whose
+// heartbeat expired as OFFLINE
+func NewHeartbeatMonitor(repo repository.DroneRepository, svc *DroneService, redis *infra.Client, interval time.Duration) *HeartbeatMonitor {
+	m := &HeartbeatMonitor{
 		droneRepo:    repo,
 		droneService: svc,
 		redisClient:  redis,
-		interval:     10 * time.Second, // Scan every 10 seconds
 	}
+	m.Periodic = lifecycle.NewPeriodic("Heartbeat Monitor", interval, m.checkDrones)
+	return m
 }
 
-func (m *HeartbeatMonitor) Start(ctx context.Context) {
-	ticker := time.NewTicker(m.interval)
-	defer ticker.Stop()
-
-	log.Println("Heartbeat Monitor started")
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			m.checkDrones()
-		}
-	}
-}
+func (m *HeartbeatMonitor) checkDrones(ctx context.Context) {
+	// One trace per sweep so its SQL and Redis spans are grouped together
+	ctx, span := otel.Tracer("heartbeat-monitor").Start(ctx, "HeartbeatMonitor.checkDrones")
+	defer span.End()
 
-func (m *HeartbeatMonitor) checkDrones() {
-	drones, err := m.droneRepo.GetActiveDrones()
+	drones, err := m.droneRepo.GetActiveDrones(ctx)
 	if err != nil {
 		log.Printf("HeartbeatMonitor: failed to get active drones: %v", err)
 		return
@@ -54,9 +50,7 @@ func (m *HeartbeatMonitor) checkDrones() {
 			continue
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		alive, err := m.redisClient.HasDroneHeartbeat(ctx, drone.ID.String())
-		cancel()
 
 		if err != nil {
 			log.Printf("HeartbeatMonitor: failed to check heartbeat for drone %s: %v", drone.ID.String(), err)
@@ -65,8 +59,9 @@ func (m *HeartbeatMonitor) checkDrones() {
 
 		if !alive {
 			log.Printf("HeartbeatMonitor: Drone %s is OFFLINE", drone.ID.String())
+			telemetry.Metrics.HeartbeatMissed(ctx)
 			// Update status to OFFLINE via DroneService (which triggers observers)
-			if err := m.droneService.UpdateStatus(drone.ID.String(), domain.DroneStatusOffline); err != nil {
+			if _, err := m.droneService.UpdateStatus(ctx, drone.ID.String(), domain.DroneStatusOffline, domain.AnyVersion); err != nil {
 				log.Printf("HeartbeatMonitor: failed to update status for drone %s: %v", drone.ID.String(), err)
 			}
 		}
@@ -1,6 +1,9 @@
 package service
 
 import (
+	"context"
+	"time"
+
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
 	"github.com/stretchr/testify/mock"
 )
@@ -10,12 +13,12 @@ type MockDroneRepository struct {
 	mock.Mock
 }
 
-func (m *MockDroneRepository) CreateDrone(drone *domain.Drone) error {
+func (m *MockDroneRepository) CreateDrone(ctx context.Context, drone *domain.Drone) error {
 	args := m.Called(drone)
 	return args.Error(0)
 }
 
-func (m *MockDroneRepository) GetActiveDrones() ([]*domain.Drone, error) {
+func (m *MockDroneRepository) GetActiveDrones(ctx context.Context) ([]*domain.Drone, error) {
 	args := m.Called()
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -23,15 +26,15 @@ func (m *MockDroneRepository) GetActiveDrones() ([]*domain.Drone, error) {
 	return args.Get(0).([]*domain.Drone), args.Error(1)
 }
 
-func (m *MockDroneRepository) GetAllDrones() ([]*domain.Drone, error) {
-	args := m.Called()
+func (m *MockDroneRepository) ListDrones(ctx context.Context, filter domain.DroneFilter) (*domain.Page[*domain.Drone], error) {
+	args := m.Called(filter)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).([]*domain.Drone), args.Error(1)
+	return args.Get(0).(*domain.Page[*domain.Drone]), args.Error(1)
 }
 
-func (m *MockDroneRepository) GetIdleDrones() ([]*domain.Drone, error) {
+func (m *MockDroneRepository) GetIdleDrones(ctx context.Context) ([]*domain.Drone, error) {
 	args := m.Called()
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -39,7 +42,7 @@ func (m *MockDroneRepository) GetIdleDrones() ([]*domain.Drone, error) {
 	return args.Get(0).([]*domain.Drone), args.Error(1)
 }
 
-func (m *MockDroneRepository) GetDroneByID(id string) (*domain.Drone, error) {
+func (m *MockDroneRepository) GetDroneByID(ctx context.Context, id string) (*domain.Drone, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -47,7 +50,7 @@ func (m *MockDroneRepository) GetDroneByID(id string) (*domain.Drone, error) {
 	return args.Get(0).(*domain.Drone), args.Error(1)
 }
 
-func (m *MockDroneRepository) GetDroneByName(name string) (*domain.Drone, error) {
+func (m *MockDroneRepository) GetDroneByName(ctx context.Context, name string) (*domain.Drone, error) {
 	args := m.Called(name)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -55,7 +58,7 @@ func (m *MockDroneRepository) GetDroneByName(name string) (*domain.Drone, error)
 	return args.Get(0).(*domain.Drone), args.Error(1)
 }
 
-func (m *MockDroneRepository) UpdateDrone(drone *domain.Drone) error {
+func (m *MockDroneRepository) UpdateDrone(ctx context.Context, drone *domain.Drone) error {
 	args := m.Called(drone)
 	return args.Error(0)
 }
@@ -65,12 +68,20 @@ type MockOrderRepository struct {
 	mock.Mock
 }
 
-func (m *MockOrderRepository) CreateOrder(order *domain.Order) error {
+func (m *MockOrderRepository) CreateOrder(ctx context.Context, order *domain.Order) error {
 	args := m.Called(order)
 	return args.Error(0)
 }
 
-func (m *MockOrderRepository) GetOrderByID(id string) (*domain.Order, error) {
+func (m *MockOrderRepository) CreateOrderIdempotent(ctx context.Context, order *domain.Order, key *domain.IdempotencyKey) (*domain.Order, bool, error) {
+	args := m.Called(order, key)
+	if args.Get(0) == nil {
+		return nil, args.Bool(1), args.Error(2)
+	}
+	return args.Get(0).(*domain.Order), args.Bool(1), args.Error(2)
+}
+
+func (m *MockOrderRepository) GetOrderByID(ctx context.Context, id string) (*domain.Order, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -78,7 +89,7 @@ func (m *MockOrderRepository) GetOrderByID(id string) (*domain.Order, error) {
 	return args.Get(0).(*domain.Order), args.Error(1)
 }
 
-func (m *MockOrderRepository) GetNextPendingOrder() (*domain.Order, error) {
+func (m *MockOrderRepository) GetNextPendingOrder(ctx context.Context) (*domain.Order, error) {
 	args := m.Called()
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -86,7 +97,7 @@ func (m *MockOrderRepository) GetNextPendingOrder() (*domain.Order, error) {
 	return args.Get(0).(*domain.Order), args.Error(1)
 }
 
-func (m *MockOrderRepository) ClaimNextPendingOrder(droneID string) (*domain.Order, error) {
+func (m *MockOrderRepository) ClaimNextPendingOrder(ctx context.Context, droneID string) (*domain.Order, error) {
 	args := m.Called(droneID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -94,28 +105,99 @@ func (m *MockOrderRepository) ClaimNextPendingOrder(droneID string) (*domain.Ord
 	return args.Get(0).(*domain.Order), args.Error(1)
 }
 
-func (m *MockOrderRepository) GetActiveOrderByDroneID(droneID string) (*domain.Order, error) {
-	args := m.Called(droneID)
+func (m *MockOrderRepository) ClaimNextPendingOrderInWindow(ctx context.Context, droneID string, trip domain.TripEstimate) (*domain.Order, error) {
+	args := m.Called(droneID, trip)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Order), args.Error(1)
 }
 
-func (m *MockOrderRepository) GetAllOrders() ([]*domain.Order, error) {
-	args := m.Called()
+func (m *MockOrderRepository) FlagOrdersAtRisk(ctx context.Context, deadline time.Time) ([]*domain.Order, error) {
+	args := m.Called(deadline)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Order), args.Error(1)
+}
+
+func (m *MockOrderRepository) ReleaseScheduledOrders(ctx context.Context, now time.Time) ([]*domain.Order, error) {
+	args := m.Called(now)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).([]*domain.Order), args.Error(1)
 }
 
-func (m *MockOrderRepository) UpdateOrder(order *domain.Order) error {
+func (m *MockOrderRepository) ClaimPendingOrder(ctx context.Context, orderID, droneID string) (*domain.Order, error) {
+	args := m.Called(orderID, droneID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Order), args.Error(1)
+}
+
+func (m *MockOrderRepository) ReserveAssignments(ctx context.Context, pairs []domain.Assignment) ([]*domain.Order, error) {
+	args := m.Called(pairs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Order), args.Error(1)
+}
+
+func (m *MockOrderRepository) GetActiveOrdersByDroneID(ctx context.Context, droneID string) ([]*domain.Order, error) {
+	args := m.Called(droneID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Order), args.Error(1)
+}
+
+func (m *MockOrderRepository) ListOrders(ctx context.Context, filter domain.OrderFilter) (*domain.Page[*domain.Order], error) {
+	args := m.Called(filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Page[*domain.Order]), args.Error(1)
+}
+
+func (m *MockOrderRepository) UpdateOrder(ctx context.Context, order *domain.Order) error {
 	args := m.Called(order)
 	return args.Error(0)
 }
 
-func (m *MockOrderRepository) UpdateOrderCoords(id string, originLat, originLon, destLat, destLon float64) error {
-	args := m.Called(id, originLat, originLon, destLat, destLon)
+func (m *MockOrderRepository) UpdateOrderCoords(ctx context.Context, order *domain.Order) error {
+	args := m.Called(order)
+	return args.Error(0)
+}
+
+func (m *MockOrderRepository) AppendOrderEvent(ctx context.Context, event *domain.OrderEvent) error {
+	args := m.Called(event)
+	return args.Error(0)
+}
+
+func (m *MockOrderRepository) GetOrderEvents(ctx context.Context, orderID string) ([]*domain.OrderEvent, error) {
+	args := m.Called(orderID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.OrderEvent), args.Error(1)
+}
+
+func (m *MockOrderRepository) CreateOrderLeg(ctx context.Context, leg *domain.OrderLeg) error {
+	args := m.Called(leg)
+	return args.Error(0)
+}
+
+func (m *MockOrderRepository) GetOrderLegs(ctx context.Context, orderID string) ([]*domain.OrderLeg, error) {
+	args := m.Called(orderID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.OrderLeg), args.Error(1)
+}
+
+func (m *MockOrderRepository) UpdateOrderLeg(ctx context.Context, leg *domain.OrderLeg) error {
+	args := m.Called(leg)
 	return args.Error(0)
 }
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/repository"
+)
+
+// OrderCompletionHandler releases the assigned drone and announces the outcome once
+// an order reaches DELIVERED, FAILED or CANCELLED. Cancelling a RESERVED order aborts
+// the drone's mission the same way. A drone on a batched trip is released with its
+// last order.
+type OrderCompletionHandler struct {
+	droneService *DroneService
+	orderRepo    repository.OrderRepository
+	publisher    events.Publisher
+}
+
+func NewOrderCompletionHandler(droneService *DroneService, orderRepo repository.OrderRepository, publisher events.Publisher) *OrderCompletionHandler {
+	return &OrderCompletionHandler{
+		droneService: droneService,
+		orderRepo:    orderRepo,
+		publisher:    publisher,
+	}
+}
+
+func (h *OrderCompletionHandler) OnOrderStatusChanged(ctx context.Context, order *domain.Order, oldStatus, newStatus domain.OrderStatus) {
+	if !isTerminal(newStatus) {
+		return
+	}
+
+	droneID := ""
+	if order.DroneID != nil {
+		droneID = order.DroneID.String()
+		h.releaseDrone(ctx, order, droneID)
+	}
+
+	h.publishCompleted(ctx, order, droneID, newStatus)
+}
+
+// releaseDrone returns the drone to IDLE, which in turn makes it available for dispatch.
+// Drones that are no longer DELIVERING (e.g. BROKEN, OFFLINE) are left alone.
+func (h *OrderCompletionHandler) releaseDrone(ctx context.Context, order *domain.Order, droneID string) {
+	drone, err := h.droneService.GetDrone(ctx, droneID)
+	if err != nil {
+		log.Printf("Failed to load drone %s for completed order %s: %v", droneID, order.ID, err)
+		return
+	}
+	if drone.Status != domain.DroneStatusDelivering {
+		return
+	}
+	remaining, err := h.orderRepo.GetActiveOrdersByDroneID(ctx, droneID)
+	if err != nil {
+		log.Printf("Failed to load remaining orders of drone %s after order %s: %v", droneID, order.ID, err)
+		return
+	}
+	if len(remaining) > 0 {
+		return
+	}
+
+	if _, err := h.droneService.UpdateStatus(ctx, droneID, domain.DroneStatusIdle, domain.AnyVersion); err != nil {
+		log.Printf("Failed to release drone %s after order %s: %v", droneID, order.ID, err)
+		return
+	}
+	log.Printf("Released drone %s after order %s was %s", droneID, order.ID, order.Status)
+}
+
+func (h *OrderCompletionHandler) publishCompleted(ctx context.Context, order *domain.Order, droneID string, status domain.OrderStatus) {
+	event := domain.OrderCompletedEvent{
+		OrderID:   order.ID.String(),
+		DroneID:   droneID,
+		Status:    status,
+		Timestamp: time.Now(),
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	routingKey := "order." + strings.ToLower(string(status))
+	if err := h.publisher.Publish(ctx, routingKey, event); err != nil {
+		log.Printf("Failed to publish %s event for order %s: %v", routingKey, order.ID, err)
+	}
+}
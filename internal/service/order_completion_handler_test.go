@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/infrastructure/membus"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderCompletionHandler_Delivered_ReleasesDrone(t *testing.T) {
+	mockDroneRepo := new(MockDroneRepository)
+	mockOrderRepo := new(MockOrderRepository)
+	bus := membus.New()
+	handler := NewOrderCompletionHandler(NewDroneService(mockDroneRepo, nil), mockOrderRepo, bus)
+
+	published := make(chan events.Message, 1)
+	require.NoError(t, bus.Subscribe("completed", "order.delivered", func(ctx context.Context, msg events.Message) error {
+		published <- msg
+		return nil
+	}))
+
+	droneID := ksuid.New()
+	order := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusDelivered, DroneID: &droneID}
+
+	mockDroneRepo.On("GetDroneByID", droneID.String()).Return(&domain.Drone{ID: droneID, Status: domain.DroneStatusDelivering}, nil)
+	mockOrderRepo.On("GetActiveOrdersByDroneID", droneID.String()).Return(nil, nil)
+	mockDroneRepo.On("UpdateDrone", mock.MatchedBy(func(d *domain.Drone) bool {
+		return d.ID == droneID && d.Status == domain.DroneStatusIdle
+	})).Return(nil)
+
+	handler.OnOrderStatusChanged(context.Background(), order, domain.OrderStatusPickedUp, domain.OrderStatusDelivered)
+
+	mockDroneRepo.AssertExpectations(t)
+	select {
+	case msg := <-published:
+		assert.Contains(t, string(msg.Body), order.ID.String())
+	case <-time.After(2 * time.Second):
+		t.Fatal("order.delivered was not published")
+	}
+}
+
+func TestOrderCompletionHandler_ReservedCancelled_AbortsMission(t *testing.T) {
+	mockDroneRepo := new(MockDroneRepository)
+	mockOrderRepo := new(MockOrderRepository)
+	handler := NewOrderCompletionHandler(NewDroneService(mockDroneRepo, nil), mockOrderRepo, membus.New())
+
+	droneID := ksuid.New()
+	order := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusCancelled, DroneID: &droneID}
+
+	mockDroneRepo.On("GetDroneByID", droneID.String()).Return(&domain.Drone{ID: droneID, Status: domain.DroneStatusDelivering}, nil)
+	mockOrderRepo.On("GetActiveOrdersByDroneID", droneID.String()).Return(nil, nil)
+	mockDroneRepo.On("UpdateDrone", mock.MatchedBy(func(d *domain.Drone) bool {
+		return d.Status == domain.DroneStatusIdle
+	})).Return(nil)
+
+	handler.OnOrderStatusChanged(context.Background(), order, domain.OrderStatusReserved, domain.OrderStatusCancelled)
+
+	mockDroneRepo.AssertExpectations(t)
+}
+
+func TestOrderCompletionHandler_BatchedDrone_ReleasedWithLastOrder(t *testing.T) {
+	mockDroneRepo := new(MockDroneRepository)
+	mockOrderRepo := new(MockOrderRepository)
+	handler := NewOrderCompletionHandler(NewDroneService(mockDroneRepo, nil), mockOrderRepo, membus.New())
+
+	droneID := ksuid.New()
+	order := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusDelivered, DroneID: &droneID}
+
+	mockDroneRepo.On("GetDroneByID", droneID.String()).Return(&domain.Drone{ID: droneID, Status: domain.DroneStatusDelivering}, nil)
+	mockOrderRepo.On("GetActiveOrdersByDroneID", droneID.String()).
+		Return([]*domain.Order{{ID: ksuid.New(), Status: domain.OrderStatusPickedUp, DroneID: &droneID}}, nil)
+
+	handler.OnOrderStatusChanged(context.Background(), order, domain.OrderStatusPickedUp, domain.OrderStatusDelivered)
+
+	// Still on its way to the other drop-off
+	mockDroneRepo.AssertNotCalled(t, "UpdateDrone", mock.Anything)
+	mockOrderRepo.AssertExpectations(t)
+}
+
+func TestOrderCompletionHandler_BrokenDrone_NotReleased(t *testing.T) {
+	mockDroneRepo := new(MockDroneRepository)
+	mockOrderRepo := new(MockOrderRepository)
+	handler := NewOrderCompletionHandler(NewDroneService(mockDroneRepo, nil), mockOrderRepo, membus.New())
+
+	droneID := ksuid.New()
+	order := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusFailed, DroneID: &droneID}
+
+	mockDroneRepo.On("GetDroneByID", droneID.String()).Return(&domain.Drone{ID: droneID, Status: domain.DroneStatusBroken}, nil)
+
+	handler.OnOrderStatusChanged(context.Background(), order, domain.OrderStatusPickedUp, domain.OrderStatusFailed)
+
+	mockDroneRepo.AssertNotCalled(t, "UpdateDrone", mock.Anything)
+}
+
+func TestOrderCompletionHandler_Ignored(t *testing.T) {
+	mockDroneRepo := new(MockDroneRepository)
+	mockOrderRepo := new(MockOrderRepository)
+	handler := NewOrderCompletionHandler(NewDroneService(mockDroneRepo, nil), mockOrderRepo, membus.New())
+
+	droneID := ksuid.New()
+
+	// Not terminal
+	handler.OnOrderStatusChanged(context.Background(), &domain.Order{ID: ksuid.New(), DroneID: &droneID}, domain.OrderStatusReserved, domain.OrderStatusPickedUp)
+
+	// Terminal but never assigned
+	handler.OnOrderStatusChanged(context.Background(), &domain.Order{ID: ksuid.New()}, domain.OrderStatusPending, domain.OrderStatusCancelled)
+
+	mockDroneRepo.AssertNotCalled(t, "GetDroneByID", mock.Anything)
+}
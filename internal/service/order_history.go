@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/repository"
+	"github.com/segmentio/ksuid"
+)
+
+// Actors recorded for transitions made by background components
+const (
+	ActorDispatcher = "system:dispatcher"
+	ActorRecovery   = "system:recovery"
+	ActorScheduler  = "system:scheduler"
+)
+
+// newOrderEvent describes the transition that just moved order from 'from' to its current status
+func newOrderEvent(order *domain.Order, from domain.OrderStatus, actor, reason string) *domain.OrderEvent {
+	return &domain.OrderEvent{
+		ID:         ksuid.New(),
+		OrderID:    order.ID,
+		FromStatus: from,
+		ToStatus:   order.Status,
+		Actor:      actor,
+		DroneID:    order.DroneID,
+		Reason:     reason,
+		CreatedAt:  time.Now(),
+	}
+}
+
+// recordOrderEvent appends to the audit trail. Failures are logged and never undo the transition.
+func recordOrderEvent(ctx context.Context, repo repository.OrderRepository, event *domain.OrderEvent) {
+	if err := repo.AppendOrderEvent(ctx, event); err != nil {
+		log.Printf("Failed to record %s -> %s event for order %s: %v", event.FromStatus, event.ToStatus, event.OrderID, err)
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/repository"
+	"github.com/segmentio/ksuid"
+)
+
+// startOrderLeg opens a new leg for the drone that just took the order. The leg
+// starts wherever the parcel currently waits, which is the origin unless an earlier
+// drone was interrupted.
+func startOrderLeg(ctx context.Context, repo repository.OrderRepository, order *domain.Order, droneID ksuid.KSUID) {
+	leg := &domain.OrderLeg{
+		ID:        ksuid.New(),
+		OrderID:   order.ID,
+		DroneID:   droneID,
+		FromLat:   order.PickupLat,
+		FromLon:   order.PickupLon,
+		ToLat:     order.DestLat,
+		ToLon:     order.DestLon,
+		Outcome:   domain.LegOutcomeInProgress,
+		StartedAt: time.Now(),
+	}
+	if err := repo.CreateOrderLeg(ctx, leg); err != nil {
+		log.Printf("Failed to start leg for order %s with drone %s: %v", order.ID, droneID, err)
+	}
+}
+
+// closeActiveOrderLeg records how the in-progress leg ended. endLat/endLon, when given,
+// replace the leg's destination with the point where the drone actually stopped.
+func closeActiveOrderLeg(ctx context.Context, repo repository.OrderRepository, orderID string, outcome domain.LegOutcome, endLat, endLon *float64) {
+	legs, err := repo.GetOrderLegs(ctx, orderID)
+	if err != nil {
+		log.Printf("Failed to load legs for order %s: %v", orderID, err)
+		return
+	}
+
+	for i := len(legs) - 1; i >= 0; i-- {
+		leg := legs[i]
+		if leg.Outcome != domain.LegOutcomeInProgress {
+			continue
+		}
+
+		now := time.Now()
+		leg.Outcome = outcome
+		leg.EndedAt = &now
+		if endLat != nil && endLon != nil {
+			leg.ToLat, leg.ToLon = *endLat, *endLon
+		}
+		if err := repo.UpdateOrderLeg(ctx, leg); err != nil {
+			log.Printf("Failed to close leg %s of order %s: %v", leg.ID, orderID, err)
+		}
+		return
+	}
+}
+
+// legOutcomeFor maps a terminal order status to the outcome of its last leg
+func legOutcomeFor(status domain.OrderStatus) domain.LegOutcome {
+	switch status {
+	case domain.OrderStatusDelivered:
+		return domain.LegOutcomeDelivered
+	case domain.OrderStatusFailed:
+		return domain.LegOutcomeFailed
+	default:
+		return domain.LegOutcomeCancelled
+	}
+}
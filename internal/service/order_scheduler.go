@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/lifecycle"
+	"go.opentelemetry.io/otel"
+)
+
+type OrderScheduler struct {
+	// Periodic runs releaseOrders every interval and serves the health check
+	*lifecycle.Periodic
+
+	orders *OrderService
+}
+
+// NewOrderScheduler releases, every interval, the SCHEDULED orders whose delivery
+// window is close enough to dispatch them
+func NewOrderScheduler(orders *OrderService, interval time.Duration) *OrderScheduler {
+	s := &OrderScheduler{orders: orders}
+	s.Periodic = lifecycle.NewPeriodic("Order Scheduler", interval, s.releaseOrders)
+	return s
+}
+
+func (s *OrderScheduler) releaseOrders(ctx context.Context) {
+	ctx, span := otel.Tracer("order-scheduler").Start(ctx, "OrderScheduler.releaseOrders")
+	defer span.End()
+
+	orders, err := s.orders.ReleaseDueOrders(ctx, time.Now())
+	if err != nil {
+		log.Printf("OrderScheduler: failed to release scheduled orders: %v", err)
+		return
+	}
+	for _, order := range orders {
+		log.Printf("OrderScheduler: released order %s for delivery after %s", order.ID, order.DeliverAfter.Format(time.RFC3339))
+	}
+}
@@ -2,140 +2,385 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
-	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/infrastructure/rabbitmq"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
+	infra "github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/infrastructure/redis"
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/repository"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/telemetry"
 	"github.com/segmentio/ksuid"
 )
 
+// OrderStatusObserver defines the interface for listening to order status changes
+type OrderStatusObserver interface {
+	OnOrderStatusChanged(ctx context.Context, order *domain.Order, oldStatus, newStatus domain.OrderStatus)
+}
+
+// idempotencyKeyTTL is how long a client may retry an order creation with the same key
+const idempotencyKeyTTL = 24 * time.Hour
+
 type OrderService struct {
-	repo      repository.OrderRepository
-	publisher *rabbitmq.Client
+	repo        repository.OrderRepository
+	publisher   events.Publisher
+	redisClient *infra.Client
+	sla         SLAPolicy
+	observers   []OrderStatusObserver
 }
 
-func NewOrderService(repo repository.OrderRepository, publisher *rabbitmq.Client) *OrderService {
+func NewOrderService(repo repository.OrderRepository, publisher events.Publisher, redisClient *infra.Client, sla SLAPolicy) *OrderService {
 	return &OrderService{
-		repo:      repo,
-		publisher: publisher,
+		repo:        repo,
+		publisher:   publisher,
+		redisClient: redisClient,
+		sla:         sla,
+		observers:   make([]OrderStatusObserver, 0),
 	}
 }
 
-func (s *OrderService) CreateOrder(originLat, originLon, destLat, destLon float64) (*domain.Order, error) {
-	order := &domain.Order{
-		ID:        ksuid.New(),
-		Status:    domain.OrderStatusPending,
-		OriginLat: originLat,
-		OriginLon: originLon,
-		DestLat:   destLat,
-		DestLon:   destLon,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+// OrderRequest is what a client asks for when placing an order
+type OrderRequest struct {
+	OriginLat, OriginLon float64
+	DestLat, DestLon     float64
+	Priority             domain.OrderPriority // STANDARD when empty
+	DeliverAfter         *time.Time           // Start of the delivery window, if any
+	DeliverBy            *time.Time           // Defaults to the promise of the priority class
+}
+
+func (s *OrderService) AddObserver(observer OrderStatusObserver) {
+	s.observers = append(s.observers, observer)
+}
+
+func (s *OrderService) notifyObservers(ctx context.Context, order *domain.Order, oldStatus, newStatus domain.OrderStatus) {
+	for _, observer := range s.observers {
+		observer.OnOrderStatusChanged(ctx, order, oldStatus, newStatus)
+	}
+}
+
+func (s *OrderService) CreateOrder(ctx context.Context, actor string, req OrderRequest) (*domain.Order, error) {
+	order, err := s.newOrder(req)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := s.repo.CreateOrder(order); err != nil {
+	if err := s.repo.CreateOrder(ctx, order); err != nil {
 		return nil, err
 	}
+	ctx = afterCommit(ctx)
+	s.orderCreated(ctx, order, actor)
 
-	// Publish OrderCreated event
-	if s.publisher != nil {
-		event := domain.OrderCreatedEvent{
-			OrderID:   order.ID.String(),
-			OriginLat: order.OriginLat,
-			OriginLon: order.OriginLon,
-			DestLat:   order.DestLat,
-			DestLon:   order.DestLon,
-			Timestamp: time.Now(),
+	return order, nil
+}
+
+// CreateOrderIdempotent creates an order at most once per (actor, key). A retry with the
+// same key and body returns the original order with replayed=true; reusing the key for a
+// different body fails with ErrIdempotencyKeyReused.
+func (s *OrderService) CreateOrderIdempotent(ctx context.Context, actor, key string, req OrderRequest) (*domain.Order, bool, error) {
+	requestHash := orderRequestHash(req)
+
+	// Fast path: replay from the Redis cache
+	if s.redisClient != nil {
+		cachedHash, orderID, found, err := s.redisClient.GetIdempotencyKey(ctx, actor, key)
+		if err != nil {
+			log.Printf("Failed to read idempotency cache: %v", err)
+		} else if found {
+			if cachedHash != requestHash {
+				return nil, false, domain.ErrIdempotencyKeyReused
+			}
+			if order, err := s.repo.GetOrderByID(ctx, orderID); err == nil {
+				return order, true, nil
+			}
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	}
 
-		if err := s.publisher.Publish(ctx, "order.created", event); err != nil {
-			log.Printf("Failed to publish OrderCreated event for order %s: %v", order.ID.String(), err)
+	order, err := s.newOrder(req)
+	if err != nil {
+		return nil, false, err
+	}
+	record := &domain.IdempotencyKey{
+		UserID:      actor,
+		Key:         key,
+		RequestHash: requestHash,
+		OrderID:     order.ID,
+		CreatedAt:   order.CreatedAt,
+		ExpiresAt:   order.CreatedAt.Add(idempotencyKeyTTL),
+	}
+
+	stored, replayed, err := s.repo.CreateOrderIdempotent(ctx, order, record)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ctx = afterCommit(ctx)
+	if s.redisClient != nil {
+		if err := s.redisClient.SetIdempotencyKey(ctx, actor, key, requestHash, stored.ID.String(), time.Until(record.ExpiresAt)); err != nil {
+			log.Printf("Failed to cache idempotency key: %v", err)
 		}
 	}
 
-	return order, nil
+	if !replayed {
+		s.orderCreated(ctx, stored, actor)
+	}
+	return stored, replayed, nil
 }
 
-func (s *OrderService) GetOrder(id string) (*domain.Order, error) {
-	order, err := s.repo.GetOrderByID(id)
-	if err != nil {
+func (s *OrderService) newOrder(req OrderRequest) (*domain.Order, error) {
+	now := time.Now()
+	order := &domain.Order{
+		ID:        ksuid.New(),
+		Status:    domain.OrderStatusPending,
+		OriginLat: req.OriginLat,
+		OriginLon: req.OriginLon,
+		PickupLat: req.OriginLat,
+		PickupLon: req.OriginLon,
+		DestLat:   req.DestLat,
+		DestLon:   req.DestLon,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.sla.schedule(order, req); err != nil {
 		return nil, err
 	}
 	return order, nil
 }
 
-func (s *OrderService) ListOrders() ([]*domain.Order, error) {
-	return s.repo.GetAllOrders()
+// orderCreated records a newly persisted order and announces it, unless it waits
+// for the scheduler to release it
+func (s *OrderService) orderCreated(ctx context.Context, order *domain.Order, actor string) {
+	recordOrderEvent(ctx, s.repo, newOrderEvent(order, "", actor, ""))
+	telemetry.Metrics.OrderCreated(ctx, string(order.Status))
+	if order.Status == domain.OrderStatusPending {
+		publishOrderCreated(ctx, s.publisher, order)
+	}
 }
 
-func (s *OrderService) WithdrawOrder(id string) error {
-	order, err := s.repo.GetOrderByID(id)
-	if err != nil {
-		return err
+// orderRequestHash fingerprints the body of a create request for idempotency checks.
+// A standard order without a deadline or window hashes as before priorities existed.
+func orderRequestHash(req OrderRequest) string {
+	body := fmt.Sprintf("%g,%g,%g,%g", req.OriginLat, req.OriginLon, req.DestLat, req.DestLon)
+	if req.Priority != "" && req.Priority != domain.OrderPriorityStandard {
+		body += "," + string(req.Priority)
+	}
+	if req.DeliverBy != nil {
+		body += "," + req.DeliverBy.UTC().Format(time.RFC3339Nano)
+	}
+	if req.DeliverAfter != nil {
+		body += ",after=" + req.DeliverAfter.UTC().Format(time.RFC3339Nano)
 	}
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
 
-	if order.Status != domain.OrderStatusPending && order.Status != domain.OrderStatusReserved {
-		return errors.New("cannot withdraw order that is already picked up or finished")
+// publishOrderCreated announces an order that is ready for dispatch
+func publishOrderCreated(ctx context.Context, publisher events.Publisher, order *domain.Order) {
+	event := domain.OrderCreatedEvent{
+		OrderID:   order.ID.String(),
+		OriginLat: order.PickupLat,
+		OriginLon: order.PickupLon,
+		DestLat:   order.DestLat,
+		DestLon:   order.DestLon,
+		Timestamp: time.Now(),
 	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-	order.Status = domain.OrderStatusCancelled
-	order.UpdatedAt = time.Now()
-	return s.repo.UpdateOrder(order)
+	if err := publisher.Publish(ctx, "order.created", event); err != nil {
+		log.Printf("Failed to publish OrderCreated event for order %s: %v", order.ID.String(), err)
+	}
 }
 
-func (s *OrderService) UpdateOrderCoords(id string, originLat, originLon, destLat, destLon float64) error {
-	order, err := s.repo.GetOrderByID(id)
+func (s *OrderService) GetOrder(ctx context.Context, id string) (*domain.Order, error) {
+	order, err := s.repo.GetOrderByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hand-off chain
+	legs, err := s.repo.GetOrderLegs(ctx, id)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	order.Legs = legs
+	return order, nil
+}
 
-	if order.Status != domain.OrderStatusPending {
-		return errors.New("cannot update destination of an order that is already in progress")
+// GetOrderHistory returns every recorded status transition of an order, oldest first
+func (s *OrderService) GetOrderHistory(ctx context.Context, id string) ([]*domain.OrderEvent, error) {
+	if _, err := s.repo.GetOrderByID(ctx, id); err != nil {
+		return nil, err
 	}
+	return s.repo.GetOrderEvents(ctx, id)
+}
 
-	return s.repo.UpdateOrderCoords(id, originLat, originLon, destLat, destLon)
+func (s *OrderService) ListOrders(ctx context.Context, filter domain.OrderFilter) (*domain.Page[*domain.Order], error) {
+	return s.repo.ListOrders(ctx, filter)
 }
 
-func (s *OrderService) UpdateOrderState(id string, newState domain.OrderStatus) (*domain.Order, error) {
-	order, err := s.repo.GetOrderByID(id)
+// WithdrawOrder cancels an order that has not been picked up yet.
+// ifMatch is the version the caller last saw, or domain.AnyVersion.
+func (s *OrderService) WithdrawOrder(ctx context.Context, id, actor string, ifMatch int) (*domain.Order, error) {
+	var order *domain.Order
+	var oldStatus domain.OrderStatus
+	err := retryOnConflict(ifMatch, func() error {
+		var err error
+		if order, err = s.repo.GetOrderByID(ctx, id); err != nil {
+			return err
+		}
+		if err := checkVersion(ifMatch, order.Version); err != nil {
+			return err
+		}
+
+		if !isValidTransition(order.Status, domain.OrderStatusCancelled) {
+			return errors.New("cannot withdraw order that is already picked up or finished")
+		}
+
+		oldStatus = order.Status
+		order.Status = domain.OrderStatusCancelled
+		order.UpdatedAt = time.Now()
+		return s.repo.UpdateOrder(ctx, order)
+	})
 	if err != nil {
 		return nil, err
 	}
+	ctx = afterCommit(ctx)
+	recordOrderEvent(ctx, s.repo, newOrderEvent(order, oldStatus, actor, "withdrawn"))
+	telemetry.Metrics.OrderTransitioned(ctx, string(order.Status))
+	if order.DroneID != nil {
+		closeActiveOrderLeg(ctx, s.repo, id, domain.LegOutcomeCancelled, nil, nil)
+	}
+
+	s.notifyObservers(ctx, order, oldStatus, order.Status)
+	return order, nil
+}
 
-	// Validate state transition (Simple valid transitions)
-	if !isValidTransition(order.Status, newState) {
-		return nil, errors.New("invalid state transition")
+// ReleaseDueOrders moves the SCHEDULED orders whose dispatch time has come to PENDING
+// and announces them to the dispatcher
+func (s *OrderService) ReleaseDueOrders(ctx context.Context, now time.Time) ([]*domain.Order, error) {
+	orders, err := s.repo.ReleaseScheduledOrders(ctx, now)
+	if err != nil {
+		return nil, err
 	}
+	ctx = afterCommit(ctx)
+	for _, order := range orders {
+		recordOrderEvent(ctx, s.repo, newOrderEvent(order, domain.OrderStatusScheduled, ActorScheduler, "delivery window"))
+		telemetry.Metrics.OrderTransitioned(ctx, string(order.Status))
+		publishOrderCreated(ctx, s.publisher, order)
+		s.notifyObservers(ctx, order, domain.OrderStatusScheduled, order.Status)
+	}
+	return orders, nil
+}
 
-	order.Status = newState
-	order.UpdatedAt = time.Now()
+// UpdateOrderCoords reroutes a PENDING or SCHEDULED order, moving its dispatch times
+// with the new flight, and returns it as updated.
+// ifMatch is the version the caller last saw, or domain.AnyVersion.
+func (s *OrderService) UpdateOrderCoords(ctx context.Context, id string, ifMatch int, originLat, originLon, destLat, destLon float64) (*domain.Order, error) {
+	var order *domain.Order
+	err := retryOnConflict(ifMatch, func() error {
+		var err error
+		if order, err = s.repo.GetOrderByID(ctx, id); err != nil {
+			return err
+		}
+		if err := checkVersion(ifMatch, order.Version); err != nil {
+			return err
+		}
 
-	if err := s.repo.UpdateOrder(order); err != nil {
+		if order.Status != domain.OrderStatusPending && order.Status != domain.OrderStatusScheduled {
+			return errors.New("cannot update destination of an order that is already in progress")
+		}
+
+		order.OriginLat, order.OriginLon = originLat, originLon
+		order.PickupLat, order.PickupLon = originLat, originLon
+		order.DestLat, order.DestLon = destLat, destLon
+		s.sla.reroute(order)
+		order.UpdatedAt = time.Now()
+		return s.repo.UpdateOrderCoords(ctx, order)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return order, nil
 }
 
+// UpdateOrderState moves an order to newState if the transition is allowed.
+// ifMatch is the version the caller last saw, or domain.AnyVersion.
+func (s *OrderService) UpdateOrderState(ctx context.Context, id string, newState domain.OrderStatus, actor, reason string, ifMatch int) (*domain.Order, error) {
+	var order *domain.Order
+	var oldStatus domain.OrderStatus
+	err := retryOnConflict(ifMatch, func() error {
+		var err error
+		if order, err = s.repo.GetOrderByID(ctx, id); err != nil {
+			return err
+		}
+		if err := checkVersion(ifMatch, order.Version); err != nil {
+			return err
+		}
+
+		// Validate state transition (Simple valid transitions)
+		if !isValidTransition(order.Status, newState) {
+			return errors.New("invalid state transition")
+		}
+
+		oldStatus = order.Status
+		order.Status = newState
+		order.UpdatedAt = time.Now()
+		return s.repo.UpdateOrder(ctx, order)
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx = afterCommit(ctx)
+	recordOrderEvent(ctx, s.repo, newOrderEvent(order, oldStatus, actor, reason))
+	telemetry.Metrics.OrderTransitioned(ctx, string(newState))
+	if newState == domain.OrderStatusDelivered {
+		telemetry.Metrics.OrderDelivered(ctx, order.CreatedAt)
+		if time.Now().After(order.DeliverBy) {
+			telemetry.Metrics.SLABreached(ctx, string(order.Priority))
+		}
+	}
+
+	if isTerminal(newState) && order.DroneID != nil {
+		closeActiveOrderLeg(ctx, s.repo, id, legOutcomeFor(newState), nil, nil)
+	}
+	if newState == domain.OrderStatusPending {
+		// Recovered parcel or early release: announce it so the dispatcher matches it
+		publishOrderCreated(ctx, s.publisher, order)
+	}
+
+	s.notifyObservers(ctx, order, oldStatus, newState)
+	return order, nil
+}
+
 func isValidTransition(current, next domain.OrderStatus) bool {
 	if next == domain.OrderStatusCancelled {
-		return current == domain.OrderStatusPending || current == domain.OrderStatusReserved
+		return current == domain.OrderStatusScheduled || current == domain.OrderStatusPending || current == domain.OrderStatusReserved
 	}
 
 	switch current {
+	case domain.OrderStatusScheduled:
+		// Released by the scheduler, or early by an admin
+		return next == domain.OrderStatusPending
 	case domain.OrderStatusPending:
 		return next == domain.OrderStatusReserved
 	case domain.OrderStatusReserved:
 		return next == domain.OrderStatusPickedUp
 	case domain.OrderStatusPickedUp:
 		return next == domain.OrderStatusDelivered || next == domain.OrderStatusFailed
+	case domain.OrderStatusAwaitingRecovery:
+		// Recovery crew either brings the parcel back into dispatch or gives it up
+		return next == domain.OrderStatusPending || next == domain.OrderStatusFailed
 	case domain.OrderStatusFailed, domain.OrderStatusDelivered, domain.OrderStatusCancelled:
 		return false // Terminal states
 	default:
 		return false
 	}
 }
+
+// isTerminal reports whether an order in this status will never change again
+func isTerminal(status domain.OrderStatus) bool {
+	return status == domain.OrderStatusDelivered || status == domain.OrderStatusFailed || status == domain.OrderStatusCancelled
+}
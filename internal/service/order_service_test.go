@@ -1,22 +1,38 @@
 package service
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/infrastructure/membus"
 	"github.com/segmentio/ksuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
+// MockOrderStatusObserver
+type MockOrderStatusObserver struct {
+	mock.Mock
+}
+
+func (m *MockOrderStatusObserver) OnOrderStatusChanged(ctx context.Context, order *domain.Order, oldStatus, newStatus domain.OrderStatus) {
+	m.Called(order, oldStatus, newStatus)
+}
+
 func TestCreateOrder(t *testing.T) {
 	mockRepo := new(MockOrderRepository)
-	service := NewOrderService(mockRepo, nil)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
 
 	mockRepo.On("CreateOrder", mock.AnythingOfType("*domain.Order")).Return(nil)
+	mockRepo.On("AppendOrderEvent", mock.MatchedBy(func(e *domain.OrderEvent) bool {
+		return e.FromStatus == "" && e.ToStatus == domain.OrderStatusPending && e.Actor == "enduser:alice"
+	})).Return(nil)
 
-	order, err := service.CreateOrder(1.0, 1.0, 2.0, 2.0)
+	order, err := service.CreateOrder(context.Background(), "enduser:alice", OrderRequest{OriginLat: 1, OriginLon: 1, DestLat: 2, DestLon: 2})
 
 	assert.NoError(t, err)
 	assert.NotNil(t, order)
@@ -25,9 +41,111 @@ func TestCreateOrder(t *testing.T) {
 	mockRepo.AssertExpectations(t)
 }
 
+func TestCreateOrderIdempotent_FirstRequest(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
+
+	stored := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending}
+	mockRepo.On("CreateOrderIdempotent", mock.MatchedBy(func(o *domain.Order) bool {
+		return o.Status == domain.OrderStatusPending && o.PickupLat == 1
+	}), mock.MatchedBy(func(k *domain.IdempotencyKey) bool {
+		return k.UserID == "enduser:alice" && k.Key == "key-1" && k.RequestHash == orderRequestHash(OrderRequest{OriginLat: 1, OriginLon: 1, DestLat: 2, DestLon: 2}) &&
+			k.ExpiresAt.Sub(k.CreatedAt) == idempotencyKeyTTL
+	})).Return(stored, false, nil)
+	mockRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
+
+	order, replayed, err := service.CreateOrderIdempotent(context.Background(), "enduser:alice", "key-1", OrderRequest{OriginLat: 1, OriginLon: 1, DestLat: 2, DestLon: 2})
+
+	assert.NoError(t, err)
+	assert.False(t, replayed)
+	assert.Equal(t, stored, order)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCreateOrderIdempotent_Replay(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
+
+	original := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusReserved}
+	mockRepo.On("CreateOrderIdempotent", mock.Anything, mock.Anything).Return(original, true, nil)
+
+	order, replayed, err := service.CreateOrderIdempotent(context.Background(), "enduser:alice", "key-1", OrderRequest{OriginLat: 1, OriginLon: 1, DestLat: 2, DestLon: 2})
+
+	assert.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, original, order)
+	// A replay is not a new order: no history entry, no event
+	mockRepo.AssertNotCalled(t, "AppendOrderEvent", mock.Anything)
+}
+
+func TestOrderRequestHash_DiffersByBody(t *testing.T) {
+	req := OrderRequest{OriginLat: 1, OriginLon: 2, DestLat: 3, DestLon: 4}
+	other := req
+	other.DestLon = 5
+	assert.Equal(t, orderRequestHash(req), orderRequestHash(req))
+	assert.NotEqual(t, orderRequestHash(req), orderRequestHash(other))
+
+	// Spelling out the default priority is the same request
+	standard := req
+	standard.Priority = domain.OrderPriorityStandard
+	assert.Equal(t, orderRequestHash(req), orderRequestHash(standard))
+	medical := req
+	medical.Priority = domain.OrderPriorityMedical
+	assert.NotEqual(t, orderRequestHash(req), orderRequestHash(medical))
+}
+
+func TestCreateOrder_WindowIsScheduledUntilDue(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	bus := membus.New()
+	service := NewOrderService(mockRepo, bus, nil, testSLA)
+
+	created := make(chan events.Message, 1)
+	require.NoError(t, bus.Subscribe("dispatch", "order.created", func(ctx context.Context, msg events.Message) error {
+		created <- msg
+		return nil
+	}))
+
+	mockRepo.On("CreateOrder", mock.AnythingOfType("*domain.Order")).Return(nil)
+	mockRepo.On("AppendOrderEvent", mock.MatchedBy(func(e *domain.OrderEvent) bool {
+		return e.FromStatus == "" && e.ToStatus == domain.OrderStatusScheduled
+	})).Return(nil)
+
+	opens := time.Now().Add(6 * time.Hour)
+	order, err := service.CreateOrder(context.Background(), "enduser:alice", OrderRequest{
+		OriginLat: 1, OriginLon: 1, DestLat: 2, DestLon: 2, DeliverAfter: &opens,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, domain.OrderStatusScheduled, order.Status)
+	select {
+	case <-created:
+		t.Fatal("a scheduled order was announced to the dispatcher")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Once due, the scheduler releases it to the dispatcher
+	released := *order
+	released.Status = domain.OrderStatusPending
+	now := time.Now()
+	mockRepo.On("ReleaseScheduledOrders", now).Return([]*domain.Order{&released}, nil)
+	mockRepo.On("AppendOrderEvent", mock.MatchedBy(func(e *domain.OrderEvent) bool {
+		return e.FromStatus == domain.OrderStatusScheduled && e.ToStatus == domain.OrderStatusPending && e.Actor == ActorScheduler
+	})).Return(nil)
+
+	orders, err := service.ReleaseDueOrders(context.Background(), now)
+	require.NoError(t, err)
+	assert.Len(t, orders, 1)
+	mockRepo.AssertExpectations(t)
+	select {
+	case msg := <-created:
+		assert.Contains(t, string(msg.Body), order.ID.String())
+	case <-time.After(2 * time.Second):
+		t.Fatal("order.created was not published on release")
+	}
+}
+
 func TestUpdateOrderState_ValidTransition(t *testing.T) {
 	mockRepo := new(MockOrderRepository)
-	service := NewOrderService(mockRepo, nil)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
 
 	orderID := ksuid.New()
 	existingOrder := &domain.Order{
@@ -40,17 +158,41 @@ func TestUpdateOrderState_ValidTransition(t *testing.T) {
 	mockRepo.On("UpdateOrder", mock.MatchedBy(func(o *domain.Order) bool {
 		return o.Status == domain.OrderStatusReserved && o.ID == orderID
 	})).Return(nil)
+	mockRepo.On("AppendOrderEvent", mock.MatchedBy(func(e *domain.OrderEvent) bool {
+		return e.OrderID == orderID && e.FromStatus == domain.OrderStatusPending &&
+			e.ToStatus == domain.OrderStatusReserved && e.Actor == "admin:root" && e.Reason == "manual"
+	})).Return(nil)
 
-	updatedOrder, err := service.UpdateOrderState(orderID.String(), domain.OrderStatusReserved)
+	updatedOrder, err := service.UpdateOrderState(context.Background(), orderID.String(), domain.OrderStatusReserved, "admin:root", "manual", domain.AnyVersion)
 
 	assert.NoError(t, err)
 	assert.Equal(t, domain.OrderStatusReserved, updatedOrder.Status)
 	mockRepo.AssertExpectations(t)
 }
 
+func TestUpdateOrderState_NotifiesObservers(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockObserver := new(MockOrderStatusObserver)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
+	service.AddObserver(mockObserver)
+
+	orderID := ksuid.New()
+	existingOrder := &domain.Order{ID: orderID, Status: domain.OrderStatusPickedUp}
+
+	mockRepo.On("GetOrderByID", orderID.String()).Return(existingOrder, nil)
+	mockRepo.On("UpdateOrder", mock.Anything).Return(nil)
+	mockRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
+	mockObserver.On("OnOrderStatusChanged", existingOrder, domain.OrderStatusPickedUp, domain.OrderStatusDelivered).Return()
+
+	_, err := service.UpdateOrderState(context.Background(), orderID.String(), domain.OrderStatusDelivered, "drone:d1", "", domain.AnyVersion)
+
+	assert.NoError(t, err)
+	mockObserver.AssertExpectations(t)
+}
+
 func TestUpdateOrderState_InvalidTransition(t *testing.T) {
 	mockRepo := new(MockOrderRepository)
-	service := NewOrderService(mockRepo, nil)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
 
 	orderID := ksuid.New()
 	existingOrder := &domain.Order{
@@ -61,35 +203,87 @@ func TestUpdateOrderState_InvalidTransition(t *testing.T) {
 	mockRepo.On("GetOrderByID", orderID.String()).Return(existingOrder, nil)
 
 	// Trying to go straight to DELIVERED from PENDING should fail
-	_, err := service.UpdateOrderState(orderID.String(), domain.OrderStatusDelivered)
+	_, err := service.UpdateOrderState(context.Background(), orderID.String(), domain.OrderStatusDelivered, "admin:root", "", domain.AnyVersion)
 
 	assert.Error(t, err)
 	assert.Equal(t, "invalid state transition", err.Error())
 	mockRepo.AssertNotCalled(t, "UpdateOrder")
 }
 
+func TestUpdateOrderState_StaleIfMatch(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
+
+	orderID := ksuid.New()
+	mockRepo.On("GetOrderByID", orderID.String()).Return(&domain.Order{ID: orderID, Status: domain.OrderStatusPickedUp, Version: 5}, nil)
+
+	_, err := service.UpdateOrderState(context.Background(), orderID.String(), domain.OrderStatusDelivered, "admin:root", "", 4)
+
+	assert.Equal(t, domain.ErrConflict, err)
+	mockRepo.AssertNotCalled(t, "UpdateOrder", mock.Anything)
+	mockRepo.AssertNumberOfCalls(t, "GetOrderByID", 1)
+}
+
+func TestUpdateOrderState_RetriesOnConflict(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
+
+	orderID := ksuid.New()
+	// Every re-read sees a fresh copy, as the database would return
+	for i := 0; i < 2; i++ {
+		mockRepo.On("GetOrderByID", orderID.String()).Return(&domain.Order{ID: orderID, Status: domain.OrderStatusPickedUp}, nil).Once()
+	}
+	mockRepo.On("UpdateOrder", mock.Anything).Return(domain.ErrConflict).Once()
+	mockRepo.On("UpdateOrder", mock.Anything).Return(nil).Once()
+	mockRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
+
+	order, err := service.UpdateOrderState(context.Background(), orderID.String(), domain.OrderStatusDelivered, "admin:root", "", domain.AnyVersion)
+
+	assert.NoError(t, err)
+	assert.Equal(t, domain.OrderStatusDelivered, order.Status)
+	mockRepo.AssertNumberOfCalls(t, "GetOrderByID", 2)
+}
+
+func TestUpdateOrderState_GivesUpAfterRepeatedConflicts(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
+
+	orderID := ksuid.New()
+	for i := 0; i < maxConflictRetries; i++ {
+		mockRepo.On("GetOrderByID", orderID.String()).Return(&domain.Order{ID: orderID, Status: domain.OrderStatusPickedUp}, nil).Once()
+	}
+	mockRepo.On("UpdateOrder", mock.Anything).Return(domain.ErrConflict)
+
+	_, err := service.UpdateOrderState(context.Background(), orderID.String(), domain.OrderStatusDelivered, "admin:root", "", domain.AnyVersion)
+
+	assert.Equal(t, domain.ErrConflict, err)
+	mockRepo.AssertNumberOfCalls(t, "UpdateOrder", maxConflictRetries)
+	mockRepo.AssertNotCalled(t, "AppendOrderEvent", mock.Anything)
+}
+
 func TestListOrders(t *testing.T) {
 	mockRepo := new(MockOrderRepository)
-	service := NewOrderService(mockRepo, nil)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
 
 	expectedOrders := []*domain.Order{
 		{ID: ksuid.New(), Status: domain.OrderStatusPending},
 		{ID: ksuid.New(), Status: domain.OrderStatusDelivered},
 	}
 
-	mockRepo.On("GetAllOrders").Return(expectedOrders, nil)
+	filter := domain.OrderFilter{Status: domain.OrderStatusPending, ListOptions: domain.ListOptions{Limit: 2}}
+	mockRepo.On("ListOrders", filter).Return(&domain.Page[*domain.Order]{Items: expectedOrders, Total: 2}, nil)
 
-	orders, err := service.ListOrders()
+	page, err := service.ListOrders(context.Background(), filter)
 
 	assert.NoError(t, err)
-	assert.Len(t, orders, 2)
-	assert.Equal(t, expectedOrders[0].ID, orders[0].ID)
+	assert.Len(t, page.Items, 2)
+	assert.Equal(t, expectedOrders[0].ID, page.Items[0].ID)
 	mockRepo.AssertExpectations(t)
 }
 
 func TestWithdrawOrder_Success(t *testing.T) {
 	mockRepo := new(MockOrderRepository)
-	service := NewOrderService(mockRepo, nil)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
 
 	orderID := ksuid.New()
 	existingOrder := &domain.Order{
@@ -101,8 +295,57 @@ func TestWithdrawOrder_Success(t *testing.T) {
 	mockRepo.On("UpdateOrder", mock.MatchedBy(func(o *domain.Order) bool {
 		return o.ID == orderID && o.Status == domain.OrderStatusCancelled
 	})).Return(nil)
+	mockRepo.On("AppendOrderEvent", mock.MatchedBy(func(e *domain.OrderEvent) bool {
+		return e.ToStatus == domain.OrderStatusCancelled && e.Actor == "enduser:alice"
+	})).Return(nil)
 
-	err := service.WithdrawOrder(orderID.String())
+	_, err := service.WithdrawOrder(context.Background(), orderID.String(), "enduser:alice", domain.AnyVersion)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestWithdrawOrder_NotifiesObservers(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockObserver := new(MockOrderStatusObserver)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
+	service.AddObserver(mockObserver)
+
+	droneID := ksuid.New()
+	existingOrder := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusReserved, DroneID: &droneID}
+
+	activeLeg := &domain.OrderLeg{ID: ksuid.New(), OrderID: existingOrder.ID, DroneID: droneID, Outcome: domain.LegOutcomeInProgress}
+
+	mockRepo.On("GetOrderByID", existingOrder.ID.String()).Return(existingOrder, nil)
+	mockRepo.On("UpdateOrder", mock.Anything).Return(nil)
+	mockRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
+	mockRepo.On("GetOrderLegs", existingOrder.ID.String()).Return([]*domain.OrderLeg{activeLeg}, nil)
+	mockRepo.On("UpdateOrderLeg", mock.MatchedBy(func(l *domain.OrderLeg) bool {
+		return l.ID == activeLeg.ID && l.Outcome == domain.LegOutcomeCancelled && l.EndedAt != nil
+	})).Return(nil)
+	mockObserver.On("OnOrderStatusChanged", existingOrder, domain.OrderStatusReserved, domain.OrderStatusCancelled).Return()
+
+	_, err := service.WithdrawOrder(context.Background(), existingOrder.ID.String(), "enduser:alice", domain.AnyVersion)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockObserver.AssertExpectations(t)
+}
+
+func TestUpdateOrderState_RecoveredParcelReturnsToPending(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
+
+	orderID := ksuid.New()
+	existingOrder := &domain.Order{ID: orderID, Status: domain.OrderStatusAwaitingRecovery}
+
+	mockRepo.On("GetOrderByID", orderID.String()).Return(existingOrder, nil)
+	mockRepo.On("UpdateOrder", mock.MatchedBy(func(o *domain.Order) bool {
+		return o.Status == domain.OrderStatusPending
+	})).Return(nil)
+	mockRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
+
+	_, err := service.UpdateOrderState(context.Background(), orderID.String(), domain.OrderStatusPending, "admin:crew", "parcel recovered", domain.AnyVersion)
 
 	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
@@ -110,7 +353,7 @@ func TestWithdrawOrder_Success(t *testing.T) {
 
 func TestWithdrawOrder_Failure_AlreadyPickedUp(t *testing.T) {
 	mockRepo := new(MockOrderRepository)
-	service := NewOrderService(mockRepo, nil)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
 
 	orderID := ksuid.New()
 	existingOrder := &domain.Order{
@@ -120,7 +363,7 @@ func TestWithdrawOrder_Failure_AlreadyPickedUp(t *testing.T) {
 
 	mockRepo.On("GetOrderByID", orderID.String()).Return(existingOrder, nil)
 
-	err := service.WithdrawOrder(orderID.String())
+	_, err := service.WithdrawOrder(context.Background(), orderID.String(), "enduser:alice", domain.AnyVersion)
 
 	assert.Error(t, err)
 	assert.Equal(t, "cannot withdraw order that is already picked up or finished", err.Error())
@@ -129,19 +372,73 @@ func TestWithdrawOrder_Failure_AlreadyPickedUp(t *testing.T) {
 
 func TestUpdateOrderCoords_Success(t *testing.T) {
 	mockRepo := new(MockOrderRepository)
-	service := NewOrderService(mockRepo, nil)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
 
 	orderID := ksuid.New()
 	existingOrder := &domain.Order{
-		ID:     orderID,
-		Status: domain.OrderStatusPending,
+		ID:      orderID,
+		Status:  domain.OrderStatusPending,
+		Version: 3,
+	}
+
+	mockRepo.On("GetOrderByID", orderID.String()).Return(existingOrder, nil)
+	mockRepo.On("UpdateOrderCoords", mock.MatchedBy(func(o *domain.Order) bool {
+		return o.Version == 3 && o.PickupLat == 10 && o.DestLat == 20
+	})).Return(nil)
+
+	_, err := service.UpdateOrderCoords(context.Background(), orderID.String(), domain.AnyVersion, 10.0, 10.0, 20.0, 20.0)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUpdateOrderCoords_RecomputesDispatchTimes(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{CruiseSpeed: 10})
+
+	// Scheduled for a 1 km flight; the new destination is about 11 km away
+	created := time.Now().Add(-time.Minute)
+	deliverAfter := created.Add(2 * time.Hour)
+	dispatchAfter := deliverAfter.Add(-100 * time.Second)
+	orderID := ksuid.New()
+	existingOrder := &domain.Order{
+		ID:        orderID,
+		Status:    domain.OrderStatusScheduled,
+		PickupLat: 30, PickupLon: 31,
+		DestLat: 30.009, DestLon: 31,
+		CreatedAt:     created,
+		DeliverAfter:  &deliverAfter,
+		DeliverBy:     deliverAfter.Add(time.Hour),
+		DispatchAfter: &dispatchAfter,
+		DispatchBy:    deliverAfter.Add(time.Hour - 100*time.Second),
+		Version:       3,
 	}
 
 	mockRepo.On("GetOrderByID", orderID.String()).Return(existingOrder, nil)
-	mockRepo.On("UpdateOrderCoords", orderID.String(), 10.0, 10.0, 20.0, 20.0).Return(nil)
+	mockRepo.On("UpdateOrderCoords", mock.Anything).Return(nil)
+
+	order, err := service.UpdateOrderCoords(context.Background(), orderID.String(), domain.AnyVersion, 30.0, 31.0, 30.1, 31.0)
+
+	assert.NoError(t, err)
+	flight := time.Duration(domain.DistanceMeters(30, 31, 30.1, 31) / 10 * float64(time.Second))
+	assert.WithinDuration(t, deliverAfter.Add(-flight), *order.DispatchAfter, time.Second)
+	assert.WithinDuration(t, order.DeliverBy.Add(-flight), order.DispatchBy, time.Second)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetOrderHistory(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	service := NewOrderService(mockRepo, membus.New(), nil, SLAPolicy{})
+
+	orderID := ksuid.New()
+	events := []*domain.OrderEvent{{ID: ksuid.New(), OrderID: orderID, ToStatus: domain.OrderStatusPending}}
+
+	mockRepo.On("GetOrderByID", orderID.String()).Return(&domain.Order{ID: orderID}, nil)
+	mockRepo.On("GetOrderEvents", orderID.String()).Return(events, nil)
 
-	err := service.UpdateOrderCoords(orderID.String(), 10.0, 10.0, 20.0, 20.0)
+	history, err := service.GetOrderHistory(context.Background(), orderID.String())
 
 	assert.NoError(t, err)
+	assert.Equal(t, events, history)
 	mockRepo.AssertExpectations(t)
 }
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/lifecycle"
+	"go.opentelemetry.io/otel"
+)
+
+// OrderSweeper backs the OrderDispatcherWorker: it matches, every interval, the
+// pending orders no event will match, because the rules that excluded every drone
+// depend on time, position or battery rather than on a drone becoming available
+type OrderSweeper struct {
+	// Periodic runs sweep every interval and serves the health check
+	*lifecycle.Periodic
+
+	dispatcher *DispatcherService
+}
+
+func NewOrderSweeper(dispatcher *DispatcherService, interval time.Duration) *OrderSweeper {
+	s := &OrderSweeper{dispatcher: dispatcher}
+	s.Periodic = lifecycle.NewPeriodic("Order Sweeper", interval, s.sweep)
+	return s
+}
+
+func (s *OrderSweeper) sweep(ctx context.Context) {
+	ctx, span := otel.Tracer("order-sweeper").Start(ctx, "OrderSweeper.sweep")
+	defer span.End()
+
+	orders, err := s.dispatcher.MatchIdleDrones(ctx)
+	if err != nil {
+		log.Printf("OrderSweeper: failed to match idle drones: %v", err)
+	}
+	for _, order := range orders {
+		log.Printf("OrderSweeper: assigned order %s to drone %s", order.ID, order.DroneID)
+	}
+}
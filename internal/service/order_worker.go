@@ -1,64 +1,160 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"log"
+	"strings"
 
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
-	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/infrastructure/rabbitmq"
-	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/repository"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
 )
 
+// OrderDispatcherWorker matches pending orders and idle drones from both sides:
+// a new order looks for an idle drone, and a drone becoming available claims the
+// oldest pending order. Whichever side arrives second completes the match, so
+// nothing has to be requeued while the other side is missing.
 type OrderDispatcherWorker struct {
-	rabbitClient *rabbitmq.Client
-	dispatcher   *DispatcherService
-	droneRepo    repository.DroneRepository
+	subscriber events.Subscriber
+	dispatcher *DispatcherService
+	queues     WorkerQueues
+	// subscriptions is how many consumers Start opened
+	subscriptions int
 }
 
-func NewOrderDispatcherWorker(rabbitClient *rabbitmq.Client, dispatcher *DispatcherService, droneRepo repository.DroneRepository) *OrderDispatcherWorker {
+// WorkerQueues names the queues the worker consumes
+type WorkerQueues struct {
+	OrderCreated   string
+	DroneAvailable string
+}
+
+func NewOrderDispatcherWorker(subscriber events.Subscriber, dispatcher *DispatcherService, queues WorkerQueues) *OrderDispatcherWorker {
 	return &OrderDispatcherWorker{
-		rabbitClient: rabbitClient,
-		dispatcher:   dispatcher,
-		droneRepo:    droneRepo,
+		subscriber: subscriber,
+		dispatcher: dispatcher,
+		queues:     queues,
 	}
 }
 
 func (w *OrderDispatcherWorker) Start() error {
-	if w.rabbitClient == nil {
+	if err := w.subscriber.Subscribe(w.queues.OrderCreated, "order.created", w.handleOrderCreated); err != nil {
+		return err
+	}
+	w.subscriptions++
+	if err := w.subscriber.Subscribe(w.queues.DroneAvailable, "drone.available", w.handleDroneAvailable); err != nil {
+		return err
+	}
+	w.subscriptions++
+	return nil
+}
+
+// Check fails when one of the worker's consumers has stopped, e.g. after the broker
+// closed the channel
+func (w *OrderDispatcherWorker) Check(ctx context.Context) error {
+	if running := w.subscriber.Consumers(); running < w.subscriptions {
+		return fmt.Errorf("%d of %d consumers running", running, w.subscriptions)
+	}
+	return nil
+}
+
+func (w *OrderDispatcherWorker) handleOrderCreated(ctx context.Context, msg events.Message) error {
+	var event domain.OrderCreatedEvent
+	if err := json.Unmarshal(msg.Body, &event); err != nil {
+		return err
+	}
+
+	log.Printf("Worker received OrderCreated event for ID: %s (message %s, delivery %d). Attempting to find a drone...",
+		event.OrderID, msg.MessageID, msg.DeliveryCount)
+
+	// 1. Rank the idle drones the same way the dispatch-plan endpoint explains it
+	plan, err := w.dispatcher.PlanDispatch(ctx, event.OrderID)
+	if err == domain.ErrNotFound {
+		// Deleted since it was announced; retrying cannot bring it back
+		log.Printf("Ignoring OrderCreated event for missing order %s", event.OrderID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if plan.OrderStatus != domain.OrderStatusPending {
+		log.Printf("Order %s already matched elsewhere: it is %s", event.OrderID, plan.OrderStatus)
 		return nil
 	}
 
-	return w.rabbitClient.Subscribe("order_dispatch_queue", "order.created", func(body []byte) error {
-		var event domain.OrderCreatedEvent
-		if err := json.Unmarshal(body, &event); err != nil {
-			return err
+	// 2. Offer the job to eligible drones, best first. ReserveJob hands out the
+	// oldest pending order the drone may take, which may be an earlier one.
+	for _, candidate := range plan.Candidates {
+		if !candidate.Eligible() {
+			break
 		}
+		order, err := w.dispatcher.ReserveJob(ctx, candidate.DroneID.String())
+		switch err {
+		case nil:
+			log.Printf("Successfully assigned order %s to drone %s", order.ID, candidate.DroneID)
+			return nil
+		case domain.ErrNoPendingOrders:
+			// Another matcher got there first
+			log.Printf("Order %s already matched elsewhere: %v", event.OrderID, err)
+			return nil
+		case domain.ErrDroneNotIdle, domain.ErrDroneBusy:
+			continue // Taken since the plan was made
+		}
+		log.Printf("Failed to reserve job for drone %s: %v", candidate.DroneID, err)
+		return err
+	}
 
-		log.Printf("Worker received OrderCreated event for ID: %s. Attempting to find a drone...", event.OrderID)
+	// The order stays PENDING; the next drone.available event or sweep will claim it.
+	log.Printf("No eligible drone for order %s (%s). It will be matched when a drone becomes available or by the next sweep.",
+		event.OrderID, describeExclusions(plan.Candidates))
+	return nil
+}
 
-		// 1. Find Idle Drones
-		drones, err := w.droneRepo.GetIdleDrones()
-		if err != nil {
-			return err
+// describeExclusions summarises why drones were passed over, e.g. "2 idle: distance 1, capacity 1"
+func describeExclusions(candidates []domain.DispatchCandidate) string {
+	if len(candidates) == 0 {
+		return "no idle drones"
+	}
+	counts := map[domain.ExclusionReason]int{}
+	var reasons []string
+	for _, c := range candidates {
+		for _, e := range c.Exclusions {
+			if counts[e.Reason] == 0 {
+				reasons = append(reasons, string(e.Reason))
+			}
+			counts[e.Reason]++
 		}
+	}
+	for i, r := range reasons {
+		reasons[i] = fmt.Sprintf("%s %d", r, counts[domain.ExclusionReason(r)])
+	}
+	return fmt.Sprintf("%d idle: %s", len(candidates), strings.Join(reasons, ", "))
+}
 
-		if len(drones) == 0 {
-			log.Printf("No idle drones available for order %s. Message will be requeued.", event.OrderID)
-			// Return error to requeue message
-			return errors.New("no idle drones available")
-		}
+func (w *OrderDispatcherWorker) handleDroneAvailable(ctx context.Context, msg events.Message) error {
+	var event domain.DroneAvailableEvent
+	if err := json.Unmarshal(msg.Body, &event); err != nil {
+		return err
+	}
 
-		// 2. Simple assignment (First available).
-		drone := drones[0]
+	log.Printf("Worker received DroneAvailable event for drone %s (message %s, delivery %d). Checking pending orders...",
+		event.DroneID, msg.MessageID, msg.DeliveryCount)
 
-		_, err = w.dispatcher.ReserveJob(drone.ID.String())
-		if err != nil {
-			log.Printf("Failed to reserve job for drone %s: %v", drone.ID.String(), err)
-			return err
+	order, err := w.dispatcher.ReserveJob(ctx, event.DroneID)
+	if err != nil {
+		switch err {
+		case domain.ErrNoPendingOrders:
+			// The drone stays IDLE; the next order.created event will pick it.
+			log.Printf("No pending orders for drone %s", event.DroneID)
+			return nil
+		case domain.ErrDroneNotIdle, domain.ErrDroneBusy, domain.ErrNotFound:
+			// Stale event: the drone was assigned or changed state in the meantime
+			log.Printf("Ignoring stale DroneAvailable event for drone %s: %v", event.DroneID, err)
+			return nil
 		}
+		return err
+	}
 
-		log.Printf("Successfully assigned order %s to drone %s", event.OrderID, drone.ID.String())
-		return nil
-	})
+	log.Printf("Successfully assigned order %s to drone %s", order.ID, event.DroneID)
+	return nil
 }
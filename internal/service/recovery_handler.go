@@ -1,43 +1,108 @@
 package service
 
 import (
+	"context"
 	"log"
 	"time"
 
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/repository"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/telemetry"
+	"github.com/segmentio/ksuid"
 )
 
 type RecoveryHandler struct {
 	orderRepo repository.OrderRepository
+	publisher events.Publisher
 }
 
-func NewRecoveryHandler(orderRepo repository.OrderRepository) *RecoveryHandler {
-	return &RecoveryHandler{orderRepo: orderRepo}
+func NewRecoveryHandler(orderRepo repository.OrderRepository, publisher events.Publisher) *RecoveryHandler {
+	return &RecoveryHandler{orderRepo: orderRepo, publisher: publisher}
 }
 
-func (h *RecoveryHandler) OnDroneStatusChanged(droneID string, oldStatus, newStatus domain.DroneStatus, currentLat, currentLon float64) {
+func (h *RecoveryHandler) OnDroneStatusChanged(ctx context.Context, droneID string, oldStatus, newStatus domain.DroneStatus, currentLat, currentLon float64) {
 	// Broken or Offline Drone Recovery Logic
 	if (newStatus == domain.DroneStatusBroken || newStatus == domain.DroneStatusOffline) && oldStatus == domain.DroneStatusDelivering {
-		// 1. Find the active order
-		order, err := h.orderRepo.GetActiveOrderByDroneID(droneID)
-		if err == nil {
-			// 2. Update Order: Origin becomes current drone location
-			order.OriginLat = currentLat
-			order.OriginLon = currentLon
-
-			// 3. Reset Status to PENDING and unassign drone
-			order.Status = domain.OrderStatusPending
-			order.DroneID = nil
-			order.UpdatedAt = time.Now()
+		// 1. Find the active orders: a drone on a batched trip holds several
+		orders, err := h.orderRepo.GetActiveOrdersByDroneID(ctx, droneID)
+		if err != nil {
+			log.Printf("Failed to find orders of %s drone %s: %v", newStatus, droneID, err)
+			return
+		}
+		for _, order := range orders {
+			h.recoverOrder(ctx, order, droneID, newStatus, currentLat, currentLon)
+		}
+	}
+}
 
-			if err := h.orderRepo.UpdateOrder(order); err != nil {
-				log.Printf("Failed to recover order for %s drone %s: %v", newStatus, droneID, err)
-			} else {
-				log.Printf("Recovered order %s from %s drone %s", order.ID, newStatus, droneID)
+// recoverOrder takes one order off a drone that stopped at currentLat, currentLon
+func (h *RecoveryHandler) recoverOrder(ctx context.Context, order *domain.Order, droneID string, droneStatus domain.DroneStatus, currentLat, currentLon float64) {
+	var oldOrderStatus domain.OrderStatus
+	var brokenDroneID *ksuid.KSUID
+	reason := "drone " + string(droneStatus)
+	orderID := order.ID.String()
+
+	reread := false
+	err := retryOnConflict(domain.AnyVersion, func() error {
+		// Re-read on conflict: an admin may have finished the order meanwhile
+		if reread {
+			var err error
+			if order, err = h.orderRepo.GetOrderByID(ctx, orderID); err != nil {
+				return err
+			}
+			if order.DroneID == nil || order.DroneID.String() != droneID ||
+				(order.Status != domain.OrderStatusReserved && order.Status != domain.OrderStatusPickedUp) {
+				return domain.ErrNotFound
 			}
-		} else if err != domain.ErrNotFound {
-			log.Printf("Error finding active order for %s drone %s: %v", newStatus, droneID, err)
 		}
+		reread = true
+		oldOrderStatus = order.Status
+		brokenDroneID = order.DroneID
+
+		// 2. If the parcel was on board, it is now wherever the drone stopped.
+		// The original origin is kept; the next leg starts from the new pickup point.
+		if oldOrderStatus == domain.OrderStatusPickedUp {
+			order.PickupLat = currentLat
+			order.PickupLon = currentLon
+		}
+
+		// 3. A broken drone leaves the parcel on the ground for a recovery crew;
+		// otherwise reset to PENDING for another drone. Either way unassign the drone.
+		if oldOrderStatus == domain.OrderStatusPickedUp && droneStatus == domain.DroneStatusBroken {
+			order.Status = domain.OrderStatusAwaitingRecovery
+		} else {
+			order.Status = domain.OrderStatusPending
+		}
+		order.DroneID = nil
+		order.UpdatedAt = time.Now()
+		return h.orderRepo.UpdateOrder(ctx, order)
+	})
+	switch {
+	case err == domain.ErrNotFound:
+		return
+	case err != nil:
+		log.Printf("Failed to recover order %s from %s drone %s: %v", orderID, droneStatus, droneID, err)
+		return
+	}
+	ctx = afterCommit(ctx)
+	log.Printf("Recovered order %s from %s drone %s, now %s", order.ID, droneStatus, droneID, order.Status)
+
+	// 4. Close the interrupted leg where the drone stopped
+	closeActiveOrderLeg(ctx, h.orderRepo, orderID, domain.LegOutcomeInterrupted, &currentLat, &currentLon)
+
+	if order.Status == domain.OrderStatusAwaitingRecovery {
+		reason += ", parcel needs recovery crew"
+	}
+	event := newOrderEvent(order, oldOrderStatus, ActorRecovery, reason)
+	event.DroneID = brokenDroneID
+	event.Latitude, event.Longitude = &currentLat, &currentLon
+	recordOrderEvent(ctx, h.orderRepo, event)
+	telemetry.Metrics.OrderRecovered(ctx, string(droneStatus), string(order.Status))
+	telemetry.Metrics.OrderTransitioned(ctx, string(order.Status))
+
+	// 5. Back in the queue: announce it like a new order so an idle drone picks it up
+	if order.Status == domain.OrderStatusPending {
+		publishOrderCreated(ctx, h.publisher, order)
 	}
 }
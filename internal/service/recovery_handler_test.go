@@ -1,67 +1,142 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/infrastructure/membus"
 	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRecoveryHandler_OnDroneStatusChanged_Success(t *testing.T) {
 	mockOrderRepo := new(MockOrderRepository)
-	handler := NewRecoveryHandler(mockOrderRepo)
+	bus := membus.New()
+	handler := NewRecoveryHandler(mockOrderRepo, bus)
+
+	published := make(chan events.Message, 1)
+	require.NoError(t, bus.Subscribe("dispatch", "order.created", func(ctx context.Context, msg events.Message) error {
+		published <- msg
+		return nil
+	}))
 
 	droneID := ksuid.New()
 	orderID := ksuid.New()
 	lat, lon := 50.0, 10.0
 
 	existingOrder := &domain.Order{
-		ID:      orderID,
-		Status:  domain.OrderStatusPickedUp,
+		ID:        orderID,
+		Status:    domain.OrderStatusPickedUp,
+		OriginLat: 1.0, OriginLon: 2.0,
+		PickupLat: 1.0, PickupLon: 2.0,
 		DroneID: &droneID,
 	}
+	activeLeg := &domain.OrderLeg{ID: ksuid.New(), OrderID: orderID, DroneID: droneID, Outcome: domain.LegOutcomeInProgress}
+
+	// Expect searching for active orders
+	mockOrderRepo.On("GetActiveOrdersByDroneID", droneID.String()).Return([]*domain.Order{existingOrder}, nil)
 
-	// Expect searching for active order
-	mockOrderRepo.On("GetActiveOrderByDroneID", droneID.String()).Return(existingOrder, nil)
+	// Expect the interrupted leg to end where the drone stopped
+	mockOrderRepo.On("GetOrderLegs", orderID.String()).Return([]*domain.OrderLeg{activeLeg}, nil)
+	mockOrderRepo.On("UpdateOrderLeg", mock.MatchedBy(func(l *domain.OrderLeg) bool {
+		return l.ID == activeLeg.ID && l.Outcome == domain.LegOutcomeInterrupted && l.ToLat == lat && l.ToLon == lon
+	})).Return(nil)
 
-	// Expect updating order
+	// Expect updating order: pickup moves to the drone, original origin is kept
 	mockOrderRepo.On("UpdateOrder", mock.MatchedBy(func(o *domain.Order) bool {
 		return o.ID == orderID &&
 			o.Status == domain.OrderStatusPending &&
 			o.DroneID == nil &&
-			o.OriginLat == lat && o.OriginLon == lon
+			o.PickupLat == lat && o.PickupLon == lon &&
+			o.OriginLat == 1.0 && o.OriginLon == 2.0
+	})).Return(nil)
+
+	// Expect audit trail entry naming the lost drone and where it stopped
+	mockOrderRepo.On("AppendOrderEvent", mock.MatchedBy(func(e *domain.OrderEvent) bool {
+		return e.FromStatus == domain.OrderStatusPickedUp && e.ToStatus == domain.OrderStatusPending &&
+			e.Actor == ActorRecovery && *e.DroneID == droneID && *e.Latitude == lat && *e.Longitude == lon
+	})).Return(nil)
+
+	handler.OnDroneStatusChanged(context.Background(), droneID.String(), domain.DroneStatusDelivering, domain.DroneStatusOffline, lat, lon)
+
+	mockOrderRepo.AssertExpectations(t)
+	// Back in the queue, the order is offered to idle drones right away
+	select {
+	case msg := <-published:
+		assert.Contains(t, string(msg.Body), orderID.String())
+	case <-time.After(2 * time.Second):
+		t.Fatal("order.created was not published")
+	}
+}
+
+func TestRecoveryHandler_OnDroneStatusChanged_BrokenWithParcel_AwaitsRecoveryCrew(t *testing.T) {
+	mockOrderRepo := new(MockOrderRepository)
+	handler := NewRecoveryHandler(mockOrderRepo, membus.New())
+
+	droneID := ksuid.New()
+	existingOrder := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPickedUp, DroneID: &droneID}
+
+	mockOrderRepo.On("GetActiveOrdersByDroneID", droneID.String()).Return([]*domain.Order{existingOrder}, nil)
+	mockOrderRepo.On("GetOrderLegs", existingOrder.ID.String()).Return(nil, nil)
+	mockOrderRepo.On("UpdateOrder", mock.MatchedBy(func(o *domain.Order) bool {
+		return o.Status == domain.OrderStatusAwaitingRecovery && o.DroneID == nil &&
+			o.PickupLat == 50.0 && o.PickupLon == 10.0
 	})).Return(nil)
+	mockOrderRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
 
-	handler.OnDroneStatusChanged(droneID.String(), domain.DroneStatusDelivering, domain.DroneStatusBroken, lat, lon)
+	handler.OnDroneStatusChanged(context.Background(), droneID.String(), domain.DroneStatusDelivering, domain.DroneStatusBroken, 50.0, 10.0)
+
+	mockOrderRepo.AssertExpectations(t)
+}
+
+func TestRecoveryHandler_OnDroneStatusChanged_ReservedKeepsPickup(t *testing.T) {
+	mockOrderRepo := new(MockOrderRepository)
+	handler := NewRecoveryHandler(mockOrderRepo, membus.New())
+
+	droneID := ksuid.New()
+	existingOrder := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusReserved, PickupLat: 1.0, PickupLon: 2.0, DroneID: &droneID}
+
+	mockOrderRepo.On("GetActiveOrdersByDroneID", droneID.String()).Return([]*domain.Order{existingOrder}, nil)
+	mockOrderRepo.On("GetOrderLegs", existingOrder.ID.String()).Return(nil, nil)
+	// Parcel never left the pickup point
+	mockOrderRepo.On("UpdateOrder", mock.MatchedBy(func(o *domain.Order) bool {
+		return o.Status == domain.OrderStatusPending && o.PickupLat == 1.0 && o.PickupLon == 2.0
+	})).Return(nil)
+	mockOrderRepo.On("AppendOrderEvent", mock.Anything).Return(nil)
+
+	handler.OnDroneStatusChanged(context.Background(), droneID.String(), domain.DroneStatusDelivering, domain.DroneStatusBroken, 50.0, 10.0)
 
 	mockOrderRepo.AssertExpectations(t)
 }
 
 func TestRecoveryHandler_OnDroneStatusChanged_Ignored(t *testing.T) {
 	mockOrderRepo := new(MockOrderRepository)
-	handler := NewRecoveryHandler(mockOrderRepo)
+	handler := NewRecoveryHandler(mockOrderRepo, membus.New())
 
 	// Not BROKEN
-	handler.OnDroneStatusChanged("id", domain.DroneStatusIdle, domain.DroneStatusDelivering, 0, 0)
+	handler.OnDroneStatusChanged(context.Background(), "id", domain.DroneStatusIdle, domain.DroneStatusDelivering, 0, 0)
 
 	// BROKEN but not from Delivering (e.g. from IDLE)
-	handler.OnDroneStatusChanged("id", domain.DroneStatusIdle, domain.DroneStatusBroken, 0, 0)
+	handler.OnDroneStatusChanged(context.Background(), "id", domain.DroneStatusIdle, domain.DroneStatusBroken, 0, 0)
 
-	mockOrderRepo.AssertNotCalled(t, "GetActiveOrderByDroneID")
+	mockOrderRepo.AssertNotCalled(t, "GetActiveOrdersByDroneID")
 }
 
 func TestRecoveryHandler_OnDroneStatusChanged_NoActiveOrder(t *testing.T) {
 	mockOrderRepo := new(MockOrderRepository)
-	handler := NewRecoveryHandler(mockOrderRepo)
+	handler := NewRecoveryHandler(mockOrderRepo, membus.New())
 
 	droneID := ksuid.New().String()
 
-	// Return NotFound
-	mockOrderRepo.On("GetActiveOrderByDroneID", droneID).Return(nil, domain.ErrNotFound)
+	mockOrderRepo.On("GetActiveOrdersByDroneID", droneID).Return(nil, nil)
 
-	handler.OnDroneStatusChanged(droneID, domain.DroneStatusDelivering, domain.DroneStatusBroken, 0, 0)
+	handler.OnDroneStatusChanged(context.Background(), droneID, domain.DroneStatusDelivering, domain.DroneStatusBroken, 0, 0)
 
 	mockOrderRepo.AssertExpectations(t)
 	mockOrderRepo.AssertNotCalled(t, "UpdateOrder")
@@ -69,15 +144,39 @@ func TestRecoveryHandler_OnDroneStatusChanged_NoActiveOrder(t *testing.T) {
 
 func TestRecoveryHandler_OnDroneStatusChanged_RepoError(t *testing.T) {
 	mockOrderRepo := new(MockOrderRepository)
-	handler := NewRecoveryHandler(mockOrderRepo)
+	handler := NewRecoveryHandler(mockOrderRepo, membus.New())
 
 	droneID := ksuid.New().String()
 
 	// Return Generic Error
-	mockOrderRepo.On("GetActiveOrderByDroneID", droneID).Return(nil, errors.New("db error"))
+	mockOrderRepo.On("GetActiveOrdersByDroneID", droneID).Return(nil, errors.New("db error"))
 
-	handler.OnDroneStatusChanged(droneID, domain.DroneStatusDelivering, domain.DroneStatusBroken, 0, 0)
+	handler.OnDroneStatusChanged(context.Background(), droneID, domain.DroneStatusDelivering, domain.DroneStatusBroken, 0, 0)
 
 	mockOrderRepo.AssertExpectations(t)
 	mockOrderRepo.AssertNotCalled(t, "UpdateOrder")
 }
+
+func TestRecoveryHandler_OnDroneStatusChanged_RecoversWholeBatch(t *testing.T) {
+	mockOrderRepo := new(MockOrderRepository)
+	handler := NewRecoveryHandler(mockOrderRepo, membus.New())
+
+	droneID := ksuid.New()
+	onBoard := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPickedUp, PickupLat: 1.0, PickupLon: 2.0, DroneID: &droneID}
+	waiting := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusReserved, PickupLat: 3.0, PickupLon: 4.0, DroneID: &droneID}
+
+	mockOrderRepo.On("GetActiveOrdersByDroneID", droneID.String()).Return([]*domain.Order{onBoard, waiting}, nil)
+	mockOrderRepo.On("GetOrderLegs", mock.Anything).Return(nil, nil)
+	// The parcel on board is left where the drone broke down, the other still waits at its pickup
+	mockOrderRepo.On("UpdateOrder", mock.MatchedBy(func(o *domain.Order) bool {
+		return o.ID == onBoard.ID && o.Status == domain.OrderStatusAwaitingRecovery && o.PickupLat == 50.0 && o.DroneID == nil
+	})).Return(nil).Once()
+	mockOrderRepo.On("UpdateOrder", mock.MatchedBy(func(o *domain.Order) bool {
+		return o.ID == waiting.ID && o.Status == domain.OrderStatusPending && o.PickupLat == 3.0 && o.DroneID == nil
+	})).Return(nil).Once()
+	mockOrderRepo.On("AppendOrderEvent", mock.Anything).Return(nil).Twice()
+
+	handler.OnDroneStatusChanged(context.Background(), droneID.String(), domain.DroneStatusDelivering, domain.DroneStatusBroken, 50.0, 10.0)
+
+	mockOrderRepo.AssertExpectations(t)
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"math"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+)
+
+// planRoute sequences the stops a drone at lat,lon still has to make for orders: a
+// pickup for each order not yet on board, then its drop-off. Nearest neighbour builds
+// the route, then 2-opt reverses stretches of it while that shortens the flight and
+// every pickup still comes before its drop-off.
+func planRoute(lat, lon float64, orders []*domain.Order) []domain.RouteStop {
+	var open []domain.RouteStop // Stops that can be flown to next
+	dropoffs := map[string]domain.RouteStop{}
+	for _, order := range orders {
+		dropoff := domain.RouteStop{OrderID: order.ID, Kind: domain.RouteStopDropoff, Lat: order.DestLat, Lon: order.DestLon}
+		if order.Status == domain.OrderStatusPickedUp {
+			open = append(open, dropoff)
+			continue
+		}
+		open = append(open, domain.RouteStop{OrderID: order.ID, Kind: domain.RouteStopPickup, Lat: order.PickupLat, Lon: order.PickupLon})
+		dropoffs[order.ID.String()] = dropoff
+	}
+
+	stops := make([]domain.RouteStop, 0, len(orders)*2)
+	atLat, atLon := lat, lon
+	for len(open) > 0 {
+		nearest := 0
+		for i := range open {
+			if domain.DistanceMeters(atLat, atLon, open[i].Lat, open[i].Lon) < domain.DistanceMeters(atLat, atLon, open[nearest].Lat, open[nearest].Lon) {
+				nearest = i
+			}
+		}
+		stop := open[nearest]
+		open = append(open[:nearest], open[nearest+1:]...)
+		stops = append(stops, stop)
+		if stop.Kind == domain.RouteStopPickup {
+			open = append(open, dropoffs[stop.OrderID.String()])
+		}
+		atLat, atLon = stop.Lat, stop.Lon
+	}
+
+	improveRoute(lat, lon, stops)
+	return stops
+}
+
+// improveRoute applies 2-opt moves to stops in place until none shortens the route
+func improveRoute(lat, lon float64, stops []domain.RouteStop) {
+	for improved := true; improved; {
+		improved = false
+		for i := 0; i < len(stops)-1; i++ {
+			for j := i + 1; j < len(stops); j++ {
+				before := routeLength(lat, lon, stops)
+				reverse(stops[i : j+1])
+				if precedes(stops) && routeLength(lat, lon, stops) < before-1e-6 {
+					improved = true
+					continue
+				}
+				reverse(stops[i : j+1])
+			}
+		}
+	}
+}
+
+// precedes reports whether every pickup on the route comes before its drop-off
+func precedes(stops []domain.RouteStop) bool {
+	delivered := map[string]bool{}
+	for _, stop := range stops {
+		id := stop.OrderID.String()
+		if stop.Kind == domain.RouteStopDropoff {
+			delivered[id] = true
+		} else if delivered[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func reverse(stops []domain.RouteStop) {
+	for i, j := 0, len(stops)-1; i < j; i, j = i+1, j-1 {
+		stops[i], stops[j] = stops[j], stops[i]
+	}
+}
+
+// routeLength is the flight in metres from lat,lon through every stop
+func routeLength(lat, lon float64, stops []domain.RouteStop) float64 {
+	var length float64
+	for _, stop := range stops {
+		length += domain.DistanceMeters(lat, lon, stop.Lat, stop.Lon)
+		lat, lon = stop.Lat, stop.Lon
+	}
+	return length
+}
+
+// estimateRoute fills in the distance flown to reach each stop and, given a cruise
+// speed in m/s, when the drone gets there
+func estimateRoute(lat, lon float64, stops []domain.RouteStop, speed float64, now time.Time) float64 {
+	var flown float64
+	for i := range stops {
+		flown += domain.DistanceMeters(lat, lon, stops[i].Lat, stops[i].Lon)
+		lat, lon = stops[i].Lat, stops[i].Lon
+		stops[i].Distance = math.Round(flown)
+		if speed > 0 {
+			eta := now.Add(time.Duration(flown / speed * float64(time.Second))).Truncate(time.Second)
+			stops[i].ETA = &eta
+		}
+	}
+	return math.Round(flown)
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanRoute_PicksUpBeforeDropOff(t *testing.T) {
+	// Drop-offs lie closer to the drone than pickups, tempting a greedy route
+	orders := []*domain.Order{
+		{ID: ksuid.New(), Status: domain.OrderStatusReserved, PickupLat: 30.03, PickupLon: 31.0, DestLat: 30.001, DestLon: 31.0},
+		{ID: ksuid.New(), Status: domain.OrderStatusReserved, PickupLat: 30.02, PickupLon: 31.0, DestLat: 30.002, DestLon: 31.0},
+		{ID: ksuid.New(), Status: domain.OrderStatusPickedUp, DestLat: 30.04, DestLon: 31.0},
+	}
+
+	stops := planRoute(30.0, 31.0, orders)
+
+	assert.Len(t, stops, 5)
+	assert.True(t, precedes(stops))
+	for _, stop := range stops {
+		if stop.OrderID == orders[2].ID {
+			assert.Equal(t, domain.RouteStopDropoff, stop.Kind) // Already on board
+		}
+	}
+}
+
+func TestPlanRoute_TwoOptNeverLengthens(t *testing.T) {
+	orders := []*domain.Order{
+		{ID: ksuid.New(), Status: domain.OrderStatusReserved, PickupLat: 30.01, PickupLon: 31.0, DestLat: 30.01, DestLon: 31.05},
+		{ID: ksuid.New(), Status: domain.OrderStatusReserved, PickupLat: 30.0, PickupLon: 31.02, DestLat: 30.04, DestLon: 31.0},
+		{ID: ksuid.New(), Status: domain.OrderStatusReserved, PickupLat: 30.03, PickupLon: 31.03, DestLat: 29.99, DestLon: 31.01},
+	}
+
+	stops := planRoute(30.0, 31.0, orders)
+	planned := routeLength(30.0, 31.0, stops)
+
+	// Collecting every parcel first, then dropping them off, is a valid but poor route
+	naive := []domain.RouteStop{}
+	for _, o := range orders {
+		naive = append(naive, domain.RouteStop{OrderID: o.ID, Kind: domain.RouteStopPickup, Lat: o.PickupLat, Lon: o.PickupLon})
+	}
+	for _, o := range orders {
+		naive = append(naive, domain.RouteStop{OrderID: o.ID, Kind: domain.RouteStopDropoff, Lat: o.DestLat, Lon: o.DestLon})
+	}
+	naiveLength := routeLength(30.0, 31.0, naive)
+	assert.True(t, precedes(stops))
+	assert.LessOrEqual(t, planned, naiveLength)
+
+	// 2-opt alone keeps precedence and only ever shortens
+	improveRoute(30.0, 31.0, naive)
+	assert.True(t, precedes(naive))
+	assert.LessOrEqual(t, routeLength(30.0, 31.0, naive), naiveLength)
+}
+
+func TestEstimateRoute_AccumulatesDistanceAndETA(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	stops := []domain.RouteStop{{Lat: 30.01, Lon: 31.0}, {Lat: 30.02, Lon: 31.0}}
+
+	total := estimateRoute(30.0, 31.0, stops, 10, now)
+
+	assert.InDelta(t, 2224, total, 5)
+	assert.Equal(t, total, stops[1].Distance)
+	assert.InDelta(t, stops[0].Distance*2, stops[1].Distance, 2)
+	if assert.NotNil(t, stops[1].ETA) {
+		assert.WithinDuration(t, now.Add(222*time.Second), *stops[1].ETA, 2*time.Second)
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+)
+
+// SLAPolicy sets the delivery deadline of new orders and their place in the dispatch queue
+type SLAPolicy struct {
+	// Promise is how long after creation each priority class is delivered by default
+	Promise map[domain.OrderPriority]time.Duration
+	// MaxWait bounds how long any order queues behind orders with less slack, so a
+	// steady stream of urgent orders cannot starve standard ones
+	MaxWait time.Duration
+	// CruiseSpeed (m/s) estimates the flight from pickup to destination
+	CruiseSpeed float64
+}
+
+// schedule sets the priority, delivery window and queue position of a new order. A
+// requested deadline may be later than the class promise, never earlier. When the
+// window opens later than the order could be flown there, the order is SCHEDULED.
+func (p SLAPolicy) schedule(order *domain.Order, req OrderRequest) error {
+	order.Priority = req.Priority
+	if order.Priority == "" {
+		order.Priority = domain.OrderPriorityStandard
+	}
+	promise := p.Promise[order.Priority]
+
+	// The promise runs from when the window opens; a window already open is no window
+	opens := order.CreatedAt
+	if req.DeliverAfter != nil && req.DeliverAfter.After(opens) {
+		opens = *req.DeliverAfter
+		order.DeliverAfter = req.DeliverAfter
+	}
+	order.DeliverBy = opens.Add(promise)
+	if req.DeliverBy != nil {
+		if req.DeliverBy.Before(order.CreatedAt.Add(promise)) {
+			return domain.ErrDeadlineTooEarly
+		}
+		if !req.DeliverBy.After(opens) {
+			return domain.ErrInvalidWindow
+		}
+		order.DeliverBy = *req.DeliverBy
+	}
+
+	if order.DeliverAfter != nil {
+		if departure := order.DeliverAfter.Add(-p.flightTime(order)); departure.After(order.CreatedAt) {
+			order.Status = domain.OrderStatusScheduled
+			order.DispatchAfter = &departure
+		}
+	}
+	order.DispatchBy = p.dispatchBy(order)
+	return nil
+}
+
+// reroute recomputes the dispatch times of an order whose pickup or destination moved:
+// both depend on the flight between them. A SCHEDULED order whose new departure has
+// already passed is released by the scheduler's next run.
+func (p SLAPolicy) reroute(order *domain.Order) {
+	if order.DispatchAfter != nil && order.DeliverAfter != nil {
+		departure := order.DeliverAfter.Add(-p.flightTime(order))
+		if departure.Before(order.CreatedAt) {
+			departure = order.CreatedAt
+		}
+		order.DispatchAfter = &departure
+	}
+	order.DispatchBy = p.dispatchBy(order)
+}
+
+// dispatchBy is the latest time the order can leave its pickup and still arrive by
+// DeliverBy, but no later than MaxWait after it could first be dispatched. Ordering
+// the queue by it serves the order with the least slack first.
+func (p SLAPolicy) dispatchBy(order *domain.Order) time.Time {
+	latest := order.DeliverBy.Add(-p.flightTime(order))
+	if p.MaxWait > 0 {
+		queued := order.CreatedAt
+		if order.DispatchAfter != nil {
+			queued = *order.DispatchAfter
+		}
+		if limit := queued.Add(p.MaxWait); limit.Before(latest) {
+			latest = limit
+		}
+	}
+	return latest
+}
+
+// flightTime estimates the flight from pickup to destination at cruise speed
+func (p SLAPolicy) flightTime(order *domain.Order) time.Duration {
+	if p.CruiseSpeed <= 0 {
+		return 0
+	}
+	meters := domain.DistanceMeters(order.PickupLat, order.PickupLon, order.DestLat, order.DestLon)
+	return time.Duration(meters / p.CruiseSpeed * float64(time.Second))
+}
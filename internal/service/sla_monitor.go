@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/lifecycle"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/repository"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/telemetry"
+	"go.opentelemetry.io/otel"
+)
+
+type SLAMonitor struct {
+	// Periodic runs checkOrders every interval and serves the health check
+	*lifecycle.Periodic
+
+	orderRepo repository.OrderRepository
+	publisher events.Publisher
+	margin    time.Duration
+}
+
+// NewSLAMonitor flags, every interval, the undelivered orders due within margin as at
+// risk of missing their deadline
+func NewSLAMonitor(repo repository.OrderRepository, publisher events.Publisher, interval, margin time.Duration) *SLAMonitor {
+	m := &SLAMonitor{
+		orderRepo: repo,
+		publisher: publisher,
+		margin:    margin,
+	}
+	m.Periodic = lifecycle.NewPeriodic("SLA Monitor", interval, m.checkOrders)
+	return m
+}
+
+func (m *SLAMonitor) checkOrders(ctx context.Context) {
+	ctx, span := otel.Tracer("sla-monitor").Start(ctx, "SLAMonitor.checkOrders")
+	defer span.End()
+
+	orders, err := m.orderRepo.FlagOrdersAtRisk(ctx, time.Now().Add(m.margin))
+	if err != nil {
+		log.Printf("SLAMonitor: failed to flag orders at risk: %v", err)
+		return
+	}
+
+	for _, order := range orders {
+		log.Printf("SLAMonitor: %s order %s is %s and due at %s", order.Priority, order.ID, order.Status,
+			order.DeliverBy.Format(time.RFC3339))
+		telemetry.Metrics.SLAAtRisk(ctx, string(order.Priority))
+
+		event := domain.OrderSLAAtRiskEvent{
+			OrderID:   order.ID.String(),
+			Status:    order.Status,
+			Priority:  order.Priority,
+			DeliverBy: order.DeliverBy,
+			Timestamp: time.Now(),
+		}
+		if err := m.publisher.Publish(ctx, "order.sla_at_risk", event); err != nil {
+			log.Printf("SLAMonitor: failed to publish SLA risk of order %s: %v", order.ID, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/events"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/infrastructure/membus"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var testSLA = SLAPolicy{
+	Promise: map[domain.OrderPriority]time.Duration{
+		domain.OrderPriorityMedical:  time.Hour,
+		domain.OrderPriorityExpress:  3 * time.Hour,
+		domain.OrderPriorityStandard: 24 * time.Hour,
+	},
+	MaxWait:     2 * time.Hour,
+	CruiseSpeed: 10,
+}
+
+func TestSLAPolicy_Schedule(t *testing.T) {
+	created := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	// About 1.1 km from pickup to destination: 111 s of flight at 10 m/s
+	newOrder := func() *domain.Order {
+		return &domain.Order{PickupLat: 30, PickupLon: 31, DestLat: 30.01, DestLon: 31, CreatedAt: created}
+	}
+
+	medical := newOrder()
+	require.NoError(t, testSLA.schedule(medical, OrderRequest{Priority: domain.OrderPriorityMedical}))
+	assert.Equal(t, created.Add(time.Hour), medical.DeliverBy)
+	assert.WithinDuration(t, created.Add(time.Hour-111*time.Second), medical.DispatchBy, time.Second)
+
+	// Standard orders queue no longer than MaxWait
+	standard := newOrder()
+	require.NoError(t, testSLA.schedule(standard, OrderRequest{}))
+	assert.Equal(t, domain.OrderPriorityStandard, standard.Priority)
+	assert.Equal(t, created.Add(24*time.Hour), standard.DeliverBy)
+	assert.Equal(t, created.Add(2*time.Hour), standard.DispatchBy)
+
+	// A client may allow more time than the class promises, never less
+	later := created.Add(48 * time.Hour)
+	relaxed := newOrder()
+	require.NoError(t, testSLA.schedule(relaxed, OrderRequest{Priority: domain.OrderPriorityExpress, DeliverBy: &later}))
+	assert.Equal(t, later, relaxed.DeliverBy)
+	sooner := created.Add(30 * time.Minute)
+	assert.Equal(t, domain.ErrDeadlineTooEarly, testSLA.schedule(newOrder(), OrderRequest{Priority: domain.OrderPriorityExpress, DeliverBy: &sooner}))
+}
+
+func TestSLAPolicy_ScheduleWindow(t *testing.T) {
+	created := time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+	newOrder := func() *domain.Order {
+		return &domain.Order{Status: domain.OrderStatusPending, PickupLat: 30, PickupLon: 31, DestLat: 30.01, DestLon: 31, CreatedAt: created}
+	}
+	opens := time.Date(2026, 1, 1, 14, 0, 0, 0, time.UTC)
+	closes := opens.Add(30 * time.Minute)
+
+	// Timed to a shift change: held back until it can leave and arrive as the window opens
+	shift := newOrder()
+	require.NoError(t, testSLA.schedule(shift, OrderRequest{Priority: domain.OrderPriorityMedical, DeliverAfter: &opens, DeliverBy: &closes}))
+	assert.Equal(t, domain.OrderStatusScheduled, shift.Status)
+	assert.Equal(t, closes, shift.DeliverBy)
+	require.NotNil(t, shift.DispatchAfter)
+	assert.WithinDuration(t, opens.Add(-111*time.Second), *shift.DispatchAfter, time.Second)
+	assert.WithinDuration(t, closes.Add(-111*time.Second), shift.DispatchBy, time.Second)
+
+	// Without a deadline, the class promise runs from the opening of the window
+	open := newOrder()
+	require.NoError(t, testSLA.schedule(open, OrderRequest{Priority: domain.OrderPriorityExpress, DeliverAfter: &opens}))
+	assert.Equal(t, opens.Add(3*time.Hour), open.DeliverBy)
+
+	// A window that opens before the parcel could get there is no reason to wait
+	soon := created.Add(time.Minute)
+	now := newOrder()
+	require.NoError(t, testSLA.schedule(now, OrderRequest{DeliverAfter: &soon}))
+	assert.Equal(t, domain.OrderStatusPending, now.Status)
+	assert.Nil(t, now.DispatchAfter)
+
+	assert.Equal(t, domain.ErrInvalidWindow, testSLA.schedule(newOrder(), OrderRequest{Priority: domain.OrderPriorityMedical, DeliverAfter: &closes, DeliverBy: &opens}))
+}
+
+func TestSLAPolicy_StandardOrdersDoNotStarve(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	standard := &domain.Order{CreatedAt: start}
+	require.NoError(t, testSLA.schedule(standard, OrderRequest{Priority: domain.OrderPriorityStandard}))
+
+	// Medical orders placed early on jump the queue...
+	early := &domain.Order{CreatedAt: start.Add(10 * time.Minute)}
+	require.NoError(t, testSLA.schedule(early, OrderRequest{Priority: domain.OrderPriorityMedical}))
+	assert.True(t, early.DispatchBy.Before(standard.DispatchBy))
+
+	// ...but once the standard order waited long enough, it goes first
+	late := &domain.Order{CreatedAt: start.Add(90 * time.Minute)}
+	require.NoError(t, testSLA.schedule(late, OrderRequest{Priority: domain.OrderPriorityMedical}))
+	assert.True(t, standard.DispatchBy.Before(late.DispatchBy))
+}
+
+func TestSLAMonitor_PublishesOrdersAtRisk(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	bus := membus.New()
+	monitor := NewSLAMonitor(mockRepo, bus, time.Minute, 15*time.Minute)
+
+	published := make(chan events.Message, 1)
+	require.NoError(t, bus.Subscribe("at-risk", "order.sla_at_risk", func(ctx context.Context, msg events.Message) error {
+		published <- msg
+		return nil
+	}))
+
+	order := &domain.Order{ID: ksuid.New(), Status: domain.OrderStatusPending, Priority: domain.OrderPriorityMedical,
+		DeliverBy: time.Now().Add(10 * time.Minute)}
+	mockRepo.On("FlagOrdersAtRisk", mock.MatchedBy(func(deadline time.Time) bool {
+		return time.Until(deadline) > 14*time.Minute && time.Until(deadline) <= 15*time.Minute
+	})).Return([]*domain.Order{order}, nil)
+
+	monitor.checkOrders(context.Background())
+
+	mockRepo.AssertExpectations(t)
+	select {
+	case msg := <-published:
+		assert.Contains(t, string(msg.Body), order.ID.String())
+		assert.Contains(t, string(msg.Body), `"priority":"MEDICAL"`)
+	case <-time.After(2 * time.Second):
+		t.Fatal("order.sla_at_risk was not published")
+	}
+}
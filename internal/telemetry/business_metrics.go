@@ -0,0 +1,222 @@
+package telemetry
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/lifecycle"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// BusinessMetrics are the fleet and delivery instruments exported on /metrics.
+// They are created on the global meter, which forwards to the provider set by InitMeter,
+// so they can be recorded from anywhere without being threaded through constructors.
+type BusinessMetrics struct {
+	ordersCreated    metric.Int64Counter
+	orderTransitions metric.Int64Counter
+	dispatchLatency  metric.Float64Histogram
+	deliveryDuration metric.Float64Histogram
+	heartbeatMisses  metric.Int64Counter
+	recoveryEvents   metric.Int64Counter
+	locationStreams  metric.Int64UpDownCounter
+	locationUpdates  metric.Int64Counter
+	slaAtRisk        metric.Int64Counter
+	slaBreaches      metric.Int64Counter
+}
+
+// Metrics is the process-wide set of business instruments
+var Metrics = newBusinessMetrics()
+
+// Delivery timings range from seconds (dispatch) to hours (delivery)
+var durationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}
+
+func newBusinessMetrics() *BusinessMetrics {
+	meter := otel.Meter("drone-backend")
+	m := &BusinessMetrics{}
+	var err error
+
+	if m.ordersCreated, err = meter.Int64Counter("drone_delivery.orders.created",
+		metric.WithDescription("Orders created")); err != nil {
+		log.Printf("failed to create metric: %v", err)
+	}
+	if m.orderTransitions, err = meter.Int64Counter("drone_delivery.orders.transitions",
+		metric.WithDescription("Order status transitions, by new status")); err != nil {
+		log.Printf("failed to create metric: %v", err)
+	}
+	if m.dispatchLatency, err = meter.Float64Histogram("drone_delivery.dispatch.latency",
+		metric.WithDescription("Time from order creation to reservation by a drone"),
+		metric.WithUnit("s"), metric.WithExplicitBucketBoundaries(durationBuckets...)); err != nil {
+		log.Printf("failed to create metric: %v", err)
+	}
+	if m.deliveryDuration, err = meter.Float64Histogram("drone_delivery.delivery.duration",
+		metric.WithDescription("Time from order creation to delivery"),
+		metric.WithUnit("s"), metric.WithExplicitBucketBoundaries(durationBuckets...)); err != nil {
+		log.Printf("failed to create metric: %v", err)
+	}
+	if m.heartbeatMisses, err = meter.Int64Counter("drone_delivery.heartbeat.misses",
+		metric.WithDescription("Drones marked OFFLINE after missing their heartbeat")); err != nil {
+		log.Printf("failed to create metric: %v", err)
+	}
+	if m.recoveryEvents, err = meter.Int64Counter("drone_delivery.recovery.events",
+		metric.WithDescription("Orders recovered from a broken or offline drone")); err != nil {
+		log.Printf("failed to create metric: %v", err)
+	}
+	if m.locationStreams, err = meter.Int64UpDownCounter("drone_delivery.grpc.location_streams",
+		metric.WithDescription("Open ReportLocation streams")); err != nil {
+		log.Printf("failed to create metric: %v", err)
+	}
+	if m.locationUpdates, err = meter.Int64Counter("drone_delivery.location.updates",
+		metric.WithDescription("Drone location reports, by transport")); err != nil {
+		log.Printf("failed to create metric: %v", err)
+	}
+	if m.slaAtRisk, err = meter.Int64Counter("drone_delivery.sla.at_risk",
+		metric.WithDescription("Orders flagged as close to their delivery deadline, by priority")); err != nil {
+		log.Printf("failed to create metric: %v", err)
+	}
+	if m.slaBreaches, err = meter.Int64Counter("drone_delivery.sla.breaches",
+		metric.WithDescription("Orders delivered after their deadline, by priority")); err != nil {
+		log.Printf("failed to create metric: %v", err)
+	}
+	return m
+}
+
+// OrderCreated counts a new order and its transition into status, the status it was
+// created in: PENDING, or SCHEDULED for a later delivery window
+func (m *BusinessMetrics) OrderCreated(ctx context.Context, status string) {
+	m.ordersCreated.Add(ctx, 1)
+	m.OrderTransitioned(ctx, status)
+}
+
+func (m *BusinessMetrics) OrderTransitioned(ctx context.Context, status string) {
+	m.orderTransitions.Add(ctx, 1, metric.WithAttributes(attribute.String("status", status)))
+}
+
+func (m *BusinessMetrics) OrderDispatched(ctx context.Context, createdAt time.Time) {
+	m.dispatchLatency.Record(ctx, time.Since(createdAt).Seconds())
+}
+
+func (m *BusinessMetrics) OrderDelivered(ctx context.Context, createdAt time.Time) {
+	m.deliveryDuration.Record(ctx, time.Since(createdAt).Seconds())
+}
+
+func (m *BusinessMetrics) HeartbeatMissed(ctx context.Context) {
+	m.heartbeatMisses.Add(ctx, 1)
+}
+
+func (m *BusinessMetrics) OrderRecovered(ctx context.Context, droneStatus, orderStatus string) {
+	m.recoveryEvents.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("drone_status", droneStatus),
+		attribute.String("order_status", orderStatus),
+	))
+}
+
+// LocationStreamOpened tracks an open stream; call the returned func when it closes
+func (m *BusinessMetrics) LocationStreamOpened(ctx context.Context) func() {
+	m.locationStreams.Add(ctx, 1)
+	return func() { m.locationStreams.Add(context.Background(), -1) }
+}
+
+func (m *BusinessMetrics) LocationUpdated(ctx context.Context, transport string) {
+	m.locationUpdates.Add(ctx, 1, metric.WithAttributes(attribute.String("transport", transport)))
+}
+
+func (m *BusinessMetrics) SLAAtRisk(ctx context.Context, priority string) {
+	m.slaAtRisk.Add(ctx, 1, metric.WithAttributes(attribute.String("priority", priority)))
+}
+
+func (m *BusinessMetrics) SLABreached(ctx context.Context, priority string) {
+	m.slaBreaches.Add(ctx, 1, metric.WithAttributes(attribute.String("priority", priority)))
+}
+
+// FleetStats reports how many orders and drones are currently in each status
+type FleetStats interface {
+	CountOrdersByStatus(ctx context.Context) (map[domain.OrderStatus]int64, error)
+	CountDronesByStatus(ctx context.Context) (map[domain.DroneStatus]int64, error)
+}
+
+// FleetGauges export the current orders and drones per status (the PENDING orders
+// being the dispatch queue depth). Counting groups the whole tables, so the counts are
+// refreshed every interval in the background and scrapes only read the last ones.
+type FleetGauges struct {
+	// Periodic runs Refresh every interval
+	*lifecycle.Periodic
+
+	stats FleetStats
+	mu    sync.Mutex
+	// orderStatuses and droneStatuses are every status seen so far, see fillStatuses
+	orderStatuses, droneStatuses map[string]struct{}
+	orders                       map[domain.OrderStatus]int64
+	drones                       map[domain.DroneStatus]int64
+}
+
+// RegisterFleetGauges registers the gauges, which stay empty until the first Refresh
+func RegisterFleetGauges(stats FleetStats, interval time.Duration) (*FleetGauges, error) {
+	g := &FleetGauges{stats: stats, orderStatuses: map[string]struct{}{}, droneStatuses: map[string]struct{}{}}
+	g.Periodic = lifecycle.NewPeriodic("Fleet Gauges", interval, g.Refresh)
+	meter := otel.Meter("drone-backend")
+
+	orders, err := meter.Int64ObservableGauge("drone_delivery.orders.current",
+		metric.WithDescription("Orders currently in each status"))
+	if err != nil {
+		return nil, err
+	}
+	drones, err := meter.Int64ObservableGauge("drone_delivery.drones.current",
+		metric.WithDescription("Drones currently in each status"))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		for status, n := range g.orders {
+			o.ObserveInt64(orders, n, metric.WithAttributes(attribute.String("status", string(status))))
+		}
+		for status, n := range g.drones {
+			o.ObserveInt64(drones, n, metric.WithAttributes(attribute.String("status", string(status))))
+		}
+		return nil
+	}, orders, drones)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Refresh counts the orders and drones per status. A count that fails keeps the
+// previous one.
+func (g *FleetGauges) Refresh(ctx context.Context) {
+	if counts, err := g.stats.CountOrdersByStatus(ctx); err == nil {
+		g.mu.Lock()
+		g.orders = fillStatuses(g.orderStatuses, counts)
+		g.mu.Unlock()
+	} else {
+		log.Printf("failed to count orders by status: %v", err)
+	}
+	if counts, err := g.stats.CountDronesByStatus(ctx); err == nil {
+		g.mu.Lock()
+		g.drones = fillStatuses(g.droneStatuses, counts)
+		g.mu.Unlock()
+	} else {
+		log.Printf("failed to count drones by status: %v", err)
+	}
+}
+
+// fillStatuses remembers every status seen so far, so a status that drops to zero
+// is reported as 0 rather than disappearing from the gauge
+func fillStatuses[S ~string](seen map[string]struct{}, counts map[S]int64) map[S]int64 {
+	for status := range counts {
+		seen[string(status)] = struct{}{}
+	}
+	for status := range seen {
+		if _, ok := counts[S(status)]; !ok {
+			counts[S(status)] = 0
+		}
+	}
+	return counts
+}
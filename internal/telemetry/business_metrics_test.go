@@ -0,0 +1,102 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MohamedDenta/Drone-Delivery-Management-Backend/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+type fakeFleetStats struct {
+	orders map[domain.OrderStatus]int64
+	drones map[domain.DroneStatus]int64
+}
+
+func (f *fakeFleetStats) CountOrdersByStatus(ctx context.Context) (map[domain.OrderStatus]int64, error) {
+	counts := make(map[domain.OrderStatus]int64)
+	for k, v := range f.orders {
+		counts[k] = v
+	}
+	return counts, nil
+}
+
+func (f *fakeFleetStats) CountDronesByStatus(ctx context.Context) (map[domain.DroneStatus]int64, error) {
+	counts := make(map[domain.DroneStatus]int64)
+	for k, v := range f.drones {
+		counts[k] = v
+	}
+	return counts, nil
+}
+
+func gaugeValues(t *testing.T, rm metricdata.ResourceMetrics, name string) map[string]int64 {
+	values := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			gauge, ok := m.Data.(metricdata.Gauge[int64])
+			require.True(t, ok)
+			for _, dp := range gauge.DataPoints {
+				status, _ := dp.Attributes.Value(attribute.Key("status"))
+				values[status.AsString()] = dp.Value
+			}
+		}
+	}
+	return values
+}
+
+func TestBusinessMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	stats := &fakeFleetStats{
+		orders: map[domain.OrderStatus]int64{domain.OrderStatusPending: 3},
+		drones: map[domain.DroneStatus]int64{domain.DroneStatusIdle: 2},
+	}
+	gauges, err := RegisterFleetGauges(stats, time.Minute)
+	require.NoError(t, err)
+	gauges.Refresh(context.Background())
+	Metrics.OrderCreated(context.Background(), "SCHEDULED")
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	assert.Equal(t, map[string]int64{"PENDING": 3}, gaugeValues(t, rm, "drone_delivery.orders.current"))
+	assert.Equal(t, map[string]int64{"IDLE": 2}, gaugeValues(t, rm, "drone_delivery.drones.current"))
+
+	var created bool
+	transitions := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch m.Name {
+			case "drone_delivery.orders.created":
+				created = m.Data.(metricdata.Sum[int64]).DataPoints[0].Value == 1
+			case "drone_delivery.orders.transitions":
+				for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+					status, _ := dp.Attributes.Value(attribute.Key("status"))
+					transitions[status.AsString()] = dp.Value
+				}
+			}
+		}
+	}
+	// A scheduled order enters PENDING only once the scheduler releases it
+	assert.Equal(t, map[string]int64{"SCHEDULED": 1}, transitions)
+	assert.True(t, created, "orders.created should be recorded through the global meter")
+
+	// Scrapes read the last counts until the next refresh
+	stats.orders = map[domain.OrderStatus]int64{domain.OrderStatusReserved: 3}
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	assert.Equal(t, map[string]int64{"PENDING": 3}, gaugeValues(t, rm, "drone_delivery.orders.current"))
+
+	// The queue drained: PENDING is still reported, as zero
+	gauges.Refresh(context.Background())
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	assert.Equal(t, map[string]int64{"PENDING": 0, "RESERVED": 3}, gaugeValues(t, rm, "drone_delivery.orders.current"))
+}
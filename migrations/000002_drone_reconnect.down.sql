@@ -0,0 +1,7 @@
+ALTER TABLE drones DROP COLUMN IF EXISTS previous_status;
+
+-- Without OFFLINE and NEEDS_INSPECTION, BROKEN is the only state that keeps a drone out of dispatch
+UPDATE drones SET status = 'BROKEN' WHERE status IN ('OFFLINE', 'NEEDS_INSPECTION');
+ALTER TABLE drones DROP CONSTRAINT IF EXISTS drones_status_check;
+ALTER TABLE drones ADD CONSTRAINT drones_status_check
+    CHECK (status IN ('IDLE', 'DELIVERING', 'BROKEN'));
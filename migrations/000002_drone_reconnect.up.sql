@@ -0,0 +1,5 @@
+ALTER TABLE drones DROP CONSTRAINT IF EXISTS drones_status_check;
+ALTER TABLE drones ADD CONSTRAINT drones_status_check
+    CHECK (status IN ('IDLE', 'DELIVERING', 'BROKEN', 'OFFLINE', 'NEEDS_INSPECTION'));
+
+ALTER TABLE drones ADD COLUMN previous_status VARCHAR(50);
@@ -0,0 +1,14 @@
+CREATE TABLE order_events (
+    id VARCHAR(27) PRIMARY KEY,
+    order_id VARCHAR(27) NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    from_status VARCHAR(50),
+    to_status VARCHAR(50) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    drone_id VARCHAR(27) REFERENCES drones(id),
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_order_events_order_id ON order_events(order_id, created_at);
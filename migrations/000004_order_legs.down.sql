@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS order_legs;
+
+-- The old schema has no cancelled or grounded orders; both end as failed
+UPDATE orders SET status = 'FAILED' WHERE status IN ('CANCELLED', 'AWAITING_RECOVERY');
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('PENDING', 'RESERVED', 'PICKED_UP', 'DELIVERED', 'FAILED'));
+
+ALTER TABLE orders DROP COLUMN IF EXISTS pickup_lon;
+ALTER TABLE orders DROP COLUMN IF EXISTS pickup_lat;
@@ -0,0 +1,24 @@
+ALTER TABLE orders ADD COLUMN pickup_lat DOUBLE PRECISION;
+ALTER TABLE orders ADD COLUMN pickup_lon DOUBLE PRECISION;
+UPDATE orders SET pickup_lat = origin_lat, pickup_lon = origin_lon;
+ALTER TABLE orders ALTER COLUMN pickup_lat SET NOT NULL;
+ALTER TABLE orders ALTER COLUMN pickup_lon SET NOT NULL;
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('PENDING', 'RESERVED', 'PICKED_UP', 'DELIVERED', 'FAILED', 'CANCELLED', 'AWAITING_RECOVERY'));
+
+CREATE TABLE order_legs (
+    id VARCHAR(27) PRIMARY KEY,
+    order_id VARCHAR(27) NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    drone_id VARCHAR(27) NOT NULL REFERENCES drones(id),
+    from_lat DOUBLE PRECISION NOT NULL,
+    from_lon DOUBLE PRECISION NOT NULL,
+    to_lat DOUBLE PRECISION NOT NULL,
+    to_lon DOUBLE PRECISION NOT NULL,
+    outcome VARCHAR(50) NOT NULL CHECK (outcome IN ('IN_PROGRESS', 'DELIVERED', 'INTERRUPTED', 'FAILED', 'CANCELLED')),
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ended_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_order_legs_order_id ON order_legs(order_id, started_at);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_drones_updated_at_id;
+DROP INDEX IF EXISTS idx_drones_created_at_id;
+DROP INDEX IF EXISTS idx_orders_drone_id;
+DROP INDEX IF EXISTS idx_orders_updated_at_id;
+DROP INDEX IF EXISTS idx_orders_created_at_id;
@@ -0,0 +1,5 @@
+CREATE INDEX idx_orders_created_at_id ON orders(created_at, id);
+CREATE INDEX idx_orders_updated_at_id ON orders(updated_at, id);
+CREATE INDEX idx_orders_drone_id ON orders(drone_id);
+CREATE INDEX idx_drones_created_at_id ON drones(created_at, id);
+CREATE INDEX idx_drones_updated_at_id ON drones(updated_at, id);
@@ -0,0 +1,2 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS version;
+ALTER TABLE drones DROP COLUMN IF EXISTS version;
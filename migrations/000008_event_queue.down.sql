@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS event_messages;
+DROP TABLE IF EXISTS event_bindings;
@@ -0,0 +1,22 @@
+-- Queues of the Postgres event broker (events.broker: postgres)
+CREATE TABLE event_bindings (
+    queue VARCHAR(255) NOT NULL,
+    routing_key VARCHAR(255) NOT NULL,
+    PRIMARY KEY (queue, routing_key)
+);
+
+CREATE TABLE event_messages (
+    id BIGSERIAL PRIMARY KEY,
+    queue VARCHAR(255) NOT NULL,
+    routing_key VARCHAR(255) NOT NULL,
+    message_id VARCHAR(27) NOT NULL,
+    body BYTEA NOT NULL,
+    headers JSONB NOT NULL DEFAULT '{}',
+    delivery_count INTEGER NOT NULL DEFAULT 0,
+    published_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    -- A claimed message is hidden until its lease expires, so a crashed consumer's
+    -- messages are redelivered
+    visible_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_event_messages_queue_visible ON event_messages(queue, visible_at, id);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_orders_deliver_by;
+DROP INDEX IF EXISTS idx_orders_pending_dispatch_by;
+ALTER TABLE orders DROP COLUMN IF EXISTS sla_at_risk_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS dispatch_by;
+ALTER TABLE orders DROP COLUMN IF EXISTS deliver_by;
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_priority_check;
+ALTER TABLE orders DROP COLUMN IF EXISTS priority;
@@ -0,0 +1,18 @@
+-- Priority classes and delivery deadlines. Existing orders become STANDARD with a
+-- day to deliver, and keep their first-come, first-served place in the queue.
+ALTER TABLE orders ADD COLUMN priority VARCHAR(20) NOT NULL DEFAULT 'STANDARD';
+ALTER TABLE orders ADD CONSTRAINT orders_priority_check
+    CHECK (priority IN ('MEDICAL', 'EXPRESS', 'STANDARD'));
+ALTER TABLE orders ADD COLUMN deliver_by TIMESTAMP WITH TIME ZONE;
+ALTER TABLE orders ADD COLUMN dispatch_by TIMESTAMP WITH TIME ZONE;
+ALTER TABLE orders ADD COLUMN sla_at_risk_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE orders SET deliver_by = created_at + INTERVAL '24 hours', dispatch_by = created_at;
+
+ALTER TABLE orders ALTER COLUMN deliver_by SET DEFAULT CURRENT_TIMESTAMP + INTERVAL '24 hours';
+ALTER TABLE orders ALTER COLUMN deliver_by SET NOT NULL;
+ALTER TABLE orders ALTER COLUMN dispatch_by SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE orders ALTER COLUMN dispatch_by SET NOT NULL;
+
+CREATE INDEX idx_orders_pending_dispatch_by ON orders(dispatch_by, id) WHERE status = 'PENDING';
+CREATE INDEX idx_orders_deliver_by ON orders(deliver_by) WHERE sla_at_risk_at IS NULL;
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_orders_scheduled_dispatch_after;
+
+-- Scheduled orders are dispatched right away from here on
+UPDATE orders SET status = 'PENDING' WHERE status = 'SCHEDULED';
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('PENDING', 'RESERVED', 'PICKED_UP', 'DELIVERED', 'FAILED', 'CANCELLED', 'AWAITING_RECOVERY'));
+
+ALTER TABLE orders DROP COLUMN IF EXISTS dispatch_after;
+ALTER TABLE orders DROP COLUMN IF EXISTS deliver_after;
@@ -0,0 +1,10 @@
+-- Delivery windows. Orders whose window opens later than they could be flown there
+-- wait as SCHEDULED until dispatch_after.
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('SCHEDULED', 'PENDING', 'RESERVED', 'PICKED_UP', 'DELIVERED', 'FAILED', 'CANCELLED', 'AWAITING_RECOVERY'));
+
+ALTER TABLE orders ADD COLUMN deliver_after TIMESTAMP WITH TIME ZONE;
+ALTER TABLE orders ADD COLUMN dispatch_after TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_orders_scheduled_dispatch_after ON orders(dispatch_after) WHERE status = 'SCHEDULED';
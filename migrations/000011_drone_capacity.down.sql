@@ -0,0 +1,2 @@
+ALTER TABLE drones DROP CONSTRAINT IF EXISTS drones_capacity_positive;
+ALTER TABLE drones DROP COLUMN IF EXISTS capacity;
@@ -0,0 +1,3 @@
+-- Drones with room for several parcels take a batch of orders per trip
+ALTER TABLE drones ADD COLUMN capacity INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE drones ADD CONSTRAINT drones_capacity_positive CHECK (capacity > 0);
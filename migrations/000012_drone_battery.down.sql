@@ -0,0 +1,2 @@
+ALTER TABLE drones DROP CONSTRAINT IF EXISTS drones_battery_percent;
+ALTER TABLE drones DROP COLUMN IF EXISTS battery;
@@ -0,0 +1,3 @@
+-- Charge left in percent, as last reported with the drone's location; NULL until it reports one
+ALTER TABLE drones ADD COLUMN battery DOUBLE PRECISION;
+ALTER TABLE drones ADD CONSTRAINT drones_battery_percent CHECK (battery BETWEEN 0 AND 100);
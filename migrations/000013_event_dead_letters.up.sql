@@ -0,0 +1,16 @@
+-- Messages of the Postgres event broker whose handler failed events.max_deliveries times,
+-- kept with the last error for inspection instead of being retried forever
+CREATE TABLE event_dead_letters (
+    id BIGINT PRIMARY KEY, -- The id the message had in event_messages
+    queue VARCHAR(255) NOT NULL,
+    routing_key VARCHAR(255) NOT NULL,
+    message_id VARCHAR(27) NOT NULL,
+    body BYTEA NOT NULL,
+    headers JSONB NOT NULL DEFAULT '{}',
+    delivery_count INTEGER NOT NULL,
+    published_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    error TEXT NOT NULL,
+    dead_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_event_dead_letters_queue ON event_dead_letters(queue, id);
@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL migrations, so the server binary can apply them
+// (see internal/migrate). Files are named NNNNNN_name.up.sql / NNNNNN_name.down.sql.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -26,6 +26,7 @@ type LocationRequest struct {
 	DroneId       string                 `protobuf:"bytes,1,opt,name=drone_id,json=droneId,proto3" json:"drone_id,omitempty"`
 	Latitude      float64                `protobuf:"fixed64,2,opt,name=latitude,proto3" json:"latitude,omitempty"`
 	Longitude     float64                `protobuf:"fixed64,3,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	Battery       *float64               `protobuf:"fixed64,4,opt,name=battery,proto3,oneof" json:"battery,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -81,6 +82,13 @@ func (x *LocationRequest) GetLongitude() float64 {
 	return 0
 }
 
+func (x *LocationRequest) GetBattery() float64 {
+	if x != nil && x.Battery != nil {
+		return *x.Battery
+	}
+	return 0
+}
+
 type LocationResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
@@ -129,11 +137,14 @@ var File_proto_drone_proto protoreflect.FileDescriptor
 
 const file_proto_drone_proto_rawDesc = "" +
 	"\n" +
-	"\x11proto/drone.proto\x12\x05drone\"f\n" +
+	"\x11proto/drone.proto\x12\x05drone\"\x91\x01\n" +
 	"\x0fLocationRequest\x12\x19\n" +
 	"\bdrone_id\x18\x01 \x01(\tR\adroneId\x12\x1a\n" +
 	"\blatitude\x18\x02 \x01(\x01R\blatitude\x12\x1c\n" +
-	"\tlongitude\x18\x03 \x01(\x01R\tlongitude\",\n" +
+	"\tlongitude\x18\x03 \x01(\x01R\tlongitude\x12\x1d\n" +
+	"\abattery\x18\x04 \x01(\x01H\x00R\abattery\x88\x01\x01B\n" +
+	"\n" +
+	"\b_battery\",\n" +
 	"\x10LocationResponse\x12\x18\n" +
 	"\amessage\x18\x01 \x01(\tR\amessage2U\n" +
 	"\fDroneService\x12E\n" +
@@ -171,6 +182,7 @@ func file_proto_drone_proto_init() {
 	if File_proto_drone_proto != nil {
 		return
 	}
+	file_proto_drone_proto_msgTypes[0].OneofWrappers = []any{}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{